@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout bounds the time spent posting to a webhook.
+const webhookTimeout = 10 * time.Second
+
+// alertThresholds are the limits that fire an alert when crossed. Zero
+// values are disabled.
+type alertThresholds struct {
+	ErrorRate    float64       // Percent of requests
+	MismatchRate float64       // Percent of completed responses
+	P99          time.Duration // Of any endpoint
+}
+
+// alert is the JSON payload posted to the alert webhook.
+type alert struct {
+	Alert     string        `json:"alert"`
+	Endpoint  string        `json:"endpoint,omitempty"`
+	Value     float64       `json:"value"`
+	Threshold float64       `json:"threshold"`
+	Time      time.Time     `json:"time"`
+	Stats     reportSummary `json:"stats"`
+}
+
+// alerter checks report summaries against thresholds, and posts an alert to
+// a webhook when one is crossed. An alert fires once when its threshold is
+// crossed, and again only after it has recovered.
+type alerter struct {
+	URL        string
+	Thresholds alertThresholds
+
+	breached map[string]bool
+}
+
+// Check returns the alerts that were newly breached in the summary.
+func (a *alerter) Check(s reportSummary) []alert {
+	if a.breached == nil {
+		a.breached = map[string]bool{}
+	}
+	now := time.Now()
+	var alerts []alert
+	check := func(name, endpoint string, value, threshold float64) {
+		key := name + " " + endpoint
+		if threshold <= 0 || value <= threshold {
+			a.breached[key] = false
+			return
+		}
+		if a.breached[key] {
+			return
+		}
+		a.breached[key] = true
+		alerts = append(alerts, alert{
+			Alert:     name,
+			Endpoint:  endpoint,
+			Value:     value,
+			Threshold: threshold,
+			Time:      now,
+			Stats:     s,
+		})
+	}
+
+	if s.Requests > 0 {
+		check("error_rate", "", s.ErrorRate, a.Thresholds.ErrorRate)
+	}
+	if s.Completed > 0 {
+		check("mismatch_rate", "", s.MismatchRate, a.Thresholds.MismatchRate)
+	}
+	for _, endpoint := range s.Endpoints {
+		if endpoint.Requests > 0 {
+			check("p99", endpoint.label(), endpoint.Timing.Percentiles["99"], a.Thresholds.P99.Seconds())
+		}
+	}
+	return alerts
+}
+
+// Fire posts the alert to the webhook.
+func (a *alerter) Fire(ctx context.Context, al alert) {
+	logger.Warn().Str("alert", al.Alert).Str("endpoint", al.Endpoint).Float64("value", al.Value).Float64("threshold", al.Threshold).Msg("alert threshold crossed")
+	if err := postJSON(ctx, a.URL, al); err != nil {
+		logger.Error().Err(err).Str("alert", al.Alert).Msg("failed to post alert")
+	}
+}
+
+// postJSON posts v as JSON to a webhook URL.
+func postJSON(ctx context.Context, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("bad status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlerterCheck(t *testing.T) {
+	a := alerter{Thresholds: alertThresholds{ErrorRate: 5, P99: time.Second}}
+	summary := func(errorRate, p99 float64) reportSummary {
+		return reportSummary{
+			Requests:  100,
+			ErrorRate: errorRate,
+			Endpoints: []endpointSummary{{
+				Endpoint: "a",
+				Requests: 100,
+				Timing:   timingSummary{Percentiles: map[string]float64{"99": p99}},
+			}},
+		}
+	}
+
+	alertNames := func(alerts []alert) []string {
+		var names []string
+		for _, al := range alerts {
+			names = append(names, al.Alert)
+		}
+		return names
+	}
+
+	steps := []struct {
+		errorRate float64
+		p99       float64
+		want      []string
+	}{
+		{1, 0.5, nil},
+		{10, 0.5, []string{"error_rate"}},
+		{10, 2, []string{"p99"}}, // error_rate is still breached
+		{1, 2, nil},
+		{10, 2, []string{"error_rate"}}, // Recovered in between
+	}
+	for i, step := range steps {
+		got := alertNames(a.Check(summary(step.errorRate, step.p99)))
+		if len(got) != len(step.want) {
+			t.Fatalf("step %d: got: %v; want: %v", i, got, step.want)
+		}
+		for j := range got {
+			if got[j] != step.want[j] {
+				t.Errorf("step %d: got: %v; want: %v", i, got, step.want)
+			}
+		}
+	}
+}
+
+func TestAlerterEndpointLabel(t *testing.T) {
+	a := alerter{Thresholds: alertThresholds{P99: time.Second}}
+	// The same URI twice, told apart by name
+	s := reportSummary{Endpoints: []endpointSummary{
+		{Endpoint: "http://a", Name: "old", Requests: 1, Timing: timingSummary{Percentiles: map[string]float64{"99": 2}}},
+		{Endpoint: "http://a", Name: "new", Requests: 1, Timing: timingSummary{Percentiles: map[string]float64{"99": 2}}},
+	}}
+	alerts := a.Check(s)
+	if len(alerts) != 2 || alerts[0].Endpoint != "old" || alerts[1].Endpoint != "new" {
+		t.Errorf("got: %+v; want p99 alerts for old and new", alerts)
+	}
+}
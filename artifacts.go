@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// uploadTimeout bounds the time spent uploading artifacts after a run.
+const uploadTimeout = 5 * time.Minute
+
+// mismatchRecord is a line of the mismatch log.
+type mismatchRecord struct {
+	ID        requestID         `json:"id"`
+	UUID      string            `json:"uuid,omitempty"`
+	Group     string            `json:"group,omitempty"`
+	Method    string            `json:"method,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	Request   json.RawMessage   `json:"request"`
+	Tags      []string          `json:"tags,omitempty"`
+	Responses []mismatchedReply `json:"responses"`
+}
+
+type mismatchedReply struct {
+	Endpoint  string          `json:"endpoint"`
+	Status    int             `json:"status,omitempty"`
+	Redirects []string        `json:"redirects,omitempty"`
+	Elapsed   float64         `json:"elapsed"` // Seconds
+	Error     string          `json:"error,omitempty"`
+	Body      json.RawMessage `json:"body,omitempty"`
+	Hash      string          `json:"hash,omitempty"`
+	Spilled   string          `json:"spilled,omitempty"` // File with the body
+	Outlier   bool            `json:"outlier,omitempty"` // Disagreed with the majority of three or more endpoints
+}
+
+// rawJSON returns data as-is if it's valid JSON, or as a JSON string
+// otherwise.
+func rawJSON(data []byte) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	s, _ := json.Marshal(string(data))
+	return json.RawMessage(s)
+}
+
+// mismatchLog writes mismatched response sets as JSON lines.
+type mismatchLog struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	f   *rotatingFile
+	err error
+
+	closed bool
+}
+
+// createMismatchLog creates the log at path, which is rolled over by the
+// rotation, or a temporary log that isn't if there's no path.
+func createMismatchLog(path string, rot rotation) (*mismatchLog, error) {
+	if path == "" {
+		tmp, err := ioutil.TempFile("", "versus-mismatches-*.jsonl")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create mismatch log: %w", err)
+		}
+		tmp.Close()
+		path, rot = tmp.Name(), rotation{}
+	}
+	f, err := createRotatingFile(path, rot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mismatch log: %w", err)
+	}
+	return &mismatchLog{w: bufio.NewWriter(f), f: f}, nil
+}
+
+// Write appends a mismatched response set of the endpoint group to the log.
+func (l *mismatchLog) Write(group string, resps []Response) {
+	line, err := json.Marshal(newMismatchRecord(group, resps))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	if err == nil {
+		_, err = l.w.Write(append(line, '\n'))
+	}
+	if err != nil && l.err == nil {
+		l.err = err
+	}
+	// Spilled bodies are deleted with the rolled file that refers to them
+	var spilled []string
+	for _, resp := range resps {
+		if resp.Spilled != "" {
+			spilled = append(spilled, resp.Spilled)
+		}
+	}
+	l.f.Record(spilled...)
+	if now := time.Now(); l.f.Due(now, l.w.Buffered()) {
+		err := l.w.Flush()
+		if err == nil {
+			err = l.f.Roll(now)
+		}
+		if err != nil && l.err == nil {
+			l.err = err
+		}
+	}
+}
+
+// newMismatchRecord returns the record of a mismatched response set of the
+// endpoint group.
+func newMismatchRecord(group string, resps []Response) mismatchRecord {
+	record := mismatchRecord{
+		ID:        resps[0].ID,
+		Group:     group,
+		Responses: make([]mismatchedReply, 0, len(resps)),
+	}
+	if resps[0].Request != nil {
+		record.UUID = resps[0].Request.UUID
+		record.Method = resps[0].Request.Method
+		record.Path = resps[0].Request.Path
+		record.Request = rawJSON(resps[0].Request.Line)
+		record.Tags = resps[0].Request.Tags
+	}
+	for _, resp := range resps {
+		reply := mismatchedReply{
+			Status:    resp.Status,
+			Redirects: resp.Redirects,
+			Elapsed:   resp.Elapsed.Seconds(),
+			Body:      rawJSON(resp.Body),
+			Hash:      resp.Hash,
+			Spilled:   resp.Spilled,
+			Outlier:   resp.Outlier,
+		}
+		if resp.client != nil {
+			reply.Endpoint = redactURL(resp.client.Endpoint)
+		}
+		if resp.Err != nil {
+			reply.Error = resp.Err.Error()
+		}
+		record.Responses = append(record.Responses, reply)
+	}
+	return record
+}
+
+// Path is the path of the log file.
+func (l *mismatchLog) Path() string {
+	return l.f.Name()
+}
+
+// Segments returns the paths of the rolled files of the log that are kept,
+// oldest first, and of the current file, once it's closed.
+func (l *mismatchLog) Segments() []string {
+	return l.f.Segments()
+}
+
+// Close flushes and closes the log, returning the first write error. It's
+// safe to call more than once.
+func (l *mismatchLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return l.err
+	}
+	l.closed = true
+	if err := l.w.Flush(); err != nil && l.err == nil {
+		l.err = err
+	}
+	if err := l.f.Close(); err != nil && l.err == nil {
+		l.err = err
+	}
+	return l.err
+}
+
+// uploadKey is the data available to upload URI templates.
+type uploadKey struct {
+	Date     string // 2006-01-02
+	Time     string // 150405
+	Unix     int64
+	Version  string
+	Hostname string
+}
+
+// expandUploadURI renders the upload URI template, such as
+// "s3://bucket/versus/{{.Date}}/{{.Hostname}}-{{.Time}}/".
+func expandUploadURI(tmpl string, now time.Time) (string, error) {
+	t, err := template.New("upload").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse upload template: %w", err)
+	}
+	hostname, _ := os.Hostname()
+	now = now.UTC()
+	key := uploadKey{
+		Date:     now.Format("2006-01-02"),
+		Time:     now.Format("150405"),
+		Unix:     now.Unix(),
+		Version:  Version,
+		Hostname: hostname,
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, key); err != nil {
+		return "", fmt.Errorf("failed to render upload template: %w", err)
+	}
+	uri := b.String()
+	if !strings.HasSuffix(uri, "/") {
+		uri += "/"
+	}
+	return uri, nil
+}
+
+// uploadArtifacts uploads the text and JSON reports, and the files of the
+// mismatch log if there is one, under the prefix URI.
+func uploadArtifacts(ctx context.Context, prefix string, reports []*report, mismatches *mismatchLog) error {
+	var text, summary bytes.Buffer
+	if err := renderReports(&text, reports, "text", palette{}); err != nil {
+		return err
+	}
+	if err := renderReports(&summary, reports, "json", palette{}); err != nil {
+		return err
+	}
+	if err := uploadObject(ctx, prefix+"report.txt", "text/plain; charset=utf-8", bytes.NewReader(text.Bytes()), int64(text.Len())); err != nil {
+		return err
+	}
+	if err := uploadObject(ctx, prefix+"report.json", "application/json", bytes.NewReader(summary.Bytes()), int64(summary.Len())); err != nil {
+		return err
+	}
+	if mismatches == nil {
+		return nil
+	}
+	files := segmentNames("mismatches.jsonl", mismatches.Segments())
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		contentType := "application/x-ndjson"
+		if strings.HasSuffix(name, ".gz") {
+			contentType = "application/gzip"
+		}
+		if err := uploadFile(ctx, prefix+name, contentType, files[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uploadFile(ctx context.Context, uri string, contentType string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return uploadObject(ctx, uri, contentType, f, info.Size())
+}
+
+func uploadObject(ctx context.Context, uri string, contentType string, body io.Reader, size int64) error {
+	req, err := objectRequest(ctx, http.MethodPut, uri, body, size, contentType)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("failed to upload %s: bad status code: %d", uri, resp.StatusCode)
+	}
+	logger.Info().Str("uri", uri).Int64("size", size).Msg("uploaded artifact")
+	return nil
+}
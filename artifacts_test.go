@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRawJSON(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"", ""},
+		{`{"a":1}`, `{"a":1}`},
+		{"not json", `"not json"`},
+	}
+	for _, tc := range tests {
+		if got := string(rawJSON([]byte(tc.data))); got != tc.want {
+			t.Errorf("rawJSON(%q): got: %s; want: %s", tc.data, got, tc.want)
+		}
+	}
+}
+
+func TestExpandUploadURI(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 30, 5, 0, time.UTC)
+	got, err := expandUploadURI("s3://bucket/runs/{{.Date}}/{{.Time}}", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "s3://bucket/runs/2024-06-01/123005/"; got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+
+	if _, err := expandUploadURI("s3://bucket/{{.Nope}}/", now); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// bisector finds the earliest request of an ordered input from which the
+// responses of stateful endpoints diverge for good, by replaying prefixes of
+// the input: a trial replays the first N requests one at a time, and diverged
+// if the responses to the last of them mismatch. Divergence is assumed to
+// persist once it started, so the onset is found in about log2(requests)
+// trials rather than by hand with head and tail.
+type bisector struct {
+	Clients Clients
+	Parser  *inputParser
+	// Reset is called before each trial to reset the endpoints' state, if set
+	Reset  func(ctx context.Context) error
+	Diff   string    // Format of body diffs
+	Scrub  *scrubber // Redacts the request and responses that are shown, if set
+	Colors palette
+}
+
+// Run bisects the records of the input, and writes the request from which
+// responses diverge, with how they differ, to out.
+func (b *bisector) Run(ctx context.Context, in io.Reader, out io.Writer) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	lines, scanErr := scanLines(in, b.Parser.Split, stop)
+	var records [][]byte
+	for line := range lines {
+		if len(line) == 0 {
+			break
+		}
+		records = append(records, line)
+	}
+	if err := <-scanErr; err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no requests to bisect")
+	}
+
+	// The whole input must diverge for there to be an onset
+	last := len(records) - 1
+	onset, err := b.trial(ctx, records, last)
+	if err != nil {
+		return err
+	}
+	if !anyMismatched(onset) {
+		releaseResponses(onset...)
+		fmt.Fprintf(out, "The responses to the last of %d requests match, there's no divergence to bisect.\n", len(records))
+		return nil
+	}
+	lo, hi := 0, last
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		resps, err := b.trial(ctx, records, mid)
+		if err != nil {
+			releaseResponses(onset...)
+			return err
+		}
+		if anyMismatched(resps) {
+			releaseResponses(onset...)
+			hi, onset = mid, resps
+		} else {
+			releaseResponses(resps...)
+			lo = mid + 1
+		}
+	}
+
+	fmt.Fprintf(out, "%s\n%s\n\n", b.Colors.Heading(fmt.Sprintf("Responses diverge from request %d of %d:", hi+1, len(records))), b.scrub(records[hi]))
+	(&repl{Clients: b.Clients, Diff: b.Diff, Colors: b.Colors}).render(out, b.Scrub.Responses(onset))
+	releaseResponses(onset...)
+	return nil
+}
+
+// scrub returns the input record as it's shown.
+func (b *bisector) scrub(record []byte) []byte {
+	if b.Scrub == nil {
+		return record
+	}
+	return b.Scrub.Scrub(record)
+}
+
+// trial resets the endpoints, replays the records up to and including the
+// one at last, and returns the responses to it.
+func (b *bisector) trial(ctx context.Context, records [][]byte, last int) ([]Response, error) {
+	if b.Reset != nil {
+		if err := b.Reset(ctx); err != nil {
+			return nil, fmt.Errorf("failed to reset endpoints: %w", err)
+		}
+	}
+	// Fresh connections and extracted values, like a new run
+	for _, c := range b.Clients {
+		if c.Extractor != nil {
+			c.Extractor = newExtractor(c.Extractor.rules)
+		}
+	}
+	transports, closeTransports, err := b.Clients.Transports()
+	if err != nil {
+		return nil, err
+	}
+	defer closeTransports()
+
+	var resps []Response
+	for i := 0; i <= last; i++ {
+		releaseResponses(resps...)
+		req, err := b.Parser.Parse(records[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse input line %d: %w", i+1, err)
+		}
+		req.ID = requestID(i + 1)
+		resps = b.Clients.Do(ctx, transports, req)
+		if err := ctx.Err(); err != nil {
+			releaseResponses(resps...)
+			return nil, err
+		}
+	}
+	logger.Info().Int("requests", last+1).Bool("diverged", anyMismatched(resps)).Msg("bisection trial")
+	return resps, nil
+}
+
+// anyMismatched returns whether any response doesn't match the first.
+func anyMismatched(resps []Response) bool {
+	for i := 1; i < len(resps); i++ {
+		if !resps[0].Equal(resps[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// shellReset returns a reset function that runs the command with the shell,
+// with its output going to stderr.
+func shellReset(command string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		return cmd.Run()
+	}
+}
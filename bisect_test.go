@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBisect(t *testing.T) {
+	// Endpoint b is thrown off for good by the poison request
+	var mu sync.Mutex
+	poisoned := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/b" && bytes.Contains(body, []byte("poison")) {
+			poisoned = true
+		}
+		if r.URL.Path == "/b" && poisoned {
+			w.Write([]byte(`{"result":"0x2"}`))
+			return
+		}
+		w.Write([]byte(`{"result":"0x1"}`))
+	}))
+	defer srv.Close()
+
+	clients, err := NewClients([]string{srv.URL + "/a#name=a", srv.URL + "/b#name=b"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trials := 0
+	b := &bisector{
+		Clients: clients,
+		Parser:  &inputParser{},
+		Diff:    "unified",
+		Reset: func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			trials += 1
+			poisoned = false
+			return nil
+		},
+	}
+
+	var input strings.Builder
+	for i := 1; i <= 20; i++ {
+		method := "eth_blockNumber"
+		if i == 13 {
+			method = "poison"
+		}
+		fmt.Fprintf(&input, "{\"id\":%d,\"method\":%q}\n", i, method)
+	}
+	var out bytes.Buffer
+	if err := b.Run(context.Background(), strings.NewReader(input.String()), &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Responses diverge from request 13 of 20:", `"poison"`, "1. b: $.result"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("got:\n%s\nwant: %s", out.String(), want)
+		}
+	}
+	if trials > 6 {
+		t.Errorf("got %d trials; want at most 6 for 20 requests", trials)
+	}
+
+	// Without the poison, there's nothing to bisect
+	out.Reset()
+	clean := strings.Replace(input.String(), "poison", "eth_call", 1)
+	if err := b.Run(context.Background(), strings.NewReader(clean), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "no divergence") {
+		t.Errorf("got:\n%s\nwant no divergence", out.String())
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// bundleManifest is the manifest.json of a run bundle: what is needed to
+// reproduce the run, and to audit it later.
+type bundleManifest struct {
+	Version    string      `json:"version"`
+	GoVersion  string      `json:"go_version"`
+	Hostname   string      `json:"hostname"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+	Seed       int64       `json:"seed"`
+	Input      inputDigest `json:"input"`
+	Groups     []groupSpec `json:"groups"`
+	Options    Options     `json:"options"` // With secrets redacted
+	Files      []string    `json:"files"`
+}
+
+// inputDigest identifies the input of a run by what was read of it.
+type inputDigest struct {
+	Source string `json:"source"`
+	SHA256 string `json:"sha256"`
+	Bytes  int64  `json:"bytes"`
+	Lines  int64  `json:"lines"`
+}
+
+// digestReader hashes and counts what is read through it. The input may
+// still be read in the background when the run is over.
+type digestReader struct {
+	r io.Reader
+
+	mu    sync.Mutex
+	hash  hash.Hash
+	bytes int64
+	lines int64
+	eof   bool
+}
+
+func newDigestReader(r io.Reader) *digestReader {
+	return &digestReader{r: r, hash: sha256.New()}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hash.Write(p[:n])
+	d.bytes += int64(n)
+	d.lines += int64(bytes.Count(p[:n], []byte("\n")))
+	if err == io.EOF {
+		d.eof = true
+	}
+	return n, err
+}
+
+// Complete returns whether the whole input was read, so that its digest is
+// final.
+func (d *digestReader) Complete() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.eof
+}
+
+// Digest returns the digest of the input read so far.
+func (d *digestReader) Digest(source string) inputDigest {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return inputDigest{
+		Source: source,
+		SHA256: hex.EncodeToString(d.hash.Sum(nil)),
+		Bytes:  d.bytes,
+		Lines:  d.lines,
+	}
+}
+
+// bundleCompression returns the compression of a bundle path by its
+// extension: .tar.zst, .tar.gz (or .tgz), or .tar for none.
+func bundleCompression(path string) (string, error) {
+	switch {
+	case strings.HasSuffix(path, ".tar.zst"):
+		return "zstd", nil
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return "gzip", nil
+	case strings.HasSuffix(path, ".tar"):
+		return "", nil
+	}
+	return "", fmt.Errorf("bundle must be a .tar.zst, .tar.gz or .tar file: %s", path)
+}
+
+// redactOptions returns the options without secrets: passwords of endpoint
+// URLs, and webhook URLs, which are credentials themselves.
+func redactOptions(options Options) Options {
+	options.Args.Endpoints = redactEndpoints(options.Args.Endpoints)
+	for _, s := range []*string{&options.Notify, &options.AlertWebhook} {
+		if *s != "" {
+			*s = "redacted"
+		}
+	}
+	options.PushGateway = redactURL(options.PushGateway)
+	options.RemoteWrite = redactURL(options.RemoteWrite)
+	options.Input = redactURL(options.Input)
+	return options
+}
+
+// redactGroups returns the groups with the secrets of their endpoints
+// redacted.
+func redactGroups(specs []groupSpec) []groupSpec {
+	groups := make([]groupSpec, len(specs))
+	for i, spec := range specs {
+		spec.Endpoints = redactEndpoints(spec.Endpoints)
+		groups[i] = spec
+	}
+	return groups
+}
+
+func redactEndpoints(endpoints []string) []string {
+	redacted := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		redacted[i] = redactURL(endpoint)
+	}
+	return redacted
+}
+
+// redactURL replaces the password of a URL, and the values of its query,
+// which often carry API keys.
+func redactURL(s string) string {
+	if !strings.ContainsAny(s, "@?") {
+		return s // Nothing to redact, without parsing it
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.User == nil && u.RawQuery == "") {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query[key] = []string{"redacted"}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// writeBundle writes the run bundle: the manifest, the text and JSON
+// reports, and the files of the run's artifacts, by their name in the
+// bundle.
+func writeBundle(path string, manifest bundleManifest, reports []*report, files map[string]string) (err error) {
+	compression, err := bundleCompression(path)
+	if err != nil {
+		return err
+	}
+	var text, summary bytes.Buffer
+	if err := renderReports(&text, reports, "text", palette{}); err != nil {
+		return err
+	}
+	if err := renderReports(&summary, reports, "json", palette{}); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	var w io.WriteCloser = nopWriteCloser{f}
+	switch compression {
+	case "zstd":
+		if w, err = zstd.NewWriter(f); err != nil {
+			return err
+		}
+	case "gzip":
+		w = gzip.NewWriter(f)
+	}
+	tw := tar.NewWriter(w)
+
+	manifest.Files = []string{"manifest.json", "report.txt", "report.json"}
+	for _, name := range []string{"mismatches.jsonl", "latencies.csv"} {
+		if files[name] != "" {
+			manifest.Files = append(manifest.Files, name)
+		}
+	}
+	// Rolled files of the logs
+	var rolled []string
+	for name, path := range files {
+		if name != "mismatches.jsonl" && name != "latencies.csv" && path != "" {
+			rolled = append(rolled, name)
+		}
+	}
+	sort.Strings(rolled)
+	manifest.Files = append(manifest.Files, rolled...)
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	entries := []struct {
+		name string
+		body []byte
+	}{
+		{"manifest.json", append(body, '\n')},
+		{"report.txt", text.Bytes()},
+		{"report.json", summary.Bytes()},
+	}
+	for _, e := range entries {
+		if err := writeTarBytes(tw, e.name, e.body, manifest.FinishedAt); err != nil {
+			return err
+		}
+	}
+	for _, name := range manifest.Files[len(entries):] {
+		if err := writeTarFile(tw, name, files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func writeTarBytes(tw *tar.Writer, name string, body []byte, modTime time.Time) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), ModTime: modTime}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(body)
+	return err
+}
+
+func writeTarFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newBundleManifest returns the manifest of a run started at the time.
+func newBundleManifest(options Options, specs []groupSpec, seed int64, startedAt time.Time) bundleManifest {
+	hostname, _ := os.Hostname()
+	return bundleManifest{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		Hostname:  hostname,
+		StartedAt: startedAt.UTC(),
+		Seed:      seed,
+		Groups:    redactGroups(specs),
+		Options:   redactOptions(options),
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// responseCache is an LRU cache of responses with a TTL, used to avoid
+// sending repeated identical requests to the reference endpoint.
+type responseCache struct {
+	size int
+	ttl  time.Duration // Zero means entries never expire
+
+	mu      sync.Mutex
+	order   *list.List // Front is most recently used
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	resp    Response
+	expires time.Time
+}
+
+func newResponseCache(size int, ttl time.Duration) *responseCache {
+	return &responseCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// Get returns the cached response for the key, if present and not expired.
+func (c *responseCache) Get(key string) (Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return Response{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return Response{}, false
+	}
+	c.order.MoveToFront(el)
+	return entry.resp, true
+}
+
+// Put adds a response to the cache, evicting the least recently used entry
+// if the cache is full.
+func (c *responseCache) Put(key string, resp Response) {
+	// The response outlives its comparison, so it can't share a pooled body
+	resp = resp.detach()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.resp, entry.expires = resp, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, resp: resp, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	c := newResponseCache(2, 0)
+	c.Put("a", Response{Body: []byte("1")})
+	c.Put("b", Response{Body: []byte("2")})
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("expected a to be cached")
+	}
+	// b is now the least recently used
+	c.Put("c", Response{Body: []byte("3")})
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expected b to be evicted")
+	}
+	if resp, ok := c.Get("c"); !ok || string(resp.Body) != "3" {
+		t.Errorf("got: %q, %t; want: %q, true", resp.Body, ok, "3")
+	}
+
+	c = newResponseCache(2, time.Nanosecond)
+	c.Put("a", Response{})
+	time.Sleep(time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("expected a to be expired")
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// Verdicts of the canary.
+const (
+	canaryPending      = "PENDING"
+	canaryPass         = "PASS"
+	canaryFail         = "FAIL"
+	canaryInconclusive = "INCONCLUSIVE"
+)
+
+var (
+	errCanaryFailed       = errors.New("canary verdict: FAIL")
+	errCanaryInconclusive = errors.New("canary verdict: INCONCLUSIVE")
+)
+
+// canaryMinPairs is the number of request pairs a metric needs before it can
+// decide the verdict, since its confidence sequence is only valid
+// asymptotically.
+const canaryMinPairs = 100
+
+// canaryTuning is the number of request pairs at which the confidence
+// sequences are tightest. They stay valid before and after it.
+const canaryTuning = 10000
+
+// canary decides whether each candidate endpoint is non-inferior to the first
+// (reference) endpoint, in error rate and latency, by sequential testing: it
+// keeps confidence sequences, which hold at every point of the run at once,
+// of the difference in error rate and of the geometric mean of the latency
+// ratio of each request pair. The verdict is reached as soon as every
+// candidate is significantly within the margins (PASS), or any of them is
+// significantly beyond one (FAIL), rather than after a fixed duration.
+type canary struct {
+	ErrorMargin   float64 // Percentage points of error rate that candidates may exceed the reference by
+	LatencyMargin float64 // Percent that candidates may be slower than the reference by
+	Confidence    float64 // Percent
+
+	// OnVerdict is called once with the verdict, when it's reached
+	OnVerdict func(verdict string)
+
+	mu         sync.Mutex
+	candidates []*Client
+	stats      map[*Client]*canaryStats
+	verdict    string
+}
+
+// canaryStats are the request pairs of a candidate and the reference.
+type canaryStats struct {
+	errors  sequentialMean // Of the candidate's error minus the reference's, 1, 0 or -1
+	latency sequentialMean // Of the log of the candidate's latency over the reference's
+}
+
+// sequentialMean is a running mean and variance, with a confidence sequence
+// of the mean.
+type sequentialMean struct {
+	n    int
+	mean float64
+	m2   float64 // Sum of squared deltas
+}
+
+func (m *sequentialMean) Add(x float64) {
+	m.n++
+	delta := x - m.mean
+	m.mean += delta / float64(m.n)
+	m.m2 += delta * (x - m.mean)
+}
+
+// Bounds returns the asymptotic confidence sequence of the mean, at
+// confidence 1-alpha, from its normal mixture boundary (Waudby-Smith et al.,
+// "Time-uniform central limit theory"), tuned for canaryTuning samples.
+func (m *sequentialMean) Bounds(alpha float64) (float64, float64) {
+	if m.n == 0 {
+		return math.Inf(-1), math.Inf(1)
+	}
+	n := float64(m.n)
+	rho2 := (-2*math.Log(alpha) + math.Log(-2*math.Log(alpha)+1)) / canaryTuning
+	v := n*(m.m2/n)*rho2 + 1
+	radius := math.Sqrt(2 * v / (n * n * rho2) * math.Log(math.Sqrt(v)/alpha))
+	return m.mean - radius, m.mean + radius
+}
+
+// Observe adds the response set of a request, in the order of the clients, to
+// the pairs of each candidate with the reference.
+func (c *canary) Observe(resps []Response) {
+	if len(resps) < 2 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats == nil {
+		c.stats = map[*Client]*canaryStats{}
+	}
+	ref := resps[0]
+	for _, resp := range resps[1:] {
+		stats, ok := c.stats[resp.client]
+		if !ok {
+			stats = &canaryStats{}
+			c.stats[resp.client] = stats
+			c.candidates = append(c.candidates, resp.client)
+		}
+		stats.errors.Add(errorValue(resp) - errorValue(ref))
+		if resp.Err == nil && ref.Err == nil && timed(resp) && timed(ref) {
+			stats.latency.Add(math.Log(float64(resp.Elapsed) / float64(ref.Elapsed)))
+		}
+	}
+	if c.verdict != "" {
+		return
+	}
+	verdict := c.decide()
+	if verdict == canaryPending {
+		return
+	}
+	c.verdict = verdict
+	logger.Info().Str("verdict", verdict).Msg("canary verdict reached")
+	if c.OnVerdict != nil {
+		c.OnVerdict(verdict)
+	}
+}
+
+func errorValue(resp Response) float64 {
+	if resp.Err != nil {
+		return 1
+	}
+	return 0
+}
+
+// alpha is the probability of a wrong verdict of each metric.
+func (c *canary) alpha() float64 {
+	return (1 - c.Confidence/100) / 2
+}
+
+// decide returns the verdict of the pairs so far.
+func (c *canary) decide() string {
+	verdict := canaryPass
+	for _, client := range c.candidates {
+		switch c.candidateVerdict(c.stats[client]) {
+		case canaryFail:
+			return canaryFail
+		case canaryPending:
+			verdict = canaryPending
+		}
+	}
+	return verdict
+}
+
+func (c *canary) candidateVerdict(stats *canaryStats) string {
+	errs := metricVerdict(&stats.errors, c.ErrorMargin/100, c.alpha())
+	latency := metricVerdict(&stats.latency, math.Log1p(c.LatencyMargin/100), c.alpha())
+	switch {
+	case errs == canaryFail || latency == canaryFail:
+		return canaryFail
+	case errs == canaryPass && latency == canaryPass:
+		return canaryPass
+	}
+	return canaryPending
+}
+
+// metricVerdict returns whether the mean of a metric is significantly under
+// the margin (PASS) or over it (FAIL).
+func metricVerdict(m *sequentialMean, margin, alpha float64) string {
+	if m.n < canaryMinPairs {
+		return canaryPending
+	}
+	low, high := m.Bounds(alpha)
+	switch {
+	case high < margin:
+		return canaryPass
+	case low > margin:
+		return canaryFail
+	}
+	return canaryPending
+}
+
+// Finish ends the test, and returns the verdict: INCONCLUSIVE if it wasn't
+// reached during the run.
+func (c *canary) Finish() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.verdict == "" {
+		c.verdict = canaryInconclusive
+	}
+	return c.verdict
+}
+
+// canarySummary is the machine-readable form of the canary verdict.
+type canarySummary struct {
+	Verdict    string                   `json:"verdict"`
+	Confidence float64                  `json:"confidence"` // Percent
+	Candidates []canaryCandidateSummary `json:"candidates"`
+}
+
+type canaryCandidateSummary struct {
+	Endpoint string              `json:"endpoint"`
+	Name     string              `json:"name,omitempty"`
+	Verdict  string              `json:"verdict"`
+	Errors   canaryMetricSummary `json:"errors"`  // Difference in error rate, in percentage points
+	Latency  canaryMetricSummary `json:"latency"` // Ratio of latencies over the reference's
+}
+
+// canaryMetricSummary is the estimate of a metric of a candidate and its
+// confidence sequence.
+type canaryMetricSummary struct {
+	Pairs    int     `json:"pairs"`
+	Estimate float64 `json:"estimate"`
+	Low      float64 `json:"low"`
+	High     float64 `json:"high"`
+	Margin   float64 `json:"margin"`
+	Verdict  string  `json:"verdict"`
+}
+
+// Summary returns the verdict and the metrics of each candidate, or nil
+// without a canary.
+func (c *canary) Summary() *canarySummary {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := &canarySummary{Verdict: c.verdict, Confidence: c.Confidence}
+	if s.Verdict == "" {
+		s.Verdict = canaryPending
+	}
+	alpha := c.alpha()
+	for _, client := range c.candidates {
+		stats := c.stats[client]
+		e := canaryCandidateSummary{Endpoint: redactURL(client.Endpoint), Name: client.Name, Verdict: c.candidateVerdict(stats)}
+
+		margin := c.ErrorMargin / 100
+		low, high := stats.errors.Bounds(alpha)
+		e.Errors = canaryMetricSummary{
+			Pairs:    stats.errors.n,
+			Estimate: stats.errors.mean * 100,
+			Low:      math.Max(low, -1) * 100,
+			High:     math.Min(high, 1) * 100,
+			Margin:   c.ErrorMargin,
+			Verdict:  metricVerdict(&stats.errors, margin, alpha),
+		}
+		margin = math.Log1p(c.LatencyMargin / 100)
+		low, high = stats.latency.Bounds(alpha)
+		e.Latency = canaryMetricSummary{
+			Pairs:    stats.latency.n,
+			Estimate: math.Exp(stats.latency.mean),
+			Low:      math.Exp(low),
+			High:     math.Exp(high),
+			Margin:   math.Exp(margin),
+			Verdict:  metricVerdict(&stats.latency, margin, alpha),
+		}
+		if stats.latency.n == 0 {
+			// JSON has no infinities
+			e.Latency.Estimate, e.Latency.Low, e.Latency.High = 0, 0, 0
+		}
+		s.Candidates = append(s.Candidates, e)
+	}
+	return s
+}
+
+// renderCanary writes the canary verdict as part of the text report.
+func renderCanary(w io.Writer, colors palette, s *canarySummary) {
+	if s == nil {
+		return
+	}
+	paint := func(verdict string) string {
+		switch verdict {
+		case canaryPass:
+			return colors.paint(ansiGreen, verdict)
+		case canaryFail:
+			return colors.paint(ansiRed, verdict)
+		}
+		return colors.paint(ansiYellow, verdict)
+	}
+	fmt.Fprintf(w, "\n%s %s at %0.f%% confidence\n", colors.Heading("** Canary verdict:"), paint(s.Verdict), s.Confidence)
+	for i, e := range s.Candidates {
+		fmt.Fprintf(w, "   %d. %q: %s\n", i+1, e.label(), paint(e.Verdict))
+		if e.Errors.Pairs > 0 {
+			fmt.Fprintf(w, "      Errors:   %+0.2fpp (%+0.2fpp to %+0.2fpp) over %d requests, margin %+0.2fpp: %s\n",
+				e.Errors.Estimate, e.Errors.Low, e.Errors.High, e.Errors.Pairs, e.Errors.Margin, paint(e.Errors.Verdict))
+		}
+		if e.Latency.Pairs > 0 {
+			fmt.Fprintf(w, "      Latency:  %0.3fx (%0.3fx to %0.3fx) over %d requests, margin %0.3fx: %s\n",
+				e.Latency.Estimate, e.Latency.Low, e.Latency.High, e.Latency.Pairs, e.Latency.Margin, paint(e.Latency.Verdict))
+		}
+	}
+}
+
+// label returns the name of the endpoint if it has one, or its URI.
+func (e canaryCandidateSummary) label() string {
+	return endpointLabel(e.Name, e.Endpoint)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runCanary observes pairs of responses of the reference and candidate until
+// the verdict is reached, and returns it with the number of pairs it took.
+func runCanary(t *testing.T, candidate func(rng *rand.Rand, ref Response) Response, max int) (string, int) {
+	t.Helper()
+	clients, err := NewClients([]string{"http://reference/", "http://candidate/"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verdicts := 0
+	var verdict string
+	c := &canary{ErrorMargin: 0.5, LatencyMargin: 10, Confidence: 95, OnVerdict: func(v string) {
+		verdicts += 1
+		verdict = v
+	}}
+	rng := rand.New(rand.NewSource(1))
+	n := 0
+	for ; n < max && verdict == ""; n++ {
+		ref := Response{client: clients[0], Elapsed: time.Duration(50+rng.Intn(50)) * time.Millisecond}
+		if rng.Float64() < 0.01 {
+			ref.Err = errors.New("bad status code: 500")
+		}
+		resp := candidate(rng, ref)
+		resp.client = clients[1]
+		c.Observe([]Response{ref, resp})
+	}
+	// Later pairs don't change the verdict
+	c.Observe([]Response{{client: clients[0]}, {client: clients[1], Err: errors.New("oops")}})
+	if verdicts > 1 {
+		t.Errorf("got %d verdicts; want 1", verdicts)
+	}
+	return c.Finish(), n
+}
+
+func TestCanary(t *testing.T) {
+	tests := []struct {
+		name      string
+		candidate func(rng *rand.Rand, ref Response) Response
+		want      string
+		max       int // Pairs to reach the verdict within
+	}{
+		{
+			name: "same",
+			candidate: func(rng *rand.Rand, ref Response) Response {
+				return Response{Err: ref.Err, Elapsed: ref.Elapsed + time.Duration(rng.Intn(10)-5)*time.Millisecond}
+			},
+			want: canaryPass,
+			max:  50000,
+		},
+		{
+			name: "errors",
+			candidate: func(rng *rand.Rand, ref Response) Response {
+				resp := Response{Elapsed: ref.Elapsed}
+				if rng.Float64() < 0.1 {
+					resp.Err = errors.New("bad status code: 502")
+				}
+				return resp
+			},
+			want: canaryFail,
+			max:  2000,
+		},
+		{
+			name: "slower",
+			candidate: func(rng *rand.Rand, ref Response) Response {
+				return Response{Err: ref.Err, Elapsed: ref.Elapsed * 3 / 2}
+			},
+			want: canaryFail,
+			max:  500,
+		},
+		{
+			name: "undecided",
+			candidate: func(rng *rand.Rand, ref Response) Response {
+				return Response{Err: ref.Err, Elapsed: ref.Elapsed}
+			},
+			want: canaryInconclusive,
+			max:  50,
+		},
+	}
+	for _, tc := range tests {
+		got, n := runCanary(t, tc.candidate, tc.max)
+		if got != tc.want {
+			t.Errorf("%s: got %s after %d pairs; want %s within %d", tc.name, got, n, tc.want, tc.max)
+		}
+	}
+}
+
+func TestSequentialMeanBounds(t *testing.T) {
+	var m sequentialMean
+	prev := 2.0
+	for i := 0; i < 10000; i++ {
+		m.Add(float64(i % 2))
+		low, high := m.Bounds(0.025)
+		if low > 0.5 || high < 0.5 {
+			t.Fatalf("after %d: got [%f, %f]; want to contain 0.5", i+1, low, high)
+		}
+		if i > 100 && high-low > prev {
+			t.Fatalf("after %d: got width %f; want narrower than %f", i+1, high-low, prev)
+		}
+		prev = high - low
+	}
+}
+
+func TestRenderCanary(t *testing.T) {
+	var buf bytes.Buffer
+	renderCanary(&buf, palette{}, &canarySummary{
+		Verdict:    canaryFail,
+		Confidence: 95,
+		Candidates: []canaryCandidateSummary{{
+			Endpoint: "http://candidate/",
+			Verdict:  canaryFail,
+			Errors:   canaryMetricSummary{Pairs: 200, Estimate: 9.5, Low: 4.1, High: 14.9, Margin: 0.5, Verdict: canaryFail},
+			Latency:  canaryMetricSummary{Pairs: 180, Estimate: 1.01, Low: 0.95, High: 1.07, Margin: 1.1, Verdict: canaryPending},
+		}},
+	})
+	got := buf.String()
+	for _, want := range []string{
+		"** Canary verdict: FAIL at 95% confidence",
+		`1. "http://candidate/": FAIL`,
+		"Errors:   +9.50pp (+4.10pp to +14.90pp) over 200 requests, margin +0.50pp: FAIL",
+		"Latency:  1.010x (0.950x to 1.070x) over 180 requests, margin 1.100x: PENDING",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got:\n%s\nwant it to contain %q", got, want)
+		}
+	}
+}
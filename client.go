@@ -6,6 +6,7 @@ import (
 	"io"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -21,7 +22,15 @@ type clientStats struct {
 	timeErrors time.Duration // Duration of error responses specifically
 	errors     map[string]int
 
+	numCached int // Number of responses served from the cache
+
+	numReconnects int // Number of times a broken connection was dialed again
+
+	bytesReceived int // Total size of bodies as received
+	bytesDecoded  int // Total size of bodies after content decoding
+
 	timing histogram
+	phases phaseStats // Of successful HTTP requests
 }
 
 func (stats *clientStats) Count(err error, elapsed time.Duration) {
@@ -41,7 +50,51 @@ func (stats *clientStats) Count(err error, elapsed time.Duration) {
 	}
 }
 
-func (stats *clientStats) Render(w io.Writer) error {
+// CountPhases records the phases of a successful HTTP request.
+func (stats *clientStats) CountPhases(p httpPhases) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.phases.Add(p, stats.timing.Limit)
+}
+
+// CountCached records a response that was served from the cache, which
+// doesn't count towards the timing.
+func (stats *clientStats) CountCached() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.numCached += 1
+}
+
+// LimitSamples bounds the latencies kept for percentiles to a uniform sample
+// of at most limit.
+func (stats *clientStats) LimitSamples(limit int) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.timing.Shrink(limit)
+}
+
+// CountReconnect records that a broken connection was dialed again, which
+// doesn't count towards the timing of the request that waited for it.
+func (stats *clientStats) CountReconnect() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.numReconnects += 1
+}
+
+// CountSize records the size of a response body as received and after
+// content decoding.
+func (stats *clientStats) CountSize(received, decoded int) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.bytesReceived += received
+	stats.bytesDecoded += decoded
+}
+
+func (stats *clientStats) Render(w io.Writer, colors palette) error {
 	// TODO: Use templating?
 	// TODO: Support JSON
 	if stats.numTotal == 0 {
@@ -67,6 +120,15 @@ func (stats *clientStats) Render(w io.Writer) error {
 	fmt.Fprintf(w, "\n")
 	fmt.Fprintf(w, "   Timing:     %0.4fs avg, %0.4fs min, %0.4fs max\n", stats.timing.Average(), stats.timing.Min(), stats.timing.Max())
 	fmt.Fprintf(w, "               %0.4fs standard deviation\n", stddev)
+	stats.phases.Render(w)
+
+	if stats.bytesReceived > 0 {
+		fmt.Fprintf(w, "   Size:       %s avg received, %s avg decoded", formatBytes(stats.bytesReceived/stats.numTotal), formatBytes(stats.bytesDecoded/stats.numTotal))
+		if stats.bytesDecoded > stats.bytesReceived {
+			fmt.Fprintf(w, " (%0.1fx compression)", float64(stats.bytesDecoded)/float64(stats.bytesReceived))
+		}
+		fmt.Fprintf(w, "\n")
+	}
 
 	fmt.Fprintf(w, "\n   Percentiles:\n")
 	buckets := []int{25, 50, 75, 90, 95, 99}
@@ -75,7 +137,14 @@ func (stats *clientStats) Render(w io.Writer) error {
 		fmt.Fprintf(w, "     %d%% in %0.4fs\n", bucket, percentiles[i])
 	}
 
-	fmt.Fprintf(w, "\n   Errors: %0.2f%%\n", errRate)
+	if stats.numCached > 0 {
+		fmt.Fprintf(w, "\n   Cached:     %d responses served from cache\n", stats.numCached)
+	}
+	if stats.numReconnects > 0 {
+		fmt.Fprintf(w, "\n   Reconnects: %d broken connections dialed again\n", stats.numReconnects)
+	}
+
+	fmt.Fprintf(w, "\n   Errors: %s\n", colors.Errors(fmt.Sprintf("%0.2f%%", errRate), errRate))
 
 	for msg, num := range stats.errors {
 		fmt.Fprintf(w, "     %d × %q\n", num, msg)
@@ -84,9 +153,31 @@ func (stats *clientStats) Render(w io.Writer) error {
 	return nil
 }
 
+// formatBytes returns a human-readable size, such as "1.5KB".
+func formatBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%0.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func NewClient(endpoint string, concurrency int) (*Client, error) {
+	profile, err := parseClientProfile(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Concurrency > 0 {
+		concurrency = profile.Concurrency
+	}
 	c := Client{
 		Endpoint:    endpoint,
+		Name:        profile.Name,
 		Concurrency: concurrency,
 		In:          make(chan Request, 2*concurrency),
 	}
@@ -109,11 +200,76 @@ func NewClients(endpoints []string, concurrency int, timeout time.Duration) (Cli
 
 type Client struct {
 	Endpoint    string
-	Concurrency int           // Number of goroutines to make requests with. Must be >=1.
-	Timeout     time.Duration // Timeout of each request
+	Name        string         // Label of the endpoint in reports, optional
+	Concurrency int            // Number of goroutines to make requests with. Must be >=1.
+	Timeout     time.Duration  // Timeout of each request
+	Encoding    string         // Accept-Encoding of HTTP requests
+	HashBodies  bool           // Compare hashes of bodies instead of keeping them
+	Cookies     bool           // Keep a cookie jar per goroutine
+	NoKeepAlive bool           // Open a new HTTP connection for every request
+	Redirects   bool           // Compare the redirect chains of HTTP responses
+	SessionKey  string         // Request field used to pin sessions to a goroutine
+	Extractor   *extractor     // Values extracted from this endpoint's responses, optional
+	RewriteID   bool           // Rewrite JSON-RPC ids on send and restore them in responses
+	Normalizers normalizers    // Applied to response bodies before comparison
+	Proto       *protoCodec    // Decodes protobuf response bodies, optional
+	Cache       *responseCache // Serves repeated requests from a cache, optional
+	MaxBodySize int            // Limit of response body bytes kept in memory, 0 is unlimited
+	Oversize    string         // Policy for bodies over MaxBodySize
+	SpillSize   int            // Bodies larger than this are spilled to disk, 0 never spills
+	SpillDir    string         // Directory of spilled bodies, or the default temporary directory
+
+	PingInterval time.Duration // Keepalive of idle websocket and MQTT connections, 0 never pings
+
+	Subscriptions subscriptionOptions
+
+	// QueueFull is what Send does when the client's queue is full: wait for
+	// room, holding back every client (block), or shed the request (drop)
+	QueueFull string
+
+	Joined time.Time // When the client joined mid-run, zero for the initial clients
 
 	In    chan Request
 	Stats clientStats
+
+	behind  int64 // Requests sent to the client and not answered yet, accessed atomically
+	blocked int64 // Nanoseconds that Send waited for room in In, accessed atomically
+	shed    int64 // Requests dropped because In was full, accessed atomically
+
+	outMu sync.Mutex
+	out   chan<- Response // Set once serving, for the responses of shed requests
+}
+
+// Behind returns the number of requests sent to the client that it hasn't
+// answered yet, whether queued or in flight: how far it is behind the input.
+func (client *Client) Behind() int {
+	return int(atomic.LoadInt64(&client.behind))
+}
+
+// Queued returns the number of requests waiting in the client's queue.
+func (client *Client) Queued() int {
+	return len(client.In)
+}
+
+// Shed returns the number of requests that were dropped because the client's
+// queue was full.
+func (client *Client) Shed() int {
+	return int(atomic.LoadInt64(&client.shed))
+}
+
+// Blocked returns how long sending requests waited for room in the client's
+// queue, holding back every client, because the client was too slow.
+func (client *Client) Blocked() time.Duration {
+	return time.Duration(atomic.LoadInt64(&client.blocked))
+}
+
+// Label returns the name of the client in reports, like endpointLabel, or ""
+// without a client.
+func (client *Client) Label() string {
+	if client == nil {
+		return ""
+	}
+	return endpointLabel(client.Name, client.Endpoint)
 }
 
 func (client *Client) Handle(req Request) {
@@ -129,42 +285,271 @@ func (client *Client) Serve(ctx context.Context, out chan<- Response) error {
 	}
 
 	logger.Debug().Str("endpoint", client.Endpoint).Int("concurrency", client.Concurrency).Msg("starting client")
+	client.outMu.Lock()
+	client.out = out
+	client.outMu.Unlock()
+	defer func() {
+		// out is closed once the clients have shut down
+		client.outMu.Lock()
+		client.out = nil
+		client.outMu.Unlock()
+	}()
+
+	if client.SessionKey == "" {
+		for i := 0; i < client.Concurrency; i++ {
+			g.Go(func() error {
+				return client.work(ctx, client.In, out)
+			})
+		}
+		return g.Wait()
+	}
 
-	for i := 0; i < client.Concurrency; i++ {
+	// Session affinity: each worker gets its own queue, and requests are
+	// routed by their session key.
+	workers := make([]chan Request, client.Concurrency)
+	for i := range workers {
+		in := make(chan Request, 2)
+		workers[i] = in
 		g.Go(func() error {
-			// Consume requests
-			t, err := NewTransport(client.Endpoint, client.Timeout)
-			if err != nil {
-				return err
+			return client.work(ctx, in, out)
+		})
+	}
+	g.Go(func() error {
+		return client.dispatch(ctx, workers)
+	})
+
+	return g.Wait()
+}
+
+// dispatch routes requests from the client's queue to the worker that owns
+// the request's session.
+func (client *Client) dispatch(ctx context.Context, workers []chan Request) error {
+	finalized := 0
+	next := 0
+	for {
+		var req Request
+		select {
+		case <-ctx.Done():
+			return nil
+		case req = <-client.In:
+		}
+
+		var w chan Request
+		if req.ID == -1 {
+			// Each worker gets exactly one final request
+			w = workers[finalized]
+			finalized += 1
+		} else if key := sessionKey(req.Line, client.SessionKey); key != "" {
+			w = workers[sessionWorker(key, len(workers))]
+		} else {
+			// No session, round robin
+			w = workers[next%len(workers)]
+			next += 1
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case w <- req:
+		}
+
+		if finalized >= len(workers) {
+			return nil
+		}
+	}
+}
+
+// transport creates a transport for the endpoint with the client's options.
+func (client *Client) transport() (Transport, error) {
+	t, err := NewTransport(client.Endpoint, transportOptions{
+		Timeout:        client.Timeout,
+		AcceptEncoding: client.Encoding,
+		HashBodies:     client.HashBodies,
+		NoKeepAlive:    client.NoKeepAlive,
+		Redirects:      client.Redirects,
+		MaxBodySize:    client.MaxBodySize,
+		Oversize:       client.Oversize,
+		SpillSize:      client.SpillSize,
+		SpillDir:       client.SpillDir,
+		PingInterval:   client.PingInterval,
+		Subscriptions:  client.Subscriptions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if client.Cookies {
+		if err := enableCookies(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// work consumes requests from in until it receives a final request.
+func (client *Client) work(ctx context.Context, in <-chan Request, out chan<- Response) error {
+	t, err := client.transport()
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Debug().Str("endpoint", client.Endpoint).Msg("aborting client")
+			return nil
+		case req := <-in:
+			if req.ID == -1 {
+				// Final request received, shutdown
+				logger.Debug().Str("endpoint", client.Endpoint).Msg("received final request, shutting down")
+				return nil
+			}
+			if client.Extractor != nil {
+				req.Line = client.Extractor.Expand(req.Line)
+				if req.Path != "" {
+					req.Path = string(client.Extractor.Expand([]byte(req.Path)))
+				}
+			}
+			if req.Delay > 0 && !sleep(ctx, req.Delay) {
+				return nil
 			}
-			for {
-				select {
-				case <-ctx.Done():
-					logger.Debug().Str("endpoint", client.Endpoint).Msg("aborting client")
+			t = client.reconnect(t)
+			var resp Response
+			if req.Abandon > 0 {
+				resp = client.abandon(ctx, t, req)
+			} else if cached, ok := client.cached(req); ok {
+				resp = cached
+			} else {
+				resp = client.do(ctx, t, req)
+				if ctx.Err() != nil {
+					// Aborted mid-request, the response is meaningless
 					return nil
-				case req := <-client.In:
-					if req.ID == -1 {
-						// Final request received, shutdown
-						logger.Debug().Str("endpoint", client.Endpoint).Msg("received final request, shutting down")
-						return nil
-					}
-					resp := req.Do(t)
-					client.Stats.Count(resp.Err, resp.Elapsed)
-					select {
-					case out <- resp:
-					default:
-						logger.Warn().Msg("response channel is overloaded, please open an issue")
-						out <- resp
-					}
+				}
+				if client.Cache != nil && resp.Err == nil && resp.Spilled == "" {
+					client.Cache.Put(req.cacheKey(), resp)
+				}
+				client.Stats.Count(resp.Err, resp.Elapsed)
+				if resp.Err == nil && resp.Phases != nil {
+					client.Stats.CountPhases(*resp.Phases)
 				}
 			}
-		})
+			if client.Extractor != nil && resp.Err == nil {
+				client.Extractor.Extract(resp.Body)
+			}
+			select {
+			case out <- resp:
+			default:
+				logger.Warn().Msg("response channel is overloaded, please open an issue")
+				out <- resp
+			}
+			atomic.AddInt64(&client.behind, -1)
+			if req.answered != nil {
+				req.answered.Done()
+			}
+		}
 	}
+}
 
-	return g.Wait()
+// reconnect returns a new transport in place of one whose connection broke,
+// so that a connection closed by the endpoint fails the requests in flight
+// rather than every later one. It returns the transport as it is if it
+// isn't broken, or if dialing again fails.
+func (client *Client) reconnect(t Transport) Transport {
+	fresh := client.redial(t)
+	if fresh != t {
+		client.Stats.CountReconnect()
+	}
+	return fresh
 }
 
-var id requestID
+// redial is reconnect without counting the reconnect in the client's stats,
+// for connections of its own, such as the rechecks'.
+func (client *Client) redial(t Transport) Transport {
+	conn, ok := t.(Connected)
+	if !ok || !conn.Broken() {
+		return t
+	}
+	conn.Close()
+	fresh, err := client.transport()
+	if err != nil {
+		logger.Warn().Err(err).Str("endpoint", client.Endpoint).Msg("failed to reconnect")
+		return t
+	}
+	logger.Debug().Str("endpoint", client.Endpoint).Msg("reconnected")
+	return fresh
+}
+
+// abandon sends the request and gives up on it after its abandon duration,
+// like a client that disconnects early.
+func (client *Client) abandon(ctx context.Context, t Transport, req Request) Response {
+	ctx, cancel := context.WithTimeout(ctx, req.Abandon)
+	defer cancel()
+	resp := req.Do(ctx, t)
+	resp.Abandoned = true
+	return resp
+}
+
+// cached returns the cached response for the request, if the client has a
+// cache.
+func (client *Client) cached(req Request) (Response, bool) {
+	if client.Cache == nil {
+		return Response{}, false
+	}
+	resp, ok := client.Cache.Get(req.cacheKey())
+	if !ok {
+		return Response{}, false
+	}
+	resp.Request = &req
+	resp.ID = req.ID
+	resp.Elapsed = 0
+	resp.Cached = true
+	client.Stats.CountCached()
+	return resp, true
+}
+
+// do sends the request with the transport and processes the response body
+// for comparison, counting its size in the client's stats.
+func (client *Client) do(ctx context.Context, t Transport, sent Request) Response {
+	return client.exchange(ctx, t, sent, true)
+}
+
+// exchange is do, counting the size of the response only if counted is set.
+func (client *Client) exchange(ctx context.Context, t Transport, sent Request, counted bool) Response {
+	// Pooled until the response is released, once its set is compared
+	req := pooledRequest(sent)
+	var rw *idRewrite
+	if client.RewriteID {
+		if line, r, err := rewriteIDs(req.Line, req.rpcID()); err == nil {
+			req.Line, req.original, rw = line, req.Line, r
+		}
+	}
+	resp := req.Do(ctx, t)
+	resp.ownsRequest = true
+	if resp.Err == nil {
+		resp.Err = decodeBody(&resp)
+	}
+	switch {
+	case !counted:
+	case resp.Oversize > 0:
+		client.Stats.CountSize(resp.Size, len(resp.Body)+resp.Oversize)
+	case resp.Hash != "":
+		client.Stats.CountSize(resp.Size, resp.HashSize)
+	default:
+		client.Stats.CountSize(resp.Size, len(resp.Body))
+	}
+	if resp.Oversize > 0 || resp.Spilled != "" {
+		// Partial or spilled bodies can't be decoded or normalized
+		return resp
+	}
+	if client.Proto != nil && resp.Err == nil {
+		resp.Body, resp.Err = client.Proto.Decode(resp.Body)
+	}
+	if rw != nil && resp.Err == nil {
+		resp.Body, resp.Err = rw.Restore(resp.Body)
+	}
+	if resp.Err == nil {
+		resp.Body = client.Normalizers.Apply(resp.Body)
+	}
+	return resp
+}
 
 type Clients []*Client
 
@@ -181,17 +566,31 @@ func (c Clients) Finalize() {
 	}
 }
 
-func (c Clients) Send(ctx context.Context, line []byte) error {
-	id += 1
+// Send sends a copy of the request to every client. The request's ID is
+// assigned by the caller, so that every group gets the same one.
+func (c Clients) Send(ctx context.Context, req Request) error {
 	for _, client := range c {
+		req := req
+		req.client = client
+		req.Timestamp = time.Now()
+		req.Peers = len(c)
+		if req.answered != nil {
+			req.answered.Add(1)
+		}
+		atomic.AddInt64(&client.behind, 1)
 		select {
-		case client.In <- Request{
-			client: client,
-			ID:     id,
-
-			Line:      line,
-			Timestamp: time.Now(),
-		}:
+		case client.In <- req:
+			continue
+		default:
+		}
+		if client.QueueFull == "drop" && client.drop(ctx, req) {
+			continue
+		}
+		// The client's queue is full, it holds back the others
+		blockedAt := time.Now()
+		select {
+		case client.In <- req:
+			atomic.AddInt64(&client.blocked, int64(time.Since(blockedAt)))
 		case <-ctx.Done():
 			return ctx.Err()
 		}
@@ -199,6 +598,80 @@ func (c Clients) Send(ctx context.Context, line []byte) error {
 	return nil
 }
 
+// drop sheds a request that doesn't fit in the client's queue, answering it
+// with a shed response so that its response set completes. It returns false
+// if the client isn't serving.
+func (client *Client) drop(ctx context.Context, req Request) bool {
+	// Held while sending, so that Serve can't return and out be closed
+	client.outMu.Lock()
+	defer client.outMu.Unlock()
+	if client.out == nil {
+		return false
+	}
+	atomic.AddInt64(&client.behind, -1)
+	atomic.AddInt64(&client.shed, 1)
+	if ctx.Err() == nil {
+		select {
+		case client.out <- Response{client: client, Request: &req, ID: req.ID, Shed: true}:
+		case <-ctx.Done():
+		}
+	}
+	if req.answered != nil {
+		req.answered.Done()
+	}
+	return true
+}
+
+// Transports creates a transport for every client, in the same order, and
+// returns a function that closes them.
+func (c Clients) Transports() ([]Transport, func(), error) {
+	transports := make([]Transport, 0, len(c))
+	closeAll := func() {
+		for _, t := range transports {
+			if closer, ok := t.(io.Closer); ok {
+				closer.Close()
+			}
+		}
+	}
+	for _, client := range c {
+		t, err := client.transport()
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("failed to create transport for %s: %w", client.Endpoint, err)
+		}
+		transports = append(transports, t)
+	}
+	return transports, closeAll, nil
+}
+
+// Do sends the request to every client concurrently, each with its transport
+// of transports, and returns the responses in the order of the clients.
+func (c Clients) Do(ctx context.Context, transports []Transport, req Request) []Response {
+	resps := make([]Response, len(c))
+	var wg sync.WaitGroup
+	for i, client := range c {
+		wg.Add(1)
+		go func(i int, client *Client) {
+			defer wg.Done()
+			req := req
+			req.client = client
+			req.Peers = len(c)
+			if client.Extractor != nil {
+				req.Line = client.Extractor.Expand(req.Line)
+				if req.Path != "" {
+					req.Path = string(client.Extractor.Expand([]byte(req.Path)))
+				}
+			}
+			resps[i] = client.do(ctx, transports[i], req)
+			if client.Extractor != nil && resps[i].Err == nil {
+				client.Extractor.Extract(resps[i].Body)
+			}
+		}(i, client)
+	}
+	wg.Wait()
+	return resps
+}
+
 func (clients Clients) Serve(ctx context.Context, out chan Response) error {
 	g, ctx := errgroup.WithContext(ctx)
 
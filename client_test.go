@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClientsSendBackpressure(t *testing.T) {
+	fast, slow := &Client{In: make(chan Request, 1)}, &Client{In: make(chan Request, 1)}
+	clients := Clients{fast, slow}
+	if err := clients.Send(context.Background(), Request{Line: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	<-fast.In // Answered right away, slow still has a queued request
+
+	sent := make(chan error)
+	go func() { sent <- clients.Send(context.Background(), Request{Line: []byte(`{}`)}) }()
+	time.Sleep(20 * time.Millisecond)
+	<-slow.In
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+
+	if got := slow.Blocked(); got < 20*time.Millisecond {
+		t.Errorf("slow: got %s blocked; want at least 20ms", got)
+	}
+	if got := fast.Blocked(); got != 0 {
+		t.Errorf("fast: got %s blocked; want none", got)
+	}
+	if got, want := slow.Behind(), 2; got != want {
+		t.Errorf("slow: got %d behind; want %d", got, want)
+	}
+	if got, want := slow.Queued(), 1; got != want {
+		t.Errorf("slow: got %d queued; want %d", got, want)
+	}
+}
+
+func TestClientsSendDrop(t *testing.T) {
+	out := make(chan Response, 10)
+	fast := &Client{In: make(chan Request, 1), QueueFull: "drop", out: out}
+	stalled := &Client{In: make(chan Request, 1), QueueFull: "drop", out: out}
+	clients := Clients{fast, stalled}
+	for i := 0; i < 3; i++ {
+		if err := clients.Send(context.Background(), Request{Line: []byte(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+		req := <-fast.In
+		out <- Response{client: fast, Request: &req, ID: req.ID, Body: []byte(`{}`)}
+	}
+	if got, want := stalled.Shed(), 2; got != want {
+		t.Errorf("got %d shed; want %d", got, want)
+	}
+	if got, want := stalled.Behind(), 1; got != want {
+		t.Errorf("got %d behind; want %d", got, want)
+	}
+
+	// The sets of shed requests complete without being compared
+	r := &report{Clients: clients}
+	r.init()
+	close(out)
+	for resp := range out {
+		if err := r.handle(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.completed != 2 || r.shed != 2 || r.mismatched != 0 || r.requests != 3 {
+		t.Errorf("got: %d completed, %d shed, %d mismatched, %d requests; want: 2, 2, 0, 3", r.completed, r.shed, r.mismatched, r.requests)
+	}
+}
+
+func TestClientDropAfterServe(t *testing.T) {
+	clients, err := NewClients([]string{"noop://a"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := clients[0]
+	client.QueueFull = "drop"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out := make(chan Response)
+	if err := client.Serve(ctx, out); err != nil && err != context.Canceled {
+		t.Fatal(err)
+	}
+	// Closed like the client set does once its clients have shut down
+	close(out)
+	if client.drop(context.Background(), Request{Line: []byte(`{}`)}) {
+		t.Errorf("got shed after serving; want not shed")
+	}
+}
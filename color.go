@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultColorThreshold is the error and mismatch rate, in percent, at which
+// rates are shown in red when no alert threshold is set.
+const defaultColorThreshold = 1.0
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// palette colors the text report when it's written to a terminal. The zero
+// palette leaves it plain.
+type palette struct {
+	Enabled      bool
+	ErrorRate    float64 // Percent at which error rates are red
+	MismatchRate float64 // Percent at which mismatch rates are red
+}
+
+// newPalette returns the palette of a report written to f: colored if it's
+// a terminal, unless colors are turned off with --no-color, NO_COLOR or a
+// dumb terminal. Thresholds of 0 are the default.
+func newPalette(f *os.File, noColor bool, errorRate, mismatchRate float64) palette {
+	p := palette{ErrorRate: errorRate, MismatchRate: mismatchRate}
+	if p.ErrorRate <= 0 {
+		p.ErrorRate = defaultColorThreshold
+	}
+	if p.MismatchRate <= 0 {
+		p.MismatchRate = defaultColorThreshold
+	}
+	p.Enabled = !noColor && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && isTerminal(f)
+	return p
+}
+
+// isTerminal returns whether the file is a character device, such as a
+// terminal, rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p palette) paint(code, s string) string {
+	if !p.Enabled || code == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// Heading returns s in bold.
+func (p palette) Heading(s string) string {
+	return p.paint(ansiBold, s)
+}
+
+// Warning returns s in yellow.
+func (p palette) Warning(s string) string {
+	return p.paint(ansiYellow, s)
+}
+
+// rateColor is green for a zero rate, yellow under the threshold, and red at
+// or over it.
+func rateColor(rate, threshold float64) string {
+	switch {
+	case rate <= 0:
+		return ansiGreen
+	case rate < threshold:
+		return ansiYellow
+	}
+	return ansiRed
+}
+
+// Errors returns s in the color of the error rate.
+func (p palette) Errors(s string, rate float64) string {
+	return p.paint(rateColor(rate, p.ErrorRate), s)
+}
+
+// Mismatches returns s in the color of the mismatch rate.
+func (p palette) Mismatches(s string, rate float64) string {
+	return p.paint(rateColor(rate, p.MismatchRate), s)
+}
+
+// tableCell is a cell of an aligned table, with the color it's painted in.
+type tableCell struct {
+	Text  string
+	Color string
+}
+
+// renderTable writes rows as aligned columns, the first two aligned left and
+// the others right. Columns are aligned by their text, so colors don't shift
+// them.
+func renderTable(w io.Writer, p palette, indent string, rows [][]tableCell) {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell.Text); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	for _, row := range rows {
+		var line strings.Builder
+		line.WriteString(indent)
+		for i, cell := range row {
+			pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell.Text))
+			if i > 0 {
+				line.WriteString("  ")
+			}
+			if i < 2 {
+				line.WriteString(p.paint(cell.Color, cell.Text))
+				if i < len(row)-1 {
+					line.WriteString(pad)
+				}
+			} else {
+				line.WriteString(pad)
+				line.WriteString(p.paint(cell.Color, cell.Text))
+			}
+		}
+		fmt.Fprintln(w, line.String())
+	}
+}
+
+// renderComparison writes the main stats of every endpoint side by side.
+func renderComparison(w io.Writer, p palette, endpoints []endpointSummary) {
+	rows := [][]tableCell{{
+		{Text: "#", Color: ansiBold}, {Text: "Endpoint", Color: ansiBold},
+		{Text: "Requests", Color: ansiBold}, {Text: "Errors", Color: ansiBold},
+		{Text: "RPS", Color: ansiBold}, {Text: "Avg", Color: ansiBold},
+		{Text: "p50", Color: ansiBold}, {Text: "p99", Color: ansiBold},
+	}}
+	for i, e := range endpoints {
+		rows = append(rows, []tableCell{
+			{Text: fmt.Sprintf("%d.", i)},
+			{Text: e.label()},
+			{Text: fmt.Sprintf("%d", e.Requests)},
+			{Text: fmt.Sprintf("%0.2f%%", e.ErrorRate), Color: rateColor(e.ErrorRate, p.ErrorRate)},
+			{Text: fmt.Sprintf("%0.2f", e.RPS)},
+			{Text: fmt.Sprintf("%0.4fs", e.Timing.Avg)},
+			{Text: fmt.Sprintf("%0.4fs", e.Timing.Percentiles["50"])},
+			{Text: fmt.Sprintf("%0.4fs", e.Timing.Percentiles["99"])},
+		})
+	}
+	fmt.Fprintf(w, "\n%s\n", p.Heading("** Comparison:"))
+	renderTable(w, p, "   ", rows)
+}
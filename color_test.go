@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRenderTable(t *testing.T) {
+	rows := [][]tableCell{
+		{{Text: "#"}, {Text: "Endpoint"}, {Text: "Errors"}},
+		{{Text: "0."}, {Text: "a"}, {Text: "0.00%", Color: ansiGreen}},
+		{{Text: "1."}, {Text: "long-name"}, {Text: "12.50%", Color: ansiRed}},
+	}
+	tests := []struct {
+		colors palette
+		want   []string
+	}{
+		{palette{}, []string{
+			"  #   Endpoint   Errors",
+			"  0.  a           0.00%",
+			"  1.  long-name  12.50%",
+		}},
+		{palette{Enabled: true}, []string{
+			"  #   Endpoint   Errors",
+			"  0.  a           \x1b[32m0.00%\x1b[0m",
+			"  1.  long-name  \x1b[31m12.50%\x1b[0m",
+		}},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		renderTable(&buf, tc.colors, "  ", rows)
+		if got, want := buf.String(), strings.Join(tc.want, "\n")+"\n"; got != want {
+			t.Errorf("got:\n%q\nwant:\n%q", got, want)
+		}
+	}
+}
+
+func TestRateColor(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want string
+	}{
+		{0, ansiGreen},
+		{0.5, ansiYellow},
+		{1, ansiRed},
+		{50, ansiRed},
+	}
+	for _, tc := range tests {
+		if got := rateColor(tc.rate, 1); got != tc.want {
+			t.Errorf("%0.2f: got: %q; want: %q", tc.rate, got, tc.want)
+		}
+	}
+}
+
+func TestPaletteNotTerminal(t *testing.T) {
+	f, err := ioutil.TempFile("", "versus-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	p := newPalette(f, false, 0, 5)
+	if p.Enabled {
+		t.Errorf("expected colors to be disabled for a file")
+	}
+	if p.ErrorRate != defaultColorThreshold || p.MismatchRate != 5 {
+		t.Errorf("got thresholds: %v, %v; want: %v, 5", p.ErrorRate, p.MismatchRate, defaultColorThreshold)
+	}
+	if got := p.Errors("1%", 1); got != "1%" {
+		t.Errorf("got: %q; want plain text", got)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// bodyKind is the family of a response Content-Type that decides how bodies
+// are compared.
+type bodyKind int
+
+const (
+	kindUnknown bodyKind = iota
+	kindJSON
+	kindXML
+	kindForm
+	kindText
+)
+
+// contentKind returns the kind of body based on the Content-Type header.
+func contentKind(header http.Header) bodyKind {
+	if header == nil {
+		return kindUnknown
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return kindUnknown
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return kindJSON
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return kindXML
+	case mediaType == "application/x-www-form-urlencoded":
+		return kindForm
+	case strings.HasPrefix(mediaType, "text/"):
+		return kindText
+	}
+	return kindUnknown
+}
+
+// bodiesEqual compares two bodies according to their kind. ok is false if
+// there is no comparator for the kind and the caller should fall back to the
+// default comparison.
+func bodiesEqual(kind bodyKind, a, b []byte) (equal bool, ok bool) {
+	switch kind {
+	case kindXML:
+		return xmlEqual(a, b), true
+	case kindForm:
+		return formEqual(a, b), true
+	case kindText:
+		return textEqual(a, b), true
+	}
+	return false, false
+}
+
+// textEqual compares text ignoring surrounding whitespace and line endings.
+func textEqual(a, b []byte) bool {
+	normalize := func(text []byte) []byte {
+		return bytes.TrimSpace(bytes.ReplaceAll(text, []byte("\r\n"), []byte("\n")))
+	}
+	return bytes.Equal(normalize(a), normalize(b))
+}
+
+// formEqual compares form-encoded bodies by their keys and values,
+// regardless of key order.
+func formEqual(a, b []byte) bool {
+	aValues, err := url.ParseQuery(strings.TrimSpace(string(a)))
+	if err != nil {
+		return false
+	}
+	bValues, err := url.ParseQuery(strings.TrimSpace(string(b)))
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(aValues, bValues)
+}
+
+// xmlNode is a normalized XML element: attributes are sorted, whitespace
+// between elements is dropped, and comments and processing instructions are
+// ignored.
+type xmlNode struct {
+	Name     xml.Name
+	Attrs    []xml.Attr
+	Text     string
+	Children []*xmlNode
+}
+
+func parseXML(body []byte) (*xmlNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			node := &xmlNode{Name: tok.Name}
+			for _, attr := range tok.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+					// Namespaces are already resolved into names
+					continue
+				}
+				node.Attrs = append(node.Attrs, attr)
+			}
+			sort.Slice(node.Attrs, func(i, j int) bool {
+				if node.Attrs[i].Name.Space != node.Attrs[j].Name.Space {
+					return node.Attrs[i].Name.Space < node.Attrs[j].Name.Space
+				}
+				return node.Attrs[i].Name.Local < node.Attrs[j].Name.Local
+			})
+			parent.Children = append(parent.Children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			parent.Text += strings.TrimSpace(string(tok))
+		}
+	}
+	return root, nil
+}
+
+// xmlEqual compares XML documents structurally.
+func xmlEqual(a, b []byte) bool {
+	aNode, err := parseXML(a)
+	if err != nil {
+		return false
+	}
+	bNode, err := parseXML(b)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(aNode, bNode)
+}
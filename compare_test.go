@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBodiesEqual(t *testing.T) {
+	tests := []struct {
+		ContentType string
+		A, B        string
+		Equal       bool
+	}{
+		{"text/xml; charset=utf-8", `<a x="1" y="2"><b>hi</b></a>`, `<?xml version="1.0"?>
+<a y="2" x="1">
+  <b>hi</b>
+</a>`, true},
+		{"application/soap+xml", `<s:Envelope xmlns:s="urn:s"><s:Body/></s:Envelope>`, `<env:Envelope xmlns:env="urn:s"><env:Body></env:Body></env:Envelope>`, true},
+		{"application/xml", `<a><b/><c/></a>`, `<a><c/><b/></a>`, false},
+		{"application/xml", `<a>1</a>`, `<a>2</a>`, false},
+		{"application/x-www-form-urlencoded", "a=1&b=2", "b=2&a=1\n", true},
+		{"application/x-www-form-urlencoded", "a=1&b=2", "a=1&b=3", false},
+		{"text/plain", "hello\r\nworld\n", "  hello\nworld", true},
+		{"text/plain", "hello", "world", false},
+	}
+
+	for i, tc := range tests {
+		header := http.Header{}
+		header.Set("Content-Type", tc.ContentType)
+		a := Response{Body: []byte(tc.A), Header: header}
+		b := Response{Body: []byte(tc.B), Header: header}
+		if got := a.Equal(b); got != tc.Equal {
+			t.Errorf("case %d: got: %t; want: %t", i, got, tc.Equal)
+		}
+	}
+}
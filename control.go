@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// feedControl paces the request feed, and lets it be paused, resumed and
+// finalized while running.
+type feedControl struct {
+	mu       sync.Mutex
+	rate     float64 // Requests per second, 0 is unlimited
+	next     time.Time
+	paused   bool
+	outside  bool          // Outside the replay windows
+	memory   bool          // Over the memory limit
+	resumed  chan struct{} // Closed once neither paused, outside the replay windows nor over the memory limit
+	finished bool
+
+	once      sync.Once
+	finalized chan struct{}
+}
+
+func newFeedControl(rate float64) *feedControl {
+	return &feedControl{
+		rate:      rate,
+		finalized: make(chan struct{}),
+	}
+}
+
+// SetRate changes the target rate of requests per second, 0 is unlimited.
+func (fc *feedControl) SetRate(rate float64) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.rate = rate
+	fc.next = time.Time{}
+}
+
+// Rate returns the target rate of requests per second.
+func (fc *feedControl) Rate() float64 {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.rate
+}
+
+// Pause stops the feed until Resume is called.
+func (fc *feedControl) Pause() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	held := fc.held()
+	fc.paused = true
+	fc.update(held)
+}
+
+// Resume continues a paused feed.
+func (fc *feedControl) Resume() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	held := fc.held()
+	fc.paused = false
+	fc.update(held)
+}
+
+// SetOutside holds the feed while it's outside the replay windows,
+// independently of Pause and Resume.
+func (fc *feedControl) SetOutside(outside bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	held := fc.held()
+	fc.outside = outside
+	fc.update(held)
+}
+
+// Outside returns whether the feed is outside the replay windows.
+func (fc *feedControl) Outside() bool {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.outside
+}
+
+// SetOverMemory holds the feed while versus is over its memory limit,
+// independently of Pause and Resume.
+func (fc *feedControl) SetOverMemory(over bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	held := fc.held()
+	fc.memory = over
+	fc.update(held)
+}
+
+// OverMemory returns whether the feed is held over the memory limit.
+func (fc *feedControl) OverMemory() bool {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.memory
+}
+
+func (fc *feedControl) held() bool {
+	return fc.paused || fc.outside || fc.memory
+}
+
+// update signals waiters of the feed once it's no longer held, given whether
+// it was held before the change.
+func (fc *feedControl) update(held bool) {
+	switch {
+	case !held && fc.held():
+		fc.resumed = make(chan struct{})
+	case held && !fc.held():
+		close(fc.resumed)
+	}
+}
+
+// Paused returns whether the feed is paused.
+func (fc *feedControl) Paused() bool {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.paused
+}
+
+// Finalize stops the feed as if the input had ended, so the run completes
+// and reports.
+func (fc *feedControl) Finalize() {
+	fc.once.Do(func() {
+		fc.mu.Lock()
+		fc.finished = true
+		fc.mu.Unlock()
+		close(fc.finalized)
+	})
+}
+
+// Done is closed when the feed is finalized.
+func (fc *feedControl) Done() <-chan struct{} {
+	return fc.finalized
+}
+
+// Finalized returns whether Finalize was called.
+func (fc *feedControl) Finalized() bool {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.finished
+}
+
+// Wait blocks until the next request may be sent. It returns false if the
+// feed was finalized in the meantime.
+func (fc *feedControl) Wait(ctx context.Context) (bool, error) {
+	for {
+		fc.mu.Lock()
+		if fc.finished {
+			fc.mu.Unlock()
+			return false, nil
+		}
+		if fc.held() {
+			resumed := fc.resumed
+			fc.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case <-fc.finalized:
+			case <-resumed:
+			}
+			continue
+		}
+		if fc.rate <= 0 {
+			fc.mu.Unlock()
+			return true, nil
+		}
+		now := time.Now()
+		if fc.next.Before(now) {
+			fc.next = now
+		}
+		wait := fc.next.Sub(now)
+		fc.next = fc.next.Add(time.Duration(float64(time.Second) / fc.rate))
+		fc.mu.Unlock()
+
+		if wait <= 0 {
+			return true, nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false, ctx.Err()
+		case <-fc.finalized:
+			timer.Stop()
+			return false, nil
+		case <-timer.C:
+			return true, nil
+		}
+	}
+}
+
+// controlStatus is the response of the control API.
+type controlStatus struct {
+	Rate      float64        `json:"rate"`
+	Paused    bool           `json:"paused"`
+	Outside   bool           `json:"outside_window,omitempty"`
+	Memory    bool           `json:"over_memory_limit,omitempty"`
+	Finalized bool           `json:"finalized"`
+	Stats     *reportSummary `json:"stats,omitempty"`
+}
+
+// serveControl serves the control API on addr until the returned shutdown
+// function is called:
+//
+//	GET  /stats           current stats and feed status
+//	POST /rate?rps=N      change the target rate, 0 is unlimited
+//	POST /pause           pause the feed
+//	POST /resume          resume the feed
+//	POST /finalize        stop the feed and report
+//	GET  /endpoints       active endpoints
+//	POST /endpoints?add=URL or ?remove=URL
+func serveControl(addr string, r *report, fc *feedControl, clients *clientSet) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for control API: %w", err)
+	}
+
+	status := func(w http.ResponseWriter, req *http.Request, withStats bool) {
+		s := controlStatus{
+			Rate:      fc.Rate(),
+			Paused:    fc.Paused(),
+			Outside:   fc.Outside(),
+			Memory:    fc.OverMemory(),
+			Finalized: fc.Finalized(),
+		}
+		if withStats {
+			summary, err := r.Snapshot(req.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			s.Stats = &summary
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+	post := func(fn func(w http.ResponseWriter, req *http.Request) bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if fn(w, req) {
+				status(w, req, false)
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, req *http.Request) {
+		status(w, req, true)
+	})
+	mux.HandleFunc("/rate", post(func(w http.ResponseWriter, req *http.Request) bool {
+		rate, err := strconv.ParseFloat(req.URL.Query().Get("rps"), 64)
+		if err != nil || rate < 0 {
+			http.Error(w, "rps must be a non-negative number", http.StatusBadRequest)
+			return false
+		}
+		logger.Info().Float64("rps", rate).Msg("control: changing rate")
+		fc.SetRate(rate)
+		return true
+	}))
+	mux.HandleFunc("/pause", post(func(w http.ResponseWriter, req *http.Request) bool {
+		logger.Info().Msg("control: pausing feed")
+		fc.Pause()
+		return true
+	}))
+	mux.HandleFunc("/resume", post(func(w http.ResponseWriter, req *http.Request) bool {
+		logger.Info().Msg("control: resuming feed")
+		fc.Resume()
+		return true
+	}))
+	mux.HandleFunc("/finalize", post(func(w http.ResponseWriter, req *http.Request) bool {
+		logger.Info().Msg("control: finalizing")
+		fc.Finalize()
+		return true
+	}))
+
+	mux.HandleFunc("/endpoints", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			var err error
+			if endpoint := req.URL.Query().Get("add"); endpoint != "" {
+				_, err = clients.Add(endpoint)
+			} else if endpoint := req.URL.Query().Get("remove"); endpoint != "" {
+				err = clients.Remove(endpoint)
+			} else {
+				err = fmt.Errorf("add or remove must be set")
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		var endpoints []string
+		for _, c := range clients.Active() {
+			endpoints = append(endpoints, c.Endpoint)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(endpoints)
+	})
+
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Error().Err(err).Msg("control API failed")
+		}
+	}()
+	logger.Info().Str("addr", ln.Addr().String()).Msg("serving control API")
+	return func() { srv.Close() }, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFeedControl(t *testing.T) {
+	ctx := context.Background()
+	fc := newFeedControl(100)
+
+	started := time.Now()
+	for i := 0; i < 5; i++ {
+		if ok, err := fc.Wait(ctx); !ok || err != nil {
+			t.Fatalf("got: %t, %v; want: true, nil", ok, err)
+		}
+	}
+	// The first request goes right away, then every 10ms
+	if elapsed := time.Since(started); elapsed < 40*time.Millisecond {
+		t.Errorf("5 requests at 100/s took %s; want at least 40ms", elapsed)
+	}
+
+	fc.SetRate(0)
+	fc.Pause()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		fc.Finalize()
+	}()
+	if ok, err := fc.Wait(ctx); ok || err != nil {
+		t.Errorf("got: %t, %v; want: false, nil after finalizing while paused", ok, err)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+// maxCorpusClasses is the number of distinct request classes counted by the
+// corpus subcommand. Further classes are counted together, so that inputs
+// with unbounded paths can't exhaust memory.
+const maxCorpusClasses = 1000
+
+// corpusSizeSample is the number of record sizes kept for the percentiles of
+// the size distribution.
+const corpusSizeSample = 100000
+
+// otherClass counts the requests of classes beyond maxCorpusClasses.
+const otherClass = "(other)"
+
+// CorpusOptions are the options of the corpus subcommand, which summarizes an
+// input before it's replayed.
+type CorpusOptions struct {
+	Input        string  `long:"input" description:"Where requests come from: - for stdin, a file path, or an s3://, gs:// or http(s):// URI. Gzipped input is decompressed." default:"-"`
+	InputFormat  string  `long:"input-format" description:"Format of input lines, as for a run." choice:"lines" choice:"envelope" default:"lines"`
+	InputFraming string  `long:"input-framing" description:"How input records are delimited, as for a run." choice:"lines" choice:"length" choice:"varint" default:"lines"`
+	Output       string  `long:"output" description:"Write the records to this file, in the input's format and framing, with --dedupe and --sample applied."`
+	Dedupe       bool    `long:"dedupe" description:"Leave out of --output the requests that repeat an earlier one, apart from their JSON-RPC ids."`
+	Sample       float64 `long:"sample" description:"Fraction of records to keep in --output, such as 0.1, reproducibly with --seed." default:"1"`
+	Seed         int64   `long:"seed" description:"Seed of the sample. (default: random)"`
+	Format       string  `long:"format" description:"Format of the summary." choice:"text" choice:"json" default:"text"`
+}
+
+// corpusMain runs the corpus subcommand with its arguments.
+func corpusMain(args []string) {
+	options := CorpusOptions{}
+	parser := flags.NewParser(&options, flags.Default)
+	parser.Name = "versus corpus"
+	if _, err := parser.ParseArgs(args); err != nil {
+		return
+	}
+	if options.Sample <= 0 || options.Sample > 1 {
+		exit(1, "--sample must be more than 0 and at most 1\n")
+	}
+	if err := runCorpus(context.Background(), options, os.Stdout); err != nil {
+		exit(2, "error during corpus: %s\n", err)
+	}
+}
+
+// runCorpus reads the whole input and writes its summary to w.
+func runCorpus(ctx context.Context, options CorpusOptions, w io.Writer) error {
+	parser := &inputParser{Envelope: options.InputFormat == "envelope"}
+	split, err := inputSplit(options.InputFraming)
+	if err != nil {
+		return err
+	}
+	input, err := openInput(ctx, options.Input)
+	if err != nil {
+		return fmt.Errorf("failed to open input: %w", err)
+	}
+	defer input.Close()
+
+	stats := newCorpusStats()
+	var out *corpusWriter
+	if options.Output != "" {
+		f, err := os.Create(options.Output)
+		if err != nil {
+			return fmt.Errorf("failed to create output: %w", err)
+		}
+		defer f.Close()
+		seed := options.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		out = &corpusWriter{
+			w:       bufio.NewWriter(f),
+			framing: options.InputFraming,
+			sample:  options.Sample,
+			rand:    rand.New(rand.NewSource(seed)),
+		}
+	}
+
+	stop := make(chan struct{})
+	lines, errc := scanLines(input, split, stop)
+	for line := range lines {
+		if len(line) == 0 {
+			// Ends the feed of a run too
+			close(stop)
+			break
+		}
+		req, err := parser.Parse(line)
+		if err != nil {
+			stats.invalid += 1
+			continue
+		}
+		dup := stats.Add(&req, len(line))
+		if out == nil || (dup && options.Dedupe) {
+			continue
+		}
+		if err := out.Write(line); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	if err := <-errc; err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+
+	summary := stats.Summary()
+	if out != nil {
+		if err := out.w.Flush(); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+		summary.Output, summary.Written = options.Output, out.written
+	}
+	if options.Format == "json" {
+		return json.NewEncoder(w).Encode(summary)
+	}
+	renderCorpus(w, summary)
+	return nil
+}
+
+// corpusStats summarizes the requests of an input.
+type corpusStats struct {
+	requests   int
+	bytes      int
+	duplicates int
+	invalid    int // Records that aren't valid requests
+	sizes      histogram
+	classes    map[string]*corpusClass
+	seen       map[uint64]struct{} // Hashes of the requests, ids aside
+}
+
+type corpusClass struct {
+	requests   int
+	bytes      int
+	duplicates int
+}
+
+func newCorpusStats() *corpusStats {
+	return &corpusStats{
+		sizes:   histogram{Limit: corpusSizeSample},
+		classes: map[string]*corpusClass{},
+		seen:    map[uint64]struct{}{},
+	}
+}
+
+// Add counts a request of the input, of a record of size bytes, and returns
+// whether it repeats an earlier request apart from its JSON-RPC ids. Requests
+// are remembered by a 64-bit hash, so that captures of many millions of
+// requests fit in memory.
+func (s *corpusStats) Add(req *Request, size int) bool {
+	s.requests += 1
+	s.bytes += size
+	s.sizes.Add(float64(size))
+
+	name := requestClass(req)
+	class, ok := s.classes[name]
+	if !ok {
+		if len(s.classes) >= maxCorpusClasses {
+			name = otherClass
+			class = s.classes[name]
+		}
+		if class == nil {
+			class = &corpusClass{}
+			s.classes[name] = class
+		}
+	}
+	class.requests += 1
+	class.bytes += size
+
+	// Ids are replaced with the same value, so requests that only differ by
+	// their id are the same
+	key := *req
+	if line, _, err := rewriteIDs(req.Line, json.RawMessage("0")); err == nil {
+		key.Line = line
+	}
+	h := fnv.New64a()
+	io.WriteString(h, key.cacheKey())
+	sum := h.Sum64()
+	if _, ok := s.seen[sum]; ok {
+		s.duplicates += 1
+		class.duplicates += 1
+		return true
+	}
+	s.seen[sum] = struct{}{}
+	return false
+}
+
+// corpusSummary is the machine-readable form of the summary of an input.
+type corpusSummary struct {
+	Requests   int                  `json:"requests"`
+	Bytes      int                  `json:"bytes"`
+	Duplicates int                  `json:"duplicates"`
+	Invalid    int                  `json:"invalid,omitempty"`
+	Sizes      corpusSizes          `json:"sizes"`
+	Classes    []corpusClassSummary `json:"classes"` // Most requests first
+	Output     string               `json:"output,omitempty"`
+	Written    int                  `json:"written,omitempty"`
+}
+
+// corpusSizes is the distribution of record sizes, in bytes. Percentiles are
+// estimated from a sample of large inputs.
+type corpusSizes struct {
+	Min     int     `json:"min"`
+	Average float64 `json:"avg"`
+	P50     int     `json:"p50"`
+	P90     int     `json:"p90"`
+	P99     int     `json:"p99"`
+	Max     int     `json:"max"`
+}
+
+type corpusClassSummary struct {
+	Class      string `json:"class"`
+	Requests   int    `json:"requests"`
+	Bytes      int    `json:"bytes"`
+	Duplicates int    `json:"duplicates"`
+}
+
+func (s *corpusStats) Summary() corpusSummary {
+	summary := corpusSummary{
+		Requests:   s.requests,
+		Bytes:      s.bytes,
+		Duplicates: s.duplicates,
+		Invalid:    s.invalid,
+		Classes:    make([]corpusClassSummary, 0, len(s.classes)),
+	}
+	if s.requests > 0 {
+		p := s.sizes.Percentiles(50, 90, 99)
+		summary.Sizes = corpusSizes{
+			Min:     int(s.sizes.Min()),
+			Average: s.sizes.Average(),
+			P50:     int(p[0]),
+			P90:     int(p[1]),
+			P99:     int(p[2]),
+			Max:     int(s.sizes.Max()),
+		}
+	}
+	for name, class := range s.classes {
+		summary.Classes = append(summary.Classes, corpusClassSummary{
+			Class:      name,
+			Requests:   class.requests,
+			Bytes:      class.bytes,
+			Duplicates: class.duplicates,
+		})
+	}
+	sort.Slice(summary.Classes, func(i, j int) bool {
+		a, b := summary.Classes[i], summary.Classes[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Class < b.Class
+	})
+	return summary
+}
+
+// renderCorpus writes the summary of an input as text.
+func renderCorpus(w io.Writer, s corpusSummary) {
+	percent := func(n, total int) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(n*100) / float64(total)
+	}
+	fmt.Fprintf(w, "** Corpus of %d requests, %s:\n", s.Requests, formatBytes(s.Bytes))
+	fmt.Fprintf(w, "   Duplicates: %d (%0.2f%%) repeat an earlier request, apart from their ids\n", s.Duplicates, percent(s.Duplicates, s.Requests))
+	if s.Invalid > 0 {
+		fmt.Fprintf(w, "   Invalid:    %d records that aren't valid requests\n", s.Invalid)
+	}
+	fmt.Fprintf(w, "   Sizes:      %s avg, %s min, %s max\n", formatBytes(int(s.Sizes.Average)), formatBytes(s.Sizes.Min), formatBytes(s.Sizes.Max))
+	fmt.Fprintf(w, "               %s p50, %s p90, %s p99\n", formatBytes(s.Sizes.P50), formatBytes(s.Sizes.P90), formatBytes(s.Sizes.P99))
+	if s.Output != "" {
+		fmt.Fprintf(w, "   Written:    %d requests to %s\n", s.Written, s.Output)
+	}
+	if len(s.Classes) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n** Methods:\n")
+	for _, c := range s.Classes {
+		name := c.Class
+		if name == "" {
+			name = "(unknown)"
+		}
+		fmt.Fprintf(w, "   %-30s %8d (%0.2f%%), %s, %0.2f%% duplicates\n", name, c.Requests, percent(c.Requests, s.Requests), formatBytes(c.Bytes), percent(c.Duplicates, c.Requests))
+	}
+}
+
+// corpusWriter writes a sample of records in an input framing.
+type corpusWriter struct {
+	w       *bufio.Writer
+	framing string
+	sample  float64 // Fraction of records written
+	rand    *rand.Rand
+	written int
+}
+
+// Write writes the record, unless it's left out of the sample.
+func (cw *corpusWriter) Write(record []byte) error {
+	if cw.sample < 1 && cw.rand.Float64() >= cw.sample {
+		return nil
+	}
+	var prefix [binary.MaxVarintLen64]byte
+	switch cw.framing {
+	case "length":
+		binary.BigEndian.PutUint32(prefix[:4], uint32(len(record)))
+		cw.w.Write(prefix[:4])
+	case "varint":
+		cw.w.Write(prefix[:binary.PutUvarint(prefix[:], uint64(len(record)))])
+	}
+	cw.w.Write(record)
+	if cw.framing == "" || cw.framing == "lines" {
+		cw.w.WriteByte('\n')
+	}
+	cw.written += 1
+	// Errors stick to the writer until it's flushed
+	_, err := cw.w.Write(nil)
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCorpus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "versus-corpus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input.jsonl")
+	lines := "" +
+		`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[1]}` + "\n" +
+		`{"jsonrpc":"2.0","id":2,"method":"eth_call","params":[1]}` + "\n" +
+		`{"jsonrpc":"2.0","id":3,"method":"eth_call","params":[2]}` + "\n" +
+		`{"jsonrpc":"2.0","id":4,"method":"eth_blockNumber"}` + "\n" +
+		"\n" +
+		`{"jsonrpc":"2.0","id":5,"method":"eth_chainId"}` + "\n"
+	if err := ioutil.WriteFile(input, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "output.jsonl")
+	var buf bytes.Buffer
+	err = runCorpus(context.Background(), CorpusOptions{
+		Input:  input,
+		Output: output,
+		Dedupe: true,
+		Sample: 1,
+		Format: "json",
+	}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got corpusSummary
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	// The empty line ends the input, like it ends the feed of a run
+	want := corpusSummary{
+		Requests:   4,
+		Bytes:      57*3 + 51,
+		Duplicates: 1,
+		Sizes:      corpusSizes{Min: 51, Average: 55.5, P50: 57, P90: 57, P99: 57, Max: 57},
+		Classes: []corpusClassSummary{
+			{Class: "eth_call", Requests: 3, Bytes: 57 * 3, Duplicates: 1},
+			{Class: "eth_blockNumber", Requests: 1, Bytes: 51},
+		},
+		Output:  output,
+		Written: 3,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+
+	written, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantWritten := "" +
+		`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[1]}` + "\n" +
+		`{"jsonrpc":"2.0","id":3,"method":"eth_call","params":[2]}` + "\n" +
+		`{"jsonrpc":"2.0","id":4,"method":"eth_blockNumber"}` + "\n"
+	if string(written) != wantWritten {
+		t.Errorf("got output %q; want %q", written, wantWritten)
+	}
+}
+
+func TestCorpusFraming(t *testing.T) {
+	for _, framing := range []string{"length", "varint"} {
+		records := []string{"a\nb", "c", "a\nb"}
+		var w bytes.Buffer
+		out := &corpusWriter{w: bufio.NewWriter(&w), framing: framing, sample: 1}
+		for _, r := range records {
+			if err := out.Write([]byte(r)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := out.w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := w.Bytes(), frame(framing, records...); !bytes.Equal(got, want) {
+			t.Errorf("%s: got %q; want %q", framing, got, want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// costTable is the cost of each class of request, such as the compute units
+// that commercial RPC providers bill, by glob pattern of the JSON-RPC method
+// or HTTP path.
+type costTable struct {
+	patterns []string // Longest first, so the most specific pattern wins
+	costs    map[string]float64
+}
+
+// readCostTable reads a cost table from a JSON file:
+//
+//	{"eth_call": 26, "eth_getLogs": 75, "eth_get*": 20, "*": 10}
+func readCostTable(path string) (*costTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cost table: %w", err)
+	}
+	var costs map[string]float64
+	if err := json.Unmarshal(data, &costs); err != nil {
+		return nil, fmt.Errorf("failed to parse cost table: %w", err)
+	}
+	return newCostTable(costs)
+}
+
+func newCostTable(costs map[string]float64) (*costTable, error) {
+	if len(costs) == 0 {
+		return nil, fmt.Errorf("cost table is empty")
+	}
+	t := &costTable{costs: costs}
+	for pattern, cost := range costs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid cost table pattern %q: %w", pattern, err)
+		}
+		if cost < 0 {
+			return nil, fmt.Errorf("negative cost for %s: %g", pattern, cost)
+		}
+		t.patterns = append(t.patterns, pattern)
+	}
+	sort.Slice(t.patterns, func(i, j int) bool {
+		a, b := t.patterns[i], t.patterns[j]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a < b
+	})
+	return t, nil
+}
+
+// Cost returns the cost of a request, and false if the table doesn't price
+// it. A JSON-RPC batch costs the sum of its calls.
+func (t *costTable) Cost(req *Request) (float64, bool) {
+	if req.Method == "" && req.Path == "" && isBatch(req.Line) {
+		var calls []rpcMessage
+		if err := json.Unmarshal(req.Line, &calls); err != nil {
+			return 0, false
+		}
+		var total float64
+		for _, call := range calls {
+			cost, ok := t.classCost(call.Method)
+			if !ok {
+				return 0, false
+			}
+			total += cost
+		}
+		return total, true
+	}
+	return t.classCost(requestClass(req))
+}
+
+func (t *costTable) classCost(class string) (float64, bool) {
+	if cost, ok := t.costs[class]; ok {
+		return cost, true
+	}
+	if class == "" {
+		return 0, false
+	}
+	candidates := []string{class}
+	if i := strings.IndexByte(class, ' '); i >= 0 {
+		// HTTP requests from the input can be priced by their path alone
+		candidates = append(candidates, class[i+1:])
+	}
+	for _, pattern := range t.patterns {
+		for _, c := range candidates {
+			if ok, _ := path.Match(pattern, c); ok {
+				return t.costs[pattern], true
+			}
+		}
+	}
+	return 0, false
+}
+
+// costStats are the costs of the requests sent to an endpoint.
+type costStats struct {
+	total    float64
+	unpriced int // Number of requests the table has no cost for
+}
+
+// countCost records the cost of a response's request to its endpoint.
+func (r *report) countCost(resp Response) {
+	if r.Costs == nil || resp.Request == nil {
+		return
+	}
+	if r.costs == nil {
+		r.costs = map[*Client]*costStats{}
+	}
+	stats, ok := r.costs[resp.client]
+	if !ok {
+		stats = &costStats{}
+		r.costs[resp.client] = stats
+	}
+	if cost, ok := r.Costs.Cost(resp.Request); ok {
+		stats.total += cost
+	} else {
+		stats.unpriced += 1
+	}
+}
+
+// costSummary returns the cost of the requests sent to the endpoint, per
+// second of its time in the run, or nil without a cost table.
+func (r *report) costSummary(c *Client) *costSummary {
+	if r.Costs == nil {
+		return nil
+	}
+	s := &costSummary{}
+	if stats, ok := r.costs[c]; ok {
+		s.Total, s.Unpriced = stats.total, stats.unpriced
+	}
+	started := r.started
+	if c.Joined.After(started) {
+		started = c.Joined
+	}
+	if elapsed := time.Since(started).Seconds(); !started.IsZero() && elapsed > 0 {
+		s.PerSecond = s.Total / elapsed
+	}
+	return s
+}
+
+// renderCosts writes the cost consumed by each endpoint as part of the text
+// report.
+func renderCosts(w io.Writer, colors palette, endpoints []endpointSummary) {
+	if len(endpoints) == 0 || endpoints[0].Cost == nil {
+		return
+	}
+	fmt.Fprintf(w, "\n%s\n", colors.Heading("** Cost:"))
+	for i, e := range endpoints {
+		fmt.Fprintf(w, "   %d. %q: %g total, %0.2f per second", i, e.label(), e.Cost.Total, e.Cost.PerSecond)
+		if e.Cost.Unpriced > 0 {
+			fmt.Fprintf(w, ", %d requests without a cost", e.Cost.Unpriced)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+// costSummary is the machine-readable form of an endpoint's cost.
+type costSummary struct {
+	Total     float64 `json:"total"`
+	PerSecond float64 `json:"per_second"` // Over the run time
+	Unpriced  int     `json:"unpriced,omitempty"`
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCostTable(t *testing.T) {
+	table, err := newCostTable(map[string]float64{
+		"eth_call":    26,
+		"eth_get*":    20,
+		"eth_getLogs": 75,
+		"/v1/*":       5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line   string
+		want   float64
+		wantOK bool
+	}{
+		{`{"method":"eth_call"}`, 26, true},
+		{`{"method":"eth_getLogs"}`, 75, true},
+		{`{"method":"eth_getBalance"}`, 20, true},
+		{`{"method":"net_version"}`, 0, false},
+		{`[{"method":"eth_call"},{"method":"eth_getLogs"}]`, 101, true},
+		{`[{"method":"eth_call"},{"method":"net_version"}]`, 0, false},
+	}
+	parser := &inputParser{}
+	for _, tc := range tests {
+		req, err := parser.Parse([]byte(tc.line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := table.Cost(&req); got != tc.want || ok != tc.wantOK {
+			t.Errorf("%s: got: %g, %t; want: %g, %t", tc.line, got, ok, tc.want, tc.wantOK)
+		}
+	}
+	if got, ok := table.Cost(&Request{Method: "GET", Path: "/v1/users?page=2"}); got != 5 || !ok {
+		t.Errorf("GET /v1/users: got: %g, %t; want: 5, true", got, ok)
+	}
+
+	for _, costs := range []map[string]float64{nil, {"eth_[": 1}, {"eth_call": -1}} {
+		if _, err := newCostTable(costs); err == nil {
+			t.Errorf("%v: got no error", costs)
+		}
+	}
+}
+
+func TestReportCosts(t *testing.T) {
+	a, b := &Client{Endpoint: "a"}, &Client{Endpoint: "b", Name: "b"}
+	table, err := newCostTable(map[string]float64{"eth_call": 26})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &report{Clients: Clients{a, b}, Costs: table}
+	call := &Request{Line: []byte(`{"method":"eth_call"}`)}
+	other := &Request{Line: []byte(`{"method":"eth_chainId"}`)}
+	for _, resp := range []Response{
+		{client: a, Request: call},
+		{client: a, Request: call, Err: errTimeout},
+		{client: a, Request: other},
+	} {
+		r.countCost(resp)
+	}
+
+	got := r.costSummary(a)
+	if got.Total != 52 || got.Unpriced != 1 {
+		t.Errorf("got: %+v; want a total of 52 with 1 unpriced request", got)
+	}
+	if got := r.costSummary(b); got.Total != 0 {
+		t.Errorf("got: %+v; want no cost", got)
+	}
+
+	var buf bytes.Buffer
+	renderCosts(&buf, palette{}, []endpointSummary{
+		{Endpoint: "a", Cost: r.costSummary(a)},
+		{Endpoint: "b", Name: "b", Cost: r.costSummary(b)},
+	})
+	for _, want := range []string{"** Cost:", `0. "a": 52 total`, "1 requests without a cost", `1. "b": 0 total`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got:\n%s\nwant: %s", buf.String(), want)
+		}
+	}
+}
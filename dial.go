@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// endpointDialer returns the dial function set by the network options of an
+// endpoint, or nil if it has none. Outbound connections can be bound to a
+// local address or interface, and restricted to IPv4 or IPv6, so that the
+// same backend can be compared over different network paths:
+//
+//	http://backend:8545/#name=lan&interface=eth1&ip=4
+//	http://backend:8545/#name=vpn&bind=10.8.0.2
+func endpointDialer(opts url.Values) (dialFunc, error) {
+	bind, iface, version := opts.Get("bind"), opts.Get("interface"), opts.Get("ip")
+	if bind == "" && iface == "" && version == "" {
+		return nil, nil
+	}
+	network := "tcp"
+	switch version {
+	case "":
+	case "4", "6":
+		network += version
+	default:
+		return nil, fmt.Errorf("invalid ip version: %s", version)
+	}
+
+	var local net.IP
+	switch {
+	case bind != "" && iface != "":
+		return nil, fmt.Errorf("bind and interface can't both be set")
+	case bind != "":
+		if local = net.ParseIP(bind); local == nil {
+			return nil, fmt.Errorf("invalid bind address: %s", bind)
+		}
+		if !ipMatches(local, version) {
+			return nil, fmt.Errorf("bind address is not IPv%s: %s", version, bind)
+		}
+	case iface != "":
+		var err error
+		if local, err = interfaceAddr(iface, version); err != nil {
+			return nil, err
+		}
+	}
+	if local != nil && version == "" {
+		// A local address of one family can't reach the other
+		if local.To4() != nil {
+			network = "tcp4"
+		} else {
+			network = "tcp6"
+		}
+	}
+
+	// Same as http.DefaultTransport's
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if local != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: local}
+	}
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}, nil
+}
+
+// interfaceAddr returns the first address of the network interface with the
+// IP version, or of either version if it's empty.
+func interfaceAddr(name string, version string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface: %w", err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses of interface %s: %w", name, err)
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || !ipMatches(ipnet.IP, version) {
+			continue
+		}
+		// Link-local IPv6 addresses need a zone to be used
+		if ipnet.IP.To4() == nil && ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipnet.IP, nil
+	}
+	if version != "" {
+		return nil, fmt.Errorf("interface %s has no IPv%s address", name, version)
+	}
+	return nil, fmt.Errorf("interface %s has no address", name)
+}
+
+func ipMatches(ip net.IP, version string) bool {
+	switch version {
+	case "4":
+		return ip.To4() != nil
+	case "6":
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// endpointHost returns the Host header and TLS configuration set by the
+// options of an endpoint, so that a server can be reached at another address
+// than its name resolves to, such as a new cluster before DNS is switched
+// over:
+//
+//	https://203.0.113.7/#host=api.example.com
+//
+// The TLS server name (SNI) defaults to the host, and can be set on its own
+// with sni. Certificates are verified against the server name.
+func endpointHost(opts url.Values, scheme string) (string, *tls.Config, error) {
+	host, sni := opts.Get("host"), opts.Get("sni")
+	if strings.ContainsAny(host, "/ ") {
+		return "", nil, fmt.Errorf("invalid host: %s", host)
+	}
+	if sni == "" && host != "" {
+		sni = host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			sni = h
+		}
+	} else if sni != "" && !tlsScheme(scheme) {
+		return "", nil, fmt.Errorf("sni is only supported over https, wss and mqtts")
+	}
+	if sni == "" || !tlsScheme(scheme) {
+		return host, nil, nil
+	}
+	return host, &tls.Config{ServerName: sni}, nil
+}
+
+func tlsScheme(scheme string) bool {
+	return scheme == "https" || scheme == "wss" || scheme == "mqtts"
+}
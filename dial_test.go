@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestEndpointDialer(t *testing.T) {
+	var gotAddr string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAddr = r.RemoteAddr
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	tr, err := NewTransport(srv.URL+"/#bind=127.0.0.1&ip=4", transportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp Response
+	if err := tr.Send(context.Background(), &Request{Line: []byte(`{}`)}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if host, _, _ := net.SplitHostPort(gotAddr); host != "127.0.0.1" {
+		t.Errorf("got: %s; want: 127.0.0.1", host)
+	}
+
+	// An IPv4 server can't be reached over IPv6
+	tr, err = NewTransport(srv.URL+"/#ip=6", transportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Send(context.Background(), &Request{Line: []byte(`{}`)}, &resp); err == nil {
+		t.Errorf("expected an error connecting over IPv6")
+	}
+
+	for _, fragment := range []string{
+		"ip=5",
+		"bind=localhost",
+		"bind=::1&ip=4",
+		"bind=127.0.0.1&interface=lo",
+		"interface=no-such-interface",
+	} {
+		if _, err := endpointDialer(mustParseQuery(t, fragment)); err == nil {
+			t.Errorf("%s: expected an error", fragment)
+		}
+	}
+	if dial, err := endpointDialer(url.Values{}); dial != nil || err != nil {
+		t.Errorf("got: %v, %v; want no dialer", dial != nil, err)
+	}
+}
+
+func TestInterfaceAddr(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		ip, err := interfaceAddr(iface.Name, "4")
+		if err != nil {
+			t.Skip(err)
+		}
+		if !ip.IsLoopback() || ip.To4() == nil {
+			t.Errorf("got: %s; want an IPv4 loopback address", ip)
+		}
+		return
+	}
+	t.Skip("no loopback interface")
+}
+
+func TestEndpointHost(t *testing.T) {
+	var gotHost, gotSNI string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost, gotSNI = r.Host, r.TLS.ServerName
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	tests := []struct {
+		fragment string
+		wantHost string
+		wantSNI  string
+		wantErr  bool
+	}{
+		{"host=example.com", "example.com", "example.com", false},
+		{"host=example.com:8443", "example.com:8443", "example.com", false},
+		{"host=api.example.net&sni=example.com", "api.example.net", "example.com", false},
+		// The certificate isn't valid for the server name
+		{"sni=other.example.net", "", "", true},
+	}
+	for _, tc := range tests {
+		tr, err := NewTransport(srv.URL+"/#"+tc.fragment, transportOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.(*httpTransport).Client.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+		gotHost, gotSNI = "", ""
+		var resp Response
+		err = tr.Send(context.Background(), &Request{Line: []byte(`{}`)}, &resp)
+		if got := err != nil; got != tc.wantErr {
+			t.Errorf("%s: got error: %v; want error: %v", tc.fragment, err, tc.wantErr)
+			continue
+		}
+		if gotHost != tc.wantHost || gotSNI != tc.wantSNI {
+			t.Errorf("%s: got: %s, %s; want: %s, %s", tc.fragment, gotHost, gotSNI, tc.wantHost, tc.wantSNI)
+		}
+	}
+
+	if _, err := NewTransport("http://localhost/#sni=example.com", transportOptions{}); err == nil {
+		t.Errorf("expected an error for sni over http")
+	}
+	if _, err := NewTransport("http://localhost/#host=a/b", transportOptions{}); err == nil {
+		t.Errorf("expected an error for an invalid host")
+	}
+}
+
+func mustParseQuery(t *testing.T, s string) url.Values {
+	t.Helper()
+	v, err := url.ParseQuery(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// maxDiffCells bounds the work of diffing two bodies, in pairs of lines
+// compared. Larger bodies are diffed as one change between their common
+// prefix and suffix.
+const maxDiffCells = 1 << 22
+
+// sideBySideWidth is the width of each column of a side-by-side diff.
+const sideBySideWidth = 60
+
+// diffLine is a line of a diff: unchanged (' '), removed ('-') or added ('+').
+type diffLine struct {
+	Op   byte
+	Text string
+	A, B int // Line numbers in each body, from 1, or 0 if not in it
+}
+
+// prettyBody returns the lines of a body, pretty-printed with sorted keys if
+// it's JSON, so that bodies differing only in formatting or key order diff
+// to their actual changes.
+func prettyBody(body []byte) []string {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err == nil && !dec.More() {
+		var pretty bytes.Buffer
+		enc := json.NewEncoder(&pretty)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err == nil {
+			body = pretty.Bytes()
+		}
+	}
+	return strings.Split(strings.TrimRight(string(body), "\n"), "\n")
+}
+
+// diffLines returns the edit script from a to b, with the fewest changes
+// unless the bodies are too large.
+func diffLines(a, b []string) []diffLine {
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		lines = append(lines, diffLine{Op: ' ', Text: a[i], A: i + 1, B: i + 1})
+	}
+
+	// Longest common subsequence of the changed middle
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	var lcs [][]int
+	if len(ma)*len(mb) <= maxDiffCells {
+		lcs = make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case lcs != nil && i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			lines = append(lines, diffLine{Op: ' ', Text: ma[i], A: prefix + i + 1, B: prefix + j + 1})
+			i++
+			j++
+		case i < len(ma) && (j == len(mb) || lcs == nil || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{Op: '-', Text: ma[i], A: prefix + i + 1})
+			i++
+		default:
+			lines = append(lines, diffLine{Op: '+', Text: mb[j], B: prefix + j + 1})
+			j++
+		}
+	}
+
+	for k := suffix; k > 0; k-- {
+		lines = append(lines, diffLine{Op: ' ', Text: a[len(a)-k], A: len(a) - k + 1, B: len(b) - k + 1})
+	}
+	return lines
+}
+
+// diffHunks splits a diff into hunks of changes with their context, leaving
+// out the unchanged lines between them.
+func diffHunks(lines []diffLine) [][]diffLine {
+	var hunks [][]diffLine
+	start, end := -1, -1
+	for i, line := range lines {
+		if line.Op == ' ' {
+			continue
+		}
+		if start >= 0 && i-diffContext > end+1 {
+			hunks = append(hunks, lines[start:end+1])
+			start = -1
+		}
+		if start < 0 {
+			start = i - diffContext
+			if start < 0 {
+				start = 0
+			}
+		}
+		end = i + diffContext
+		if end >= len(lines) {
+			end = len(lines) - 1
+		}
+	}
+	if start >= 0 {
+		hunks = append(hunks, lines[start:end+1])
+	}
+	return hunks
+}
+
+// hunkRange returns the first line and number of lines of a hunk in one of
+// the bodies, as in the header of a unified diff.
+func hunkRange(hunk []diffLine, line func(diffLine) int) (int, int) {
+	first, n := 0, 0
+	for _, l := range hunk {
+		if line(l) == 0 {
+			continue
+		}
+		if n == 0 {
+			first = line(l)
+		}
+		n++
+	}
+	return first, n
+}
+
+// unifiedDiff returns a unified diff of two bodies.
+func unifiedDiff(aName, bName string, a, b []byte) string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+	for _, hunk := range diffHunks(diffLines(prettyBody(a), prettyBody(b))) {
+		aFirst, aLines := hunkRange(hunk, func(l diffLine) int { return l.A })
+		bFirst, bLines := hunkRange(hunk, func(l diffLine) int { return l.B })
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", aFirst, aLines, bFirst, bLines)
+		for _, line := range hunk {
+			fmt.Fprintf(&buf, "%c%s\n", line.Op, line.Text)
+		}
+	}
+	return buf.String()
+}
+
+// sideBySideDiff returns the changes of two bodies in two columns, marked
+// with | where lines differ, < where they were removed and > where added.
+func sideBySideDiff(aName, bName string, a, b []byte) string {
+	var buf strings.Builder
+	row := func(left string, mark byte, right string) {
+		fmt.Fprintf(&buf, "%-*s %c %s\n", sideBySideWidth, clip(left, sideBySideWidth), mark, clip(right, sideBySideWidth))
+	}
+	row(aName, ' ', bName)
+	for i, hunk := range diffHunks(diffLines(prettyBody(a), prettyBody(b))) {
+		if i > 0 {
+			row("...", ' ', "...")
+		}
+		for k := 0; k < len(hunk); {
+			if hunk[k].Op == ' ' {
+				row(hunk[k].Text, ' ', hunk[k].Text)
+				k++
+				continue
+			}
+			// Pair a run of removed lines with the added lines that follow
+			var removed, added []string
+			for ; k < len(hunk) && hunk[k].Op == '-'; k++ {
+				removed = append(removed, hunk[k].Text)
+			}
+			for ; k < len(hunk) && hunk[k].Op == '+'; k++ {
+				added = append(added, hunk[k].Text)
+			}
+			for n := 0; n < len(removed) || n < len(added); n++ {
+				switch {
+				case n < len(removed) && n < len(added):
+					row(removed[n], '|', added[n])
+				case n < len(removed):
+					row(removed[n], '<', "")
+				default:
+					row("", '>', added[n])
+				}
+			}
+		}
+	}
+	return buf.String()
+}
+
+// clip shortens a line to the width, marking that it was cut.
+func clip(s string, width int) string {
+	if len(s) <= width {
+		return s
+	}
+	return s[:width-3] + "..."
+}
+
+// bodyDiff returns the difference between two bodies in the format: unified,
+// side-by-side, or raw for both bodies as they are.
+func bodyDiff(format, aName, bName string, a, b []byte) string {
+	switch format {
+	case "side-by-side":
+		return sideBySideDiff(aName, bName, a, b)
+	case "raw":
+		return fmt.Sprintf("%s\n\t%s\n%s\n\t%s", aName, a, bName, b)
+	}
+	return unifiedDiff(aName, bName, a, b)
+}
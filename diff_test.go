@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := []byte(`{"jsonrpc":"2.0","id":1,"result":{"a":1,"b":2,"c":3,"d":4,"e":5,"f":6,"g":7,"h":8,"i":9,"j":10,"k":"<x>","l":12}}`)
+	// Same keys in another order, with two values changed
+	b := []byte(`{"id":1,"jsonrpc":"2.0","result":{"l":12,"k":"<y>","j":10,"i":9,"h":8,"g":7,"f":6,"e":5,"d":4,"c":3,"b":20,"a":1}}`)
+	want := strings.Join([]string{
+		"--- a",
+		"+++ b",
+		"@@ -3,7 +3,7 @@",
+		`   "jsonrpc": "2.0",`,
+		`   "result": {`,
+		`     "a": 1,`,
+		`-    "b": 2,`,
+		`+    "b": 20,`,
+		`     "c": 3,`,
+		`     "d": 4,`,
+		`     "e": 5,`,
+		"@@ -12,7 +12,7 @@",
+		`     "h": 8,`,
+		`     "i": 9,`,
+		`     "j": 10,`,
+		`-    "k": "<x>",`,
+		`+    "k": "<y>",`,
+		`     "l": 12`,
+		`   }`,
+		` }`,
+	}, "\n") + "\n"
+	if got := unifiedDiff("a", "b", a, b); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSideBySideDiff(t *testing.T) {
+	got := sideBySideDiff("a", "b", []byte("x\ny\nz"), []byte("x\nY\nz\nw"))
+	want := []string{
+		fmt.Sprintf("%-60s   %s", "a", "b"),
+		fmt.Sprintf("%-60s   %s", "x", "x"),
+		fmt.Sprintf("%-60s | %s", "y", "Y"),
+		fmt.Sprintf("%-60s   %s", "z", "z"),
+		fmt.Sprintf("%-60s > %s", "", "w"),
+	}
+	if want := strings.Join(want, "\n") + "\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	// Too large to find the fewest changes, but still a valid edit script
+	a := make([]string, 3000)
+	b := make([]string, 3000)
+	for i := range a {
+		a[i], b[i] = fmt.Sprint(i), fmt.Sprint(i)
+	}
+	a[10], b[2990] = "a", "b"
+	var removed, added int
+	for _, line := range diffLines(a, b) {
+		switch line.Op {
+		case '-':
+			removed++
+		case '+':
+			added++
+		}
+	}
+	if removed != 2981 || added != 2981 {
+		t.Errorf("got: -%d +%d; want: -2981 +2981", removed, added)
+	}
+}
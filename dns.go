@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dnsUDPSize is the UDP payload size advertised with EDNS0, the size that
+// avoids fragmentation on most paths.
+const dnsUDPSize = 1232
+
+// dnsTypes are the record types that can be queried by name. Others can be
+// queried as TYPE<N>, such as TYPE64.
+var dnsTypes = map[string]uint16{
+	"A": 1, "NS": 2, "CNAME": 5, "SOA": 6, "PTR": 12, "MX": 15, "TXT": 16,
+	"AAAA": 28, "SRV": 33, "DS": 43, "DNSKEY": 48, "HTTPS": 65, "ANY": 255, "CAA": 257,
+}
+
+var dnsRcodes = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+
+// dnsTransport sends DNS queries to a resolver over UDP, retrying over TCP
+// when the answer is truncated. Answers are compared as a set, without their
+// TTLs, which differ between resolvers and over time.
+type dnsTransport struct {
+	addr    string
+	timeout time.Duration
+}
+
+func newDNSTransport(host string, opts transportOptions) *dnsTransport {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "53")
+	}
+	return &dnsTransport{addr: host, timeout: opts.Timeout}
+}
+
+// dnsQuery is a question of an input line, either as "NAME [TYPE]", such as
+// "example.com AAAA", or as {"name": "example.com", "type": "AAAA"}. The type
+// defaults to A.
+type dnsQuery struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func parseDNSQuery(line []byte) (name string, qtype uint16, err error) {
+	var q dnsQuery
+	if line = bytes.TrimSpace(line); len(line) > 0 && line[0] == '{' {
+		if err := json.Unmarshal(line, &q); err != nil {
+			return "", 0, fmt.Errorf("invalid dns query: %w", err)
+		}
+	} else {
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 || len(fields) > 2 {
+			return "", 0, fmt.Errorf("invalid dns query %q: must be NAME [TYPE]", line)
+		}
+		q.Name = fields[0]
+		if len(fields) == 2 {
+			q.Type = fields[1]
+		}
+	}
+	if q.Name == "" {
+		return "", 0, fmt.Errorf("dns query has no name")
+	}
+	qtype = 1
+	if q.Type != "" {
+		t := strings.ToUpper(q.Type)
+		if n, ok := dnsTypes[t]; ok {
+			qtype = n
+		} else if n, err := strconv.ParseUint(strings.TrimPrefix(t, "TYPE"), 10, 16); err == nil && strings.HasPrefix(t, "TYPE") {
+			qtype = uint16(n)
+		} else {
+			return "", 0, fmt.Errorf("unknown dns type: %s", q.Type)
+		}
+	}
+	name = q.Name
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name, qtype, nil
+}
+
+func (t *dnsTransport) Send(ctx context.Context, req *Request, resp *Response) error {
+	if req.Method != "" || req.Path != "" {
+		return fmt.Errorf("request methods and paths are only supported over http")
+	}
+	name, qtype, err := parseDNSQuery(req.Line)
+	if err != nil {
+		return err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	query, err := packDNSQuery(id, name, qtype)
+	if err != nil {
+		return err
+	}
+	timeout := t.timeout
+	if req.Timeout > 0 {
+		timeout = req.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	answer, err := t.exchange(ctx, "udp", query)
+	if err == nil && len(answer) > 2 && answer[2]&0x02 != 0 {
+		// Truncated, the whole answer needs TCP
+		answer, err = t.exchange(ctx, "tcp", query)
+	}
+	if err != nil {
+		return err
+	}
+	msg, err := parseDNSMessage(answer)
+	if err != nil {
+		return err
+	}
+	if msg.ID != id {
+		return fmt.Errorf("dns answer id %d does not match query id %d", msg.ID, id)
+	}
+	body, err := json.Marshal(msg.Answer)
+	if err != nil {
+		return err
+	}
+	resp.Body = body
+	resp.Size = len(answer)
+	return nil
+}
+
+// exchange sends a query over the network, udp or tcp, and returns the
+// answer.
+func (t *dnsTransport) exchange(ctx context.Context, network string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, t.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, dnsUDPSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	// Messages over TCP are prefixed with their length
+	framed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	copy(framed[2:], query)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// packDNSQuery returns a recursive query for the name and type, with an
+// EDNS0 record advertising dnsUDPSize.
+func packDNSQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, 12, 12+len(name)+2+4+11)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // Recursion desired
+	binary.BigEndian.PutUint16(msg[4:], 1)      // Questions
+	binary.BigEndian.PutUint16(msg[10:], 1)     // Additional records
+	if name != "." {
+		for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid dns name: %s", name)
+			}
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+	}
+	msg = append(msg, 0)
+	msg = append(msg, byte(qtype>>8), byte(qtype), 0, 1) // Class IN
+	// OPT pseudo-record: root name, type 41, class is the UDP size
+	msg = append(msg, 0, 0, 41, byte(dnsUDPSize>>8), byte(dnsUDPSize&0xff), 0, 0, 0, 0, 0, 0)
+	if len(msg) > 12+255+4+11 {
+		return nil, fmt.Errorf("invalid dns name: %s", name)
+	}
+	return msg, nil
+}
+
+// dnsAnswer is the comparable form of a DNS answer: its response code and
+// its answer records, sorted, without TTLs.
+type dnsAnswer struct {
+	Rcode   string   `json:"rcode"`
+	Records []string `json:"answer"`
+}
+
+type dnsMessage struct {
+	ID     uint16
+	Answer dnsAnswer
+}
+
+var errDNSMessage = errors.New("invalid dns message")
+
+func parseDNSMessage(msg []byte) (*dnsMessage, error) {
+	if len(msg) < 12 {
+		return nil, errDNSMessage
+	}
+	m := &dnsMessage{ID: binary.BigEndian.Uint16(msg)}
+	rcode := int(msg[3] & 0x0f)
+	if rcode < len(dnsRcodes) {
+		m.Answer.Rcode = dnsRcodes[rcode]
+	} else {
+		m.Answer.Rcode = "RCODE" + strconv.Itoa(rcode)
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	for i := 0; i < questions; i++ {
+		_, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+	}
+	m.Answer.Records = []string{}
+	for i := 0; i < answers; i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errDNSMessage
+		}
+		rtype := binary.BigEndian.Uint16(msg[next:])
+		size := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		if start+size > len(msg) {
+			return nil, errDNSMessage
+		}
+		data, err := formatDNSData(msg, rtype, start, size)
+		if err != nil {
+			return nil, err
+		}
+		m.Answer.Records = append(m.Answer.Records, strings.ToLower(name)+" "+dnsTypeName(rtype)+" "+data)
+		off = start + size
+	}
+	sort.Strings(m.Answer.Records)
+	return m, nil
+}
+
+// readDNSName reads a possibly compressed name at off, and returns it with
+// the offset after it.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errDNSMessage
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 64 {
+				return "", 0, errDNSMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errDNSMessage
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// formatDNSData returns the record data in its presentation format, or in
+// the generic format of RFC 3597 for types that aren't known.
+func formatDNSData(msg []byte, rtype uint16, off, size int) (string, error) {
+	data := msg[off : off+size]
+	switch rtype {
+	case 1, 28: // A, AAAA
+		if (rtype == 1 && size != 4) || (rtype == 28 && size != 16) {
+			return "", errDNSMessage
+		}
+		return net.IP(data).String(), nil
+	case 2, 5, 12: // NS, CNAME, PTR
+		name, _, err := readDNSName(msg, off)
+		return strings.ToLower(name), err
+	case 15: // MX
+		if size < 3 {
+			return "", errDNSMessage
+		}
+		name, _, err := readDNSName(msg, off+2)
+		return fmt.Sprintf("%d %s", binary.BigEndian.Uint16(data), strings.ToLower(name)), err
+	case 16: // TXT
+		var parts []string
+		for i := 0; i < len(data); {
+			n := int(data[i])
+			if i+1+n > len(data) {
+				return "", errDNSMessage
+			}
+			parts = append(parts, strconv.Quote(string(data[i+1:i+1+n])))
+			i += 1 + n
+		}
+		return strings.Join(parts, " "), nil
+	case 33: // SRV
+		if size < 7 {
+			return "", errDNSMessage
+		}
+		name, _, err := readDNSName(msg, off+6)
+		return fmt.Sprintf("%d %d %d %s", binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:]), binary.BigEndian.Uint16(data[4:]), strings.ToLower(name)), err
+	}
+	return fmt.Sprintf("\\# %d %s", size, hex.EncodeToString(data)), nil
+}
+
+func dnsTypeName(rtype uint16) string {
+	for name, n := range dnsTypes {
+		if n == rtype {
+			return name
+		}
+	}
+	return "TYPE" + strconv.Itoa(int(rtype))
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dnsRecord is an answer record of the test server, whose name points to the
+// question's.
+type dnsRecord struct {
+	Type uint16
+	TTL  uint32
+	Data []byte
+}
+
+// serveDNS answers queries over UDP and TCP on the same port with the
+// records, truncating UDP answers if truncate is set. It returns its address
+// and a function to stop it.
+func serveDNS(t *testing.T, records []dnsRecord, truncate bool) (string, func()) {
+	t.Helper()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Skipf("tcp port is taken: %s", err)
+	}
+
+	answer := func(query []byte, truncated bool) []byte {
+		_, end, err := readDNSName(query, 12)
+		if err != nil {
+			return nil
+		}
+		msg := append([]byte{}, query[:end+4]...)
+		msg[2] |= 0x80 // Response
+		binary.BigEndian.PutUint16(msg[10:], 0)
+		if truncated {
+			msg[2] |= 0x02
+			return msg
+		}
+		binary.BigEndian.PutUint16(msg[6:], uint16(len(records)))
+		for _, r := range records {
+			msg = append(msg, 0xc0, 12, byte(r.Type>>8), byte(r.Type), 0, 1)
+			msg = append(msg, byte(r.TTL>>24), byte(r.TTL>>16), byte(r.TTL>>8), byte(r.TTL))
+			msg = append(msg, byte(len(r.Data)>>8), byte(len(r.Data)))
+			msg = append(msg, r.Data...)
+		}
+		return msg
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(answer(buf[:n], truncate), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			var size [2]byte
+			if _, err := io.ReadFull(conn, size[:]); err == nil {
+				query := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err := io.ReadFull(conn, query); err == nil {
+					msg := answer(query, false)
+					conn.Write(append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...))
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return udp.LocalAddr().String(), func() {
+		udp.Close()
+		tcp.Close()
+	}
+}
+
+func TestDNSTransport(t *testing.T) {
+	a := []dnsRecord{
+		{Type: 1, TTL: 60, Data: []byte{192, 0, 2, 1}},
+		{Type: 1, TTL: 60, Data: []byte{192, 0, 2, 2}},
+		{Type: 16, TTL: 60, Data: []byte("\x05hello\x05world")},
+	}
+	// Same answer in another order and with other TTLs
+	b := []dnsRecord{a[2], a[1], a[0]}
+	for i := range b {
+		b[i].TTL = 300
+	}
+
+	send := func(addr string, line string) Response {
+		t.Helper()
+		tr, err := NewTransport("dns://"+addr, transportOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp Response
+		if err := tr.Send(context.Background(), &Request{Line: []byte(line)}, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	addrA, stopA := serveDNS(t, a, false)
+	defer stopA()
+	addrB, stopB := serveDNS(t, b, false)
+	defer stopB()
+	addrT, stopT := serveDNS(t, a, true)
+	defer stopT()
+
+	got := send(addrA, "Example.com A")
+	want := `{"rcode":"NOERROR","answer":["example.com. A 192.0.2.1","example.com. A 192.0.2.2","example.com. TXT \"hello\" \"world\""]}`
+	if string(got.Body) != want {
+		t.Errorf("got: %s; want: %s", got.Body, want)
+	}
+	other := send(addrB, `{"name":"example.com","type":"a"}`)
+	if !got.Equal(other) {
+		t.Errorf("got: %s; want equal to: %s", other.Body, got.Body)
+	}
+	// Truncated answers are retried over TCP
+	truncated := send(addrT, "example.com")
+	if string(truncated.Body) != want {
+		t.Errorf("got: %s; want: %s", truncated.Body, want)
+	}
+}
+
+func TestDNSTransportRequestTimeout(t *testing.T) {
+	// Never answers
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	tr, err := NewTransport("dns://"+udp.LocalAddr().String(), transportOptions{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	var resp Response
+	if err := tr.Send(context.Background(), &Request{Line: []byte("example.com"), Timeout: 20 * time.Millisecond}, &resp); err == nil {
+		t.Errorf("got no error; want a timeout")
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("took %s; want the request's 20ms timeout", elapsed)
+	}
+}
+
+func TestDNSTransportOptions(t *testing.T) {
+	if _, err := NewTransport("dns://127.0.0.1/#keepalive=off", transportOptions{}); err == nil || !strings.Contains(err.Error(), "keepalive") {
+		t.Errorf("got: %v; want an error about keepalive", err)
+	}
+	tr, err := NewTransport("dns://127.0.0.1/#name=local", transportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tr.(*dnsTransport).addr, "127.0.0.1:53"; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+}
+
+func TestParseDNSQuery(t *testing.T) {
+	tests := []struct {
+		line  string
+		name  string
+		qtype uint16
+		err   bool
+	}{
+		{line: "example.com", name: "example.com.", qtype: 1},
+		{line: "example.com. aaaa", name: "example.com.", qtype: 28},
+		{line: `{"name":"example.com","type":"MX"}`, name: "example.com.", qtype: 15},
+		{line: "example.com TYPE64", name: "example.com.", qtype: 64},
+		{line: "example.com BOGUS", err: true},
+		{line: "example.com A extra", err: true},
+		{line: `{"type":"A"}`, err: true},
+		{line: "", err: true},
+	}
+	for _, test := range tests {
+		name, qtype, err := parseDNSQuery([]byte(test.line))
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.line, err)
+			continue
+		}
+		if name != test.name || qtype != test.qtype {
+			t.Errorf("%q: got: %s %d; want: %s %d", test.line, name, qtype, test.name, test.qtype)
+		}
+	}
+}
+
+func TestParseDNSMessage(t *testing.T) {
+	// Invalid compression pointers and truncated records are errors
+	query, err := packDNSQuery(1, "example.com.", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := append([]byte{}, query[:len(query)-11]...)
+	binary.BigEndian.PutUint16(msg[6:], 1)
+	binary.BigEndian.PutUint16(msg[10:], 0)
+	for _, tail := range [][]byte{
+		{0xc0, 0xff},
+		{0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0},
+		{0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 3, 192, 0, 2},
+	} {
+		if _, err := parseDNSMessage(append(append([]byte{}, msg...), tail...)); err == nil {
+			t.Errorf("%x: expected an error", tail)
+		}
+	}
+	// Pointer loops end
+	loop := append(append([]byte{}, msg...), 0xc0, byte(len(msg)))
+	if _, err := parseDNSMessage(loop); err == nil {
+		t.Errorf("expected an error for a pointer loop")
+	}
+}
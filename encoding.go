@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// decodeBody decompresses the response body according to its
+// Content-Encoding header, so that endpoints with different compression
+// settings can be compared. Size keeps the size as received.
+func decodeBody(resp *Response) error {
+	if resp.decoded || resp.Header == nil || len(resp.Body) == 0 {
+		return nil
+	}
+	header := resp.Header.Get("Content-Encoding")
+	if header == "" {
+		return nil
+	}
+	r, err := decodeReader(bytes.NewReader(resp.Body), header)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s body: %w", header, err)
+	}
+	resp.Body = body
+	return nil
+}
+
+// decodeReader wraps r with decoders for the encodings of a Content-Encoding
+// header, so that the body can be decoded as a stream.
+func decodeReader(r io.Reader, header string) (io.Reader, error) {
+	// Encodings are listed in the order they were applied
+	encodings := strings.Split(header, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		var err error
+		switch encoding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(r)
+		case "br":
+			r = brotli.NewReader(r)
+		case "deflate":
+			r, err = deflateReader(r)
+		default:
+			return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s body: %w", encoding, err)
+		}
+	}
+	return r, nil
+}
+
+// deflateReader returns a reader for a deflate encoded body. The deflate
+// content encoding is supposed to be zlib-wrapped, but some servers send raw
+// deflate streams.
+func deflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	// A zlib header has compression method 8 and a checksum over both bytes
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+// hashBody reads a body as a stream, decoding its content encoding, and
+// records the SHA-256 of the decoded content in the response instead of
+// keeping the body in memory.
+func hashBody(body io.Reader, encoding string, resp *Response) error {
+	received := &countingReader{Reader: body}
+	r, err := decodeReader(received, encoding)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	resp.Size = received.n
+	if err != nil {
+		return err
+	}
+	resp.Hash = hex.EncodeToString(h.Sum(nil))
+	resp.HashSize = int(n)
+	return nil
+}
+
+// Policies for bodies over the size limit.
+const (
+	oversizeTruncate = "truncate" // Compare the kept prefix only
+	oversizeHash     = "hash"     // Compare the kept prefix and a hash of the remainder
+	oversizeSkip     = "skip"     // Don't compare the response set
+)
+
+// limitBody reads a body as a stream, decoding its content encoding, and
+// keeps up to limit bytes of the decoded content in the response. The
+// remainder is handled according to the policy.
+func limitBody(body io.Reader, encoding string, limit int, policy string, resp *Response) error {
+	received := &countingReader{Reader: body}
+	r, err := decodeReader(received, encoding)
+	if err != nil {
+		return err
+	}
+	resp.decoded = true
+	resp.Body, err = ioutil.ReadAll(io.LimitReader(r, int64(limit)))
+	if err != nil {
+		resp.Size = received.n
+		return err
+	}
+
+	var n int64
+	if policy == oversizeHash {
+		h := sha256.New()
+		n, err = io.Copy(h, r)
+		if n > 0 {
+			resp.Hash = hex.EncodeToString(h.Sum(nil))
+			resp.HashSize = int(n)
+		}
+	} else {
+		n, err = io.Copy(ioutil.Discard, r)
+	}
+	resp.Size = received.n
+	resp.Oversize = int(n)
+	if n > 0 && policy == oversizeSkip {
+		resp.Body = nil
+		resp.Skipped = true
+	}
+	return err
+}
+
+// spillBody reads a body as a stream, decoding its content encoding. Bodies
+// up to the threshold are kept in memory, and larger ones are written to a
+// temporary file in dir while they're hashed, so that they can be compared by
+// their SHA-256 and inspected later.
+func spillBody(body io.Reader, encoding string, threshold int, dir string, resp *Response) error {
+	received := &countingReader{Reader: body}
+	r, err := decodeReader(received, encoding)
+	if err != nil {
+		return err
+	}
+	resp.decoded = true
+	buf, err := ioutil.ReadAll(io.LimitReader(r, int64(threshold)+1))
+	if err != nil || len(buf) <= threshold {
+		resp.Body = buf
+		resp.Size = received.n
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "versus-body-*")
+	if err != nil {
+		return fmt.Errorf("failed to spill body: %w", err)
+	}
+	h := sha256.New()
+	w := io.MultiWriter(f, h)
+	n, err := w.Write(buf)
+	if err == nil {
+		var rest int64
+		rest, err = io.Copy(w, r)
+		n += int(rest)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	resp.Size = received.n
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to spill body: %w", err)
+	}
+	resp.Hash = hex.EncodeToString(h.Sum(nil))
+	resp.HashSize = n
+	resp.Spilled = f.Name()
+	return nil
+}
+
+// wholeHash returns the SHA-256 and size of the whole decoded body, if the
+// response has the whole body or only its hash.
+func wholeHash(resp *Response) (string, int, bool) {
+	switch {
+	case resp.Oversize > 0:
+		return "", 0, false
+	case resp.Hash != "" && len(resp.Body) == 0:
+		return resp.Hash, resp.HashSize, true
+	case resp.Hash == "":
+		return sha256Hex(resp.Body), len(resp.Body), true
+	}
+	return "", 0, false
+}
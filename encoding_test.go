@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLimitBody(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("0123456789"))
+	w.Close()
+
+	tests := []struct {
+		policy   string
+		body     string
+		oversize int
+		hashSize int
+		skipped  bool
+	}{
+		{oversizeTruncate, "0123", 6, 0, false},
+		{oversizeHash, "0123", 6, 6, false},
+		{oversizeSkip, "", 6, 0, true},
+	}
+	for _, tc := range tests {
+		var resp Response
+		if err := limitBody(bytes.NewReader(gz.Bytes()), "gzip", 4, tc.policy, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Body) != tc.body || resp.Oversize != tc.oversize || resp.HashSize != tc.hashSize || resp.Skipped != tc.skipped {
+			t.Errorf("%s: got: %q, %d oversize, %d hashed, skipped %t; want: %q, %d, %d, %t", tc.policy,
+				resp.Body, resp.Oversize, resp.HashSize, resp.Skipped, tc.body, tc.oversize, tc.hashSize, tc.skipped)
+		}
+		if resp.Size != gz.Len() {
+			t.Errorf("%s: got size: %d; want: %d", tc.policy, resp.Size, gz.Len())
+		}
+	}
+
+	// Under the limit, the body is kept whole
+	var resp Response
+	if err := limitBody(bytes.NewReader([]byte("01")), "", 4, oversizeSkip, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Body) != "01" || resp.Oversize != 0 || resp.Skipped {
+		t.Errorf("got: %q, %d oversize, skipped %t; want: \"01\", 0, false", resp.Body, resp.Oversize, resp.Skipped)
+	}
+}
+
+func TestSpillBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "versus-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var small, large Response
+	if err := spillBody(bytes.NewReader([]byte("0123")), "", 4, dir, &small); err != nil {
+		t.Fatal(err)
+	}
+	if string(small.Body) != "0123" || small.Spilled != "" {
+		t.Errorf("got: %q, spilled to %q; want: \"0123\" in memory", small.Body, small.Spilled)
+	}
+
+	if err := spillBody(bytes.NewReader([]byte("0123456789")), "", 4, dir, &large); err != nil {
+		t.Fatal(err)
+	}
+	if len(large.Body) != 0 || large.Spilled == "" || large.HashSize != 10 {
+		t.Fatalf("got: %q, spilled to %q, %d hashed; want: 10 bytes spilled", large.Body, large.Spilled, large.HashSize)
+	}
+	spilled, err := ioutil.ReadFile(large.Spilled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(spilled) != "0123456789" {
+		t.Errorf("got: %q in spilled file; want: \"0123456789\"", spilled)
+	}
+
+	// A spilled body matches the same body kept in memory
+	inMemory := Response{Body: []byte("0123456789")}
+	if !large.Equal(inMemory) {
+		t.Errorf("expected spilled body to equal the same body in memory")
+	}
+	if large.Equal(small) {
+		t.Errorf("expected spilled body to differ from a different body")
+	}
+}
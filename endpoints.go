@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// clientSet is the set of endpoint clients of a run, which can change while
+// it's serving. Requests are only sent to the clients that are active at the
+// time.
+type clientSet struct {
+	// Configure applies the run's options to clients that join mid-run
+	Configure func(*Client)
+	// OnJoin is called when a client joins mid-run, after it started serving
+	OnJoin func(*Client)
+	// OnLeave is called when a client is removed mid-run, once it's no longer
+	// sent requests
+	OnLeave func(*Client)
+
+	concurrency int
+	timeout     time.Duration
+
+	started chan struct{} // Closed once Serve has started the clients
+
+	mu        sync.Mutex
+	active    Clients
+	g         *errgroup.Group
+	ctx       context.Context
+	out       chan<- Response
+	finalized bool
+}
+
+func newClientSet(clients Clients, concurrency int, timeout time.Duration) *clientSet {
+	return &clientSet{
+		active:      clients,
+		concurrency: concurrency,
+		timeout:     timeout,
+		started:     make(chan struct{}),
+	}
+}
+
+// Active returns the clients that requests are currently sent to.
+func (s *clientSet) Active() Clients {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(Clients(nil), s.active...)
+}
+
+// Serve serves the active clients, and the ones that join later, until they
+// have all shut down.
+func (s *clientSet) Serve(ctx context.Context, out chan<- Response) error {
+	s.mu.Lock()
+	s.g, s.ctx = errgroup.WithContext(ctx)
+	s.out = out
+	for _, c := range s.active {
+		s.serve(c)
+	}
+	g := s.g
+	s.mu.Unlock()
+	close(s.started)
+	return g.Wait()
+}
+
+func (s *clientSet) serve(c *Client) {
+	g, ctx, out := s.g, s.ctx, s.out
+	g.Go(func() error {
+		return c.Serve(ctx, out)
+	})
+}
+
+// Send sends the request to every active client, once they're serving.
+func (s *clientSet) Send(ctx context.Context, req Request) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.started:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active.Send(ctx, req)
+}
+
+// Finalize shuts down the active clients once they're done with the requests
+// sent so far, once they're serving. No clients can join afterwards.
+func (s *clientSet) Finalize() {
+	<-s.started
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finalized = true
+	s.active.Finalize()
+}
+
+// Add starts a client for the endpoint, which receives requests from now on.
+func (s *clientSet) Add(endpoint string) (*Client, error) {
+	c, err := NewClient(endpoint, s.concurrency)
+	if err != nil {
+		return nil, err
+	}
+	c.Timeout = s.timeout
+	if s.Configure != nil {
+		s.Configure(c)
+	}
+	c.Joined = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.g == nil || s.finalized {
+		return nil, fmt.Errorf("clients are not serving")
+	}
+	for _, other := range s.active {
+		if other.Endpoint == endpoint {
+			return nil, fmt.Errorf("endpoint is already active: %s", endpoint)
+		}
+	}
+	s.serve(c)
+	s.active = append(s.active, c)
+	if s.OnJoin != nil {
+		s.OnJoin(c)
+	}
+	logger.Info().Str("endpoint", endpoint).Msg("endpoint joined")
+	return c, nil
+}
+
+// Remove stops sending requests to the endpoint. Its client shuts down once
+// it's done with the requests it already received. The first endpoint is the
+// reference that the others are compared to, and it can't be removed.
+func (s *clientSet) Remove(endpoint string) error {
+	c, err := s.remove(endpoint)
+	if err != nil {
+		return err
+	}
+	// No more requests are sent to it
+	Clients{c}.Finalize()
+	if s.OnLeave != nil {
+		s.OnLeave(c)
+	}
+	logger.Info().Str("endpoint", endpoint).Msg("endpoint left")
+	return nil
+}
+
+// remove takes the endpoint's client out of the active set.
+func (s *clientSet) remove(endpoint string) (*Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.active {
+		if c.Endpoint != endpoint {
+			continue
+		}
+		if i == 0 {
+			return nil, fmt.Errorf("can't remove the reference endpoint: %s", endpoint)
+		}
+		s.active = append(s.active[:i:i], s.active[i+1:]...)
+		return c, nil
+	}
+	return nil, fmt.Errorf("endpoint is not active: %s", endpoint)
+}
+
+// Reload makes the active set match the endpoints, adding and removing
+// clients as needed.
+func (s *clientSet) Reload(endpoints []string) error {
+	want := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		want[endpoint] = true
+	}
+	active := map[string]bool{}
+	for _, c := range s.Active() {
+		active[c.Endpoint] = true
+	}
+	// Add first, so the set is never empty
+	for _, endpoint := range endpoints {
+		if !active[endpoint] {
+			if _, err := s.Add(endpoint); err != nil {
+				return err
+			}
+			active[endpoint] = true
+		}
+	}
+	for endpoint := range active {
+		if !want[endpoint] {
+			if err := s.Remove(endpoint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// clientProfile is the client-side configuration of an endpoint, set by its
+// per-endpoint options. The same URI can be given several times with
+// different profiles to compare client configurations against one server:
+//
+//	http://localhost:8545/#name=c16&concurrency=16
+//	http://localhost:8545/#name=c64-close&concurrency=64&keepalive=off
+type clientProfile struct {
+	Name        string
+	Concurrency int // Overrides --concurrency if set
+}
+
+func parseClientProfile(endpoint string) (clientProfile, error) {
+	var profile clientProfile
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return profile, err
+	}
+	opts, err := endpointOptions(u)
+	if err != nil {
+		return profile, err
+	}
+	profile.Name = opts.Get("name")
+	if v := opts.Get("concurrency"); v != "" {
+		if profile.Concurrency, err = strconv.Atoi(v); err != nil || profile.Concurrency < 1 {
+			return profile, fmt.Errorf("invalid concurrency: %s", v)
+		}
+	}
+	return profile, nil
+}
+
+// readEndpointsFile reads endpoints from a file, one per line. Blank lines
+// and lines starting with # are ignored.
+func readEndpointsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var endpoints []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		endpoints = append(endpoints, line)
+	}
+	return endpoints, scanner.Err()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientSet(t *testing.T) {
+	clients, err := NewClients([]string{"noop://a", "noop://b"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newClientSet(clients, 1, time.Second)
+
+	ctx := context.Background()
+	out := make(chan Response, 10)
+	done := make(chan error)
+	go func() {
+		done <- s.Serve(ctx, out)
+	}()
+	// Clients can only join once serving started
+	for {
+		if _, err := s.Add("noop://c"); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := s.Add("noop://c"); err == nil {
+		t.Errorf("expected an error adding an active endpoint")
+	}
+	if err := s.Remove("noop://a"); err == nil {
+		t.Errorf("expected an error removing the reference endpoint")
+	}
+	var left []*Client
+	s.OnLeave = func(c *Client) { left = append(left, c) }
+	if err := s.Remove("noop://b"); err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0] != clients[1] {
+		t.Errorf("got left: %v; want noop://b", left)
+	}
+	if err := s.Send(ctx, Request{Line: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	s.Finalize()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	var got []string
+	for resp := range out {
+		got = append(got, resp.client.Endpoint)
+		if resp.Request.Peers != 2 {
+			t.Errorf("got: %d peers; want: 2", resp.Request.Peers)
+		}
+	}
+	if len(got) != 2 {
+		t.Errorf("got responses from: %v; want: noop://a, noop://c", got)
+	}
+
+	if _, err := s.Add("noop://d"); err == nil {
+		t.Errorf("expected an error adding to a finalized set")
+	}
+}
+
+func TestClientProfile(t *testing.T) {
+	clients, err := NewClients([]string{
+		"noop://localhost/",
+		"noop://localhost/#name=wide&concurrency=8",
+	}, 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := clients[0].Concurrency, 2; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := clients[0].Label(), "noop://localhost/"; got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+	if got, want := clients[1].Concurrency, 8; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := clients[1].Stats.Concurrency, 8; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := clients[1].Label(), "wide"; got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+
+	for _, endpoint := range []string{
+		"noop://localhost/#concurrency=0",
+		"noop://localhost/#concurrency=lots",
+		"noop://localhost/#nmae=x",
+	} {
+		if _, err := NewClient(endpoint, 1); err == nil {
+			t.Errorf("%s: expected an error", endpoint)
+		}
+	}
+}
+
+func TestKeepAliveOption(t *testing.T) {
+	tr, err := NewTransport("http://localhost/#keepalive=off", transportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.(*httpTransport).Client.Transport.(*http.Transport).DisableKeepAlives {
+		t.Errorf("expected keep-alives to be disabled")
+	}
+	tests := []struct {
+		endpoint string
+		disabled bool
+	}{
+		{"http://localhost/", true},
+		{"http://localhost/#keepalive=off", true},
+		{"http://localhost/#keepalive=on", false},
+	}
+	for _, tc := range tests {
+		tr, err := NewTransport(tc.endpoint, transportOptions{NoKeepAlive: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tr.(*httpTransport).Client.Transport.(*http.Transport).DisableKeepAlives; got != tc.disabled {
+			t.Errorf("%s with --no-keepalive: got: %v; want: %v", tc.endpoint, got, tc.disabled)
+		}
+	}
+	if _, err := NewTransport("http://localhost/#keepalive=maybe", transportOptions{}); err == nil {
+		t.Errorf("expected an error for an invalid keepalive")
+	}
+}
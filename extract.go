@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type extractRule struct {
+	Name string
+	Path jsonPath
+}
+
+// parseExtractRules parses rules in the form of NAME=JSONPATH, such as
+// "userID=$.result.id".
+func parseExtractRules(specs []string) ([]extractRule, error) {
+	return parseNamedPaths("extract rule", specs)
+}
+
+// parseNamedPaths parses NAME=JSONPATH specs, kind names them in errors.
+func parseNamedPaths(kind string, specs []string) ([]extractRule, error) {
+	rules := make([]extractRule, 0, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid %s %q: must be NAME=JSONPATH", kind, spec)
+		}
+		path, err := parseJSONPath(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, extractRule{Name: parts[0], Path: path})
+	}
+	return rules, nil
+}
+
+// extractor captures values from responses and substitutes them as
+// {{NAME}} into later requests. Each client has its own extractor, so values
+// are scoped per endpoint.
+type extractor struct {
+	rules []extractRule
+
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func newExtractor(rules []extractRule) *extractor {
+	return &extractor{
+		rules:  rules,
+		values: make(map[string][]byte, len(rules)),
+	}
+}
+
+// Extract captures the values of all rules found in the response body.
+// Values of rules that are not found are left unchanged.
+func (e *extractor) Extract(body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, rule := range e.rules {
+		found, ok := rule.Path.Lookup(v)
+		if !ok {
+			continue
+		}
+		switch found := found.(type) {
+		case string:
+			e.values[rule.Name] = []byte(found)
+		case json.Number:
+			e.values[rule.Name] = []byte(found.String())
+		default:
+			raw, err := json.Marshal(found)
+			if err != nil {
+				continue
+			}
+			e.values[rule.Name] = raw
+		}
+	}
+}
+
+// Expand returns the line with {{NAME}} placeholders replaced by extracted
+// values. Placeholders without a value are left as-is. The original line is
+// never modified.
+func (e *extractor) Expand(line []byte) []byte {
+	if !bytes.Contains(line, []byte("{{")) {
+		return line
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	out := make([]byte, 0, len(line))
+	rest := line
+	for {
+		start := bytes.Index(rest, []byte("{{"))
+		if start == -1 {
+			break
+		}
+		end := bytes.Index(rest[start:], []byte("}}"))
+		if end == -1 {
+			break
+		}
+		end += start
+		name := string(rest[start+2 : end])
+		if v, ok := e.values[name]; ok {
+			out = append(out, rest[:start]...)
+			out = append(out, v...)
+		} else {
+			out = append(out, rest[:end+2]...)
+		}
+		rest = rest[end+2:]
+	}
+	return append(out, rest...)
+}
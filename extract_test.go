@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestJSONPath(t *testing.T) {
+	v := map[string]interface{}{
+		"result": map[string]interface{}{
+			"id":    "abc",
+			"items": []interface{}{"x", "y", "z"},
+			"a.b":   true,
+		},
+	}
+
+	tests := []struct {
+		Path  string
+		Want  interface{}
+		Found bool
+	}{
+		{"$.result.id", "abc", true},
+		{"$.result.items[1]", "y", true},
+		{"$.result.items[-1]", "z", true},
+		{"$.result['a.b']", true, true},
+		{"$.result.items[3]", nil, false},
+		{"$.missing", nil, false},
+		{"$.result.id.nope", nil, false},
+	}
+
+	for _, tc := range tests {
+		p, err := parseJSONPath(tc.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := p.Lookup(v)
+		if ok != tc.Found || got != tc.Want {
+			t.Errorf("%s: got: %v, %t; want: %v, %t", tc.Path, got, ok, tc.Want, tc.Found)
+		}
+	}
+
+	for _, invalid := range []string{"result", "$.", "$[1", "$[x]"} {
+		if _, err := parseJSONPath(invalid); err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+func TestExtractor(t *testing.T) {
+	rules, err := parseExtractRules([]string{"id=$.result.id", "n=$.result.n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newExtractor(rules)
+
+	line := []byte(`{"params":["{{id}}", {{n}}, "{{unknown}}"]}`)
+	if got, want := string(e.Expand(line)), string(line); got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+
+	e.Extract([]byte(`{"result":{"id":"0xabc","n":12345678901234567890}}`))
+	if got, want := string(e.Expand(line)), `{"params":["0xabc", 12345678901234567890, "{{unknown}}"]}`; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+
+	// Missing values don't clobber previous values
+	e.Extract([]byte(`{"result":{}}`))
+	if got, want := string(e.Expand([]byte("{{id}}"))), "0xabc"; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tags of requests with injected faults, so that their stats are broken down
+// in the report.
+const (
+	faultDelayTag     = "fault=delay"
+	faultDuplicateTag = "fault=duplicate"
+)
+
+// fault is an injected fault: it's applied to a request with a probability,
+// for a duration if it has one.
+type fault struct {
+	Probability float64
+	Duration    time.Duration
+}
+
+// parseFault parses a fault as PROBABILITY or PROBABILITY:DURATION, such as
+// "0.05:200ms".
+func parseFault(spec string, withDuration bool) (fault, error) {
+	var f fault
+	p := spec
+	if withDuration {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 {
+			return f, fmt.Errorf("invalid fault %q: must be PROBABILITY:DURATION", spec)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil || d <= 0 {
+			return f, fmt.Errorf("invalid fault duration: %s", parts[1])
+		}
+		p, f.Duration = parts[0], d
+	}
+	prob, err := strconv.ParseFloat(p, 64)
+	if err != nil || prob < 0 || prob > 1 {
+		return f, fmt.Errorf("invalid fault probability %q: must be between 0 and 1", p)
+	}
+	f.Probability = prob
+	return f, nil
+}
+
+// faultInjector injects client-side faults into requests. Faults are decided
+// per request, so every endpoint gets the same ones.
+type faultInjector struct {
+	Delay     fault // Wait before sending the request
+	Drop      fault // Abandon the request after the duration, without comparing it
+	Duplicate fault // Send the request a second time
+
+	rand *rand.Rand
+}
+
+func newFaultInjector(seed int64) *faultInjector {
+	return &faultInjector{rand: rand.New(rand.NewSource(seed))}
+}
+
+func (f *faultInjector) hit(fault fault) bool {
+	return fault.Probability > 0 && f.rand.Float64() < fault.Probability
+}
+
+// Inject applies faults to the request, and returns whether it must also be
+// sent as a duplicate.
+func (f *faultInjector) Inject(req *Request) bool {
+	if f.hit(f.Delay) {
+		req.Delay = f.Delay.Duration
+		req.Tags = withTag(req.Tags, faultDelayTag)
+	}
+	if f.hit(f.Drop) {
+		req.Abandon = f.Drop.Duration
+	}
+	return f.hit(f.Duplicate)
+}
+
+// duplicate returns a copy of the request to send as a duplicate.
+func duplicate(req Request) Request {
+	req.Tags = withTag(req.Tags, faultDuplicateTag)
+	return req
+}
+
+// withTag returns a copy of the sorted tags with the tag added.
+func withTag(tags []string, tag string) []string {
+	tags = append(append(make([]string, 0, len(tags)+1), tags...), tag)
+	sort.Strings(tags)
+	return tags
+}
+
+// sleep waits for the duration, and returns false if the context is done
+// first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
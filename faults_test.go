@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFault(t *testing.T) {
+	f, err := parseFault("0.05:200ms", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f, (fault{0.05, 200 * time.Millisecond}); got != want {
+		t.Errorf("got: %v; want: %v", got, want)
+	}
+	if f, err = parseFault("1", false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.Probability, 1.0; got != want {
+		t.Errorf("got: %f; want: %f", got, want)
+	}
+
+	for _, spec := range []string{"0.05", "1.5:1s", "0.1:soon", "0.1:-1s", "often:1s"} {
+		if _, err := parseFault(spec, true); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	decisions := func(seed int64) []bool {
+		f := newFaultInjector(seed)
+		f.Duplicate = fault{Probability: 0.5}
+		var got []bool
+		for i := 0; i < 20; i++ {
+			got = append(got, f.Inject(&Request{}))
+		}
+		return got
+	}
+	if got, want := decisions(42), decisions(42); !reflect.DeepEqual(got, want) {
+		t.Errorf("same seed, different faults: %v and %v", got, want)
+	}
+
+	f := newFaultInjector(1)
+	f.Delay = fault{1, time.Second}
+	f.Drop = fault{0, time.Second}
+	req := Request{Tags: []string{"tenant=acme"}}
+	if f.Inject(&req) {
+		t.Errorf("got a duplicate without a duplicate fault")
+	}
+	if got, want := req.Delay, time.Second; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+	if got, want := req.Abandon, time.Duration(0); got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+	if got, want := req.Tags, []string{"fault=delay", "tenant=acme"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+	if got, want := duplicate(req).Tags, []string{"fault=delay", "fault=duplicate", "tenant=acme"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+}
+
+func TestReportAbandoned(t *testing.T) {
+	clients, err := NewClients([]string{"noop://foo", "noop://bar"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := report{Clients: clients}
+	r.init()
+
+	req := &Request{ID: 1, Abandon: time.Millisecond}
+	r.handle(Response{client: clients[0], ID: 1, Request: req, Abandoned: true})
+	r.handle(Response{client: clients[1], ID: 1, Request: req, Abandoned: true, Body: []byte("x")})
+	if got, want := r.dropped, 1; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := r.completed, 0; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := r.requests, 0; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := r.mismatched, 0; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxRecordSize is the largest framed input record. Lines are limited to the
+// scanner's buffer instead.
+const maxRecordSize = 64 * 1024 * 1024
+
+var errTruncatedRecord = errors.New("truncated input record")
+
+// inputSplit returns the split function of an input framing, or nil for
+// lines:
+//
+//	lines   a record per line
+//	length  each record is prefixed with its size, as a 4-byte big-endian integer
+//	varint  each record is prefixed with its size, as an unsigned varint, like
+//	        delimited protobuf streams
+//
+// Framed records can contain newlines and binary data.
+func inputSplit(framing string) (bufio.SplitFunc, error) {
+	switch framing {
+	case "", "lines":
+		return nil, nil
+	case "length":
+		return splitLengthPrefixed, nil
+	case "varint":
+		return splitVarintPrefixed, nil
+	}
+	return nil, fmt.Errorf("unsupported input framing: %s", framing)
+}
+
+func splitLengthPrefixed(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) < 4 {
+		return needRecord(data, atEOF)
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	return splitRecord(data, 4, size, atEOF)
+}
+
+func splitVarintPrefixed(data []byte, atEOF bool) (int, []byte, error) {
+	size, n := binary.Uvarint(data)
+	switch {
+	case n == 0:
+		return needRecord(data, atEOF)
+	case n < 0 || size > maxRecordSize:
+		return 0, nil, fmt.Errorf("input record is larger than %s", formatBytes(maxRecordSize))
+	}
+	return splitRecord(data, n, int(size), atEOF)
+}
+
+// splitRecord returns the record of size after the prefix, once data has all
+// of it.
+func splitRecord(data []byte, prefix, size int, atEOF bool) (int, []byte, error) {
+	if size > maxRecordSize {
+		return 0, nil, fmt.Errorf("input record is larger than %s", formatBytes(maxRecordSize))
+	}
+	if len(data) < prefix+size {
+		return needRecord(data, atEOF)
+	}
+	return prefix + size, data[prefix : prefix+size], nil
+}
+
+// needRecord asks for more data, unless the input ended within a record.
+func needRecord(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) > 0 {
+		return 0, nil, errTruncatedRecord
+	}
+	return 0, nil, nil
+}
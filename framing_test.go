@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func frame(framing string, records ...string) []byte {
+	var buf bytes.Buffer
+	for _, r := range records {
+		switch framing {
+		case "length":
+			var size [4]byte
+			binary.BigEndian.PutUint32(size[:], uint32(len(r)))
+			buf.Write(size[:])
+		case "varint":
+			var size [binary.MaxVarintLen64]byte
+			buf.Write(size[:binary.PutUvarint(size[:], uint64(len(r)))])
+		}
+		buf.WriteString(r)
+	}
+	return buf.Bytes()
+}
+
+func scanAll(t *testing.T, framing string, input []byte) ([]string, error) {
+	t.Helper()
+	split, err := inputSplit(framing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	lines, errc := scanLines(bytes.NewReader(input), split, stop)
+	var got []string
+	for line := range lines {
+		got = append(got, string(line))
+	}
+	return got, <-errc
+}
+
+func TestInputFraming(t *testing.T) {
+	// Records with newlines and binary data, and one over the line buffer
+	large := string(bytes.Repeat([]byte("x"), 2*1024*1024))
+	records := []string{"{\"a\":\n1}", "\x00\xff\r\n\x01", large}
+	for _, framing := range []string{"length", "varint"} {
+		got, err := scanAll(t, framing, frame(framing, records...))
+		if err != nil {
+			t.Fatalf("%s: %s", framing, err)
+		}
+		if !reflect.DeepEqual(got, records) {
+			t.Errorf("%s: got %d records; want: %d", framing, len(got), len(records))
+		}
+
+		truncated := frame(framing, "abc")
+		if _, err := scanAll(t, framing, truncated[:len(truncated)-1]); err != errTruncatedRecord {
+			t.Errorf("%s: got: %v; want: %v", framing, err, errTruncatedRecord)
+		}
+	}
+
+	got, err := scanAll(t, "lines", []byte("a\r\nb\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+
+	if _, err := inputSplit("cbor"); err == nil {
+		t.Errorf("expected an error for an unsupported framing")
+	}
+}
+
+func TestInputFramingTooLarge(t *testing.T) {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], maxRecordSize+1)
+	if _, err := scanAll(t, "length", size[:]); err == nil {
+		t.Errorf("expected an error for a record over the limit")
+	}
+}
@@ -3,8 +3,11 @@ module github.com/INFURA/versus
 go 1.13
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/gorilla/websocket v1.4.2
 	github.com/jessevdk/go-flags v1.4.0
+	github.com/klauspost/compress v1.15.9
 	github.com/rs/zerolog v1.17.2
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
+	google.golang.org/protobuf v1.28.1
 )
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// groupSpec is an endpoint group of a groups file.
+type groupSpec struct {
+	Name      string   `json:"name"`
+	Endpoints []string `json:"endpoints"`
+	// Tags filter the input: only requests with any of them are sent to the
+	// group, or every request if there are none
+	Tags []string `json:"tags"`
+}
+
+// readGroupsFile reads the endpoint groups of a run from a JSON file:
+//
+//	[
+//	  {"name": "eth", "endpoints": ["https://a.example.com/eth", "https://b.example.com/eth"], "tags": ["service=eth"]},
+//	  {"name": "btc", "endpoints": ["https://a.example.com/btc", "https://b.example.com/btc"], "tags": ["service=btc"]}
+//	]
+func readGroupsFile(path string) ([]groupSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read groups file: %w", err)
+	}
+	var specs []groupSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("failed to parse groups file: %w", err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("groups file has no groups")
+	}
+	names := map[string]bool{}
+	for _, spec := range specs {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("every group must have a name")
+		}
+		if names[spec.Name] {
+			return nil, fmt.Errorf("duplicate group name: %s", spec.Name)
+		}
+		names[spec.Name] = true
+		if len(spec.Endpoints) == 0 {
+			return nil, fmt.Errorf("group has no endpoints: %s", spec.Name)
+		}
+	}
+	return specs, nil
+}
+
+// group is a set of endpoints that are compared with each other, with its
+// own report.
+type group struct {
+	Name   string
+	Tags   []string
+	Report *report
+	Set    *clientSet
+}
+
+// Accepts returns whether the request is sent to the group.
+func (g *group) Accepts(req Request) bool {
+	if len(g.Tags) == 0 {
+		return true
+	}
+	for _, want := range g.Tags {
+		for _, tag := range req.Tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestSink receives the requests of the input feed.
+type requestSink interface {
+	Send(ctx context.Context, req Request) error
+	// Finalize is called once the feed ends
+	Finalize()
+}
+
+// groupSet sends each request to the groups that accept it.
+type groupSet []*group
+
+func (gs groupSet) Send(ctx context.Context, req Request) error {
+	for _, g := range gs {
+		if !g.Accepts(req) {
+			continue
+		}
+		if err := g.Set.Send(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gs groupSet) Finalize() {
+	for _, g := range gs {
+		g.Set.Finalize()
+	}
+}
+
+// Reports returns the report of every group.
+func (gs groupSet) Reports() []*report {
+	reports := make([]*report, 0, len(gs))
+	for _, g := range gs {
+		reports = append(reports, g.Report)
+	}
+	return reports
+}
+
+// renderReports writes the reports in the format, text, json or html, with text
+// colored by the palette. A single report without a group is written as-is,
+// otherwise each is headed by its group.
+func renderReports(w io.Writer, reports []*report, format string, colors palette) error {
+	if format == "html" {
+		return renderHTML(w, reports)
+	}
+	if len(reports) == 1 && reports[0].Group == "" {
+		if format == "json" {
+			return reports[0].RenderJSON(w)
+		}
+		return reports[0].Render(w, colors)
+	}
+	if format == "json" {
+		var out struct {
+			Groups []reportSummary `json:"groups"`
+		}
+		for _, r := range reports {
+			out.Groups = append(out.Groups, r.Summary())
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	for i, r := range reports {
+		if i > 0 {
+			fmt.Fprintf(w, "\n")
+		}
+		fmt.Fprintf(w, "%s\n\n", colors.Heading(fmt.Sprintf("=== Group %q", r.Group)))
+		if err := r.Render(w, colors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
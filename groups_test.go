@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadGroupsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "versus-groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		data    string
+		wantErr bool
+	}{
+		{`[{"name":"eth","endpoints":["noop://a"],"tags":["service=eth"]},{"name":"all","endpoints":["noop://b"]}]`, false},
+		{`[]`, true},
+		{`[{"endpoints":["noop://a"]}]`, true},
+		{`[{"name":"eth"}]`, true},
+		{`[{"name":"eth","endpoints":["noop://a"]},{"name":"eth","endpoints":["noop://b"]}]`, true},
+	}
+	for i, tc := range tests {
+		path := filepath.Join(dir, "groups.json")
+		if err := ioutil.WriteFile(path, []byte(tc.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		specs, err := readGroupsFile(path)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%d: got error: %v; want error: %t", i, err, tc.wantErr)
+			continue
+		}
+		if err == nil && len(specs) != 2 {
+			t.Errorf("%d: got: %d groups; want: 2", i, len(specs))
+		}
+	}
+}
+
+func TestGroupAccepts(t *testing.T) {
+	all := &group{Name: "all"}
+	eth := &group{Name: "eth", Tags: []string{"service=eth", "canary"}}
+
+	tests := []struct {
+		tags    []string
+		wantAll bool
+		wantEth bool
+	}{
+		{nil, true, false},
+		{[]string{"service=btc"}, true, false},
+		{[]string{"service=eth"}, true, true},
+		{[]string{"canary", "service=btc"}, true, true},
+	}
+	for _, tc := range tests {
+		req := Request{Tags: tc.tags}
+		if got, want := all.Accepts(req), tc.wantAll; got != want {
+			t.Errorf("%q: got: %t; want: %t", tc.tags, got, want)
+		}
+		if got, want := eth.Accepts(req), tc.wantEth; got != want {
+			t.Errorf("%q: got: %t; want: %t", tc.tags, got, want)
+		}
+	}
+}
+
+func TestRenderGroupReports(t *testing.T) {
+	reports := []*report{{Group: "eth"}, {Group: "btc"}}
+	var buf bytes.Buffer
+	if err := renderReports(&buf, reports, "json", palette{}); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Groups []reportSummary `json:"groups"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(out.Groups), 2; got != want {
+		t.Fatalf("got: %d; want: %d", got, want)
+	}
+	if got, want := out.Groups[1].Group, "btc"; got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+
+	buf.Reset()
+	if err := renderReports(&buf, []*report{{}}, "json", palette{}); err != nil {
+		t.Fatal(err)
+	}
+	var summary reportSummary
+	if err := json.Unmarshal(buf.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summary.Version, Version; got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// grpcCodes are the names of gRPC status codes, as Connect spells them, so
+// that errors of gRPC, gRPC-Web and Connect endpoints compare equal.
+var grpcCodes = []string{
+	"ok", "canceled", "unknown", "invalid_argument", "deadline_exceeded",
+	"not_found", "already_exists", "permission_denied", "resource_exhausted",
+	"failed_precondition", "aborted", "out_of_range", "unimplemented",
+	"internal", "unavailable", "data_loss", "unauthenticated",
+}
+
+// rpcProtocol frames unary requests and their responses for an RPC protocol
+// over HTTP: native gRPC (grpc), which needs HTTP/2, gRPC-Web (grpc-web), or
+// Connect (connect). Requests are the message as it is in the input, encoded
+// with the codec: binary protobuf (proto) or JSON (json).
+type rpcProtocol struct {
+	Name  string
+	Codec string
+}
+
+func newRPCProtocol(name, codec string) (*rpcProtocol, error) {
+	switch codec {
+	case "":
+		codec = "proto"
+	case "proto", "json":
+	default:
+		return nil, fmt.Errorf("invalid codec: %s", codec)
+	}
+	return &rpcProtocol{Name: name, Codec: codec}, nil
+}
+
+// ContentType returns the content type of requests.
+func (p *rpcProtocol) ContentType() string {
+	switch p.Name {
+	case "grpc":
+		return "application/grpc+" + p.Codec
+	case "grpc-web":
+		return "application/grpc-web+" + p.Codec
+	default:
+		return "application/" + p.Codec
+	}
+}
+
+// Encode returns the request body of a message.
+func (p *rpcProtocol) Encode(msg []byte) []byte {
+	if p.Name == "connect" {
+		// Unary Connect requests aren't enveloped
+		return msg
+	}
+	framed := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(framed[1:], uint32(len(msg)))
+	return append(framed, msg...)
+}
+
+// SetHeaders sets the protocol's headers of a request.
+func (p *rpcProtocol) SetHeaders(h http.Header) {
+	switch p.Name {
+	case "grpc":
+		h.Set("TE", "trailers")
+	case "grpc-web":
+		h.Set("X-Grpc-Web", "1")
+	case "connect":
+		h.Set("Connect-Protocol-Version", "1")
+	}
+}
+
+// Read reads the response message into resp.Body, and returns the RPC's
+// error status as an error, such as "rpc error: not_found: no such user".
+func (p *rpcProtocol) Read(httpResp *http.Response, resp *Response) error {
+	defer httpResp.Body.Close()
+	if p.Name == "connect" {
+		if httpResp.StatusCode != http.StatusOK {
+			return readConnectError(httpResp)
+		}
+		return readPooledBody(httpResp.Body, resp)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status code: %d", httpResp.StatusCode)
+	}
+	if err := readPooledBody(httpResp.Body, resp); err != nil {
+		return err
+	}
+	msg, trailer, err := unframeGRPC(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = msg
+
+	// Trailers-only responses have the status in their headers
+	status := httpResp.Header
+	if trailer != nil {
+		status = trailer
+	} else if httpResp.Trailer.Get("Grpc-Status") != "" {
+		status = httpResp.Trailer
+	}
+	code := status.Get("Grpc-Status")
+	if code == "" {
+		return fmt.Errorf("missing grpc-status")
+	}
+	if code == "0" {
+		return nil
+	}
+	resp.Body = nil
+	message, err := url.PathUnescape(status.Get("Grpc-Message"))
+	if err != nil {
+		message = status.Get("Grpc-Message")
+	}
+	return rpcError(code, message)
+}
+
+// unframeGRPC returns the message of a gRPC response body, and the trailers
+// of gRPC-Web responses, which are the last frame.
+func unframeGRPC(body []byte) ([]byte, http.Header, error) {
+	var msg []byte
+	var trailer http.Header
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return nil, nil, fmt.Errorf("truncated grpc frame")
+		}
+		flags, size := body[0], binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(size) {
+			return nil, nil, fmt.Errorf("truncated grpc frame")
+		}
+		frame := body[5 : 5+size]
+		body = body[5+size:]
+		switch {
+		case flags&0x80 != 0:
+			h, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(frame, "\r\n\r\n"...)))).ReadMIMEHeader()
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid grpc-web trailers: %w", err)
+			}
+			trailer = http.Header(h)
+		case flags&0x01 != 0:
+			return nil, nil, fmt.Errorf("compressed grpc messages are not supported")
+		case msg != nil:
+			return nil, nil, fmt.Errorf("streaming grpc responses are not supported")
+		default:
+			msg = frame
+		}
+	}
+	return msg, trailer, nil
+}
+
+// readConnectError returns the error of a Connect response, from its JSON
+// body such as {"code": "not_found", "message": "no such user"}.
+func readConnectError(httpResp *http.Response) error {
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	var e struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &e); err != nil || e.Code == "" {
+		return fmt.Errorf("bad status code: %d", httpResp.StatusCode)
+	}
+	return rpcError(e.Code, e.Message)
+}
+
+// rpcError returns the error of an RPC status, by the name or number of its
+// code.
+func rpcError(code, message string) error {
+	if n, err := strconv.Atoi(code); err == nil && n >= 0 && n < len(grpcCodes) {
+		code = grpcCodes[n]
+	}
+	if message == "" {
+		return fmt.Errorf("rpc error: %s", strings.ToLower(code))
+	}
+	return fmt.Errorf("rpc error: %s: %s", strings.ToLower(code), message)
+}
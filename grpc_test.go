@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rpcUsers answers GetUser calls, whose message is the user id, with the
+// message "user:ID", or not_found for unknown ids, in every protocol.
+func rpcUsers(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ct := r.Header.Get("Content-Type")
+		if r.URL.Path != "/acme.v1.Users/GetUser" {
+			t.Errorf("got path: %s", r.URL.Path)
+		}
+		if ct == "application/proto" {
+			// Connect, unframed
+			if r.Header.Get("Connect-Protocol-Version") != "1" {
+				t.Errorf("got connect headers: %v", r.Header)
+			}
+			if string(body) != "42" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"code":"not_found","message":"no such user"}`))
+				return
+			}
+			w.Header().Set("Content-Type", "application/proto")
+			w.Write([]byte("user:42"))
+			return
+		}
+		if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+			t.Errorf("got unframed %s request: %q", ct, body)
+			return
+		}
+		id := string(body[5:])
+		frame := func(flags byte, data string) []byte {
+			f := make([]byte, 5)
+			f[0] = flags
+			binary.BigEndian.PutUint32(f[1:], uint32(len(data)))
+			return append(f, data...)
+		}
+		w.Header().Set("Content-Type", ct)
+		switch ct {
+		case "application/grpc-web+proto":
+			if id != "42" {
+				w.Write(frame(0x80, "grpc-status: 5\r\ngrpc-message: no%20such%20user\r\n"))
+				return
+			}
+			w.Write(frame(0, "user:42"))
+			w.Write(frame(0x80, "grpc-status: 0\r\n"))
+		case "application/grpc+proto":
+			if r.ProtoMajor != 2 || r.Header.Get("TE") != "trailers" {
+				t.Errorf("got HTTP/%d grpc request with TE %q", r.ProtoMajor, r.Header.Get("TE"))
+			}
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			if id != "42" {
+				w.Header().Set("Grpc-Status", "5")
+				w.Header().Set("Grpc-Message", "no%20such%20user")
+				return
+			}
+			w.Write(frame(0, "user:42"))
+			w.Header().Set("Grpc-Status", "0")
+		default:
+			t.Errorf("got content type: %s", ct)
+		}
+	})
+}
+
+func TestRPCProtocols(t *testing.T) {
+	plain := httptest.NewServer(rpcUsers(t))
+	defer plain.Close()
+	h2 := httptest.NewUnstartedServer(rpcUsers(t))
+	h2.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	h2.StartTLS()
+	defer h2.Close()
+
+	path := "/acme.v1.Users/GetUser"
+	newTransport := func(endpoint string) Transport {
+		tr, err := NewTransport(endpoint, transportOptions{Timeout: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(endpoint, "https") {
+			// Trust the test server, its certificate is self-signed
+			tr.(*httpTransport).Client.Transport.(*http.Transport).TLSClientConfig = h2.Client().Transport.(*http.Transport).TLSClientConfig
+		}
+		return tr
+	}
+	transports := map[string]Transport{
+		"grpc-web": newTransport("http+grpc-web" + strings.TrimPrefix(plain.URL, "http") + path),
+		"connect":  newTransport("http+connect" + strings.TrimPrefix(plain.URL, "http") + path),
+		"grpc":     newTransport("https+grpc" + strings.TrimPrefix(h2.URL, "https") + path),
+	}
+	for name, tr := range transports {
+		for _, tc := range []struct {
+			id      string
+			want    string
+			wantErr string
+		}{
+			{"42", "user:42", ""},
+			{"7", "", "rpc error: not_found: no such user"},
+		} {
+			var resp Response
+			err := tr.Send(context.Background(), &Request{Line: []byte(tc.id)}, &resp)
+			if gotErr := ""; err != nil {
+				gotErr = err.Error()
+				if gotErr != tc.wantErr {
+					t.Errorf("%s %s: got error: %s; want: %q", name, tc.id, gotErr, tc.wantErr)
+				}
+			} else if tc.wantErr != "" || string(resp.Body) != tc.want {
+				t.Errorf("%s %s: got: %q; want: %q, error %q", name, tc.id, resp.Body, tc.want, tc.wantErr)
+			}
+		}
+	}
+}
+
+func TestRPCProtocolOptions(t *testing.T) {
+	for _, endpoint := range []string{
+		"http+grpc://localhost/acme.v1.Users/GetUser",
+		"http+connect://localhost/#codec=xml",
+		"http://localhost/#codec=json",
+	} {
+		if _, err := NewTransport(endpoint, transportOptions{}); err == nil {
+			t.Errorf("%s: got no error", endpoint)
+		}
+	}
+	tr, err := NewTransport("http+connect://localhost/#codec=json", transportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.(*httpTransport).contentType; got != "application/json" {
+		t.Errorf("got content type: %s; want application/json", got)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headRequest asks an Ethereum endpoint for the height of its chain head.
+var headRequest = []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+
+// headTracker periodically queries the chain head of each endpoint, to tell
+// how far behind the highest endpoint each one is, and whether mismatches
+// happened while endpoints were at different heights, which is usually a
+// node lagging rather than a bug.
+type headTracker struct {
+	Interval time.Duration
+	Suppress bool // Don't count mismatches while endpoints are at different heights
+
+	mu         sync.Mutex
+	clients    []*Client
+	heads      map[*Client]*headStats
+	transports map[*Client]Transport // Used by Serve only
+}
+
+// headStats are the chain heads of an endpoint.
+type headStats struct {
+	height uint64 // At the last successful check
+	known  bool
+	checks int // Number of successful checks
+	failed int // Number of failed checks
+	lagSum uint64
+	maxLag uint64 // Blocks behind the highest endpoint
+}
+
+func newHeadTracker(clients []*Client, interval time.Duration, suppress bool) *headTracker {
+	t := &headTracker{Interval: interval, Suppress: suppress, heads: map[*Client]*headStats{}}
+	for _, c := range clients {
+		t.Add(c)
+	}
+	return t
+}
+
+// Add tracks the chain head of a client that joined mid-run.
+func (t *headTracker) Add(c *Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clients = append(t.clients, c)
+	t.heads[c] = &headStats{}
+}
+
+// Remove stops tracking the chain head of a client that left mid-run. Its
+// heads so far are kept for the report.
+func (t *headTracker) Remove(c *Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, other := range t.clients {
+		if other == c {
+			t.clients = append(t.clients[:i:i], t.clients[i+1:]...)
+			return
+		}
+	}
+}
+
+// Serve checks the chain heads every interval until the context is done.
+func (t *headTracker) Serve(ctx context.Context) {
+	defer func() {
+		for _, tr := range t.transports {
+			if closer, ok := tr.(io.Closer); ok {
+				closer.Close()
+			}
+		}
+	}()
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+	for {
+		t.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check queries the chain head of every endpoint at once, and records how
+// far behind the highest one each is.
+func (t *headTracker) check(ctx context.Context) {
+	t.mu.Lock()
+	clients := append([]*Client(nil), t.clients...)
+	t.mu.Unlock()
+	t.closeRemoved(clients)
+
+	heights := make([]uint64, len(clients))
+	errs := make([]error, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		tr, err := t.transport(c)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		wg.Add(1)
+		go func(i int, c *Client, tr Transport) {
+			defer wg.Done()
+			heights[i], errs[i] = queryHead(ctx, c, tr)
+		}(i, c, tr)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	var highest uint64
+	for i := range clients {
+		if errs[i] == nil && heights[i] > highest {
+			highest = heights[i]
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, c := range clients {
+		stats := t.heads[c]
+		if errs[i] != nil {
+			logger.Debug().Err(errs[i]).Str("endpoint", c.Endpoint).Msg("failed to check chain head")
+			stats.failed += 1
+			stats.known = false
+			continue
+		}
+		lag := highest - heights[i]
+		stats.height, stats.known = heights[i], true
+		stats.checks += 1
+		stats.lagSum += lag
+		if lag > stats.maxLag {
+			stats.maxLag = lag
+		}
+	}
+}
+
+// closeRemoved closes the transports of the clients that aren't tracked
+// anymore.
+func (t *headTracker) closeRemoved(clients []*Client) {
+	tracked := make(map[*Client]bool, len(clients))
+	for _, c := range clients {
+		tracked[c] = true
+	}
+	for c, tr := range t.transports {
+		if tracked[c] {
+			continue
+		}
+		if closer, ok := tr.(io.Closer); ok {
+			closer.Close()
+		}
+		delete(t.transports, c)
+	}
+}
+
+func (t *headTracker) transport(c *Client) (Transport, error) {
+	if tr, ok := t.transports[c]; ok {
+		return tr, nil
+	}
+	tr, err := c.transport()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transport for %s: %w", c.Endpoint, err)
+	}
+	if t.transports == nil {
+		t.transports = map[*Client]Transport{}
+	}
+	t.transports[c] = tr
+	return tr, nil
+}
+
+// queryHead returns the height of the endpoint's chain head.
+func queryHead(ctx context.Context, c *Client, t Transport) (uint64, error) {
+	resp := c.do(ctx, t, Request{client: c, Line: headRequest})
+	defer resp.release()
+	if resp.Err != nil {
+		return 0, resp.Err
+	}
+	var msg struct {
+		Result string          `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(resp.Body, &msg); err != nil {
+		return 0, fmt.Errorf("invalid eth_blockNumber response: %w", err)
+	}
+	if len(msg.Error) > 0 {
+		return 0, fmt.Errorf("eth_blockNumber failed: %s", msg.Error)
+	}
+	if !strings.HasPrefix(msg.Result, "0x") {
+		return 0, fmt.Errorf("invalid eth_blockNumber result: %q", msg.Result)
+	}
+	height, err := strconv.ParseUint(msg.Result[2:], 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid eth_blockNumber result: %q", msg.Result)
+	}
+	return height, nil
+}
+
+// Lagging returns whether the endpoints of the responses were at different
+// heights at the last check.
+func (t *headTracker) Lagging(resps []Response) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var height uint64
+	seen := false
+	for _, resp := range resps {
+		stats, ok := t.heads[resp.client]
+		if !ok || !stats.known {
+			continue
+		}
+		if seen && stats.height != height {
+			return true
+		}
+		height, seen = stats.height, true
+	}
+	return false
+}
+
+// headSummary is the machine-readable form of an endpoint's chain heads.
+type headSummary struct {
+	Height uint64  `json:"height"` // At the last successful check
+	Checks int     `json:"checks"`
+	Failed int     `json:"failed,omitempty"`
+	AvgLag float64 `json:"avg_lag"` // Blocks behind the highest endpoint
+	MaxLag uint64  `json:"max_lag"`
+}
+
+// Summary returns the chain heads of the client, or nil if it isn't tracked.
+func (t *headTracker) Summary(c *Client) *headSummary {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats, ok := t.heads[c]
+	if !ok {
+		return nil
+	}
+	s := &headSummary{Height: stats.height, Checks: stats.checks, Failed: stats.failed, MaxLag: stats.maxLag}
+	if stats.checks > 0 {
+		s.AvgLag = float64(stats.lagSum) / float64(stats.checks)
+	}
+	return s
+}
+
+// renderHeads writes the chain heads of each endpoint as part of the text
+// report.
+func renderHeads(w io.Writer, colors palette, endpoints []endpointSummary, lagging int, suppressed bool) {
+	if len(endpoints) == 0 || endpoints[0].Head == nil {
+		return
+	}
+	fmt.Fprintf(w, "\n%s\n", colors.Heading("** Chain head:"))
+	for i, e := range endpoints {
+		h := e.Head
+		if h.Checks == 0 {
+			fmt.Fprintf(w, "   %d. %q: unknown, %d failed checks\n", i, e.label(), h.Failed)
+			continue
+		}
+		fmt.Fprintf(w, "   %d. %q: at %d, %0.2f blocks behind on average, %d at most, over %d checks", i, e.label(), h.Height, h.AvgLag, h.MaxLag, h.Checks)
+		if h.Failed > 0 {
+			fmt.Fprintf(w, ", %d failed", h.Failed)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if lagging > 0 {
+		verb := "counted"
+		if suppressed {
+			verb = "not counted"
+		}
+		fmt.Fprintf(w, "   Lagging:    %d mismatched results while endpoints were at different heights, %s as mismatches\n", lagging, verb)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHeadTracker(t *testing.T) {
+	var mu sync.Mutex
+	heights := map[string]string{"/a": "0x10", "/b": "0xe", "/c": "oops"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + heights[r.URL.Path] + `"}`))
+	}))
+	defer srv.Close()
+
+	clients, err := NewClients([]string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := clients[0], clients[1], clients[2]
+	heads := newHeadTracker(clients, time.Second, true)
+	heads.check(context.Background())
+	mu.Lock()
+	heights["/b"] = "0x10"
+	mu.Unlock()
+	heads.check(context.Background())
+
+	if got := heads.Summary(a); got.Height != 16 || got.Checks != 2 || got.MaxLag != 0 {
+		t.Errorf("a: got: %+v", got)
+	}
+	if got := heads.Summary(b); got.Height != 16 || got.AvgLag != 1 || got.MaxLag != 2 {
+		t.Errorf("b: got: %+v; want 1 block behind on average, 2 at most", got)
+	}
+	if got := heads.Summary(c); got.Checks != 0 || got.Failed != 2 {
+		t.Errorf("c: got: %+v; want 2 failed checks", got)
+	}
+	if got := heads.Summary(&Client{}); got != nil {
+		t.Errorf("untracked: got: %+v; want nil", got)
+	}
+
+	// Mismatches are suppressed while endpoints are at different heights
+	r := &report{Clients: clients, Heads: heads}
+	one := Response{client: a, ID: 1, Body: []byte(`{"result":"0x1"}`)}
+	other := Response{client: b, ID: 1, Body: []byte(`{"result":"0x2"}`)}
+	r.compareSet([]Response{one}, other)
+	if r.mismatched != 1 || r.lagging != 0 {
+		t.Errorf("at the same height: got %d mismatched, %d lagging; want 1, 0", r.mismatched, r.lagging)
+	}
+	mu.Lock()
+	heights["/b"] = "0xf"
+	mu.Unlock()
+	heads.check(context.Background())
+	one.ID, other.ID = 2, 2
+	r.compareSet([]Response{one}, other)
+	if r.mismatched != 1 || r.lagging != 1 {
+		t.Errorf("at different heights: got %d mismatched, %d lagging; want 1, 1", r.mismatched, r.lagging)
+	}
+
+	var buf bytes.Buffer
+	renderHeads(&buf, palette{}, r.Summary().Endpoints, r.lagging, heads.Suppress)
+	for _, want := range []string{"** Chain head:", `0. "` + a.Endpoint + `": at 16, 0.00 blocks behind on average`, "unknown, 3 failed checks", "1 mismatched results while endpoints were at different heights, not counted"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got:\n%s\nwant: %s", buf.String(), want)
+		}
+	}
+}
+
+func TestHeadTrackerRemove(t *testing.T) {
+	clients, err := NewClients([]string{"noop://a", "noop://b"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHeadTracker(clients, time.Hour, false)
+	h.check(context.Background())
+	if len(h.transports) != 2 {
+		t.Fatalf("got %d transports; want 2", len(h.transports))
+	}
+	h.Remove(clients[1])
+	h.check(context.Background())
+	if _, ok := h.transports[clients[1]]; ok || len(h.transports) != 1 {
+		t.Errorf("got transports %v; want only the remaining endpoint's", h.transports)
+	}
+	if h.Summary(clients[1]) == nil {
+		t.Errorf("got no heads for the removed endpoint; want them kept for the report")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maxHeaderValues is the number of distinct values of a header that are
+// counted per endpoint. Further values are counted together, so that headers
+// with unbounded values, such as a remaining rate limit, can't exhaust memory.
+const maxHeaderValues = 100
+
+// topHeaderValues is the number of most frequent values of a header shown in
+// the text report.
+const topHeaderValues = 5
+
+// headerCounts is the distribution of a header's values in the responses of
+// an endpoint.
+type headerCounts struct {
+	values    map[string]int
+	responses int
+	missing   int // Number of responses without the header
+	other     int // Number of responses with values beyond maxHeaderValues
+}
+
+// countHeaders records the values of the captured headers of a response.
+func (r *report) countHeaders(resp Response) {
+	if len(r.Headers) == 0 || resp.Header == nil {
+		return
+	}
+	if r.headerCounts == nil {
+		r.headerCounts = make([]map[*Client]*headerCounts, len(r.Headers))
+	}
+	for i, name := range r.Headers {
+		if r.headerCounts[i] == nil {
+			r.headerCounts[i] = map[*Client]*headerCounts{}
+		}
+		counts, ok := r.headerCounts[i][resp.client]
+		if !ok {
+			counts = &headerCounts{values: map[string]int{}}
+			r.headerCounts[i][resp.client] = counts
+		}
+		counts.responses += 1
+		values, ok := resp.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			counts.missing += 1
+			continue
+		}
+		value := strings.Join(values, ", ")
+		if _, ok := counts.values[value]; !ok && len(counts.values) >= maxHeaderValues {
+			counts.other += 1
+			continue
+		}
+		counts.values[value] += 1
+	}
+}
+
+// headerSummary is the machine-readable form of the distribution of a
+// header's values.
+type headerSummary struct {
+	Header    string                  `json:"header"`
+	Endpoints []headerEndpointSummary `json:"endpoints"`
+}
+
+// headerEndpointSummary is the distribution of a header's values in the
+// responses of an endpoint.
+type headerEndpointSummary struct {
+	Endpoint  string         `json:"endpoint"`
+	Name      string         `json:"name,omitempty"`
+	Responses int            `json:"responses"`
+	Values    map[string]int `json:"values,omitempty"`
+	Missing   int            `json:"missing,omitempty"`
+	Other     int            `json:"other,omitempty"` // Responses with values beyond maxHeaderValues
+}
+
+// label returns the name of the endpoint if it has one, or its URI.
+func (e headerEndpointSummary) label() string {
+	return endpointLabel(e.Name, e.Endpoint)
+}
+
+// headerSummaries returns the distribution of every captured header's values,
+// in the order they were given.
+func (r *report) headerSummaries() []headerSummary {
+	if len(r.Headers) == 0 {
+		return nil
+	}
+	summaries := make([]headerSummary, 0, len(r.Headers))
+	for i, name := range r.Headers {
+		s := headerSummary{
+			Header:    http.CanonicalHeaderKey(name),
+			Endpoints: make([]headerEndpointSummary, 0, len(r.Clients)),
+		}
+		for _, c := range r.Clients {
+			e := headerEndpointSummary{Endpoint: redactURL(c.Endpoint), Name: c.Name}
+			if i < len(r.headerCounts) {
+				if counts, ok := r.headerCounts[i][c]; ok {
+					e.Responses, e.Missing, e.Other = counts.responses, counts.missing, counts.other
+					if len(counts.values) > 0 {
+						e.Values = make(map[string]int, len(counts.values))
+						for value, n := range counts.values {
+							e.Values[value] = n
+						}
+					}
+				}
+			}
+			s.Endpoints = append(s.Endpoints, e)
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
+
+// renderHeaders writes the most frequent values of every captured header as
+// part of the text report.
+func renderHeaders(w io.Writer, headers []headerSummary) {
+	if len(headers) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n** Response headers:\n")
+	for _, s := range headers {
+		fmt.Fprintf(w, "\n   %s\n", s.Header)
+		for i, e := range s.Endpoints {
+			if e.Responses == 0 {
+				fmt.Fprintf(w, "     %d. %q: no responses\n", i, e.label())
+				continue
+			}
+			values := make([]string, 0, len(e.Values))
+			for value := range e.Values {
+				values = append(values, value)
+			}
+			sort.Slice(values, func(i, j int) bool {
+				a, b := values[i], values[j]
+				if e.Values[a] != e.Values[b] {
+					return e.Values[a] > e.Values[b]
+				}
+				return a < b
+			})
+			var parts []string
+			percent := func(n int) float64 { return float64(n*100) / float64(e.Responses) }
+			for j, value := range values {
+				if j == topHeaderValues {
+					rest := e.Other
+					for _, value := range values[j:] {
+						rest += e.Values[value]
+					}
+					parts = append(parts, fmt.Sprintf("other %0.2f%%", percent(rest)))
+					break
+				}
+				parts = append(parts, fmt.Sprintf("%q %0.2f%%", value, percent(e.Values[value])))
+			}
+			if len(values) <= topHeaderValues && e.Other > 0 {
+				parts = append(parts, fmt.Sprintf("other %0.2f%%", percent(e.Other)))
+			}
+			if e.Missing > 0 {
+				parts = append(parts, fmt.Sprintf("missing %0.2f%%", percent(e.Missing)))
+			}
+			fmt.Fprintf(w, "     %d. %q: %s\n", i, e.label(), strings.Join(parts, ", "))
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReportHeaders(t *testing.T) {
+	a, b := &Client{Endpoint: "a"}, &Client{Endpoint: "b", Name: "b"}
+	r := &report{Clients: Clients{a, b}, Headers: []string{"x-cache", "X-Ratelimit-Remaining"}}
+	for i, cache := range []string{"HIT", "HIT", "MISS", ""} {
+		header := http.Header{"X-Ratelimit-Remaining": {fmt.Sprint(i)}}
+		if cache != "" {
+			header.Set("X-Cache", cache)
+		}
+		r.countHeaders(Response{client: a, Header: header})
+	}
+	for i := 0; i < maxHeaderValues+2; i++ {
+		r.countHeaders(Response{client: b, Header: http.Header{"X-Ratelimit-Remaining": {fmt.Sprint(i)}}})
+	}
+	r.countHeaders(Response{client: b}) // Without headers, such as over websockets
+
+	summaries := r.headerSummaries()
+	if len(summaries) != 2 || summaries[0].Header != "X-Cache" {
+		t.Fatalf("got: %+v; want X-Cache and X-Ratelimit-Remaining", summaries)
+	}
+	ea := summaries[0].Endpoints[0]
+	if ea.Responses != 4 || ea.Values["HIT"] != 2 || ea.Values["MISS"] != 1 || ea.Missing != 1 {
+		t.Errorf("got: %+v; want 2 hits, 1 miss and 1 missing", ea)
+	}
+	eb := summaries[1].Endpoints[1]
+	if eb.Responses != maxHeaderValues+2 || len(eb.Values) != maxHeaderValues || eb.Other != 2 {
+		t.Errorf("got %d responses, %d values, %d other; want %d, %d, 2", eb.Responses, len(eb.Values), eb.Other, maxHeaderValues+2, maxHeaderValues)
+	}
+
+	var buf bytes.Buffer
+	renderHeaders(&buf, summaries)
+	for _, want := range []string{
+		`0. "a": "HIT" 50.00%, "MISS" 25.00%, missing 25.00%`,
+		`1. "b": missing 100.00%`,
+		`1. "b": "0" 0.98%, "1" 0.98%, "10" 0.98%, "11" 0.98%, "12" 0.98%, other 95.10%`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got:\n%s\nwant: %s", buf.String(), want)
+		}
+	}
+}
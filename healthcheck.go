@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// HealthCheck sends a single request to the endpoint with a fresh transport,
+// and returns an error if it fails or the response is a JSON-RPC error.
+func (client *Client) HealthCheck(ctx context.Context, line []byte) error {
+	t, err := client.transport()
+	if err != nil {
+		return err
+	}
+	if closer, ok := t.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	req := Request{client: client, Line: line}
+	resp := req.Do(ctx, t)
+	if resp.Err != nil {
+		return resp.Err
+	}
+	if err := decodeBody(&resp); err != nil {
+		return err
+	}
+	var msg rpcMessage
+	if json.Unmarshal(resp.Body, &msg) == nil && len(msg.Error) > 0 && string(msg.Error) != "null" {
+		return fmt.Errorf("JSON-RPC error: %s", msg.Error)
+	}
+	return nil
+}
+
+// healthCheck checks every client concurrently, and returns the errors of
+// the failing endpoints.
+func healthCheck(ctx context.Context, clients Clients, line []byte) map[string]error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := map[string]error{}
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			err := c.HealthCheck(ctx, line)
+			if err == nil {
+				logger.Info().Str("endpoint", c.Endpoint).Msg("health check passed")
+				return
+			}
+			mu.Lock()
+			failed[c.Endpoint] = err
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	return failed
+}
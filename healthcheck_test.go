@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"1","error":null}`))
+		case "/rpc-error":
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"syncing"}}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	clients, err := NewClients([]string{srv.URL + "/ok", srv.URL + "/rpc-error", srv.URL + "/down"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := healthCheck(context.Background(), clients, []byte(`{"jsonrpc":"2.0","id":1,"method":"net_version"}`))
+	if _, ok := failed[srv.URL+"/ok"]; ok {
+		t.Errorf("expected /ok to pass")
+	}
+	if err := failed[srv.URL+"/rpc-error"]; err == nil || err.Error() != `JSON-RPC error: {"code":-32000,"message":"syncing"}` {
+		t.Errorf("got: %v; want: JSON-RPC error", err)
+	}
+	if err := failed[srv.URL+"/down"]; err == nil {
+		t.Errorf("expected /down to fail")
+	}
+}
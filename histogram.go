@@ -1,26 +1,65 @@
 package main
 
-import "sort"
-
-// TODO: Replace histogram implementation with a sparse bucket based one so
-// it's memory-bounded.
+import (
+	"math/rand"
+	"sort"
+)
 
+// histogram tracks a distribution of values. By default every value is kept,
+// so percentiles are exact. With a Limit, a uniform sample of at most Limit
+// values is kept instead (reservoir sampling), so that memory stays bounded
+// and percentiles are estimates. Count, min, max, total, average and variance
+// are always exact.
 type histogram struct {
+	Limit int // Maximum number of values kept for percentiles, 0 is unlimited
+
 	all   []float64
+	count int
 	min   float64
 	max   float64
 	total float64
+
+	mean float64 // Running mean and sum of squared deltas, for the variance
+	m2   float64
 }
 
 func (h *histogram) Add(point float64) {
-	h.all = append(h.all, point)
+	h.count++
 	h.total += point
-	if len(h.all) == 1 || h.min > point {
+	if h.count == 1 || h.min > point {
 		h.min = point
 	}
 	if h.max < point {
 		h.max = point
 	}
+	delta := point - h.mean
+	h.mean += delta / float64(h.count)
+	h.m2 += delta * (point - h.mean)
+
+	if h.Limit <= 0 || len(h.all) < h.Limit {
+		h.all = append(h.all, point)
+	} else if i := rand.Intn(h.count); i < h.Limit {
+		// Keep each value seen so far with equal probability
+		h.all[i] = point
+	}
+}
+
+// Shrink bounds the values kept to a uniform sample of at most limit from now
+// on, if fewer aren't kept already.
+func (h *histogram) Shrink(limit int) {
+	if h.Limit > 0 && h.Limit <= limit {
+		return
+	}
+	h.Limit = limit
+	if len(h.all) <= limit {
+		return
+	}
+	for i := 0; i < limit; i++ {
+		j := i + rand.Intn(len(h.all)-i)
+		h.all[i], h.all[j] = h.all[j], h.all[i]
+	}
+	// Copied so that the rest of the values are released
+	h.all = append([]float64(nil), h.all[:limit]...)
 }
 
 func (h *histogram) Total() float64 {
@@ -36,22 +75,17 @@ func (h *histogram) Max() float64 {
 }
 
 func (h *histogram) Average() float64 {
-	return h.total / float64(len(h.all))
+	return h.total / float64(h.count)
 }
 
 func (h *histogram) Variance() float64 {
 	// Population variance
-	mean := h.Average()
-	sum := 0.0
-	for _, v := range h.all {
-		delta := v - mean
-		sum += delta * delta
-	}
-	return sum / float64(h.Len())
+	return h.m2 / float64(h.count)
 }
 
+// Len is the number of values added.
 func (h *histogram) Len() int {
-	return len(h.all)
+	return h.count
 }
 
 // Percentiles takes buckets in whole percentages (e.g. 95 is 95%) and returns
@@ -85,3 +119,25 @@ func (h *histogram) Percentiles(buckets ...int) []float64 {
 	}
 	return r
 }
+
+// Quantiles takes fractions of the values, between 0 and 1, and returns the
+// value at each of them, in the same order. They're estimates with a Limit.
+func (h *histogram) Quantiles(fractions ...float64) []float64 {
+	r := make([]float64, len(fractions))
+	if len(h.all) == 0 {
+		return r
+	}
+
+	sort.Float64s(h.all)
+
+	for i, q := range fractions {
+		j := int(q * float64(len(h.all)))
+		if j >= len(h.all) {
+			j = len(h.all) - 1
+		} else if j < 0 {
+			j = 0
+		}
+		r[i] = h.all[j]
+	}
+	return r
+}
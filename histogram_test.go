@@ -49,3 +49,71 @@ func TestHistogramVariance(t *testing.T) {
 		t.Errorf("got: %0.4f; want: %0.4f", got, want)
 	}
 }
+
+func TestHistogramReservoir(t *testing.T) {
+	h := histogram{Limit: 1000}
+	for i := 1; i <= 100000; i++ {
+		h.Add(float64(i))
+	}
+
+	if got, want := len(h.all), 1000; got != want {
+		t.Errorf("got: %d values kept; want: %d", got, want)
+	}
+	if got, want := h.Len(), 100000; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := h.Average(), 50000.5; got != want {
+		t.Errorf("got: %0.4f; want: %0.4f", got, want)
+	}
+	if got, want := h.Max(), 100000.0; got != want {
+		t.Errorf("got: %0.4f; want: %0.4f", got, want)
+	}
+
+	// Percentiles are estimates, the median of the sample should be close
+	median := h.Percentiles(50)[0]
+	if median < 45000 || median > 55000 {
+		t.Errorf("got: %0.4f; want: about 50000", median)
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	h := histogram{}
+	if got := h.Quantiles(0.5); got[0] != 0 {
+		t.Errorf("got: %0.4f; want: 0 without values", got[0])
+	}
+	for i := 1000; i >= 1; i-- {
+		h.Add(float64(i))
+	}
+	got := h.Quantiles(0, 0.5, 0.999, 1)
+	for i, want := range []float64{1, 501, 1000, 1000} {
+		if got[i] != want {
+			t.Errorf("%d: got: %0.4f; want: %0.4f", i, got[i], want)
+		}
+	}
+}
+
+func TestHistogramShrink(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 10000; i++ {
+		h.Add(float64(i))
+	}
+	h.Shrink(100)
+	for i := 10001; i <= 20000; i++ {
+		h.Add(float64(i))
+	}
+
+	if got, want := len(h.all), 100; got != want {
+		t.Errorf("got: %d values kept; want: %d", got, want)
+	}
+	if got, want := h.Len(), 20000; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := h.Max(), 20000.0; got != want {
+		t.Errorf("got: %0.4f; want: %0.4f", got, want)
+	}
+	// A larger limit doesn't grow the sample back
+	h.Shrink(1000)
+	if got, want := h.Limit, 100; got != want {
+		t.Errorf("got: limit %d; want: %d", got, want)
+	}
+}
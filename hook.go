@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// mismatchHook hands each mismatched response set, as a record of the
+// mismatch log, to a webhook or a command, for triage pipelines that file
+// tickets or annotate dashboards. Mismatches beyond the rate limit are
+// counted rather than sent, so that a burst of them can't flood the pipeline.
+type mismatchHook struct {
+	URL     string  // Posted the record as JSON, if set
+	Command string  // Run with the shell and the record on stdin, if set
+	Rate    float64 // Mismatches sent per second at most, 0 is unlimited
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	next    time.Time // When the rate limit allows the next mismatch
+	skipped int       // Mismatches over the rate limit
+}
+
+// Fire sends the mismatched response set of the endpoint group in the
+// background, unless it's over the rate limit.
+func (h *mismatchHook) Fire(group string, resps []Response) {
+	if !h.allow(time.Now()) {
+		return
+	}
+	// Bodies are released after comparing, the record is encoded right away
+	record, err := json.Marshal(newMismatchRecord(group, resps))
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to encode mismatch for the hook")
+		return
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		if err := h.send(record); err != nil {
+			logger.Error().Err(err).Int64("id", int64(resps[0].ID)).Msg("mismatch hook failed")
+		}
+	}()
+}
+
+// allow returns whether a mismatch at now is within the rate limit.
+func (h *mismatchHook) allow(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.Rate <= 0 {
+		return true
+	}
+	if now.Before(h.next) {
+		if h.skipped == 0 {
+			logger.Warn().Float64("rate", h.Rate).Msg("mismatch hook is over its rate limit, skipping mismatches")
+		}
+		h.skipped += 1
+		return false
+	}
+	h.next = now.Add(time.Duration(float64(time.Second) / h.Rate))
+	return true
+}
+
+// send hands the record to the webhook and the command. They get their own
+// context, so that mismatches of the end of a run are still sent once it's
+// over.
+func (h *mismatchHook) send(record []byte) error {
+	if h.URL != "" {
+		if err := postJSON(context.Background(), h.URL, json.RawMessage(record)); err != nil {
+			return fmt.Errorf("failed to post mismatch: %w", err)
+		}
+	}
+	if h.Command != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+		cmd.Stdin = bytes.NewReader(append(record, '\n'))
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to run mismatch command: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close waits for the mismatches being sent, and returns the number of
+// mismatches that were skipped over the rate limit.
+func (h *mismatchHook) Close() int {
+	h.wg.Wait()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.skipped
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMismatchHook(t *testing.T) {
+	var mu sync.Mutex
+	var posted []mismatchRecord
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var record mismatchRecord
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		posted = append(posted, record)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "versus-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "mismatch.json")
+
+	a, b := &Client{Endpoint: "a"}, &Client{Endpoint: "b"}
+	req := &Request{ID: 7, Line: []byte(`{"method":"eth_call"}`)}
+	resps := []Response{
+		{client: a, Request: req, ID: 7, Body: []byte(`{"result":"0x1"}`)},
+		{client: b, Request: req, ID: 7, Body: []byte(`{"result":"0x2"}`)},
+	}
+	h := &mismatchHook{URL: srv.URL, Command: "cat > " + out, Rate: 1}
+	h.Fire("", resps)
+	h.Fire("", resps) // Over the rate limit
+	if skipped := h.Close(); skipped != 1 {
+		t.Errorf("got %d skipped; want 1", skipped)
+	}
+
+	if len(posted) != 1 || posted[0].ID != 7 || len(posted[0].Responses) != 2 || string(posted[0].Responses[1].Body) != `{"result":"0x2"}` {
+		t.Errorf("got posted: %+v; want the mismatch", posted)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record mismatchRecord
+	if err := json.Unmarshal(data, &record); err != nil || record.Responses[0].Endpoint != "a" {
+		t.Errorf("got command input: %s (%v); want the mismatch", data, err)
+	}
+}
+
+func TestMismatchHookRate(t *testing.T) {
+	h := &mismatchHook{Rate: 2}
+	start := time.Now()
+	for _, tc := range []struct {
+		at   time.Duration
+		want bool
+	}{
+		{0, true},
+		{100 * time.Millisecond, false},
+		{500 * time.Millisecond, true},
+		{900 * time.Millisecond, false},
+		{2 * time.Second, true},
+	} {
+		if got := h.allow(start.Add(tc.at)); got != tc.want {
+			t.Errorf("at %s: got: %t; want: %t", tc.at, got, tc.want)
+		}
+	}
+	if h.skipped != 2 {
+		t.Errorf("got %d skipped; want 2", h.skipped)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// curveColors are the colors of each endpoint's curves in plots.
+var curveColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+
+// Size of plots, and their margins around the axes, in pixels.
+const (
+	plotWidth  = 720
+	plotHeight = 360
+	plotLeft   = 70
+	plotRight  = 20
+	plotTop    = 20
+	plotBottom = 45
+)
+
+// maxNines is the highest percentile of percentile plots, as a number of
+// nines: 99.99%.
+const maxNines = 4
+
+// Quantiles returns the latencies, in seconds, at each fraction of requests,
+// and the number of requests.
+func (stats *clientStats) Quantiles(fractions ...float64) ([]float64, int) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.timing.Quantiles(fractions...), stats.timing.Len()
+}
+
+// latencyCurve is the latency distribution of an endpoint: the latencies, in
+// seconds, at evenly spaced fractions of requests in CDF plots, or at evenly
+// spaced nines in percentile plots.
+type latencyCurve struct {
+	Label     string
+	Latencies []float64
+	Requests  int
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>versus report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+pre { background: #f6f6f6; padding: 1em; overflow-x: auto; }
+svg { display: block; margin: 1em 0; }
+svg text { font-size: 12px; fill: #444; }
+.grid { stroke: #ddd; }
+.axis { stroke: #444; }
+.curve { fill: none; stroke-width: 2; }
+</style>
+</head>
+<body>
+<h1>versus report</h1>
+<p>Version {{.Version}}, generated {{.Generated}}</p>
+{{range .Groups}}
+{{if .Name}}<h2>Group {{.Name}}</h2>{{end}}
+<h3>Latency distribution</h3>
+{{.CDF}}
+<h3>Latency by percentile</h3>
+{{.Percentiles}}
+<h3>Report</h3>
+<pre>{{.Text}}</pre>
+{{end}}
+</body>
+</html>
+`))
+
+type htmlGroup struct {
+	Name        string
+	CDF         template.HTML
+	Percentiles template.HTML
+	Text        string
+}
+
+// renderHTML writes the reports as an HTML page, with plots of the latency
+// distribution of each endpoint overlaid, so that differences in their
+// shapes, such as bimodal latencies or long tails, stand out, followed by the
+// text report.
+func renderHTML(w io.Writer, reports []*report) error {
+	data := struct {
+		Version   string
+		Generated string
+		Groups    []htmlGroup
+	}{Version: Version, Generated: time.Now().UTC().Format(time.RFC3339)}
+	for _, r := range reports {
+		var text bytes.Buffer
+		if err := r.Render(&text, palette{}); err != nil {
+			return err
+		}
+		data.Groups = append(data.Groups, htmlGroup{
+			Name:        r.Group,
+			CDF:         template.HTML(cdfPlot(cdfCurves(r.Clients))),
+			Percentiles: template.HTML(percentilePlot(percentileCurves(r.Clients))),
+			Text:        text.String(),
+		})
+	}
+	return htmlTemplate.Execute(w, data)
+}
+
+// cdfCurves returns the latencies of each endpoint at every half percent of
+// requests.
+func cdfCurves(clients Clients) []latencyCurve {
+	fractions := make([]float64, 201)
+	for i := range fractions {
+		fractions[i] = float64(i) / 200
+	}
+	curves := make([]latencyCurve, 0, len(clients))
+	for _, c := range clients {
+		curve := latencyCurve{Label: c.Label()}
+		curve.Latencies, curve.Requests = c.Stats.Quantiles(fractions...)
+		curves = append(curves, curve)
+	}
+	return curves
+}
+
+// percentileCurves returns the latencies of each endpoint at every hundredth
+// of a nine, up to maxNines, or as far as the endpoint's requests go.
+func percentileCurves(clients Clients) []latencyCurve {
+	curves := make([]latencyCurve, 0, len(clients))
+	for _, c := range clients {
+		_, n := c.Stats.Quantiles()
+		var fractions []float64
+		for i := 0; i <= maxNines*100; i++ {
+			q := 1 - math.Pow(10, -float64(i)/100)
+			if n == 0 || q > 1-1/float64(n) {
+				break
+			}
+			fractions = append(fractions, q)
+		}
+		curve := latencyCurve{Label: c.Label()}
+		curve.Latencies, curve.Requests = c.Stats.Quantiles(fractions...)
+		curves = append(curves, curve)
+	}
+	return curves
+}
+
+// latencyRange returns the lowest and highest positive latencies of the
+// curves, widened to a decade if they're the same.
+func latencyRange(curves []latencyCurve) (float64, float64) {
+	low, high := math.Inf(1), 0.0
+	for _, curve := range curves {
+		for _, l := range curve.Latencies {
+			if l > 0 {
+				low, high = math.Min(low, l), math.Max(high, l)
+			}
+		}
+	}
+	if high == 0 {
+		return 0.001, 1
+	}
+	if high/low < 10 {
+		mid := math.Sqrt(low * high)
+		low, high = mid/math.Sqrt(10), mid*math.Sqrt(10)
+	}
+	return low, high
+}
+
+// logTicks returns the 1, 2, 5 steps of each decade between low and high.
+func logTicks(low, high float64) []float64 {
+	var ticks []float64
+	for decade := math.Pow(10, math.Floor(math.Log10(low))); decade <= high; decade *= 10 {
+		for _, step := range []float64{1, 2, 5} {
+			if t := decade * step; t >= low && t <= high {
+				ticks = append(ticks, t)
+			}
+		}
+	}
+	return ticks
+}
+
+// formatLatency returns a latency in seconds as a short duration label.
+func formatLatency(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	switch {
+	case d >= time.Second:
+		return fmt.Sprintf("%gs", math.Round(d.Seconds()*100)/100)
+	case d >= time.Millisecond:
+		return fmt.Sprintf("%gms", math.Round(float64(d)/float64(time.Millisecond)*100)/100)
+	}
+	return fmt.Sprintf("%gµs", math.Round(float64(d)/float64(time.Microsecond)*100)/100)
+}
+
+// svgPlot draws the axes, grid and legend of a plot, around the curves drawn
+// by the caller in plot coordinates.
+type svgPlot struct {
+	buf bytes.Buffer
+}
+
+func (p *svgPlot) start(label string) {
+	fmt.Fprintf(&p.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img" aria-label="%s">`+"\n", plotWidth, plotHeight, html.EscapeString(label))
+}
+
+func (p *svgPlot) xTick(x float64, label string) {
+	fmt.Fprintf(&p.buf, `<line class="grid" x1="%.1f" y1="%d" x2="%.1f" y2="%d"/>`+"\n", x, plotTop, x, plotHeight-plotBottom)
+	fmt.Fprintf(&p.buf, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`+"\n", x, plotHeight-plotBottom+16, html.EscapeString(label))
+}
+
+func (p *svgPlot) yTick(y float64, label string) {
+	fmt.Fprintf(&p.buf, `<line class="grid" x1="%d" y1="%.1f" x2="%d" y2="%.1f"/>`+"\n", plotLeft, y, plotWidth-plotRight, y)
+	fmt.Fprintf(&p.buf, `<text x="%d" y="%.1f" text-anchor="end">%s</text>`+"\n", plotLeft-6, y+4, html.EscapeString(label))
+}
+
+func (p *svgPlot) curve(i int, points []string) {
+	if len(points) == 0 {
+		return
+	}
+	fmt.Fprintf(&p.buf, `<polyline class="curve" stroke="%s" points="%s"/>`+"\n", curveColors[i%len(curveColors)], strings.Join(points, " "))
+}
+
+func (p *svgPlot) finish(xLabel, yLabel string, curves []latencyCurve) string {
+	fmt.Fprintf(&p.buf, `<line class="axis" x1="%d" y1="%d" x2="%d" y2="%d"/>`+"\n", plotLeft, plotHeight-plotBottom, plotWidth-plotRight, plotHeight-plotBottom)
+	fmt.Fprintf(&p.buf, `<line class="axis" x1="%d" y1="%d" x2="%d" y2="%d"/>`+"\n", plotLeft, plotTop, plotLeft, plotHeight-plotBottom)
+	fmt.Fprintf(&p.buf, `<text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n", (plotLeft+plotWidth-plotRight)/2, plotHeight-8, html.EscapeString(xLabel))
+	fmt.Fprintf(&p.buf, `<text x="14" y="%d" text-anchor="middle" transform="rotate(-90 14 %d)">%s</text>`+"\n", (plotTop+plotHeight-plotBottom)/2, (plotTop+plotHeight-plotBottom)/2, html.EscapeString(yLabel))
+	for i, curve := range curves {
+		y := plotTop + 10 + i*16
+		fmt.Fprintf(&p.buf, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="2"/>`+"\n", plotLeft+10, y, plotLeft+30, y, curveColors[i%len(curveColors)])
+		fmt.Fprintf(&p.buf, `<text x="%d" y="%d">%d. %s (%d requests)</text>`+"\n", plotLeft+36, y+4, i, html.EscapeString(curve.Label), curve.Requests)
+	}
+	p.buf.WriteString("</svg>\n")
+	return p.buf.String()
+}
+
+// cdfPlot returns an SVG plot of the share of requests (y) under each
+// latency (x, on a log scale) of each curve.
+func cdfPlot(curves []latencyCurve) string {
+	low, high := latencyRange(curves)
+	width, height := float64(plotWidth-plotLeft-plotRight), float64(plotHeight-plotTop-plotBottom)
+	x := func(l float64) float64 {
+		return plotLeft + width*(math.Log10(l)-math.Log10(low))/(math.Log10(high)-math.Log10(low))
+	}
+	y := func(q float64) float64 {
+		return plotTop + height*(1-q)
+	}
+
+	var p svgPlot
+	p.start("Latency distribution of each endpoint")
+	for _, t := range logTicks(low, high) {
+		p.xTick(x(t), formatLatency(t))
+	}
+	for _, q := range []float64{0, 0.25, 0.5, 0.75, 1} {
+		p.yTick(y(q), fmt.Sprintf("%g%%", q*100))
+	}
+	for i, curve := range curves {
+		if curve.Requests == 0 {
+			continue
+		}
+		var points []string
+		for j, l := range curve.Latencies {
+			if l <= 0 {
+				continue
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(l), y(float64(j)/float64(len(curve.Latencies)-1))))
+		}
+		p.curve(i, points)
+	}
+	return p.finish("Latency", "Requests", curves)
+}
+
+// percentilePlot returns an SVG plot of the latency (y, on a log scale) at
+// each percentile (x, by its number of nines, so that 90%, 99% and 99.9% are
+// evenly spaced) of each curve, which spreads out the tail of the
+// distribution.
+func percentilePlot(curves []latencyCurve) string {
+	low, high := latencyRange(curves)
+	width, height := float64(plotWidth-plotLeft-plotRight), float64(plotHeight-plotTop-plotBottom)
+	x := func(nines float64) float64 {
+		return plotLeft + width*nines/maxNines
+	}
+	y := func(l float64) float64 {
+		return plotTop + height*(1-(math.Log10(l)-math.Log10(low))/(math.Log10(high)-math.Log10(low)))
+	}
+
+	var p svgPlot
+	p.start("Latency by percentile of each endpoint")
+	for nines, label := range []string{"0%", "90%", "99%", "99.9%", "99.99%"} {
+		p.xTick(x(float64(nines)), label)
+	}
+	for _, t := range logTicks(low, high) {
+		p.yTick(y(t), formatLatency(t))
+	}
+	for i, curve := range curves {
+		var points []string
+		for j, l := range curve.Latencies {
+			if l <= 0 {
+				continue
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(float64(j)/100), y(l)))
+		}
+		p.curve(i, points)
+	}
+	return p.finish("Percentile", "Latency", curves)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderHTML(t *testing.T) {
+	clients, err := NewClients([]string{"noop://fast", "noop://slow#name=a<b"}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 1000; i++ {
+		clients[0].Stats.Count(nil, time.Duration(i)*time.Microsecond)
+		clients[1].Stats.Count(nil, time.Duration(i)*time.Millisecond)
+	}
+	r := &report{Clients: clients, Group: "eth"}
+	r.init()
+	r.started = time.Now()
+	r.requests, r.completed = 2000, 1000
+
+	var buf bytes.Buffer
+	if err := renderHTML(&buf, []*report{r}); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"<h2>Group eth</h2>",
+		`aria-label="Latency distribution of each endpoint"`,
+		`aria-label="Latency by percentile of each endpoint"`,
+		"1. a&lt;b (1000 requests)",
+		">99.9%</text>",
+		"** Summary for 2 endpoints:",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got no %q in:\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, "<polyline"); n != 4 {
+		t.Errorf("got %d curves; want 4", n)
+	}
+	if strings.Contains(got, "a<b") {
+		t.Errorf("got an unescaped endpoint name")
+	}
+}
+
+func TestLogTicks(t *testing.T) {
+	got := logTicks(0.0015, 0.3)
+	want := []float64{0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2}
+	if len(got) != len(want) {
+		t.Fatalf("got: %v; want: %v", got, want)
+	}
+	for i := range got {
+		if formatLatency(got[i]) != formatLatency(want[i]) {
+			t.Errorf("got: %v; want: %v", got, want)
+			break
+		}
+	}
+	labels := []string{formatLatency(0.0005), formatLatency(0.02), formatLatency(1.5)}
+	if want := []string{"500µs", "20ms", "1.5s"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("got: %v; want: %v", labels, want)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// inputEnvelope is an input line in the envelope format, which carries
+// metadata along with the request:
+//
+//	{"request": {"jsonrpc": "2.0", ...}, "tags": {"tenant": "acme"}}
+//	{"method": "GET", "path": "/v2/users/123", "timeout": "250ms"}
+//
+// The request is sent as-is, or as the contents of a JSON string. Tags are an
+// object of names and values, or an array of names. The path is relative to
+// each endpoint's URI, and the method defaults to POST with a request and GET
+// without. The timeout overrides --timeout for the request.
+type inputEnvelope struct {
+	Request json.RawMessage `json:"request"`
+	Tags    json.RawMessage `json:"tags"`
+	Method  string          `json:"method"`
+	Path    string          `json:"path"`
+	Timeout string          `json:"timeout"`
+}
+
+// inputParser turns input lines into requests.
+type inputParser struct {
+	Envelope bool            // Lines are in the envelope format
+	TagRules []extractRule   // Tag requests with values found in them
+	Split    bufio.SplitFunc // Splits the input into records, or lines if nil
+}
+
+// Parse returns the request of an input line, with its tags.
+func (p *inputParser) Parse(line []byte) (Request, error) {
+	req := Request{Line: line}
+	var tags []string
+	if p.Envelope {
+		var env inputEnvelope
+		if err := json.Unmarshal(line, &env); err != nil {
+			return req, fmt.Errorf("invalid input envelope: %w", err)
+		}
+		if len(env.Request) == 0 && env.Path == "" {
+			return req, fmt.Errorf("input envelope has no request or path")
+		}
+		req.Method = strings.ToUpper(env.Method)
+		req.Path = env.Path
+		if env.Timeout != "" {
+			timeout, err := time.ParseDuration(env.Timeout)
+			if err != nil || timeout <= 0 {
+				return req, fmt.Errorf("invalid input envelope timeout: %s", env.Timeout)
+			}
+			req.Timeout = timeout
+		}
+		req.Line = []byte(env.Request)
+		if len(env.Request) > 0 && env.Request[0] == '"' {
+			var s string
+			if err := json.Unmarshal(env.Request, &s); err != nil {
+				return req, fmt.Errorf("invalid input envelope request: %w", err)
+			}
+			req.Line = []byte(s)
+		}
+		var err error
+		if tags, err = envelopeTags(env.Tags); err != nil {
+			return req, err
+		}
+	}
+	if len(p.TagRules) > 0 {
+		tags = append(tags, p.ruleTags(req.Line)...)
+	}
+	if len(tags) > 0 {
+		sort.Strings(tags)
+		req.Tags = tags
+	}
+	return req, nil
+}
+
+func envelopeTags(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var names []string
+	if err := json.Unmarshal(raw, &names); err == nil {
+		return names, nil
+	}
+	var values map[string]string
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("input envelope tags must be an object of strings or an array of strings")
+	}
+	tags := make([]string, 0, len(values))
+	for name, value := range values {
+		tags = append(tags, name+"="+value)
+	}
+	return tags, nil
+}
+
+// ruleTags returns a NAME=VALUE tag for each rule whose path is found in the
+// request.
+func (p *inputParser) ruleTags(line []byte) []string {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil
+	}
+	var tags []string
+	for _, rule := range p.TagRules {
+		found, ok := rule.Path.Lookup(v)
+		if !ok {
+			continue
+		}
+		var value string
+		switch found := found.(type) {
+		case string:
+			value = found
+		case json.Number:
+			value = found.String()
+		default:
+			raw, err := json.Marshal(found)
+			if err != nil {
+				continue
+			}
+			value = string(raw)
+		}
+		tags = append(tags, rule.Name+"="+value)
+	}
+	return tags
+}
+
+// parseTagRules parses tag rules in the form of NAME=JSONPATH, such as
+// "method=$.method".
+func parseTagRules(specs []string) ([]extractRule, error) {
+	return parseNamedPaths("tag rule", specs)
+}
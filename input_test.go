@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInputParser(t *testing.T) {
+	rules, err := parseTagRules([]string{"method=$.method", "block=$.params[0]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		parser   inputParser
+		line     string
+		wantLine string
+		wantTags []string
+	}{
+		{
+			parser:   inputParser{},
+			line:     `{"method":"eth_call"}`,
+			wantLine: `{"method":"eth_call"}`,
+		},
+		{
+			parser:   inputParser{TagRules: rules},
+			line:     `{"method":"eth_getBlockByNumber","params":[123,false]}`,
+			wantLine: `{"method":"eth_getBlockByNumber","params":[123,false]}`,
+			wantTags: []string{"block=123", "method=eth_getBlockByNumber"},
+		},
+		{
+			parser:   inputParser{Envelope: true},
+			line:     `{"request":{"method":"eth_call"},"tags":{"tenant":"acme","flag":"new-cache"}}`,
+			wantLine: `{"method":"eth_call"}`,
+			wantTags: []string{"flag=new-cache", "tenant=acme"},
+		},
+		{
+			parser:   inputParser{Envelope: true, TagRules: rules},
+			line:     `{"request":"{\"method\":\"eth_call\"}","tags":["canary"]}`,
+			wantLine: `{"method":"eth_call"}`,
+			wantTags: []string{"canary", "method=eth_call"},
+		},
+	}
+	for _, tc := range tests {
+		req, err := tc.parser.Parse([]byte(tc.line))
+		if err != nil {
+			t.Errorf("%s: %s", tc.line, err)
+			continue
+		}
+		if got, want := string(req.Line), tc.wantLine; got != want {
+			t.Errorf("got: %s; want: %s", got, want)
+		}
+		if got, want := req.Tags, tc.wantTags; !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %q; want: %q", got, want)
+		}
+	}
+
+	envelope := inputParser{Envelope: true}
+	req, err := envelope.Parse([]byte(`{"method":"get","path":"/v2/users/123"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.Method+" "+req.Path, "GET /v2/users/123"; got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+	if got, want := len(req.Line), 0; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	req, err = envelope.Parse([]byte(`{"request":{},"timeout":"250ms"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.Timeout, 250*time.Millisecond; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+
+	for _, line := range []string{
+		`{"method":"eth_call"}`,
+		`{"request":{},"tags":{"n":1}}`,
+		`{"request":{},"timeout":"soon"}`,
+		`{"request":{},"timeout":"-1s"}`,
+		`not json`,
+	} {
+		if _, err := envelope.Parse([]byte(line)); err == nil {
+			t.Errorf("%s: expected an error", line)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a parsed subset of JSONPath: a root ($) followed by any number
+// of .field, ['field'] or [index] selectors. Negative indices count from the
+// end of an array.
+type jsonPath []pathSegment
+
+type pathSegment struct {
+	Key   string
+	Index int
+	IsKey bool
+}
+
+func parseJSONPath(s string) (jsonPath, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("invalid path %q: must start with $", s)
+	}
+	var p jsonPath
+	rest := s[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid path %q: empty field name", s)
+			}
+			p = append(p, pathSegment{Key: rest[:end], IsKey: true})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid path %q: unterminated [", s)
+			}
+			sel := rest[1:end]
+			rest = rest[end+1:]
+			if len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0] {
+				p = append(p, pathSegment{Key: sel[1 : len(sel)-1], IsKey: true})
+				continue
+			}
+			idx, err := strconv.Atoi(sel)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: bad index %q", s, sel)
+			}
+			p = append(p, pathSegment{Index: idx})
+		default:
+			return nil, fmt.Errorf("invalid path %q: unexpected %q", s, rest[0])
+		}
+	}
+	return p, nil
+}
+
+// Lookup returns the value at the path within a decoded JSON value.
+func (p jsonPath) Lookup(v interface{}) (interface{}, bool) {
+	for _, seg := range p {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			if !seg.IsKey {
+				return nil, false
+			}
+			var ok bool
+			if v, ok = node[seg.Key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			if seg.IsKey {
+				return nil, false
+			}
+			i := seg.Index
+			if i < 0 {
+				i += len(node)
+			}
+			if i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Replace sets the value at the path within a decoded JSON value, and returns
+// whether it was there. The root itself can't be replaced.
+func (p jsonPath) Replace(v interface{}, value interface{}) bool {
+	if len(p) == 0 {
+		return false
+	}
+	parent, ok := p[:len(p)-1].Lookup(v)
+	if !ok {
+		return false
+	}
+	seg := p[len(p)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := node[seg.Key]; !ok || !seg.IsKey {
+			return false
+		}
+		node[seg.Key] = value
+	case []interface{}:
+		i := seg.Index
+		if i < 0 {
+			i += len(node)
+		}
+		if seg.IsKey || i < 0 || i >= len(node) {
+			return false
+		}
+		node[i] = value
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// isBatch returns true if the body looks like a JSON-RPC batch (a JSON array).
+func isBatch(body []byte) bool {
+	body = bytes.TrimLeft(body, " \t\r\n")
+	return len(body) > 0 && body[0] == '['
+}
+
+// parseBatch decodes a JSON-RPC batch into its elements, keyed by their
+// compacted id. Elements without an id, or with a duplicate id, are keyed by
+// their position instead.
+func parseBatch(body []byte) (map[string]json.RawMessage, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(body, &elements); err != nil {
+		return nil, err
+	}
+	batch := make(map[string]json.RawMessage, len(elements))
+	for i, el := range elements {
+		key := batchKey(el)
+		if _, dupe := batch[key]; key == "" || dupe {
+			key = "#" + strconv.Itoa(i)
+		}
+		batch[key] = el
+	}
+	return batch, nil
+}
+
+// batchKey returns the compacted id of a JSON-RPC message, or an empty string.
+func batchKey(msg json.RawMessage) string {
+	var obj struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(msg, &obj); err != nil || len(obj.ID) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, obj.ID); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// batchDiff compares two JSON-RPC batches element-wise, matching elements by
+// id. It returns the sorted keys of elements that are missing on either side
+// or differ. ok is false if either body is not a batch.
+func batchDiff(a, b []byte) (keys []string, ok bool) {
+	if !isBatch(a) || !isBatch(b) {
+		return nil, false
+	}
+	aBatch, err := parseBatch(a)
+	if err != nil {
+		return nil, false
+	}
+	bBatch, err := parseBatch(b)
+	if err != nil {
+		return nil, false
+	}
+
+	for key, aEl := range aBatch {
+		bEl, found := bBatch[key]
+		if !found || !(bytes.Equal(aEl, bEl) || jsonEqual(aEl, bEl)) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range bBatch {
+		if _, found := aBatch[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, true
+}
+
+// idRewrite records the original ids of a JSON-RPC request whose ids were
+// rewritten before sending, so that responses can be correlated and restored.
+type idRewrite struct {
+	batch    bool
+	sent     []string          // Normalized ids that were sent, per element
+	original []json.RawMessage // Original ids, per element
+}
+
+// rewriteIDs replaces the ids of a JSON-RPC request (or of every element in a
+// batch) with values derived from the versus request id. Notifications
+// without an id are left alone.
+func rewriteIDs(line []byte, id json.RawMessage) ([]byte, *idRewrite, error) {
+	rw := &idRewrite{batch: isBatch(line)}
+
+	var elements []map[string]json.RawMessage
+	if rw.batch {
+		if err := json.Unmarshal(line, &elements); err != nil {
+			return line, nil, err
+		}
+	} else {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(line, &obj); err != nil {
+			return line, nil, err
+		}
+		elements = append(elements, obj)
+	}
+
+	for i, el := range elements {
+		orig, ok := el["id"]
+		if !ok {
+			rw.sent = append(rw.sent, "")
+			rw.original = append(rw.original, nil)
+			continue
+		}
+		// Batch elements get a unique id within the batch
+		newID := id
+		if rw.batch {
+			newID = json.RawMessage(strconv.Itoa(i))
+		}
+		el["id"] = newID
+		rw.sent = append(rw.sent, normalizeID(newID))
+		rw.original = append(rw.original, orig)
+	}
+
+	var out []byte
+	var err error
+	if rw.batch {
+		out, err = marshalJSON(elements)
+	} else {
+		out, err = marshalJSON(elements[0])
+	}
+	if err != nil {
+		return line, nil, err
+	}
+	return out, rw, nil
+}
+
+// normalizeID returns a raw JSON id as a string that is the same for numbers
+// and strings of the same value, so that id types don't matter.
+func normalizeID(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// Restore checks that the response ids correlate with the rewritten request
+// ids, and replaces them with the original ids so that responses from
+// different endpoints compare equal regardless of the id they returned.
+func (rw *idRewrite) Restore(body []byte) ([]byte, error) {
+	original := make(map[string]json.RawMessage, len(rw.sent))
+	for i, sent := range rw.sent {
+		if sent != "" {
+			original[sent] = rw.original[i]
+		}
+	}
+
+	restore := func(el map[string]json.RawMessage) error {
+		id, ok := el["id"]
+		if !ok || string(id) == "null" {
+			// Some errors are returned without an id, nothing to correlate
+			return nil
+		}
+		orig, ok := original[normalizeID(id)]
+		if !ok {
+			return fmt.Errorf("response id %s does not match any request id", id)
+		}
+		el["id"] = orig
+		return nil
+	}
+
+	if rw.batch && isBatch(body) {
+		var elements []map[string]json.RawMessage
+		if err := json.Unmarshal(body, &elements); err != nil {
+			return body, nil
+		}
+		for _, el := range elements {
+			if err := restore(el); err != nil {
+				return body, err
+			}
+		}
+		return marshalJSON(elements)
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(body, &obj); err != nil {
+		// Not a JSON-RPC object, leave it for the comparison to sort out
+		return body, nil
+	}
+	if err := restore(obj); err != nil {
+		return body, err
+	}
+	return marshalJSON(obj)
+}
+
+// marshalJSON is json.Marshal without HTML escaping, so that values pass
+// through unchanged.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestBatchDiff(t *testing.T) {
+	tests := []struct {
+		A, B string
+		Keys []string
+		OK   bool
+	}{
+		{`[{"id":1,"result":"a"},{"id":2,"result":"b"}]`, `[{"id":2,"result":"b"},{"id":1,"result":"a"}]`, nil, true},
+		{`[{"id":1,"result":"a"},{"id":2,"result":"b"}]`, `[{"result":"b","id":2},{"id":1,"result":"x"}]`, []string{"1"}, true},
+		{`[{"id":1,"result":"a"},{"id":"2","result":"b"}]`, `[{"id":1,"result":"a"}]`, []string{`"2"`}, true},
+		{`[{"id":1,"result":"a"}]`, `{"id":1,"result":"a"}`, nil, false},
+		{`[{"id":1,"result":"a"}`, `[{"id":1,"result":"a"}]`, nil, false},
+	}
+
+	for i, tc := range tests {
+		keys, ok := batchDiff([]byte(tc.A), []byte(tc.B))
+		if ok != tc.OK || !reflect.DeepEqual(keys, tc.Keys) {
+			t.Errorf("case %d: got: %q, %t; want: %q, %t", i, keys, ok, tc.Keys, tc.OK)
+		}
+	}
+}
+
+func TestResponseEqualBatch(t *testing.T) {
+	a := Response{Body: []byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"result":"0x2"}]`)}
+	b := Response{Body: []byte(`[{"jsonrpc":"2.0","id":2,"result":"0x2"},{"jsonrpc":"2.0","id":1,"result":"0x1"}]`)}
+	if !a.Equal(b) {
+		t.Errorf("reordered batches should be equal")
+	}
+}
+
+func TestRewriteIDs(t *testing.T) {
+	line := []byte(`{"jsonrpc":"2.0","id":"abc","method":"eth_blockNumber","params":[]}`)
+	sent, rw, err := rewriteIDs(line, json.RawMessage("42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(sent), `{"id":42,"jsonrpc":"2.0","method":"eth_blockNumber","params":[]}`; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+
+	// Responses with the same id value but a different type are restored
+	for _, body := range []string{`{"jsonrpc":"2.0","id":42,"result":"0x1"}`, `{"jsonrpc":"2.0","id":"42","result":"0x1"}`} {
+		restored, err := rw.Restore([]byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(restored), `{"id":"abc","jsonrpc":"2.0","result":"0x1"}`; got != want {
+			t.Errorf("got: %s; want: %s", got, want)
+		}
+	}
+
+	if _, err := rw.Restore([]byte(`{"jsonrpc":"2.0","id":43,"result":"0x1"}`)); err == nil {
+		t.Errorf("expected uncorrelated response id to fail")
+	}
+}
+
+func TestRewriteIDsUUID(t *testing.T) {
+	req := &Request{ID: 3, UUID: "0f8fad5b-d9cb-469f-a165-70867728950e", Line: []byte(`{"id":1,"method":"a"}`)}
+	sent, rw, err := rewriteIDs(req.Line, req.rpcID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(sent), `{"id":"0f8fad5b-d9cb-469f-a165-70867728950e","method":"a"}`; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+	restored, err := rw.Restore([]byte(`{"id":"0f8fad5b-d9cb-469f-a165-70867728950e","result":"a"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(restored), `{"id":1,"result":"a"}`; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+
+	req.UUID = ""
+	if got, want := string(req.rpcID()), "3"; got != want {
+		t.Errorf("got rpc id %s; want %s", got, want)
+	}
+}
+
+func TestRewriteIDsBatch(t *testing.T) {
+	line := []byte(`[{"id":1,"method":"a"},{"method":"notify"},{"id":1,"method":"b"}]`)
+	sent, rw, err := rewriteIDs(line, json.RawMessage("7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(sent), `[{"id":0,"method":"a"},{"method":"notify"},{"id":2,"method":"b"}]`; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+
+	restored, err := rw.Restore([]byte(`[{"id":2,"result":"b"},{"id":"0","result":"a"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(restored), `[{"id":1,"result":"b"},{"id":1,"result":"a"}]`; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+}
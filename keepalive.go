@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Connected is a type of Transport over a long-lived connection, such as a
+// websocket, which can break between requests and be dialed again.
+type Connected interface {
+	// Broken returns whether the connection failed, so that every further
+	// request would fail with it.
+	Broken() bool
+	Close() error
+}
+
+// keepalive pings long-lived connections that have been idle for its
+// interval, so that idle timeouts of the endpoint or of proxies on the way
+// don't close them between paced requests.
+type keepalive struct {
+	Interval time.Duration // 0 never pings
+
+	active int64 // Unix nanoseconds of the last use of the connection, accessed atomically
+}
+
+// Touch records a use of the connection.
+func (k *keepalive) Touch() {
+	atomic.StoreInt64(&k.active, time.Now().UnixNano())
+}
+
+// idle returns how long the connection hasn't been used for.
+func (k *keepalive) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&k.active)))
+}
+
+// Run calls ping whenever the connection was idle for the interval, until
+// done is closed or pinging fails, which the connection's reads notice.
+func (k *keepalive) Run(done <-chan struct{}, ping func() error) {
+	if k.Interval <= 0 {
+		return
+	}
+	k.Touch()
+	timer := time.NewTimer(k.Interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-timer.C:
+			wait := k.Interval - k.idle(now)
+			if wait <= 0 {
+				if err := ping(); err != nil {
+					logger.Debug().Err(err).Msg("failed to send keepalive")
+					return
+				}
+				k.Touch()
+				wait = k.Interval
+			}
+			timer.Reset(wait)
+		}
+	}
+}
+
+// closed returns whether the channel is closed, without waiting.
+func closed(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestKeepalive(t *testing.T) {
+	k := &keepalive{Interval: 30 * time.Millisecond}
+	done := make(chan struct{})
+	var pings int32
+	go k.Run(done, func() error {
+		atomic.AddInt32(&pings, 1)
+		return nil
+	})
+	defer close(done)
+
+	// A busy connection isn't pinged
+	for i := 0; i < 10; i++ {
+		k.Touch()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&pings); got != 0 {
+		t.Errorf("got %d pings while busy; want 0", got)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&pings); got < 2 {
+		t.Errorf("got %d pings while idle; want at least 2", got)
+	}
+}
+
+// serveWebsocket echoes messages over websockets, closing each connection
+// after closeAfter messages if it's set, and counts the pings it gets.
+func serveWebsocket(t *testing.T, closeAfter int, pings *int32) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetPingHandler(func(data string) error {
+			atomic.AddInt32(pings, 1)
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		for n := 1; ; n++ {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+			if n == closeAfter {
+				return
+			}
+		}
+	}))
+}
+
+func TestWebsocketPing(t *testing.T) {
+	var pings int32
+	server := serveWebsocket(t, 0, &pings)
+	defer server.Close()
+
+	tr, err := NewTransport("ws"+strings.TrimPrefix(server.URL, "http"), transportOptions{Timeout: time.Second, PingInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.(*websocketTransport).Close()
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&pings); got < 2 {
+		t.Errorf("got %d pings; want at least 2", got)
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	var pings int32
+	server := serveWebsocket(t, 1, &pings)
+	defer server.Close()
+
+	clients, err := NewClients([]string{"ws" + strings.TrimPrefix(server.URL, "http")}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := clients[0]
+	out := make(chan Response, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Serve(ctx, out)
+
+	for i := 1; i <= 3; i++ {
+		if err := clients.Send(ctx, Request{ID: requestID(i), Line: []byte(`{"id":1}`)}); err != nil {
+			t.Fatal(err)
+		}
+		resp := <-out
+		if resp.Err != nil || string(resp.Body) != `{"id":1}` {
+			t.Errorf("request %d: got %s, %v; want the echo", i, resp.Body, resp.Err)
+		}
+		// Until the client notices that the endpoint closed the connection
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := client.Stats.Summary().Reconnects; got != 2 {
+		t.Errorf("got %d reconnects; want 2", got)
+	}
+}
+
+func TestWebsocketLateReply(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if strings.Contains(string(message), "slow") {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	clients, err := NewClients([]string{"ws" + strings.TrimPrefix(server.URL, "http")}, 1, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := clients[0]
+	out := make(chan Response, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Serve(ctx, out)
+
+	for _, line := range []string{`{"id":1,"slow":true}`, `{"id":2}`} {
+		if err := clients.Send(ctx, Request{ID: 1, Line: []byte(line)}); err != nil {
+			t.Fatal(err)
+		}
+		resp := <-out
+		if line == `{"id":2}` && (resp.Err != nil || string(resp.Body) != line) {
+			t.Errorf("got %s, %v; want %s after the previous reply timed out", resp.Body, resp.Err, line)
+		}
+	}
+}
+
+func TestWebsocketSendCanceled(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Never replies
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	tr, err := NewTransport("ws"+strings.TrimPrefix(server.URL, "http"), transportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.(*websocketTransport).Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var resp Response
+	if err := tr.Send(ctx, &Request{Line: []byte(`{}`)}, &resp); err != context.DeadlineExceeded {
+		t.Errorf("got %v; want %v", err, context.DeadlineExceeded)
+	}
+	if !tr.(*websocketTransport).Broken() {
+		t.Errorf("got a usable connection after giving up on the reply; want broken")
+	}
+}
+
+func TestWebsocketCloseUnread(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// More notifications than the transport has room for, unread
+		for i := 0; i < 64; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"method":"eth_subscription"}`)); err != nil {
+				return
+			}
+		}
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	tr, err := NewTransport("ws"+strings.TrimPrefix(server.URL, "http"), transportOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := tr.(*websocketTransport)
+	time.Sleep(50 * time.Millisecond)
+	ws.Close()
+	select {
+	case <-ws.done:
+	case <-time.After(time.Second):
+		t.Errorf("reading didn't stop after closing with unread messages")
+	}
+}
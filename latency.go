@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyHeader is the header of the latency log.
+var latencyHeader = []string{
+	"id", "group", "class", "tags",
+	"reference", "candidate", "reference_seconds", "candidate_seconds",
+	"reference_error", "candidate_error", "mismatched",
+}
+
+// latencyLog writes the latencies of every completed response set as CSV,
+// with a row per candidate endpoint joined to the reference (first) endpoint
+// by request, so that their correlation can be plotted per class of request.
+type latencyLog struct {
+	mu  sync.Mutex
+	w   *csv.Writer
+	buf *bufio.Writer
+	f   *rotatingFile
+	err error
+
+	closed bool
+}
+
+// createLatencyLog creates the log, which is rolled over by the rotation
+// with a header in every file.
+func createLatencyLog(path string, rot rotation) (*latencyLog, error) {
+	f, err := createRotatingFile(path, rot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create latency log: %w", err)
+	}
+	buf := bufio.NewWriter(f)
+	l := &latencyLog{w: csv.NewWriter(buf), buf: buf, f: f}
+	l.w.Write(latencyHeader)
+	return l, nil
+}
+
+// Write appends the rows of a response set of the endpoint group, in the
+// order of the group's clients, so that the first response is the
+// reference. Responses that weren't timed, such as cached ones, have no row.
+func (l *latencyLog) Write(group string, resps []Response, mismatched bool) {
+	if len(resps) < 2 || !timed(resps[0]) {
+		return
+	}
+	var class, tags string
+	if req := resps[0].Request; req != nil {
+		class = requestClass(req)
+		tags = strings.Join(req.Tags, " ")
+	}
+	ref := resps[0]
+	rows := make([][]string, 0, len(resps)-1)
+	for _, resp := range resps[1:] {
+		if !timed(resp) {
+			continue
+		}
+		rows = append(rows, []string{
+			strconv.FormatInt(int64(ref.ID), 10), group, class, tags,
+			ref.client.Label(), resp.client.Label(),
+			strconv.FormatFloat(ref.Elapsed.Seconds(), 'f', 6, 64),
+			strconv.FormatFloat(resp.Elapsed.Seconds(), 'f', 6, 64),
+			strconv.FormatBool(ref.Err != nil), strconv.FormatBool(resp.Err != nil),
+			strconv.FormatBool(mismatched),
+		})
+	}
+
+	if len(rows) == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	if err := l.w.WriteAll(rows); err != nil && l.err == nil {
+		l.err = err
+	}
+	l.f.Record()
+	if now := time.Now(); l.f.Due(now, l.buf.Buffered()) {
+		err := l.buf.Flush()
+		if err == nil {
+			err = l.f.Roll(now)
+		}
+		if err != nil && l.err == nil {
+			l.err = err
+		}
+		l.w.Write(latencyHeader)
+	}
+}
+
+// timed returns whether the response's elapsed time is a latency of its
+// endpoint, and not of a cache.
+func timed(resp Response) bool {
+	return !resp.Cached && resp.Elapsed > 0
+}
+
+// Segments returns the paths of the rolled files of the log that are kept,
+// oldest first, and of the current file, once it's closed.
+func (l *latencyLog) Segments() []string {
+	return l.f.Segments()
+}
+
+// Close flushes and closes the log, returning the first write error. It's
+// safe to call more than once.
+func (l *latencyLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return l.err
+	}
+	l.closed = true
+	if err := l.buf.Flush(); err != nil && l.err == nil {
+		l.err = err
+	}
+	if err := l.f.Close(); err != nil && l.err == nil {
+		l.err = err
+	}
+	return l.err
+}
+
+// requestClass returns what kind of request it is, to group latencies by:
+// the method and path of HTTP requests from the input, the JSON-RPC method,
+// or "batch" for JSON-RPC batches.
+func requestClass(req *Request) string {
+	if req.Method != "" || req.Path != "" {
+		path := req.Path
+		if i := strings.IndexByte(path, '?'); i >= 0 {
+			path = path[:i]
+		}
+		return strings.TrimSpace(req.Method + " " + path)
+	}
+	if isBatch(req.Line) {
+		return "batch"
+	}
+	var m rpcMessage
+	if err := json.Unmarshal(req.Line, &m); err != nil {
+		return ""
+	}
+	return m.Method
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "versus-latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "latency.csv")
+	l, err := createLatencyLog(path, rotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients, err := NewClients([]string{"noop://a", "noop://b", "noop://c"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := report{Clients: clients, ComparedResponses: func(resps []Response, mismatched bool) {
+		l.Write("eth", resps, mismatched)
+	}}
+	r.init()
+	req := &Request{ID: 7, Line: []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_call"}`), Tags: []string{"canary", "tenant=acme"}}
+	// Responses arrive in any order, the first client is the reference
+	r.handle(Response{client: clients[2], ID: 7, Request: req, Elapsed: 30 * time.Millisecond, Err: errors.New("timeout")})
+	r.handle(Response{client: clients[0], ID: 7, Request: req, Elapsed: 10 * time.Millisecond})
+	r.handle(Response{client: clients[1], ID: 7, Request: req, Elapsed: 20 * time.Millisecond})
+	// Cached responses weren't timed, nor is a set with a cached reference
+	cached := &Request{ID: 8, Line: req.Line}
+	r.handle(Response{client: clients[0], ID: 8, Request: cached, Elapsed: 10 * time.Millisecond})
+	r.handle(Response{client: clients[1], ID: 8, Request: cached, Cached: true})
+	r.handle(Response{client: clients[2], ID: 8, Request: cached, Elapsed: 30 * time.Millisecond})
+	r.handle(Response{client: clients[0], ID: 9, Request: &Request{ID: 9}, Cached: true})
+	r.handle(Response{client: clients[1], ID: 9, Request: &Request{ID: 9}, Elapsed: 20 * time.Millisecond})
+	r.handle(Response{client: clients[2], ID: 9, Request: &Request{ID: 9}, Elapsed: 30 * time.Millisecond})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		strings.Join(latencyHeader, ","),
+		"7,eth,eth_call,canary tenant=acme,noop://a,noop://b,0.010000,0.020000,false,false,true",
+		"7,eth,eth_call,canary tenant=acme,noop://a,noop://c,0.010000,0.030000,false,true,true",
+		"8,eth,eth_call,,noop://a,noop://c,0.010000,0.030000,false,false,false",
+	}, "\n") + "\n"
+	if got := string(data); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRequestClass(t *testing.T) {
+	tests := []struct {
+		req  Request
+		want string
+	}{
+		{Request{Line: []byte(`{"method":"eth_getBalance","params":[]}`)}, "eth_getBalance"},
+		{Request{Line: []byte(`[{"method":"eth_call"}]`)}, "batch"},
+		{Request{Method: "GET", Path: "/v2/users?page=2"}, "GET /v2/users"},
+		{Request{Path: "/health"}, "/health"},
+		{Request{Line: []byte(`not json`)}, ""},
+	}
+	for _, tc := range tests {
+		if got := requestClass(&tc.req); got != tc.want {
+			t.Errorf("got: %q; want: %q", got, tc.want)
+		}
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	flags "github.com/jessevdk/go-flags"
@@ -24,9 +27,98 @@ type Options struct {
 		Endpoints []string `positional-arg-name:"endpoint" description:"API endpoint to load test, such as \"http://localhost:8080/\""`
 	} `positional-args:"yes"`
 
-	Timeout     string `long:"timeout" description:"Abort request after duration" default:"30s"`
-	StopAfter   string `long:"stop-after" description:"Stop after N requests per endpoint, N can be a number or duration."`
-	Concurrency int    `long:"concurrency" description:"Concurrent requests per endpoint" default:"1"`
+	Timeout               string   `long:"timeout" description:"Abort request after duration" default:"30s"`
+	StopAfter             string   `long:"stop-after" description:"Stop after N requests per endpoint, N can be a number or duration."`
+	Input                 string   `long:"input" description:"Where requests come from: - for stdin, a file path, or an s3://, gs:// or http(s):// URI. Gzipped input is decompressed." default:"-"`
+	PriorityInput         string   `long:"priority-input" description:"Also read requests from this file or named pipe (FIFO), and send them ahead of the input as they come, such as to probe calls during a long replay. They're tagged lane=priority, and aren't paced, paused, fault-injected or counted by --stop-after."`
+	InputFormat           string   `long:"input-format" description:"Format of input lines: a request per line (lines), or a JSON envelope with the request and its metadata, such as {\"request\": {...}, \"tags\": {\"tenant\": \"acme\"}} (envelope)." choice:"lines" choice:"envelope" default:"lines"`
+	InputFraming          string   `long:"input-framing" description:"How input records are delimited: by newlines (lines), or prefixed with their size as a 4-byte big-endian integer (length) or an unsigned varint (varint), so that requests can contain newlines and binary data." choice:"lines" choice:"length" choice:"varint" default:"lines"`
+	Tag                   []string `long:"tag" description:"Tag each request with a value found in it, as NAME=JSONPATH (e.g. \"method=$.method\"). Stats are broken down by tag in the report. Can be repeated."`
+	StartAt               string   `long:"start-at" description:"Wait until this time (RFC 3339, such as \"2024-06-01T12:00:00Z\") before sending requests, so that several instances start at the same moment."`
+	Concurrency           int      `long:"concurrency" description:"Concurrent requests per endpoint" default:"1"`
+	QueueSize             int      `long:"queue-size" description:"Queue up to N requests per endpoint, so that a slow endpoint doesn't hold back the others until its queue is full. (default: twice the concurrency)"`
+	QueueFull             string   `long:"queue-full" description:"What to do with requests for an endpoint whose queue is full: wait for room, holding back every endpoint (block), or drop the request for that endpoint and count it (drop)." choice:"block" choice:"drop" default:"block"`
+	AcceptEncoding        string   `long:"accept-encoding" description:"Accept-Encoding header of HTTP requests. Responses are decoded before comparing, supported encodings are gzip, br and deflate." default:"gzip"`
+	HashBodies            bool     `long:"hash-bodies" description:"Compare HTTP responses by the SHA-256 and size of their decoded body, without keeping bodies in memory. Useful for large binary responses."`
+	Cookies               bool     `long:"cookies" description:"Keep a cookie jar per concurrent client, so session cookies persist between requests."`
+	CompareRedirects      bool     `long:"compare-redirects" description:"Compare the redirect chains that led to HTTP responses, by the status and target of each redirect, as well as the responses."`
+	NoKeepAlive           bool     `long:"no-keepalive" description:"Open a new connection, with its TCP and TLS handshakes, for every HTTP request, so latencies include the cost of cold connections. Endpoints with keepalive=on still reuse connections."`
+	PingInterval          string   `long:"ping-interval" description:"Send a keepalive over websocket and MQTT connections that were idle for this long, a ping frame or a PINGREQ packet, so that idle timeouts don't close them between paced requests. Broken connections are dialed again before the next request, outside its timing, and counted as reconnects. 0 never pings." default:"30s"`
+	SessionKey            string   `long:"session-key" description:"Top-level JSON field of the request that identifies its session. Requests of the same session are sent by the same concurrent client. Implies --cookies."`
+	Extract               []string `long:"extract" description:"Extract a value from each response as NAME=JSONPATH (e.g. \"userID=$.result.id\") and substitute it into later requests containing {{NAME}}. Values are kept per endpoint. Can be repeated."`
+	RewriteID             bool     `long:"rewrite-id" description:"Rewrite JSON-RPC request ids to unique values when sending, and restore the original ids in responses before comparing them."`
+	RequestIDs            string   `long:"request-ids" description:"How requests are identified in logs, the mismatch log and the results stream: by their number in the run (counter), or also by a random UUID (uuid), which is unique across runs and replaces JSON-RPC ids with --rewrite-id." choice:"counter" choice:"uuid" default:"counter"`
+	Recheck               string   `long:"recheck" description:"Send the requests of mismatched results again after this delay (e.g. \"2s\"), and only count a mismatch if the responses still differ, so that endpoints briefly out of sync, such as a node a block behind, aren't reported."`
+	HeadCheck             string   `long:"head-check" description:"Query the chain head (eth_blockNumber) of each endpoint at this interval (e.g. \"5s\"), report how far behind the highest endpoint each one is, and count mismatches while endpoints were at different heights, which are usually a node lagging."`
+	HeadSuppress          bool     `long:"head-suppress" description:"Don't count mismatches while endpoints were at different heights as mismatches. Requires --head-check."`
+	Normalize             []string `long:"normalize" description:"Normalize responses before comparing them. Can be repeated. (options: eth-quantity, eth-address, eth-logs, eth-null, or ethereum for all of them)"`
+	ProtoDescriptors      string   `long:"proto-descriptors" description:"FileDescriptorSet (from protoc --include_imports --descriptor_set_out) used to decode protobuf responses before comparing them."`
+	ProtoMessage          string   `long:"proto-message" description:"Fully-qualified name of the protobuf message type of responses, such as \"acme.v1.GetUserResponse\". Requires --proto-descriptors."`
+	LatencySamples        int      `long:"latency-samples" description:"Keep a uniform sample of at most N latencies per endpoint for percentiles, so memory stays bounded on long runs. Averages, min and max stay exact. 0 keeps every latency."`
+	MaxBodySize           string   `long:"max-body-size" description:"Keep at most this much of each decoded response body in memory, such as 512KB or 10MB."`
+	Oversize              string   `long:"oversize" description:"What to do with bodies over --max-body-size: compare the kept prefix (truncate), compare the prefix and a hash of the remainder (hash), or don't compare the results (skip)." choice:"truncate" choice:"hash" choice:"skip" default:"hash"`
+	SpillSize             string   `long:"spill-size" description:"Write decoded response bodies larger than this (such as 10MB) to temporary files, and compare them by hash. Files of mismatched responses are kept for inspection."`
+	SpillDir              string   `long:"spill-dir" description:"Directory for spilled response bodies. (default: the system temporary directory)"`
+	CacheReference        int      `long:"cache-reference" description:"Cache up to N responses of the first (reference) endpoint, so repeated identical requests don't hit it again. Other endpoints are always queried."`
+	CacheTTL              string   `long:"cache-ttl" description:"Expire cached reference responses after duration." default:"1m"`
+	SubscriptionWindow    string   `long:"subscription-window" description:"Collect notifications of subscription requests (e.g. eth_subscribe) over websockets, and the events of server-sent event streams (text/event-stream) over HTTP, for this duration, then compare the streams. Event streams are read until they end without a window."`
+	SubscriptionUnordered bool     `long:"subscription-unordered" description:"Compare subscription notifications as a set, ignoring their order."`
+	EventIgnore           []string `long:"event-ignore" description:"Drop this field from the JSON data of server-sent events before comparing them, at any depth, such as a timestamp. Event ids are always ignored. Can be repeated."`
+	Interactive           bool     `long:"interactive" short:"i" description:"Read requests typed on the terminal instead of the input, send each to every endpoint right away, and show their latencies and a diff of mismatched responses."`
+	Bisect                bool     `long:"bisect" description:"Find the earliest request of an ordered input from which responses keep mismatching, such as the one that throws a stateful backend off, by replaying shorter and shorter prefixes of the input one request at a time. The input is kept in memory."`
+	BisectReset           string   `long:"bisect-reset" description:"Shell command that resets the state of the endpoints before each replay of --bisect, such as restoring a database snapshot."`
+	Format                string   `long:"format" description:"Format of the report printed after the run. The HTML report plots the latency distribution of each endpoint, above the text report." choice:"text" choice:"json" choice:"html" default:"text"`
+	NoColor               bool     `long:"no-color" description:"Don't color the report and logs, which are colored on terminals unless NO_COLOR is set. Error and mismatch rates are red from --alert-error-rate and --alert-mismatch-rate, or 1%."`
+	Diff                  string   `long:"diff" description:"How mismatched bodies are shown in verbose logs: a unified diff of their pretty-printed JSON (unified), the same in two columns (side-by-side), or both bodies as they are (raw)." choice:"unified" choice:"side-by-side" choice:"raw" default:"unified"`
+	Results               string   `long:"results" description:"Stream a JSON line per compared request, with the status, latency and error of each endpoint's response and whether they mismatched, to this named pipe (FIFO) or file, or to the consumers that connect to a Unix socket, such as \"unix:/tmp/versus.sock\". Lines are dropped for consumers that can't keep up."`
+	LatencyLog            string   `long:"latency-log" description:"Write the latency of every compared request to this CSV file, as a row per endpoint paired with the first (reference) endpoint's latency, along with the request's JSON-RPC method or path and its tags. For plotting the latency correlation between endpoints."`
+	Bundle                string   `long:"bundle" description:"Write the run to this .tar.zst, .tar.gz or .tar file when it's over, to reproduce and audit it later: the resolved options (without secrets), the version, the seed, a digest of the input, the reports, and the mismatch and latency logs."`
+	MismatchLog           string   `long:"mismatch-log" description:"Write mismatched response sets to this file as JSON lines, with the request and every endpoint's response."`
+	RotateEvery           string   `long:"rotate-every" description:"Roll the mismatch and latency logs over to a new file on every multiple of this duration of the clock, such as 1h for on the hour. Rolled files are named after the time they were started at, such as mismatches-20240601T140000.jsonl, and compressed with gzip."`
+	RotateSize            string   `long:"rotate-size" description:"Roll the mismatch and latency logs over once they're this large, such as 100MB, like --rotate-every."`
+	RotateRecords         int      `long:"rotate-records" description:"Roll the mismatch and latency logs over after this many mismatched response sets or compared requests, like --rotate-every."`
+	RotateKeep            int      `long:"rotate-keep" description:"Keep this many rolled files of each log, and delete the oldest along with the spilled bodies they refer to. (default: keep all)"`
+	MemoryLimit           string   `long:"memory-limit" description:"Keep the heap under this size, such as 2GB, for long runs: past it, percentiles are estimated from a uniform sample of latencies, and if that's not enough, requests are held until the heap is back under 90% of it."`
+	ScrubPath             []string `long:"scrub-path" description:"Redact the value at this JSONPath, such as \"$.params[0].email\" or \"$.result.token\", from requests and response bodies before they're written to the mismatch log, mismatch hooks, verbose logs and reports. Paths that start with a field apply to each element of JSON-RPC batches. Can be repeated."`
+	ScrubPattern          []string `long:"scrub-pattern" description:"Redact the matches of this regular expression, such as \"Bearer [A-Za-z0-9._-]+\", from requests, response bodies and errors before they're written out, like --scrub-path. Spilled bodies of mismatches aren't kept when scrubbing. Can be repeated."`
+	Upload                string   `long:"upload" description:"Upload the text and JSON reports and the mismatch log to this s3:// or gs:// prefix after the run. It's a template with {{.Date}}, {{.Time}}, {{.Unix}}, {{.Version}} and {{.Hostname}}, such as \"s3://bucket/versus/{{.Date}}/{{.Hostname}}-{{.Time}}/\"."`
+	Notify                string   `long:"notify" description:"Post a summary of the run to this Slack or Discord incoming webhook URL when it's over."`
+	PushGateway           string   `long:"push-gateway" description:"Push metrics to this Prometheus Pushgateway URL during and after the run."`
+	RemoteWrite           string   `long:"remote-write" description:"Push metrics to this Prometheus remote-write URL during and after the run."`
+	PushJob               string   `long:"push-job" description:"Job label of pushed metrics." default:"versus"`
+	PushInstance          string   `long:"push-instance" description:"Instance label of pushed metrics. (default: hostname)"`
+	PushInterval          string   `long:"push-interval" description:"How often metrics are pushed during the run, 0 to only push at the end." default:"30s"`
+	HealthCheck           string   `long:"health-check" description:"Send this request (e.g. '{\"jsonrpc\":\"2.0\",\"id\":1,\"method\":\"net_version\"}') to every endpoint before starting, and refuse to start if any of them fails or returns a JSON-RPC error."`
+	HealthCheckWarn       bool     `long:"health-check-warn" description:"Only warn about failed health checks, and start anyway."`
+	Window                []string `long:"window" description:"Only send requests during this daily time window, as HH:MM-HH:MM such as \"02:00-05:00\" or \"22:00-02:00\", and hold them outside of it, such as for replays allowed off-peak. The run and its report carry on across windows. Can be repeated."`
+	WindowTimezone        string   `long:"window-timezone" description:"Time zone of --window, such as \"Europe/Berlin\". (default: local time)"`
+	Rate                  float64  `long:"rate" description:"Send at most this many requests per second, 0 is unlimited. Can be changed at runtime with the control API."`
+	Lockstep              bool     `long:"lockstep" description:"Wait until every endpoint answered a request before sending the next one, instead of sending requests as fast as the endpoints take them, so requests don't interfere with each other and divergences are deterministic to debug."`
+	Groups                string   `long:"groups" description:"Run several independent endpoint groups, each with its own report, from this JSON file: [{\"name\": \"eth\", \"endpoints\": [...], \"tags\": [\"service=eth\"]}, ...]. A group only receives the requests with any of its tags, or all of them if it has none."`
+	EndpointsFile         string   `long:"endpoints-file" description:"Read more endpoints from this file, one per line. On SIGHUP, the file is read again and endpoints are added or removed to match it."`
+	Control               string   `long:"control" description:"Serve a control API on this address, such as \"127.0.0.1:8099\": GET /stats, POST /rate?rps=N, /pause, /resume and /finalize."`
+	SLO                   []string `long:"slo" description:"Check endpoints against a latency objective for a JSON-RPC method or HTTP path, written as PATTERN < DURATION [pNN] with a glob pattern, such as \"eth_call < 200ms p99\" or \"GET /v1/* < 50ms p95\", and report whether each met it. The percentile defaults to p99. Can be repeated."`
+	HeaderStats           []string `long:"header-stats" description:"Count the values of this response header per endpoint, such as X-Cache, Server or a rate limit header, and report their distribution. Can be repeated."`
+	CostTable             string   `long:"cost-table" description:"Price requests with this JSON file of the cost of each JSON-RPC method or HTTP path, as glob patterns, such as the compute units providers bill: {\"eth_call\": 26, \"eth_getLogs\": 75, \"*\": 10}. The report includes the total and per-second cost of each endpoint."`
+	Canary                bool     `long:"canary" description:"Test whether every other (candidate) endpoint is non-inferior to the first (reference) endpoint in error rate and latency, with sequential testing, and stop the run as soon as the verdict is significant. Exits with 0 on PASS, 3 on FAIL, and 4 if the run ended before a verdict."`
+	CanaryErrorMargin     float64  `long:"canary-error-margin" description:"Percentage points of error rate that candidates may exceed the reference by, and still pass --canary." default:"0.5"`
+	CanaryLatencyMargin   float64  `long:"canary-latency-margin" description:"Percent that candidates may be slower than the reference by on average, as the geometric mean of the latency ratio of each request, and still pass --canary." default:"10"`
+	CanaryConfidence      float64  `long:"canary-confidence" description:"Confidence of the --canary verdict, in percent." default:"95"`
+	MismatchWebhook       string   `long:"mismatch-webhook" description:"Post each mismatch, with its request and responses as in the mismatch log, to this webhook URL, for triage pipelines."`
+	MismatchCommand       string   `long:"mismatch-command" description:"Run this shell command for each mismatch, with its request and responses as a JSON line of the mismatch log on stdin."`
+	MismatchHookRate      float64  `long:"mismatch-hook-rate" description:"Send at most this many mismatches per second to --mismatch-webhook and --mismatch-command, and count the others. 0 is unlimited." default:"1"`
+	AlertWebhook          string   `long:"alert-webhook" description:"Post a JSON alert with the current stats to this URL when a threshold is crossed mid-run."`
+	AlertErrorRate        float64  `long:"alert-error-rate" description:"Alert when the error rate exceeds this percentage."`
+	AlertMismatchRate     float64  `long:"alert-mismatch-rate" description:"Alert when the mismatch rate exceeds this percentage."`
+	AlertP99              string   `long:"alert-p99" description:"Alert when the 99th percentile latency of any endpoint exceeds this duration."`
+	AlertInterval         string   `long:"alert-interval" description:"How often alert thresholds are checked." default:"1m"`
+	FaultDelay            string   `long:"fault-delay" description:"Delay requests before sending them, as PROBABILITY:DURATION (e.g. \"0.05:500ms\"). Faults are decided per request, so every endpoint gets the same ones."`
+	FaultDrop             string   `long:"fault-drop" description:"Abandon requests after a duration, as PROBABILITY:DURATION (e.g. \"0.01:50ms\"), like clients that disconnect early. Abandoned requests aren't compared."`
+	FaultDuplicate        string   `long:"fault-duplicate" description:"Send requests a second time with this probability (e.g. \"0.01\"), like retrying clients."`
+	Seed                  int64    `long:"seed" description:"Seed of random decisions such as fault injection and shuffling, to reproduce a run. (default: random)"`
+	Shuffle               bool     `long:"shuffle" description:"Randomize the order of requests before sending them, reproducibly with --seed, to break up clusters of similar requests in captured traffic."`
+	ShuffleWindow         int      `long:"shuffle-window" description:"Number of requests shuffled together: requests move about this far from their place in the input on average." default:"10000"`
+	Pprof                 string   `long:"pprof" description:"Serve pprof endpoints under /debug/pprof/ on this address, such as \"127.0.0.1:6060\", to profile versus itself."`
 	//CompareResponse string `long:"compare-response" description:"Load all response bodies and compare between endpoints, will affect throughput." default:"on"`
 
 	//Source string `long:"source" description:"Where requests come from (options: stdin-post, stdin-get)" default:"stdin-jsons"` // Someday: stdin-tcpdump, file://foo.json, ws://remote-endpoint
@@ -46,6 +138,11 @@ func exit(code int, format string, args ...interface{}) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "corpus" {
+		corpusMain(os.Args[2:])
+		return
+	}
+
 	options := Options{}
 	p, err := flags.NewParser(&options, flags.Default).ParseArgs(os.Args[1:])
 	if err != nil {
@@ -60,10 +157,13 @@ func main() {
 		os.Exit(0)
 	}
 
-	if len(options.Args.Endpoints) == 0 {
+	if len(options.Args.Endpoints) == 0 && options.EndpointsFile == "" && options.Groups == "" {
 		exit(1, "must specify at least one endpoint\n")
 	}
 
+	if !newPalette(os.Stderr, options.NoColor, 0, 0).Enabled {
+		logger = logger.Output(zerolog.ConsoleWriter{Out: os.Stderr, NoColor: true})
+	}
 	switch len(options.Verbose) {
 	case 0:
 		logger = logger.Level(zerolog.WarnLevel)
@@ -86,11 +186,38 @@ func main() {
 		panic("aborted")
 	}(abort)
 
-	if err := run(ctx, options); err != nil {
+	switch err := run(ctx, options); err {
+	case nil:
+	case errCanaryFailed:
+		exit(3, "%s\n", err)
+	case errCanaryInconclusive:
+		exit(4, "%s\n", err)
+	default:
 		exit(2, "error during run: %s\n", err)
 	}
 }
 
+// parseSize parses a size in bytes, with an optional KB, MB or GB suffix.
+func parseSize(s string) (int, error) {
+	multiplier := 1
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	for _, unit := range []struct {
+		suffix string
+		n      int
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"B", 1}} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSuffix(upper, unit.suffix)
+			multiplier = unit.n
+			break
+		}
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(upper), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int(n) * multiplier, nil
+}
+
 func parseStopAfter(s string) (time.Duration, int, error) {
 	n, err := strconv.ParseUint(s, 10, 32)
 	if err == nil {
@@ -105,7 +232,39 @@ func parseStopAfter(s string) (time.Duration, int, error) {
 	return d, 0, nil
 }
 
+// runEndpoints returns the endpoints given as arguments, followed by the ones
+// in the endpoints file.
+func runEndpoints(options Options) ([]string, error) {
+	endpoints := append([]string(nil), options.Args.Endpoints...)
+	if options.EndpointsFile != "" {
+		more, err := readEndpointsFile(options.EndpointsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read endpoints file: %w", err)
+		}
+		endpoints = append(endpoints, more...)
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("must specify at least one endpoint")
+	}
+	return endpoints, nil
+}
+
 func run(ctx context.Context, options Options) error {
+	startedAt := time.Now()
+	if options.Bundle != "" {
+		if _, err := bundleCompression(options.Bundle); err != nil {
+			return err
+		}
+	}
+	var startAt time.Time
+	if options.StartAt != "" {
+		t, err := time.Parse(time.RFC3339, options.StartAt)
+		if err != nil {
+			return fmt.Errorf("failed to parse start time: %w", err)
+		}
+		startAt = t
+	}
+
 	var stopAfter int
 	if options.StopAfter != "" {
 		d, n, err := parseStopAfter(options.StopAfter)
@@ -113,7 +272,12 @@ func run(ctx context.Context, options Options) error {
 			return err
 		}
 		if d > 0 {
-			timeoutCtx, cancel := context.WithTimeout(ctx, d)
+			// The duration starts counting once requests start being sent
+			start := time.Now()
+			if startAt.After(start) {
+				start = startAt
+			}
+			timeoutCtx, cancel := context.WithDeadline(ctx, start.Add(d))
 			defer cancel()
 			ctx = timeoutCtx
 		}
@@ -129,6 +293,17 @@ func run(ctx context.Context, options Options) error {
 		timeout = d
 	}
 
+	var subscriptions subscriptionOptions
+	if options.SubscriptionWindow != "" {
+		d, err := time.ParseDuration(options.SubscriptionWindow)
+		if err != nil {
+			return fmt.Errorf("failed to parse subscription window: %w", err)
+		}
+		subscriptions.Window = d
+	}
+	subscriptions.Unordered = options.SubscriptionUnordered
+	subscriptions.IgnoreFields = options.EventIgnore
+
 	if options.Concurrency < 1 {
 		logger.Info().Int("concurrency", options.Concurrency).Msg("concurrency is less than 1, overriding to 1")
 		options.Concurrency = 1
@@ -140,34 +315,506 @@ func run(ctx context.Context, options Options) error {
 	if respBuffer < 50 {
 		respBuffer = 50
 	}
-	// responses is closed when clients are shut down
-	responses := make(chan Response, respBuffer)
+
+	var specs []groupSpec
+	if options.Groups != "" {
+		if len(options.Args.Endpoints) > 0 || options.EndpointsFile != "" {
+			return fmt.Errorf("--groups can't be used with endpoint arguments or --endpoints-file")
+		}
+		if options.Control != "" {
+			return fmt.Errorf("--groups can't be used with --control")
+		}
+		var err error
+		if specs, err = readGroupsFile(options.Groups); err != nil {
+			return err
+		}
+	} else {
+		endpoints, err := runEndpoints(options)
+		if err != nil {
+			return err
+		}
+		specs = []groupSpec{{Endpoints: endpoints}}
+	}
+
+	extractRules, err := parseExtractRules(options.Extract)
+	if err != nil {
+		return err
+	}
+	parser := &inputParser{Envelope: options.InputFormat == "envelope"}
+	if parser.Split, err = inputSplit(options.InputFraming); err != nil {
+		return err
+	}
+	if parser.TagRules, err = parseTagRules(options.Tag); err != nil {
+		return err
+	}
+	normalizers, err := parseNormalizers(options.Normalize)
+	if err != nil {
+		return err
+	}
+	var codec *protoCodec
+	if options.ProtoDescriptors != "" || options.ProtoMessage != "" {
+		if options.ProtoDescriptors == "" || options.ProtoMessage == "" {
+			return fmt.Errorf("--proto-descriptors and --proto-message must be used together")
+		}
+		if options.HashBodies {
+			return fmt.Errorf("--hash-bodies can't be used with protobuf decoding")
+		}
+		if codec, err = loadProtoCodec(options.ProtoDescriptors, options.ProtoMessage); err != nil {
+			return err
+		}
+	}
+	var maxBodySize int
+	if options.MaxBodySize != "" {
+		if maxBodySize, err = parseSize(options.MaxBodySize); err != nil {
+			return fmt.Errorf("failed to parse max body size: %w", err)
+		}
+		if options.HashBodies {
+			return fmt.Errorf("--hash-bodies can't be used with --max-body-size")
+		}
+	}
+	var spillSize int
+	if options.SpillSize != "" {
+		if spillSize, err = parseSize(options.SpillSize); err != nil {
+			return fmt.Errorf("failed to parse spill size: %w", err)
+		}
+		if options.HashBodies || maxBodySize > 0 {
+			return fmt.Errorf("--spill-size can't be used with --hash-bodies or --max-body-size")
+		}
+	}
+	rot := rotation{Records: options.RotateRecords, Keep: options.RotateKeep}
+	if options.RotateEvery != "" {
+		if rot.Every, err = time.ParseDuration(options.RotateEvery); err != nil {
+			return fmt.Errorf("failed to parse rotate every: %w", err)
+		}
+	}
+	if options.RotateSize != "" {
+		size, err := parseSize(options.RotateSize)
+		if err != nil {
+			return fmt.Errorf("failed to parse rotate size: %w", err)
+		}
+		rot.Size = int64(size)
+	}
+	var memoryLimit int
+	if options.MemoryLimit != "" {
+		if memoryLimit, err = parseSize(options.MemoryLimit); err != nil {
+			return fmt.Errorf("failed to parse memory limit: %w", err)
+		}
+	}
+	pingInterval, err := time.ParseDuration(options.PingInterval)
+	if err != nil || pingInterval < 0 {
+		return fmt.Errorf("failed to parse ping interval: %s", options.PingInterval)
+	}
+	configure := func(c *Client) {
+		if len(extractRules) > 0 {
+			c.Extractor = newExtractor(extractRules)
+		}
+		c.Encoding = options.AcceptEncoding
+		c.HashBodies = options.HashBodies
+		c.Cookies = options.Cookies || options.SessionKey != ""
+		c.NoKeepAlive = options.NoKeepAlive
+		c.Redirects = options.CompareRedirects
+		c.SessionKey = options.SessionKey
+		c.RewriteID = options.RewriteID
+		c.Normalizers = normalizers
+		c.Proto = codec
+		c.Subscriptions = subscriptions
+		c.MaxBodySize = maxBodySize
+		c.Oversize = options.Oversize
+		c.SpillSize = spillSize
+		c.SpillDir = options.SpillDir
+		c.PingInterval = pingInterval
+		c.Stats.timing.Limit = options.LatencySamples
+		c.QueueFull = options.QueueFull
+		if options.QueueSize > 0 {
+			c.In = make(chan Request, options.QueueSize)
+		}
+	}
+
+	seed := options.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	var faults *faultInjector
+	if options.FaultDelay != "" || options.FaultDrop != "" || options.FaultDuplicate != "" {
+		faults = newFaultInjector(seed)
+		if options.FaultDelay != "" {
+			if faults.Delay, err = parseFault(options.FaultDelay, true); err != nil {
+				return err
+			}
+		}
+		if options.FaultDrop != "" {
+			if faults.Drop, err = parseFault(options.FaultDrop, true); err != nil {
+				return err
+			}
+		}
+		if options.FaultDuplicate != "" {
+			if faults.Duplicate, err = parseFault(options.FaultDuplicate, false); err != nil {
+				return err
+			}
+		}
+		logger.Info().Int64("seed", seed).Msg("injecting faults")
+	}
+	var shuffle *shuffler
+	if options.Shuffle {
+		shuffle = newShuffler(options.ShuffleWindow, seed)
+		logger.Info().Int64("seed", seed).Int("window", shuffle.Window).Msg("shuffling requests")
+	}
+
+	var cacheTTL time.Duration
+	if options.CacheReference > 0 {
+		if cacheTTL, err = time.ParseDuration(options.CacheTTL); err != nil {
+			return fmt.Errorf("failed to parse cache ttl: %w", err)
+		}
+	}
+	var recheckDelay time.Duration
+	if options.Recheck != "" {
+		if recheckDelay, err = time.ParseDuration(options.Recheck); err != nil {
+			return fmt.Errorf("failed to parse recheck delay: %w", err)
+		}
+	}
+	var headInterval time.Duration
+	if options.HeadCheck != "" {
+		if headInterval, err = time.ParseDuration(options.HeadCheck); err != nil || headInterval <= 0 {
+			return fmt.Errorf("failed to parse head check interval: %s", options.HeadCheck)
+		}
+	} else if options.HeadSuppress {
+		return fmt.Errorf("--head-suppress requires --head-check")
+	}
+	var schedule *windowSchedule
+	if len(options.Window) > 0 {
+		location := time.Local
+		if options.WindowTimezone != "" {
+			if location, err = time.LoadLocation(options.WindowTimezone); err != nil {
+				return fmt.Errorf("invalid window time zone: %w", err)
+			}
+		}
+		if schedule, err = newWindowSchedule(options.Window, location); err != nil {
+			return err
+		}
+	} else if options.WindowTimezone != "" {
+		return fmt.Errorf("--window-timezone requires --window")
+	}
+	var scrub *scrubber
+	if len(options.ScrubPath) > 0 || len(options.ScrubPattern) > 0 {
+		if scrub, err = newScrubber(options.ScrubPath, options.ScrubPattern); err != nil {
+			return err
+		}
+	}
+	var hook *mismatchHook
+	if options.MismatchWebhook != "" || options.MismatchCommand != "" {
+		hook = &mismatchHook{URL: options.MismatchWebhook, Command: options.MismatchCommand, Rate: options.MismatchHookRate}
+	}
+	var cnry *canary
+	if options.Canary {
+		if options.Groups != "" {
+			return fmt.Errorf("--canary can't be used with --groups")
+		}
+		if len(specs[0].Endpoints) < 2 {
+			return fmt.Errorf("--canary requires a reference and at least one candidate endpoint")
+		}
+		if options.CanaryConfidence <= 0 || options.CanaryConfidence >= 100 {
+			return fmt.Errorf("--canary-confidence must be between 0 and 100")
+		}
+		cnry = &canary{
+			ErrorMargin:   options.CanaryErrorMargin,
+			LatencyMargin: options.CanaryLatencyMargin,
+			Confidence:    options.CanaryConfidence,
+		}
+	}
+	var pusher *metricsPusher
+	var pushInterval time.Duration
+	if options.PushGateway != "" || options.RemoteWrite != "" {
+		pusher = &metricsPusher{
+			Gateway:     options.PushGateway,
+			RemoteWrite: options.RemoteWrite,
+			Job:         options.PushJob,
+			Instance:    options.PushInstance,
+		}
+		if pusher.Instance == "" {
+			pusher.Instance, _ = os.Hostname()
+		}
+		if pushInterval, err = time.ParseDuration(options.PushInterval); err != nil {
+			return fmt.Errorf("failed to parse push interval: %w", err)
+		}
+	}
+	var costs *costTable
+	if options.CostTable != "" {
+		if costs, err = readCostTable(options.CostTable); err != nil {
+			return err
+		}
+	}
+	var slos []latencySLO
+	for _, spec := range options.SLO {
+		slo, err := parseSLO(spec)
+		if err != nil {
+			return err
+		}
+		slos = append(slos, slo)
+	}
+	var thresholds alertThresholds
+	var alertInterval time.Duration
+	if options.AlertWebhook != "" {
+		thresholds.ErrorRate = options.AlertErrorRate
+		thresholds.MismatchRate = options.AlertMismatchRate
+		if options.AlertP99 != "" {
+			if thresholds.P99, err = time.ParseDuration(options.AlertP99); err != nil {
+				return fmt.Errorf("failed to parse alert p99: %w", err)
+			}
+		}
+		if alertInterval, err = time.ParseDuration(options.AlertInterval); err != nil {
+			return fmt.Errorf("failed to parse alert interval: %w", err)
+		}
+	}
+
+	var mismatches *mismatchLog
+	if options.MismatchLog != "" || options.Upload != "" || options.Bundle != "" {
+		// Uploads and bundles include the mismatch log, so keep a temporary one if needed
+		if mismatches, err = createMismatchLog(options.MismatchLog, rot); err != nil {
+			return err
+		}
+		defer mismatches.Close()
+		if options.MismatchLog == "" {
+			defer os.Remove(mismatches.Path())
+		}
+	}
+	var results *resultStream
+	if options.Results != "" {
+		if results, err = openResultStream(options.Results); err != nil {
+			return err
+		}
+		results.Scrub = scrub
+		defer results.Close()
+	}
+	var latencies *latencyLog
+	if options.LatencyLog != "" {
+		if latencies, err = createLatencyLog(options.LatencyLog, rot); err != nil {
+			return err
+		}
+		defer latencies.Close()
+	}
+	verbose := len(options.Verbose) > 0
 
 	// Launch clients
-	clients, err := NewClients(options.Args.Endpoints, options.Concurrency, timeout)
+	self := newSelfMonitor()
+	go self.Serve(ctx)
+	var groups groupSet
+	numClients := 0
+	for _, spec := range specs {
+		clients, err := NewClients(spec.Endpoints, options.Concurrency, timeout)
+		if err != nil {
+			return fmt.Errorf("failed to create clients: %w", err)
+		}
+		names := map[string]bool{}
+		for _, c := range clients {
+			if c.Name != "" && names[c.Name] {
+				return fmt.Errorf("duplicate endpoint name: %s", c.Name)
+			}
+			names[c.Name] = true
+			configure(c)
+		}
+		if options.CacheReference > 0 {
+			clients[0].Cache = newResponseCache(options.CacheReference, cacheTTL)
+		}
+
+		if options.HealthCheck != "" {
+			failed := healthCheck(ctx, clients, []byte(options.HealthCheck))
+			for _, c := range clients {
+				if err, ok := failed[c.Endpoint]; ok {
+					logger.Warn().Err(err).Str("endpoint", c.Endpoint).Msg("health check failed")
+				}
+			}
+			if len(failed) > 0 && !options.HealthCheckWarn {
+				return fmt.Errorf("health check failed for %d of %d endpoints", len(failed), len(clients))
+			}
+		}
+
+		r := &report{Clients: clients, StartAt: startAt, Group: spec.Name, Self: self, SLOs: slos, Costs: costs, Headers: options.HeaderStats, Canary: cnry, Scrub: scrub}
+		r.Pusher, r.PushInterval = pusher, pushInterval
+		if options.AlertWebhook != "" {
+			r.Alerts = &alerter{URL: options.AlertWebhook, Thresholds: thresholds}
+			r.AlertInterval = alertInterval
+		}
+		if verbose || mismatches != nil || hook != nil {
+			name := spec.Name
+			r.MismatchedResponse = func(resps []Response) {
+				if verbose {
+					logger.Info().Int64("id", int64(resps[0].ID)).Str("group", name).Msgf("mismatched responses: %s", Responses(resps).Diff(options.Diff))
+				}
+				if mismatches != nil {
+					mismatches.Write(name, resps)
+				}
+				if hook != nil {
+					hook.Fire(name, resps)
+				}
+			}
+		}
+		if options.Recheck != "" {
+			rc := &rechecker{Delay: recheckDelay}
+			defer rc.Close()
+			r.Recheck = rc.Recheck
+		}
+		if options.HeadCheck != "" {
+			r.Heads = newHeadTracker(clients, headInterval, options.HeadSuppress)
+			go r.Heads.Serve(ctx)
+		}
+		if latencies != nil || results != nil {
+			name := spec.Name
+			r.ComparedResponses = func(resps []Response, mismatched bool) {
+				if latencies != nil {
+					latencies.Write(name, resps, mismatched)
+				}
+				if results != nil {
+					results.Write(name, resps, mismatched)
+				}
+			}
+		}
+
+		set := newClientSet(clients, options.Concurrency, timeout)
+		set.Configure = configure
+		set.OnJoin = func(c *Client) {
+			r.Join(ctx, c)
+			if r.Heads != nil {
+				r.Heads.Add(c)
+			}
+		}
+		set.OnLeave = func(c *Client) {
+			if r.Heads != nil {
+				r.Heads.Remove(c)
+			}
+		}
+		groups = append(groups, &group{Name: spec.Name, Tags: spec.Tags, Report: r, Set: set})
+		numClients += len(clients)
+	}
+
+	if options.Interactive {
+		if len(groups) > 1 {
+			return fmt.Errorf("interactive mode can't be combined with --groups")
+		}
+		r := &repl{
+			Clients: groups[0].Report.Clients,
+			Parser:  parser,
+			Diff:    options.Diff,
+			Colors:  newPalette(os.Stdout, options.NoColor, options.AlertErrorRate, options.AlertMismatchRate),
+			Prompt:  isTerminal(os.Stdin),
+		}
+		return r.Run(ctx, os.Stdin, os.Stdout)
+	}
+
+	input, err := openInput(ctx, options.Input)
 	if err != nil {
-		return fmt.Errorf("failed to create clients: %w", err)
+		return fmt.Errorf("failed to open input: %w", err)
+	}
+	defer input.Close()
+	if options.Bisect {
+		if len(groups) > 1 {
+			return fmt.Errorf("bisection can't be combined with --groups")
+		}
+		b := &bisector{
+			Clients: groups[0].Report.Clients,
+			Parser:  parser,
+			Diff:    options.Diff,
+			Scrub:   scrub,
+			Colors:  newPalette(os.Stdout, options.NoColor, options.AlertErrorRate, options.AlertMismatchRate),
+		}
+		if options.BisectReset != "" {
+			b.Reset = shellReset(options.BisectReset)
+		}
+		return b.Run(ctx, input, os.Stdout)
+	}
+	var source io.Reader = input
+	var digest *digestReader
+	if options.Bundle != "" || pusher != nil {
+		digest = newDigestReader(input)
+		source = digest
+	}
+	if pusher != nil {
+		pusher.Info = newRunInfo(options, specs, digest)
 	}
 
-	r := report{Clients: clients}
-	g.Go(func() error {
-		return r.Serve(ctx, responses)
-	})
-	if len(options.Verbose) > 0 {
-		r.MismatchedResponse = func(resps []Response) {
-			logger.Info().Int("id", int(resps[0].ID)).Msgf("mismatched responses: %s", Responses(resps).String())
+	var priority io.Reader
+	if options.PriorityInput != "" {
+		f, err := openPriorityInput(options.PriorityInput)
+		if err != nil {
+			return fmt.Errorf("failed to open priority input: %w", err)
 		}
+		defer f.Close()
+		priority = f
 	}
 
-	g.Go(func() error {
-		defer close(responses)
-		return clients.Serve(ctx, responses)
-	})
+	if options.Pprof != "" {
+		shutdown, err := servePprof(options.Pprof)
+		if err != nil {
+			return err
+		}
+		defer shutdown()
+	}
 
-	logger.Info().Int("clients", len(clients)).Msg("started endpoint clients, waiting for stdin")
+	feed := newFeedControl(options.Rate)
+	ids := &idGenerator{UUIDs: options.RequestIDs == "uuid"}
+	if memoryLimit > 0 {
+		self.SetGuard(&memoryGuard{Limit: uint64(memoryLimit), Feed: feed, Degrade: func() {
+			for _, gr := range groups {
+				for _, c := range gr.Set.Active() {
+					c.Stats.LimitSamples(degradedSamples)
+				}
+			}
+		}})
+	}
+	if cnry != nil {
+		cnry.OnVerdict = func(string) { feed.Finalize() }
+	}
+	if schedule != nil {
+		// Held from the first request if it's outside the windows
+		schedule.Apply(feed, time.Now())
+		go schedule.Serve(ctx, feed)
+	}
+	if options.Control != "" {
+		shutdown, err := serveControl(options.Control, groups[0].Report, feed, groups[0].Set)
+		if err != nil {
+			return err
+		}
+		defer shutdown()
+	}
+
+	if options.EndpointsFile != "" {
+		set := groups[0].Set
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for range hup {
+				endpoints, err := runEndpoints(options)
+				if err == nil {
+					err = set.Reload(endpoints)
+				}
+				if err != nil {
+					logger.Error().Err(err).Msg("failed to reload endpoints")
+				}
+			}
+		}()
+	}
+
+	for _, gr := range groups {
+		gr := gr
+		// responses is closed when the group's clients are shut down
+		responses := make(chan Response, respBuffer)
+		g.Go(func() error {
+			return gr.Report.Serve(ctx, responses)
+		})
+		g.Go(func() error {
+			defer close(responses)
+			return gr.Set.Serve(ctx, responses)
+		})
+	}
+
+	logger.Info().Int("clients", numClients).Int("groups", len(groups)).Str("input", options.Input).Msg("started endpoint clients, waiting for input")
 
 	g.Go(func() error {
-		return pump(ctx, os.Stdin, clients, stopAfter)
+		if err := waitUntil(ctx, startAt); err != nil {
+			groups.Finalize()
+			return err
+		}
+		return pump(ctx, source, priority, parser, shuffle, faults, groups, ids, stopAfter, options.Lockstep, feed)
 	})
 
 	if err := g.Wait(); err == context.Canceled {
@@ -177,32 +824,189 @@ func run(ctx context.Context, options Options) error {
 	}
 
 	// Report
-	return r.Render(os.Stdout)
+	var verdict string
+	if cnry != nil {
+		verdict = cnry.Finish()
+	}
+	reports := groups.Reports()
+	colors := newPalette(os.Stdout, options.NoColor, options.AlertErrorRate, options.AlertMismatchRate)
+	if err := renderReports(os.Stdout, reports, options.Format, colors); err != nil {
+		return err
+	}
+
+	if results != nil {
+		if dropped := results.Close(); dropped > 0 {
+			logger.Warn().Int("dropped", dropped).Msg("lines of the results stream were dropped for slow consumers")
+		}
+	}
+	if latencies != nil {
+		if err := latencies.Close(); err != nil {
+			return fmt.Errorf("failed to write latency log: %w", err)
+		}
+	}
+	if hook != nil {
+		if skipped := hook.Close(); skipped > 0 {
+			logger.Warn().Int("skipped", skipped).Msg("mismatches over the rate limit of the mismatch hook were not sent")
+		}
+	}
+	if mismatches != nil {
+		if err := mismatches.Close(); err != nil {
+			return fmt.Errorf("failed to write mismatch log: %w", err)
+		}
+	}
+	if options.Bundle != "" {
+		manifest := newBundleManifest(options, specs, seed, startedAt)
+		manifest.FinishedAt = time.Now().UTC()
+		manifest.Input = digest.Digest(options.Input)
+		files := segmentNames("mismatches.jsonl", mismatches.Segments())
+		if latencies != nil {
+			for name, path := range segmentNames("latencies.csv", latencies.Segments()) {
+				files[name] = path
+			}
+		}
+		if err := writeBundle(options.Bundle, manifest, reports, files); err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+	}
+	if options.Upload != "" {
+		prefix, err := expandUploadURI(options.Upload, time.Now())
+		if err != nil {
+			return err
+		}
+		// The run's context is done by now, uploads get their own
+		uploadCtx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+		defer cancel()
+		if err := uploadArtifacts(uploadCtx, prefix, reports, mismatches); err != nil {
+			return fmt.Errorf("failed to upload artifacts: %w", err)
+		}
+	}
+	if pusher != nil {
+		pushCtx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		defer cancel()
+		for _, r := range reports {
+			if err := pusher.Push(pushCtx, r.Summary()); err != nil {
+				return fmt.Errorf("failed to push metrics: %w", err)
+			}
+		}
+	}
+	if options.Notify != "" {
+		for _, r := range reports {
+			if err := notify(context.Background(), options.Notify, notifyMessage(r.Summary())); err != nil {
+				return fmt.Errorf("failed to post notification: %w", err)
+			}
+		}
+	}
+	switch verdict {
+	case canaryFail:
+		return errCanaryFailed
+	case canaryInconclusive:
+		return errCanaryInconclusive
+	}
+	return nil
 }
 
-// pump takes lines from a reader and pumps them into the clients
-func pump(ctx context.Context, r io.Reader, clients Clients, stopAfter int) error {
+// waitUntil blocks until the given time, unless it's zero or in the past.
+func waitUntil(ctx context.Context, t time.Time) error {
+	wait := time.Until(t)
+	if t.IsZero() || wait <= 0 {
+		return nil
+	}
+	logger.Info().Time("start", t).Dur("wait", wait).Msg("waiting for start time")
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// pump takes lines from a reader and pumps them into the clients, paced by
+// the feed control, and shuffled if there is a shuffler. Lines of the priority
+// reader, if any, are sent ahead of the others as they come, without pacing.
+// In lockstep, each request is only sent once every client answered the
+// previous one.
+func pump(ctx context.Context, r, priority io.Reader, parser *inputParser, shuffle *shuffler, faults *faultInjector, clients requestSink, ids *idGenerator, stopAfter int, lockstep bool, feed *feedControl) error {
 	defer clients.Finalize()
 
-	scanner := bufio.NewScanner(r)
-	// Some lines are really long, let's allocate a big fat megabyte for lines.
-	buf := make([]byte, 1024*1024)
-	scanner.Buffer(buf, cap(buf))
+	stop := make(chan struct{})
+	defer close(stop)
+	lines, scanErr := scanLines(r, parser.Split, stop)
+	if shuffle != nil {
+		lines = shuffle.Shuffle(lines, stop)
+	}
+	var lane *priorityLane
+	if priority != nil {
+		lane = &priorityLane{}
+		lane.lines, lane.errc = scanLines(priority, parser.Split, stop)
+	}
+	// takePriority sends a record of the priority lane right away
+	takePriority := func(line []byte, ok bool) error {
+		if !ok {
+			lane.End()
+			return nil
+		}
+		if len(line) == 0 {
+			// Only the main input ends the feed
+			return nil
+		}
+		req, err := parser.Parse(line)
+		if err != nil {
+			logger.Warn().Err(err).Msg("failed to parse priority input line")
+			return nil
+		}
+		req.Tags = withTag(req.Tags, priorityTag)
+		return sendRequest(ctx, clients, ids, req, false, lockstep)
+	}
 
 	n := 0
-	for scanner.Scan() {
+	for {
+		// Priority records go first
+		select {
+		case line, ok := <-lane.Lines():
+			if err := takePriority(line, ok); err != nil {
+				return err
+			}
+			continue
+		default:
+		}
+
+		var line []byte
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		default:
+		case <-feed.Done():
+			logger.Info().Msgf("feed finalized after %d requests", n)
+			return nil
+		case l, ok := <-lane.Lines():
+			if err := takePriority(l, ok); err != nil {
+				return err
+			}
+			continue
+		case l, ok := <-lines:
+			if !ok {
+				return <-scanErr
+			}
+			line = l
 		}
 
-		line := scanner.Bytes()
 		if len(line) == 0 { // Done
 			logger.Debug().Msg("reached end of feed")
 			return nil
 		}
-		if err := clients.Send(ctx, line); err != nil {
+		if ok, err := waitFeed(ctx, feed, lane, takePriority); err != nil {
+			return err
+		} else if !ok {
+			logger.Info().Msgf("feed finalized after %d requests", n)
+			return nil
+		}
+		req, err := parser.Parse(line)
+		if err != nil {
+			return fmt.Errorf("failed to parse input line %d: %w", n+1, err)
+		}
+		dup := faults != nil && faults.Inject(&req)
+		if err := sendRequest(ctx, clients, ids, req, dup, lockstep); err != nil {
 			return err
 		}
 		n += 1
@@ -212,9 +1016,76 @@ func pump(ctx context.Context, r io.Reader, clients Clients, stopAfter int) erro
 			return nil
 		}
 	}
+}
 
-	if err := scanner.Err(); err != nil {
+// sendRequest sends the request to the clients, and a duplicate of it if
+// dup is set, each with a new ID. In lockstep, it waits until the clients
+// answered both.
+func sendRequest(ctx context.Context, clients requestSink, ids *idGenerator, req Request, dup, lockstep bool) error {
+	if lockstep {
+		req.answered = &sync.WaitGroup{}
+	}
+	req.ID, req.UUID = ids.Next()
+	if err := clients.Send(ctx, req); err != nil {
 		return err
 	}
+	if dup {
+		req := duplicate(req)
+		req.ID, req.UUID = ids.Next()
+		if err := clients.Send(ctx, req); err != nil {
+			return err
+		}
+	}
+	if lockstep {
+		return waitAnswered(ctx, req.answered)
+	}
 	return nil
 }
+
+// waitAnswered waits until every client that a request was sent to has
+// answered it.
+func waitAnswered(ctx context.Context, answered *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		answered.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scanLines reads lines, or the records of the split function if it's set,
+// in the background until the reader ends or stop is closed, so that a
+// blocked read doesn't hold up the feed. The error channel receives the
+// result of the scan once lines is closed.
+func scanLines(r io.Reader, split bufio.SplitFunc, stop <-chan struct{}) (<-chan []byte, <-chan error) {
+	lines := make(chan []byte)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		// Some lines are really long, let's allocate a big fat megabyte for lines.
+		buf := make([]byte, 1024*1024)
+		scanner.Buffer(buf, cap(buf))
+		if split != nil {
+			scanner.Buffer(buf, maxRecordSize)
+			scanner.Split(split)
+		}
+		for scanner.Scan() {
+			// The scanner reuses its buffer, and requests outlive the scan
+			line := append([]byte(nil), scanner.Bytes()...)
+			select {
+			case lines <- line:
+			case <-stop:
+				errc <- nil
+				return
+			}
+		}
+		errc <- scanner.Err()
+	}()
+	return lines, errc
+}
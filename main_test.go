@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"100", 100},
+		{"100B", 100},
+		{"512KB", 512 << 10},
+		{"10mb", 10 << 20},
+		{"1GB", 1 << 30},
+	}
+	for _, tc := range tests {
+		got, err := parseSize(tc.s)
+		if err != nil {
+			t.Errorf("parseSize(%q): %s", tc.s, err)
+		} else if got != tc.want {
+			t.Errorf("parseSize(%q): got: %d; want: %d", tc.s, got, tc.want)
+		}
+	}
+	if _, err := parseSize("lots"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestPumpLockstep(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight += 1
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight -= 1
+		mu.Unlock()
+		w.Write([]byte(`{"result":"0x1"}`))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		lockstep bool
+		want     func(n int) bool
+	}{
+		{true, func(n int) bool { return n <= 2 }},
+		{false, func(n int) bool { return n > 2 }},
+	} {
+		maxInFlight = 0
+		clients, err := NewClients([]string{srv.URL + "/a", srv.URL + "/b"}, 4, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set := newClientSet(clients, 4, time.Second)
+		out := make(chan Response, 100)
+		done := make(chan error, 1)
+		go func() {
+			defer close(out)
+			done <- set.Serve(context.Background(), out)
+		}()
+
+		var input strings.Builder
+		for i := 0; i < 20; i++ {
+			fmt.Fprintf(&input, "{\"id\":%d}\n", i)
+		}
+		err = pump(context.Background(), strings.NewReader(input.String()), nil, &inputParser{}, nil, nil, set, &idGenerator{}, 0, tc.lockstep, newFeedControl(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for range out {
+			n += 1
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if n != 40 {
+			t.Errorf("lockstep %t: got %d responses; want: 40", tc.lockstep, n)
+		}
+		if !tc.want(maxInFlight) {
+			t.Errorf("lockstep %t: got %d requests in flight", tc.lockstep, maxInFlight)
+		}
+	}
+}
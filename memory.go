@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+	"time"
+)
+
+// degradedSamples is the number of latencies kept per endpoint for
+// percentiles once the memory limit was reached.
+const degradedSamples = 10000
+
+// memoryGuard keeps the heap of long runs under a limit, degrading
+// gracefully rather than growing until the process is killed. The first time
+// the heap is over the limit, what grows with the run is bounded, such as
+// latencies kept for percentiles, and memory is returned to the system. If
+// the heap is still over the limit, the feed is held until the endpoints
+// caught up and the heap is back under 90% of it.
+type memoryGuard struct {
+	Limit   uint64
+	Feed    *feedControl // Held while over the limit
+	Degrade func()       // Bounds the memory used by the run from now on
+
+	degraded  bool
+	holding   bool
+	heldSince time.Time
+	held      time.Duration
+}
+
+// Check degrades the run or holds the feed for the heap size at now.
+func (g *memoryGuard) Check(heap uint64, now time.Time) {
+	if g == nil || g.Limit == 0 {
+		return
+	}
+	switch {
+	case heap > g.Limit && !g.degraded:
+		g.degraded = true
+		logger.Warn().Str("heap", formatBytes(int(heap))).Str("limit", formatBytes(int(g.Limit))).Msgf("over the memory limit, keeping %d latencies per endpoint for percentiles", degradedSamples)
+		if g.Degrade != nil {
+			g.Degrade()
+		}
+		debug.FreeOSMemory()
+	case heap > g.Limit && !g.holding:
+		g.holding, g.heldSince = true, now
+		logger.Warn().Str("heap", formatBytes(int(heap))).Str("limit", formatBytes(int(g.Limit))).Msg("still over the memory limit, holding requests")
+		if g.Degrade != nil {
+			// Endpoints may have joined since
+			g.Degrade()
+		}
+		g.Feed.SetOverMemory(true)
+		debug.FreeOSMemory()
+	case g.holding && heap < g.Limit/10*9:
+		g.holding = false
+		g.held += now.Sub(g.heldSince)
+		logger.Info().Str("heap", formatBytes(int(heap))).Msg("back under the memory limit, sending requests")
+		g.Feed.SetOverMemory(false)
+	}
+}
+
+// memorySummary is the machine-readable form of the memory guard's state.
+type memorySummary struct {
+	Limit    uint64  `json:"limit"`
+	Degraded bool    `json:"degraded"`
+	Holding  bool    `json:"holding,omitempty"`
+	Held     float64 `json:"held"` // Seconds the feed was held for, in total
+}
+
+// Summary returns the state at now, or nil without a guard.
+func (g *memoryGuard) Summary(now time.Time) *memorySummary {
+	if g == nil || g.Limit == 0 {
+		return nil
+	}
+	s := &memorySummary{Limit: g.Limit, Degraded: g.degraded, Holding: g.holding, Held: g.held.Seconds()}
+	if g.holding {
+		s.Held += now.Sub(g.heldSince).Seconds()
+	}
+	return s
+}
+
+// Render writes the state as part of versus's own resource usage.
+func (s *memorySummary) Render(w io.Writer) {
+	if s == nil || !s.Degraded {
+		return
+	}
+	fmt.Fprintf(w, "               over the %s memory limit: percentiles estimated from %d latencies per endpoint", formatBytes(int(s.Limit)), degradedSamples)
+	if held := time.Duration(s.Held * float64(time.Second)); held > 0 {
+		fmt.Fprintf(w, ", requests held for %s", held.Round(time.Second))
+	}
+	fmt.Fprintf(w, "\n")
+}
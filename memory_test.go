@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryGuard(t *testing.T) {
+	feed := newFeedControl(0)
+	var degraded int
+	g := &memoryGuard{Limit: 1000, Feed: feed, Degrade: func() { degraded += 1 }}
+	start := time.Now()
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	g.Check(500, at(0))
+	if degraded != 0 || feed.OverMemory() {
+		t.Fatalf("under the limit: got %d degrades, held %t; want 0, false", degraded, feed.OverMemory())
+	}
+	// Degraded first, then held if that's not enough
+	g.Check(2000, at(1))
+	if degraded != 1 || feed.OverMemory() {
+		t.Fatalf("over the limit: got %d degrades, held %t; want 1, false", degraded, feed.OverMemory())
+	}
+	g.Check(2000, at(2))
+	if degraded != 2 || !feed.OverMemory() {
+		t.Fatalf("still over the limit: got %d degrades, held %t; want 2, true", degraded, feed.OverMemory())
+	}
+	g.Check(950, at(3))
+	if !feed.OverMemory() {
+		t.Errorf("over 90%% of the limit: got released; want held")
+	}
+	g.Check(800, at(5))
+	if feed.OverMemory() {
+		t.Errorf("under 90%% of the limit: got held; want released")
+	}
+
+	s := g.Summary(at(6))
+	if want := (memorySummary{Limit: 1000, Degraded: true, Held: 3}); *s != want {
+		t.Errorf("got %+v; want %+v", *s, want)
+	}
+	var buf bytes.Buffer
+	s.Render(&buf)
+	if want := "requests held for 3s"; !strings.Contains(buf.String(), want) {
+		t.Errorf("got %q; want it to contain %q", buf.String(), want)
+	}
+	if (*memoryGuard)(nil).Summary(at(6)) != nil {
+		t.Errorf("got a summary without a guard; want nil")
+	}
+}
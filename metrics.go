@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type metricLabel struct {
+	Name  string
+	Value string
+}
+
+// metricSample is a single value of a gauge or counter.
+type metricSample struct {
+	Name   string
+	Type   string // counter or gauge
+	Labels []metricLabel
+	Value  float64
+}
+
+// summaryMetrics converts a report summary into Prometheus samples. They are
+// grouped by name, so that each name gets a single TYPE line.
+func summaryMetrics(s reportSummary) []metricSample {
+	var samples []metricSample
+	perEndpoint := func(name, typ string, value func(e endpointSummary) float64) {
+		for _, e := range s.Endpoints {
+			samples = append(samples, metricSample{name, typ, []metricLabel{{"endpoint", redactURL(e.Endpoint)}}, value(e)})
+		}
+	}
+	perEndpoint("versus_requests_total", "counter", func(e endpointSummary) float64 { return float64(e.Requests) })
+	perEndpoint("versus_errors_total", "counter", func(e endpointSummary) float64 { return float64(e.Errors) })
+	perEndpoint("versus_cached_total", "counter", func(e endpointSummary) float64 { return float64(e.Cached) })
+	perEndpoint("versus_received_bytes_total", "counter", func(e endpointSummary) float64 { return float64(e.BytesReceived) })
+	perEndpoint("versus_requests_per_second", "gauge", func(e endpointSummary) float64 { return e.RPS })
+	perEndpoint("versus_behind_requests", "gauge", func(e endpointSummary) float64 { return float64(e.Behind) })
+	perEndpoint("versus_queued_requests", "gauge", func(e endpointSummary) float64 { return float64(e.Queued) })
+	perEndpoint("versus_blocked_seconds_total", "counter", func(e endpointSummary) float64 { return e.Blocked })
+	for _, e := range s.Endpoints {
+		for _, bucket := range reportBuckets {
+			quantile := strconv.FormatFloat(float64(bucket)/100, 'f', -1, 64)
+			samples = append(samples, metricSample{
+				"versus_request_duration_seconds", "gauge",
+				[]metricLabel{{"endpoint", redactURL(e.Endpoint)}, {"quantile", quantile}},
+				e.Timing.Percentiles[strconv.Itoa(bucket)],
+			})
+		}
+	}
+	samples = append(samples,
+		metricSample{"versus_completed_total", "counter", nil, float64(s.Completed)},
+		metricSample{"versus_mismatched_total", "counter", nil, float64(s.Mismatched)},
+		metricSample{"versus_run_time_seconds", "gauge", nil, s.RunTime},
+	)
+	if s.Self != nil {
+		samples = append(samples,
+			metricSample{"versus_self_cpu_seconds_total", "counter", nil, s.Self.CPU},
+			metricSample{"versus_self_goroutines", "gauge", nil, float64(s.Self.Goroutines)},
+			metricSample{"versus_self_heap_bytes", "gauge", nil, float64(s.Self.HeapBytes)},
+			metricSample{"versus_self_gc_pause_seconds_total", "counter", nil, s.Self.GCPause},
+		)
+	}
+	return samples
+}
+
+// writeExposition writes samples in the Prometheus text exposition format.
+func writeExposition(w io.Writer, samples []metricSample) {
+	var last string
+	for _, sample := range samples {
+		if sample.Name != last {
+			fmt.Fprintf(w, "# TYPE %s %s\n", sample.Name, sample.Type)
+			last = sample.Name
+		}
+		fmt.Fprint(w, sample.Name)
+		if len(sample.Labels) > 0 {
+			labels := make([]string, len(sample.Labels))
+			for i, l := range sample.Labels {
+				labels[i] = l.Name + "=" + strconv.Quote(l.Value)
+			}
+			fmt.Fprintf(w, "{%s}", strings.Join(labels, ","))
+		}
+		fmt.Fprintf(w, " %s\n", strconv.FormatFloat(sample.Value, 'g', -1, 64))
+	}
+}
+
+// encodeWriteRequest encodes samples as a remote-write WriteRequest protobuf
+// message, with one time series per sample.
+func encodeWriteRequest(samples []metricSample, extra []metricLabel, ts time.Time) []byte {
+	var req []byte
+	for _, sample := range samples {
+		labels := append([]metricLabel{{"__name__", sample.Name}}, sample.Labels...)
+		labels = append(labels, extra...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+		var series []byte
+		for _, l := range labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.Name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.Value)
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var s []byte
+		s = protowire.AppendTag(s, 1, protowire.Fixed64Type)
+		s = protowire.AppendFixed64(s, math.Float64bits(sample.Value))
+		s = protowire.AppendTag(s, 2, protowire.VarintType)
+		s = protowire.AppendVarint(s, uint64(ts.UnixNano()/int64(time.Millisecond)))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, s)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, series)
+	}
+	return req
+}
+
+// snappyEncode encodes src in the snappy block format, as required by
+// remote-write. It only emits literals: the payloads are small, and this
+// avoids a dependency for a compressor.
+func snappyEncode(src []byte) []byte {
+	dst := make([]byte, binary.MaxVarintLen64, len(src)+len(src)/60+binary.MaxVarintLen64+3)
+	dst = dst[:binary.PutUvarint(dst, uint64(len(src)))]
+	for len(src) > 0 {
+		n := len(src)
+		if n > 65536 {
+			n = 65536
+		}
+		switch {
+		case n <= 60:
+			dst = append(dst, byte(n-1)<<2)
+		case n <= 256:
+			dst = append(dst, 60<<2, byte(n-1))
+		default:
+			dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
+
+// runInfo is the metadata of a run, exported as info metrics whose value is
+// always 1, so that dashboards can tell which configuration and input
+// produced the measurements they show.
+type runInfo struct {
+	ConfigHash string // Of the options and groups, without secrets
+	Input      string // Source of the input, without secrets
+
+	digest *digestReader // Of the input, optional
+}
+
+// newRunInfo returns the metadata of a run with the options and groups, and
+// the digest of its input if it's read through one.
+func newRunInfo(options Options, specs []groupSpec, digest *digestReader) *runInfo {
+	config, _ := json.Marshal(struct {
+		Options Options     `json:"options"`
+		Groups  []groupSpec `json:"groups"`
+	}{redactOptions(options), redactGroups(specs)})
+	sum := sha256.Sum256(config)
+	return &runInfo{
+		ConfigHash: hex.EncodeToString(sum[:8]),
+		Input:      redactURL(options.Input),
+		digest:     digest,
+	}
+}
+
+// Metrics returns the info metrics of the run and of the endpoints of the
+// summary. The input's digest is only exported once the input was read
+// whole, so that its series doesn't change with every push.
+func (info *runInfo) Metrics(s reportSummary) []metricSample {
+	if info == nil {
+		return nil
+	}
+	samples := []metricSample{{"versus_run_info", "gauge", []metricLabel{
+		{"version", Version},
+		{"go_version", runtime.Version()},
+		{"config_hash", info.ConfigHash},
+		{"input", info.Input},
+	}, 1}}
+	for _, e := range s.Endpoints {
+		labels := []metricLabel{{"endpoint", redactURL(e.Endpoint)}}
+		if e.Name != "" {
+			labels = append(labels, metricLabel{"name", e.Name})
+		}
+		samples = append(samples, metricSample{"versus_endpoint_info", "gauge", labels, 1})
+	}
+	if info.digest != nil {
+		digest := info.digest.Digest(info.Input)
+		if info.digest.Complete() {
+			samples = append(samples, metricSample{"versus_input_info", "gauge", []metricLabel{{"input", info.Input}, {"sha256", digest.SHA256}}, 1})
+		}
+		samples = append(samples,
+			metricSample{"versus_input_read_bytes_total", "counter", nil, float64(digest.Bytes)},
+			metricSample{"versus_input_read_lines_total", "counter", nil, float64(digest.Lines)},
+		)
+	}
+	return samples
+}
+
+// metricsPusher pushes report metrics to a Pushgateway and/or a
+// remote-write endpoint.
+type metricsPusher struct {
+	Gateway     string // Pushgateway base URL
+	RemoteWrite string // Remote-write URL
+	Job         string
+	Instance    string
+	Info        *runInfo // Metadata of the run pushed alongside, optional
+}
+
+// Push sends the current metrics of the summary.
+func (p *metricsPusher) Push(ctx context.Context, s reportSummary) error {
+	samples := append(summaryMetrics(s), p.Info.Metrics(s)...)
+	if p.Gateway != "" {
+		var body bytes.Buffer
+		writeExposition(&body, samples)
+		uri := strings.TrimSuffix(p.Gateway, "/") + "/metrics/job/" + url.PathEscape(p.Job)
+		if p.Instance != "" {
+			uri += "/instance/" + url.PathEscape(p.Instance)
+		}
+		if s.Group != "" {
+			uri += "/group/" + url.PathEscape(s.Group)
+		}
+		if err := pushMetrics(ctx, http.MethodPut, uri, body.Bytes(), http.Header{
+			"Content-Type": {"text/plain; version=0.0.4"},
+		}); err != nil {
+			return fmt.Errorf("failed to push to gateway: %w", err)
+		}
+	}
+	if p.RemoteWrite != "" {
+		extra := []metricLabel{{"job", p.Job}}
+		if p.Instance != "" {
+			extra = append(extra, metricLabel{"instance", p.Instance})
+		}
+		if s.Group != "" {
+			extra = append(extra, metricLabel{"group", s.Group})
+		}
+		body := snappyEncode(encodeWriteRequest(samples, extra, time.Now()))
+		if err := pushMetrics(ctx, http.MethodPost, p.RemoteWrite, body, http.Header{
+			"Content-Type":                      {"application/x-protobuf"},
+			"Content-Encoding":                  {"snappy"},
+			"X-Prometheus-Remote-Write-Version": {"0.1.0"},
+		}); err != nil {
+			return fmt.Errorf("failed to remote-write: %w", err)
+		}
+	}
+	return nil
+}
+
+func pushMetrics(ctx context.Context, method string, uri string, body []byte, header http.Header) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("bad status code: %d", resp.StatusCode)
+	}
+	return nil
+}
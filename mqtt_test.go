@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveMQTT is an MQTT 5 broker for n clients, which replies to every
+// request with the handler's payload, published to every client subscribed to
+// the response topic. A late reply with other correlation data is sent first,
+// to check that it's skipped.
+func serveMQTT(t *testing.T, n int, handler func(payload []byte) []byte) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connects := make(chan string, n)
+	var mu sync.Mutex // Guards writes and subscriptions
+	subscriptions := make(map[net.Conn]string)
+	write := func(conn net.Conn, header byte, rest []byte) {
+		var buf bytes.Buffer
+		buf.WriteByte(header)
+		writeMQTTVarint(&buf, len(rest))
+		buf.Write(rest)
+		mu.Lock()
+		defer mu.Unlock()
+		conn.Write(buf.Bytes())
+	}
+	// publish sends to the other subscribers first, so that they have it
+	// before the publisher gets its reply
+	publish := func(from net.Conn, topic string, rest []byte) {
+		mu.Lock()
+		var conns []net.Conn
+		for conn, subscribed := range subscriptions {
+			if subscribed == topic && conn != from {
+				conns = append(conns, conn)
+			}
+		}
+		mu.Unlock()
+		for _, conn := range append(conns, from) {
+			write(conn, mqttPublish<<4, rest)
+		}
+	}
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			typ, flags, body, err := readMQTTPacket(r)
+			if err != nil {
+				return
+			}
+			switch typ {
+			case mqttConnect:
+				// Protocol name, version, flags, keep alive, properties
+				clientID, _, _ := readMQTTString(body, 11)
+				connects <- clientID
+				write(conn, mqttConnAck<<4, []byte{0, 0, 0})
+			case mqttSubscribe:
+				// Packet id, properties
+				topic, _, _ := readMQTTString(body, 3)
+				mu.Lock()
+				subscriptions[conn] = topic
+				mu.Unlock()
+				write(conn, mqttSubAck<<4, []byte{body[0], body[1], 0, 0})
+			case mqttPublish:
+				topic, msg, _, err := parseMQTTPublish(flags, body)
+				if err != nil || topic != "devices/rpc" {
+					return
+				}
+				props := body[2+len(topic):]
+				size, n, _ := readMQTTVarint(props)
+				responseTopic, _, _ := readMQTTString(props[n:size+n], 1)
+				reply := func(correlation string, payload []byte) {
+					var pub, p bytes.Buffer
+					writeMQTTString(&pub, responseTopic)
+					p.WriteByte(mqttCorrelationData)
+					writeMQTTString(&p, correlation)
+					writeMQTTVarint(&pub, p.Len())
+					pub.Write(p.Bytes())
+					pub.Write(payload)
+					publish(conn, responseTopic, pub.Bytes())
+				}
+				reply("stale", []byte(`{"stale":true}`))
+				reply(string(msg.Correlation), handler(msg.Payload))
+			case mqttDisconnect:
+				return
+			}
+		}
+	}
+	go func() {
+		defer l.Close()
+		for i := 0; i < n; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return l.Addr().String(), connects
+}
+
+func TestMQTTTransport(t *testing.T) {
+	addr, connects := serveMQTT(t, 1, func(payload []byte) []byte {
+		return bytes.ToUpper(payload)
+	})
+	tr, err := NewTransport("mqtt://user:secret@"+addr+"/devices/rpc#name=broker", transportOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.(*mqttTransport).Close()
+	if clientID := <-connects; !strings.HasPrefix(clientID, "versus-") {
+		t.Errorf("got client id: %q; want a versus- prefix", clientID)
+	}
+
+	for _, line := range []string{`{"id":1}`, `{"id":2}`} {
+		var resp Response
+		if err := tr.Send(context.Background(), &Request{Line: []byte(line)}, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(resp.Body), strings.ToUpper(line); got != want {
+			t.Errorf("got: %s; want: %s", got, want)
+		}
+	}
+}
+
+func TestMQTTTransportTimeout(t *testing.T) {
+	addr, _ := serveMQTT(t, 1, func(payload []byte) []byte {
+		time.Sleep(200 * time.Millisecond)
+		return payload
+	})
+	tr, err := NewTransport("mqtt://"+addr+"/devices/rpc", transportOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.(*mqttTransport).Close()
+	var resp Response
+	err = tr.Send(context.Background(), &Request{Line: []byte(`{}`), Timeout: 50 * time.Millisecond}, &resp)
+	if err != errTimeout {
+		t.Errorf("got: %v; want: %v", err, errTimeout)
+	}
+}
+
+func TestMQTTTransportSharedResponseTopic(t *testing.T) {
+	addr, _ := serveMQTT(t, 2, func(payload []byte) []byte {
+		return bytes.ToUpper(payload)
+	})
+	var transports []Transport
+	for i := 0; i < 2; i++ {
+		tr, err := NewTransport("mqtt://"+addr+"/devices/rpc#response-topic=versus/replies", transportOptions{Timeout: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tr.(*mqttTransport).Close()
+		transports = append(transports, tr)
+	}
+
+	// Both get every reply, and each only takes its own
+	for i, line := range []string{`{"id":"a"}`, `{"id":"b"}`} {
+		var resp Response
+		if err := transports[i].Send(context.Background(), &Request{Line: []byte(line)}, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(resp.Body), strings.ToUpper(line); got != want {
+			t.Errorf("transport %d: got: %s; want: %s", i, got, want)
+		}
+	}
+}
+
+func TestMQTTTransportOptions(t *testing.T) {
+	for _, endpoint := range []string{
+		"mqtt://127.0.0.1:1/",
+		"mqtt://127.0.0.1:1/devices/+",
+		"mqtt://127.0.0.1:1/devices/rpc#response-topic=replies/%23",
+		"mqtt://127.0.0.1:1/devices/rpc#keepalive=off",
+	} {
+		if _, err := NewTransport(endpoint, transportOptions{}); err == nil || strings.Contains(err.Error(), "connect") {
+			t.Errorf("%s: got: %v; want an invalid option error", endpoint, err)
+		}
+	}
+}
+
+func TestParseMQTTPublish(t *testing.T) {
+	var body bytes.Buffer
+	writeMQTTString(&body, "replies")
+	body.Write([]byte{0, 7}) // Packet id, at QoS 1
+	var props bytes.Buffer
+	props.WriteByte(0x01) // Payload format indicator
+	props.WriteByte(1)
+	props.WriteByte(0x26) // User property
+	writeMQTTString(&props, "k")
+	writeMQTTString(&props, "v")
+	props.WriteByte(mqttCorrelationData)
+	writeMQTTString(&props, "42")
+	writeMQTTVarint(&body, props.Len())
+	body.Write(props.Bytes())
+	body.WriteString("payload")
+
+	topic, msg, packetID, err := parseMQTTPublish(0x02, body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic != "replies" || string(msg.Correlation) != "42" || string(msg.Payload) != "payload" || packetID != 7 {
+		t.Errorf("got: %s %s %s %d; want: replies 42 payload 7", topic, msg.Correlation, msg.Payload, packetID)
+	}
+
+	if _, _, _, err := parseMQTTPublish(0x02, body.Bytes()[:12]); err == nil {
+		t.Errorf("expected an error for a truncated packet")
+	}
+}
+
+func TestMQTTTransportCloseUnread(t *testing.T) {
+	addr, _ := serveMQTT(t, 2, func(payload []byte) []byte {
+		return payload
+	})
+	var transports []*mqttTransport
+	for i := 0; i < 2; i++ {
+		tr, err := NewTransport("mqtt://"+addr+"/devices/rpc#response-topic=versus/replies", transportOptions{Timeout: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tr.(*mqttTransport).Close()
+		transports = append(transports, tr.(*mqttTransport))
+	}
+	// The other transport gets more replies than it has room for, unread
+	for i := 0; i < 2*cap(transports[1].messages); i++ {
+		var resp Response
+		if err := transports[0].Send(context.Background(), &Request{Line: []byte(`{}`)}, &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	transports[1].Close()
+	select {
+	case <-transports[1].done:
+	case <-time.After(time.Second):
+		t.Errorf("reading didn't stop after closing with unread messages")
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// normalizer canonicalizes a decoded JSON value and returns the result, so
+// that semantically equivalent responses from different endpoints compare
+// equal.
+type normalizer func(v interface{}) interface{}
+
+var builtinNormalizers = map[string]normalizer{
+	"eth-quantity": normalizeQuantities,
+	"eth-address":  normalizeAddresses,
+	"eth-logs":     normalizeLogs,
+	"eth-null":     normalizeNulls,
+}
+
+// normalizerAliases expand into several normalizers, in order.
+var normalizerAliases = map[string][]string{
+	"ethereum": {"eth-null", "eth-quantity", "eth-address", "eth-logs"},
+}
+
+// normalizers is a chain of normalizers that are applied in order.
+type normalizers []normalizer
+
+func parseNormalizers(names []string) (normalizers, error) {
+	var chain normalizers
+	for _, name := range names {
+		expanded, ok := normalizerAliases[name]
+		if !ok {
+			expanded = []string{name}
+		}
+		for _, name := range expanded {
+			n, ok := builtinNormalizers[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown normalizer: %s", name)
+			}
+			chain = append(chain, n)
+		}
+	}
+	return chain, nil
+}
+
+// Apply returns the normalized body. Bodies that aren't JSON are returned
+// unchanged.
+func (chain normalizers) Apply(body []byte) []byte {
+	if len(chain) == 0 || len(body) == 0 {
+		return body
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return body
+	}
+	for _, n := range chain {
+		v = n(v)
+	}
+	out, err := marshalJSON(v)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// walk calls fn on every value in a decoded JSON value (depth-first, children
+// first) and replaces it with the result. key is the object key of the value,
+// or empty for array elements and the root.
+func walk(v interface{}, key string, fn func(v interface{}, key string) interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for k, child := range node {
+			node[k] = walk(child, k, fn)
+		}
+	case []interface{}:
+		for i, child := range node {
+			node[i] = walk(child, "", fn)
+		}
+	}
+	return fn(v, key)
+}
+
+// quantityFields are the Ethereum JSON-RPC fields that hold hex-encoded
+// quantities, as opposed to hex-encoded data where leading zeros matter.
+var quantityFields = map[string]bool{
+	"baseFeePerGas":        true,
+	"blobGasUsed":          true,
+	"blockNumber":          true,
+	"chainId":              true,
+	"cumulativeGasUsed":    true,
+	"difficulty":           true,
+	"effectiveGasPrice":    true,
+	"excessBlobGas":        true,
+	"gas":                  true,
+	"gasLimit":             true,
+	"gasPrice":             true,
+	"gasUsed":              true,
+	"logIndex":             true,
+	"maxFeePerBlobGas":     true,
+	"maxFeePerGas":         true,
+	"maxPriorityFeePerGas": true,
+	"nonce":                true,
+	"number":               true,
+	"size":                 true,
+	"status":               true,
+	"timestamp":            true,
+	"totalDifficulty":      true,
+	"transactionIndex":     true,
+	"type":                 true,
+	"v":                    true,
+	"value":                true,
+}
+
+// maxResultQuantityDigits is the longest bare string result that is treated
+// as a quantity (e.g. eth_blockNumber, eth_getBalance). Longer results are
+// usually data, such as eth_call return values.
+const maxResultQuantityDigits = 32
+
+func isHex(s string) bool {
+	if len(s) < 3 || s[0] != '0' || (s[1] != 'x' && s[1] != 'X') {
+		return false
+	}
+	for _, c := range s[2:] {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeQuantities rewrites hex quantities without leading zeros and in
+// lowercase, so that "0x00" and "0x0" are equal.
+func normalizeQuantities(v interface{}) interface{} {
+	return walk(v, "", func(v interface{}, key string) interface{} {
+		s, ok := v.(string)
+		if !ok || !isHex(s) {
+			return v
+		}
+		if !quantityFields[key] && !(key == "result" && len(s)-2 <= maxResultQuantityDigits) {
+			return v
+		}
+		n, ok := new(big.Int).SetString(s[2:], 16)
+		if !ok {
+			return v
+		}
+		return "0x" + n.Text(16)
+	})
+}
+
+// normalizeAddresses lowercases addresses, so that EIP-55 checksummed and
+// lowercase addresses are equal.
+func normalizeAddresses(v interface{}) interface{} {
+	return walk(v, "", func(v interface{}, key string) interface{} {
+		if s, ok := v.(string); ok && len(s) == 42 && isHex(s) {
+			return strings.ToLower(s)
+		}
+		return v
+	})
+}
+
+// isLog returns true if the value looks like an Ethereum log object.
+func isLog(v interface{}) bool {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, hasTopics := obj["topics"]
+	_, hasData := obj["data"]
+	return hasTopics && hasData
+}
+
+// logOrder returns the parsed value of a hex quantity field of a log.
+func logOrder(log interface{}, field string) *big.Int {
+	n := new(big.Int)
+	if s, ok := log.(map[string]interface{})[field].(string); ok && isHex(s) {
+		n.SetString(s[2:], 16)
+	}
+	return n
+}
+
+// normalizeLogs sorts arrays of logs by block number and log index, so that
+// endpoints returning the same logs in a different order are equal.
+func normalizeLogs(v interface{}) interface{} {
+	return walk(v, "", func(v interface{}, key string) interface{} {
+		logs, ok := v.([]interface{})
+		if !ok || len(logs) < 2 {
+			return v
+		}
+		for _, log := range logs {
+			if !isLog(log) {
+				return v
+			}
+		}
+		sort.SliceStable(logs, func(i, j int) bool {
+			if c := logOrder(logs[i], "blockNumber").Cmp(logOrder(logs[j], "blockNumber")); c != 0 {
+				return c < 0
+			}
+			return logOrder(logs[i], "logIndex").Cmp(logOrder(logs[j], "logIndex")) < 0
+		})
+		return logs
+	})
+}
+
+// normalizeNulls removes object fields that are null, so that null and
+// missing optional fields are equal.
+func normalizeNulls(v interface{}) interface{} {
+	return walk(v, "", func(v interface{}, key string) interface{} {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		for k, child := range obj {
+			if child == nil {
+				delete(obj, k)
+			}
+		}
+		return obj
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestNormalizers(t *testing.T) {
+	chain, err := parseNormalizers([]string{"ethereum"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		A, B string
+	}{
+		{
+			`{"jsonrpc":"2.0","id":1,"result":"0x00"}`,
+			`{"jsonrpc":"2.0","id":1,"result":"0x0"}`,
+		},
+		{
+			`{"id":1,"result":{"from":"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed","gas":"0x05208","to":null}}`,
+			`{"id":1,"result":{"from":"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed","gas":"0x5208"}}`,
+		},
+		{
+			`{"id":1,"result":[{"blockNumber":"0x1","logIndex":"0x2","topics":[],"data":"0x"},{"blockNumber":"0x1","logIndex":"0x1","topics":[],"data":"0x"}]}`,
+			`{"id":1,"result":[{"blockNumber":"0x1","logIndex":"0x1","topics":[],"data":"0x"},{"blockNumber":"0x01","logIndex":"0x2","topics":[],"data":"0x"}]}`,
+		},
+	}
+
+	for i, tc := range tests {
+		a, b := chain.Apply([]byte(tc.A)), chain.Apply([]byte(tc.B))
+		if string(a) != string(b) {
+			t.Errorf("case %d: not normalized:\n%s\n%s", i, a, b)
+		}
+	}
+
+	// Data is not a quantity, leading zeros matter
+	a := chain.Apply([]byte(`{"result":{"input":"0x00"}}`))
+	b := chain.Apply([]byte(`{"result":{"input":"0x0"}}`))
+	if string(a) == string(b) {
+		t.Errorf("data should not be normalized: %s", a)
+	}
+
+	if _, err := parseNormalizers([]string{"nope"}); err == nil {
+		t.Errorf("expected error for unknown normalizer")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+)
+
+// notifyMessage formats the end-of-run summary for a chat message. Latency
+// deltas are relative to the first (reference) endpoint.
+func notifyMessage(s reportSummary) string {
+	var b strings.Builder
+	group := ""
+	if s.Group != "" {
+		group = fmt.Sprintf(" of %s", s.Group)
+	}
+	fmt.Fprintf(&b, "*versus* run summary%s (%s): %d endpoints, %d completed results in %0.1fs\n", group, s.Version, len(s.Endpoints), s.Completed, s.RunTime)
+	fmt.Fprintf(&b, "Mismatched: %d (%0.2f%%), errors: %d (%0.2f%%)\n", s.Mismatched, s.MismatchRate, s.Errors, s.ErrorRate)
+
+	b.WriteString("```\n")
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "endpoint\trps\terrors\tp50\tp99\tΔp50\tΔp99\n")
+	for i, e := range s.Endpoints {
+		p50, p99 := e.Timing.Percentiles["50"], e.Timing.Percentiles["99"]
+		delta50, delta99 := "-", "-"
+		if i > 0 {
+			ref := s.Endpoints[0].Timing.Percentiles
+			delta50 = fmt.Sprintf("%+0.4fs", p50-ref["50"])
+			delta99 = fmt.Sprintf("%+0.4fs", p99-ref["99"])
+		}
+		fmt.Fprintf(w, "%s\t%0.2f\t%0.2f%%\t%0.4fs\t%0.4fs\t%s\t%s\n", e.label(), e.RPS, e.ErrorRate, p50, p99, delta50, delta99)
+	}
+	w.Flush()
+	b.WriteString("```")
+	return b.String()
+}
+
+// notify posts the message to a Slack or Discord incoming webhook, depending
+// on the webhook's host.
+func notify(ctx context.Context, webhook string, message string) error {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return err
+	}
+	var payload interface{}
+	if host := u.Hostname(); host == "discord.com" || strings.HasSuffix(host, ".discord.com") || host == "discordapp.com" {
+		payload = map[string]string{"content": message}
+	} else {
+		payload = map[string]string{"text": message}
+	}
+	return postJSON(ctx, webhook, payload)
+}
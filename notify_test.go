@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNotifyMessage(t *testing.T) {
+	s := reportSummary{
+		Version:      "dev",
+		Completed:    10,
+		Mismatched:   1,
+		MismatchRate: 10,
+		Endpoints: []endpointSummary{
+			{Endpoint: "a", RPS: 100, Timing: timingSummary{Percentiles: map[string]float64{"50": 0.1, "99": 0.5}}},
+			{Endpoint: "b", RPS: 50, Timing: timingSummary{Percentiles: map[string]float64{"50": 0.2, "99": 0.25}}},
+		},
+	}
+	got := notifyMessage(s)
+	for _, want := range []string{
+		"Mismatched: 1 (10.00%)",
+		"a         100.00  0.00%   0.1000s  0.5000s  -         -",
+		"b         50.00   0.00%   0.2000s  0.2500s  +0.1000s  -0.2500s",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryMargin is how long before expiry a token gets refreshed.
+const tokenExpiryMargin = 30 * time.Second
+
+// defaultTokenTimeout bounds token fetches when there's no request timeout.
+const defaultTokenTimeout = 30 * time.Second
+
+// oauth2TokenSource fetches and caches access tokens with the OAuth2
+// client-credentials flow.
+type oauth2TokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scope        string
+	timeout      time.Duration
+
+	mu       sync.Mutex
+	token    string
+	expires  time.Time
+	fetching chan struct{} // Closed when the fetch in progress is done
+	err      error         // Of the last fetch
+}
+
+var (
+	tokenSourcesMu sync.Mutex
+	tokenSources   = map[string]*oauth2TokenSource{}
+)
+
+// newTokenSource returns the token source configured by the endpoint
+// options, or nil if the endpoint doesn't use OAuth2:
+//
+//	oauth2-token-url=https://auth.example.com/token&oauth2-client-id=ID&oauth2-client-secret-env=SECRET&oauth2-scope=read
+//
+// Token sources are shared by all the transports with the same settings, so
+// the workers of an endpoint share a token. Fetches are bounded by timeout.
+func newTokenSource(opts url.Values, timeout time.Duration) (*oauth2TokenSource, error) {
+	tokenURL := opts.Get("oauth2-token-url")
+	if tokenURL == "" {
+		return nil, nil
+	}
+	ts := &oauth2TokenSource{
+		tokenURL: tokenURL,
+		clientID: opts.Get("oauth2-client-id"),
+		scope:    opts.Get("oauth2-scope"),
+		timeout:  timeout,
+	}
+	if ts.timeout <= 0 {
+		ts.timeout = defaultTokenTimeout
+	}
+	if ts.clientID == "" {
+		return nil, fmt.Errorf("oauth2 requires oauth2-client-id")
+	}
+	if name := opts.Get("oauth2-client-secret-env"); name != "" {
+		ts.clientSecret = os.Getenv(name)
+		if ts.clientSecret == "" {
+			return nil, fmt.Errorf("oauth2 client secret is not set: %s", name)
+		}
+	}
+
+	key := strings.Join([]string{ts.tokenURL, ts.clientID, ts.clientSecret, ts.scope}, "\x00")
+	tokenSourcesMu.Lock()
+	defer tokenSourcesMu.Unlock()
+	if shared, ok := tokenSources[key]; ok {
+		return shared, nil
+	}
+	tokenSources[key] = ts
+	return ts, nil
+}
+
+// Token returns a valid access token. A missing or expired token is fetched
+// while the caller waits; one that's about to expire is still returned while
+// a new one is fetched in the background. Only one fetch runs at a time, and
+// the lock isn't held across it, so a slow token server doesn't stall the
+// workers that still have a usable token.
+func (ts *oauth2TokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	now := time.Now()
+	valid := ts.token != "" && (ts.expires.IsZero() || now.Before(ts.expires))
+	fresh := valid && (ts.expires.IsZero() || now.Add(tokenExpiryMargin).Before(ts.expires))
+	if !fresh && ts.fetching == nil {
+		ts.fetching = make(chan struct{})
+		go ts.refresh(ts.fetching)
+	}
+	if valid {
+		token := ts.token
+		ts.mu.Unlock()
+		return token, nil
+	}
+	fetching := ts.fetching
+	ts.mu.Unlock()
+
+	select {
+	case <-fetching:
+	case <-ctx.Done():
+		return "", fmt.Errorf("failed to fetch oauth2 token: %w", ctx.Err())
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.err != nil {
+		return "", ts.err
+	}
+	return ts.token, nil
+}
+
+// refresh fetches a new token and closes done once it's stored, or the error
+// is if it failed.
+func (ts *oauth2TokenSource) refresh(done chan struct{}) {
+	token, expires, err := ts.fetch()
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.fetching = nil
+	ts.err = err
+	if err == nil {
+		ts.token, ts.expires = token, expires
+	} else {
+		logger.Warn().Err(err).Str("token_url", ts.tokenURL).Msg("failed to refresh oauth2 token")
+	}
+	close(done)
+}
+
+// fetch requests a new token from the token server. It's bounded by the
+// source's timeout rather than any one request's context, since the token is
+// shared by all the workers.
+func (ts *oauth2TokenSource) fetch() (string, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if ts.scope != "" {
+		form.Set("scope", ts.scope)
+	}
+	req, err := http.NewRequest(http.MethodPost, ts.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(ts.clientID), url.QueryEscape(ts.clientSecret))
+
+	client := &http.Client{Timeout: ts.timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to fetch oauth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", time.Time{}, fmt.Errorf("failed to fetch oauth2 token: bad status code: %d", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to decode oauth2 token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("oauth2 token response has no access_token")
+	}
+
+	var expires time.Time
+	if token.ExpiresIn > 0 {
+		expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	logger.Debug().Str("token_url", ts.tokenURL).Time("expires", expires).Msg("fetched oauth2 token")
+	return token.AccessToken, expires, nil
+}
+
+// Invalidate drops the cached token if it's the given one, e.g. after it was
+// rejected, so the next request fetches a new one.
+func (ts *oauth2TokenSource) Invalidate(token string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token == token {
+		ts.token = ""
+	}
+}
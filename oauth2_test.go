@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOAuth2TokenSource(t *testing.T) {
+	var fetched int32
+	var expiresIn int32 = 3600
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&fetched, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, n, atomic.LoadInt32(&expiresIn))
+	}))
+	defer srv.Close()
+
+	ts, err := newTokenSource(url.Values{"oauth2-token-url": {srv.URL}, "oauth2-client-id": {"client"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared, _ := newTokenSource(url.Values{"oauth2-token-url": {srv.URL}, "oauth2-client-id": {"client"}}, 0); shared != ts {
+		t.Errorf("expected token sources with the same settings to be shared")
+	}
+
+	ctx := context.Background()
+	tokens := []string{}
+	for i := 0; i < 2; i++ {
+		token, err := ts.Token(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	ts.Invalidate("token-1")
+	atomic.StoreInt32(&expiresIn, 10) // Within the expiry margin, so always refreshed
+	for i := 0; i < 2; i++ {
+		token, err := ts.Token(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+		waitForRefresh(ts)
+	}
+
+	// The token about to expire is still used while it's refreshed
+	want := []string{"token-1", "token-1", "token-2", "token-2"}
+	if fmt.Sprint(tokens) != fmt.Sprint(want) {
+		t.Errorf("got: %v; want: %v", tokens, want)
+	}
+	if token, _ := ts.Token(ctx); token != "token-3" {
+		t.Errorf("got %q after the refresh; want token-3", token)
+	}
+}
+
+// waitForRefresh waits for the background fetch of ts, if any, to finish.
+func waitForRefresh(ts *oauth2TokenSource) {
+	ts.mu.Lock()
+	fetching := ts.fetching
+	ts.mu.Unlock()
+	if fetching != nil {
+		<-fetching
+	}
+}
+
+func TestOAuth2TokenSourceSingleFetch(t *testing.T) {
+	var fetched int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		<-release
+		fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+	}))
+	defer srv.Close()
+	ts, err := newTokenSource(url.Values{"oauth2-token-url": {srv.URL}, "oauth2-client-id": {"single"}}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ts.Token(context.Background())
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := atomic.LoadInt32(&fetched); got != 1 {
+		t.Errorf("got %d fetches; want 1", got)
+	}
+}
+
+func TestOAuth2TokenSourceTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer srv.Close()
+	defer close(hang)
+	ts, err := newTokenSource(url.Values{"oauth2-token-url": {srv.URL}, "oauth2-client-id": {"hang"}}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A caller's own deadline gives up first, the fetch is bounded by the timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := ts.Token(ctx); err == nil {
+		t.Errorf("got a token before the deadline; want an error")
+	}
+	started := time.Now()
+	if _, err := ts.Token(context.Background()); err == nil {
+		t.Errorf("got a token from a hanging server; want an error")
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("took %s to fail; want it bounded by the timeout", elapsed)
+	}
+}
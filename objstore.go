@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// openInput opens the source of requests: "-" for stdin, a local path, or a
+// URI with an s3://, gs://, http:// or https:// scheme. Gzipped input is
+// decompressed transparently.
+func openInput(ctx context.Context, uri string) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	switch {
+	case uri == "" || uri == "-":
+		r = os.Stdin
+	case strings.HasPrefix(uri, "s3://"), strings.HasPrefix(uri, "gs://"), strings.HasPrefix(uri, "http://"), strings.HasPrefix(uri, "https://"):
+		req, err := objectRequest(ctx, http.MethodGet, uri, nil, 0, "")
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 400 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to fetch %s: bad status code: %d", uri, resp.StatusCode)
+		}
+		r = resp.Body
+	default:
+		f, err := os.Open(strings.TrimPrefix(uri, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	return gunzipReader(r)
+}
+
+// gunzipReader decompresses the stream if it starts with the gzip magic
+// bytes, and returns it as-is otherwise.
+func gunzipReader(r io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		r.Close()
+		return nil, err
+	}
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return readCloser{br, r}, nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return readCloser{gz, r}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// objectRequest builds an authenticated request for an object URI. s3://
+// objects are signed with credentials from the AWS environment variables,
+// and gs:// objects use the GOOGLE_OAUTH_ACCESS_TOKEN environment variable
+// as a bearer token, if set. The body may be nil, otherwise size is its
+// length. S3 uploads are signed with an unsigned payload so that the body
+// can be streamed.
+func objectRequest(ctx context.Context, method string, uri string, body io.Reader, size int64, contentType string) (*http.Request, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "s3":
+		region := awsRegionFromEnv()
+		endpoint := &url.URL{
+			Scheme: "https",
+			Host:   fmt.Sprintf("%s.s3.%s.amazonaws.com", u.Host, region),
+			Path:   u.Path,
+		}
+		if custom := os.Getenv("AWS_ENDPOINT_URL_S3"); custom != "" {
+			// Path-style addressing for S3-compatible stores
+			if endpoint, err = url.Parse(custom); err != nil {
+				return nil, err
+			}
+			endpoint.Path = path.Join(endpoint.Path, u.Host, u.Path)
+		}
+		req, err := newObjectRequest(ctx, method, endpoint.String(), body, size, contentType)
+		if err != nil {
+			return nil, err
+		}
+		creds, err := awsCredentialsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		payloadHash := emptySHA256
+		if body != nil {
+			payloadHash = "UNSIGNED-PAYLOAD"
+		}
+		signV4(req, creds, region, "s3", payloadHash, time.Now())
+		return req, nil
+	case "gs":
+		endpoint := &url.URL{
+			Scheme: "https",
+			Host:   "storage.googleapis.com",
+			Path:   "/" + u.Host + u.Path,
+		}
+		req, err := newObjectRequest(ctx, method, endpoint.String(), body, size, contentType)
+		if err != nil {
+			return nil, err
+		}
+		if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req, nil
+	case "http", "https":
+		return newObjectRequest(ctx, method, uri, body, size, contentType)
+	}
+	return nil, fmt.Errorf("unsupported object storage scheme: %s", u.Scheme)
+}
+
+func newObjectRequest(ctx context.Context, method string, uri string, body io.Reader, size int64, contentType string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// maxPatterns is the number of distinct mismatch patterns that are kept.
+// Mismatches of further patterns are only counted, so that differences with
+// unbounded paths can't exhaust memory.
+const maxPatterns = 100
+
+// maxPatternPaths is the number of differing JSON paths in a pattern, beyond
+// which a difference is only described by its first paths.
+const maxPatternPaths = 8
+
+// maxExampleSize is the number of bytes kept of the request and bodies of a
+// pattern's example.
+const maxExampleSize = 512
+
+// mismatchPattern is a kind of divergence between endpoints: mismatched
+// response sets with the same differences, wherever in their arrays they are,
+// are most likely caused by the same bug.
+type mismatchPattern struct {
+	Differences []string // Per differing endpoint, such as `"b": $.result[*].gasUsed`
+	Count       int
+	Example     patternExample // The first mismatch of the pattern
+}
+
+// patternExample is a mismatch of a pattern, trimmed to be shown in reports.
+type patternExample struct {
+	Request   string            `json:"request"`
+	Responses []patternResponse `json:"responses"`
+}
+
+type patternResponse struct {
+	Endpoint string `json:"endpoint"`
+	Status   int    `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Body     string `json:"body,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// patternSummary is the machine-readable form of a mismatch pattern.
+type patternSummary struct {
+	Differences []string       `json:"differences"`
+	Count       int            `json:"count"`
+	Percent     float64        `json:"percent"` // Of mismatched response sets
+	Example     patternExample `json:"example"`
+}
+
+// countPattern records a mismatched response set in the pattern of its
+// differences from the first endpoint. It must be called before the bodies
+// are released.
+func (r *report) countPattern(resps []Response) {
+	resps = r.inClientOrder(resps)
+	var differences []string
+	for _, resp := range resps[1:] {
+		for _, diff := range responseDifferences(&resps[0], &resp) {
+			differences = append(differences, fmt.Sprintf("%q: %s", resp.client.Label(), diff))
+		}
+	}
+	fingerprint := strings.Join(differences, "\n")
+
+	r.patternSets += 1
+	if p, ok := r.patternIndex[fingerprint]; ok {
+		p.Count += 1
+		return
+	}
+	if r.patternIndex == nil {
+		r.patternIndex = map[string]*mismatchPattern{}
+	}
+	if len(r.patterns) >= maxPatterns {
+		r.otherPatterns += 1
+		return
+	}
+	p := &mismatchPattern{Differences: differences, Count: 1, Example: newPatternExample(r.Scrub.Responses(resps))}
+	r.patterns = append(r.patterns, p)
+	r.patternIndex[fingerprint] = p
+}
+
+// inClientOrder returns a copy of the response set sorted in the order of
+// the report's clients, so that the first endpoint's response is the
+// reference.
+func (r *report) inClientOrder(resps []Response) []Response {
+	order := make(map[*Client]int, len(r.Clients))
+	for i, c := range r.Clients {
+		order[c] = i
+	}
+	sorted := append([]Response(nil), resps...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return order[sorted[i].client] < order[sorted[j].client]
+	})
+	return sorted
+}
+
+// responseDifferences describes how a response differs from the reference
+// one, by its status, error, or the JSON paths of its body that differ.
+// Array indices are left out of paths, so that the same field differing in
+// any element is the same difference.
+func responseDifferences(ref, resp *Response) []string {
+	var diffs []string
+	if ref.Status != resp.Status {
+		diffs = append(diffs, fmt.Sprintf("status %d instead of %d", resp.Status, ref.Status))
+	}
+	switch {
+	case ref.Err == nil && resp.Err != nil:
+		return append(diffs, "error")
+	case ref.Err != nil && resp.Err == nil:
+		return append(diffs, "no error")
+	case ref.Err != nil && resp.Err != nil:
+		if ref.Err.Error() != resp.Err.Error() {
+			diffs = append(diffs, "different error")
+		}
+		return diffs
+	}
+	if !redirectsEqual(ref.Redirects, resp.Redirects) {
+		diffs = append(diffs, "redirects")
+	}
+	if ref.Equal(*resp) {
+		return diffs
+	}
+	if ref.Hash != "" || resp.Hash != "" || ref.Spilled != "" || resp.Spilled != "" {
+		return append(diffs, "body hash")
+	}
+	paths, ok := jsonDiffPaths(ref.Body, resp.Body)
+	if !ok || len(paths) == 0 {
+		return append(diffs, "body")
+	}
+	if len(paths) > maxPatternPaths {
+		paths = append(paths[:maxPatternPaths], "...")
+	}
+	return append(diffs, strings.Join(paths, ", "))
+}
+
+// jsonDiffPaths returns the sorted paths at which two JSON bodies differ.
+// Elements of batches are matched by their JSON-RPC id. ok is false if either
+// body is not JSON.
+func jsonDiffPaths(a, b []byte) (paths []string, ok bool) {
+	found := map[string]bool{}
+	if keys, ok := batchDiff(a, b); ok {
+		aBatch, _ := parseBatch(a)
+		bBatch, _ := parseBatch(b)
+		for _, key := range keys {
+			aEl, aOK := aBatch[key]
+			bEl, bOK := bBatch[key]
+			var aValue, bValue interface{}
+			if !aOK || !bOK || json.Unmarshal(aEl, &aValue) != nil || json.Unmarshal(bEl, &bValue) != nil {
+				found["$[*]"] = true
+				continue
+			}
+			diffJSON("$[*]", aValue, bValue, found)
+		}
+	} else {
+		var aValue, bValue interface{}
+		if json.Unmarshal(a, &aValue) != nil || json.Unmarshal(b, &bValue) != nil {
+			return nil, false
+		}
+		diffJSON("$", aValue, bValue, found)
+	}
+	for path := range found {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, true
+}
+
+func diffJSON(path string, a, b interface{}, found map[string]bool) {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			found[path] = true
+			return
+		}
+		for key, aValue := range a {
+			if bValue, ok := b[key]; ok {
+				diffJSON(path+"."+key, aValue, bValue, found)
+			} else {
+				found[path+"."+key] = true
+			}
+		}
+		for key := range b {
+			if _, ok := a[key]; !ok {
+				found[path+"."+key] = true
+			}
+		}
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			found[path] = true
+			return
+		}
+		for i := range a {
+			diffJSON(path+"[*]", a[i], b[i], found)
+		}
+	default:
+		if !reflect.DeepEqual(a, b) {
+			found[path] = true
+		}
+	}
+}
+
+func newPatternExample(resps []Response) patternExample {
+	var ex patternExample
+	if req := resps[0].Request; req != nil {
+		ex.Request = trimExample(string(req.Line))
+		if req.Path != "" {
+			ex.Request = strings.TrimSpace(req.Method + " " + req.Path + " " + ex.Request)
+		}
+	}
+	for _, resp := range resps {
+		pr := patternResponse{
+			Endpoint: resp.client.Label(),
+			Status:   resp.Status,
+			Body:     trimExample(string(resp.Body)),
+			Hash:     resp.Hash,
+		}
+		if resp.Err != nil {
+			pr.Error = resp.Err.Error()
+		}
+		ex.Responses = append(ex.Responses, pr)
+	}
+	return ex
+}
+
+func trimExample(s string) string {
+	if len(s) <= maxExampleSize {
+		return s
+	}
+	return s[:maxExampleSize] + "..."
+}
+
+// patternSummaries returns the mismatch patterns, most frequent first.
+func (r *report) patternSummaries() []patternSummary {
+	if len(r.patterns) == 0 {
+		return nil
+	}
+	patterns := append([]*mismatchPattern(nil), r.patterns...)
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return patterns[i].Count > patterns[j].Count
+	})
+	summaries := make([]patternSummary, 0, len(patterns))
+	for _, p := range patterns {
+		summaries = append(summaries, patternSummary{
+			Differences: p.Differences,
+			Count:       p.Count,
+			Percent:     float64(p.Count*100) / float64(r.patternSets),
+			Example:     p.Example,
+		})
+	}
+	return summaries
+}
+
+// renderPatterns writes the mismatch patterns, with an example of each, as
+// part of the text report.
+func renderPatterns(w io.Writer, patterns []patternSummary, sets, other int) {
+	if len(patterns) == 0 {
+		return
+	}
+	distinct := fmt.Sprintf("%d", len(patterns))
+	if other > 0 {
+		distinct = "over " + distinct
+	}
+	fmt.Fprintf(w, "\n** %d mismatched results across %s distinct patterns:\n", sets, distinct)
+	for i, p := range patterns {
+		fmt.Fprintf(w, "\n   %d. %d results (%0.2f%%):\n", i+1, p.Count, p.Percent)
+		for _, diff := range p.Differences {
+			fmt.Fprintf(w, "      %s\n", diff)
+		}
+		fmt.Fprintf(w, "      Example request: %s\n", p.Example.Request)
+		for _, resp := range p.Example.Responses {
+			fmt.Fprintf(w, "        %q:", resp.Endpoint)
+			if resp.Status != 0 {
+				fmt.Fprintf(w, " %d", resp.Status)
+			}
+			switch {
+			case resp.Error != "":
+				fmt.Fprintf(w, " error: %s", resp.Error)
+			case resp.Hash != "" && resp.Body == "":
+				fmt.Fprintf(w, " sha256:%s", resp.Hash)
+			default:
+				fmt.Fprintf(w, " %s", resp.Body)
+			}
+			fmt.Fprintf(w, "\n")
+		}
+	}
+	if other > 0 {
+		fmt.Fprintf(w, "\n   Patterns beyond the first %d were only counted, for %d results.\n", maxPatterns, other)
+	}
+}
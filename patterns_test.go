@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONDiffPaths(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		want   []string
+		wantOK bool
+	}{
+		{`{"a":1}`, `{"a":1}`, nil, true},
+		{`{"a":1,"b":2}`, `{"a":2,"c":2}`, []string{"$.a", "$.b", "$.c"}, true},
+		{`{"r":[{"x":1,"y":1},{"x":2,"y":2}]}`, `{"r":[{"x":1,"y":0},{"x":2,"y":0}]}`, []string{"$.r[*].y"}, true},
+		{`{"r":[1,2]}`, `{"r":[1]}`, []string{"$.r"}, true},
+		{`{"r":{"a":1}}`, `{"r":"a"}`, []string{"$.r"}, true},
+		{`[{"id":1,"result":"a"},{"id":2,"result":"b"}]`, `[{"id":2,"result":"b"},{"id":1,"result":"c"}]`, []string{"$[*].result"}, true},
+		{`not json`, `{}`, nil, false},
+	}
+	for _, tc := range tests {
+		got, ok := jsonDiffPaths([]byte(tc.a), []byte(tc.b))
+		if ok != tc.wantOK || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s vs %s: got: %q, %v; want: %q, %v", tc.a, tc.b, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestReportPatterns(t *testing.T) {
+	clients, err := NewClients([]string{
+		"noop://foo",
+		"noop://bar",
+	}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := report{Clients: clients}
+	r.init()
+
+	bodies := [][2]string{
+		{`{"result":{"gas":1,"n":1}}`, `{"result":{"gas":2,"n":1}}`},
+		{`{"result":{"gas":5,"n":2}}`, `{"result":{"gas":7,"n":2}}`},
+		{`{"result":[{"gas":5},{"gas":6}]}`, `{"result":[{"gas":5},{"gas":7}]}`},
+		{`{"result":"a"}`, `{"result":"a"}`},
+		{`{"result":{"gas":1,"n":3}}`, `{"result":{"gas":1}}`},
+	}
+	for i, pair := range bodies {
+		id := requestID(i)
+		req := &Request{ID: id, Line: []byte(`{"method":"eth_estimateGas"}`)}
+		// Responses arrive in any order, differences are relative to the first client
+		r.handle(Response{client: clients[1], ID: id, Request: req, Body: []byte(pair[1])})
+		r.handle(Response{client: clients[0], ID: id, Request: req, Body: []byte(pair[0])})
+	}
+
+	patterns := r.Summary().Patterns
+	var got []string
+	for _, p := range patterns {
+		got = append(got, strings.Join(p.Differences, "; "))
+	}
+	want := []string{
+		`"noop://bar": $.result.gas`,
+		`"noop://bar": $.result[*].gas`,
+		`"noop://bar": $.result.n`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got: %q; want: %q", got, want)
+	}
+	if got, want := patterns[0].Count, 2; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := patterns[0].Example.Responses[0].Body, bodies[0][0]; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+
+	var buf bytes.Buffer
+	renderPatterns(&buf, patterns, r.patternSets, r.otherPatterns)
+	if got, want := buf.String(), "4 mismatched results across 3 distinct patterns"; !strings.Contains(got, want) {
+		t.Errorf("got: %s; want it to contain: %s", got, want)
+	}
+}
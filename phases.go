@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// httpPhases are the durations of the phases of an HTTP request, which tell
+// network setup and the transfer of the payload apart from the server's think
+// time. The connection phases are zero when a connection was reused.
+type httpPhases struct {
+	DNS     time.Duration // Resolving the host
+	Connect time.Duration // Opening the TCP connection
+	TLS     time.Duration // The TLS handshake
+
+	Write     time.Duration // From getting a connection until the request was written
+	FirstByte time.Duration // Until the first byte of the final response, from the start
+	Header    time.Duration // From the first byte until the headers were read
+	Body      time.Duration // From the headers until the body was read
+}
+
+// phaseTrace times the phases of an HTTP request with httptrace. Connections
+// are dialed in the background, so the trace can be called concurrently.
+type phaseTrace struct {
+	mu      sync.Mutex
+	started time.Time
+	phases  httpPhases
+
+	dnsStart, connectStart, tlsStart time.Time
+	gotConn, firstByte, header       time.Time
+}
+
+// Start returns the context to send the request with.
+func (pt *phaseTrace) Start(ctx context.Context) context.Context {
+	pt.started = time.Now()
+	// since returns the time since start, or zero if it's unset
+	since := func(start time.Time) time.Duration {
+		if start.IsZero() {
+			return 0
+		}
+		return time.Since(start)
+	}
+	record := func(f func()) {
+		pt.mu.Lock()
+		defer pt.mu.Unlock()
+		f()
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			record(func() { pt.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			record(func() { pt.phases.DNS = since(pt.dnsStart) })
+		},
+		ConnectStart: func(_, _ string) {
+			record(func() { pt.connectStart = time.Now() })
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				record(func() { pt.phases.Connect = since(pt.connectStart) })
+			}
+		},
+		TLSHandshakeStart: func() {
+			record(func() { pt.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				record(func() { pt.phases.TLS = since(pt.tlsStart) })
+			}
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			record(func() { pt.gotConn = time.Now() })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			record(func() { pt.phases.Write = since(pt.gotConn) })
+		},
+		GotFirstResponseByte: func() {
+			// Called for each response of a redirect chain, the last one wins
+			record(func() { pt.firstByte = time.Now() })
+		},
+	})
+}
+
+// GotHeader records that the response headers were read.
+func (pt *phaseTrace) GotHeader() {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.header = time.Now()
+}
+
+// Phases returns the phases of the request, once its body was read.
+func (pt *phaseTrace) Phases() *httpPhases {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.firstByte.IsZero() || pt.header.IsZero() {
+		return nil
+	}
+	phases := pt.phases
+	phases.FirstByte = pt.firstByte.Sub(pt.started)
+	phases.Header = pt.header.Sub(pt.firstByte)
+	phases.Body = time.Since(pt.header)
+	return &phases
+}
+
+// phaseStats are the distributions of an endpoint's request phases. The
+// connection phases only include the requests that opened a connection.
+type phaseStats struct {
+	dns     histogram
+	connect histogram
+	tls     histogram
+
+	write     histogram
+	firstByte histogram
+	header    histogram
+	body      histogram
+}
+
+// Add records the phases, keeping at most limit of each for percentiles like
+// the endpoint's timing.
+func (ps *phaseStats) Add(p httpPhases, limit int) {
+	for _, phase := range []struct {
+		h        *histogram
+		d        time.Duration
+		optional bool // Only on new connections
+	}{
+		{&ps.dns, p.DNS, true},
+		{&ps.connect, p.Connect, true},
+		{&ps.tls, p.TLS, true},
+		{&ps.write, p.Write, false},
+		{&ps.firstByte, p.FirstByte, false},
+		{&ps.header, p.Header, false},
+		{&ps.body, p.Body, false},
+	} {
+		if phase.optional && phase.d == 0 {
+			continue
+		}
+		phase.h.Limit = limit
+		phase.h.Add(phase.d.Seconds())
+	}
+}
+
+// Render writes the average and median of each phase, in the order they
+// happen.
+func (ps *phaseStats) Render(w io.Writer) {
+	if ps.firstByte.Len() == 0 {
+		return
+	}
+	heading := "Phases:"
+	row := func(h *histogram, what string) {
+		fmt.Fprintf(w, "   %-11s %0.4fs avg (%0.4fs median) %s\n", heading, h.Average(), h.Percentiles(50)[0], what)
+		heading = ""
+	}
+	if n := ps.dns.Len(); n > 0 {
+		row(&ps.dns, fmt.Sprintf("resolving DNS (%d lookups)", n))
+	}
+	if n := ps.connect.Len(); n > 0 {
+		row(&ps.connect, fmt.Sprintf("connecting (%d connections)", n))
+	}
+	if n := ps.tls.Len(); n > 0 {
+		row(&ps.tls, fmt.Sprintf("in TLS handshakes (%d)", n))
+	}
+	row(&ps.write, "writing the request")
+	row(&ps.firstByte, "to first byte")
+	row(&ps.header, "reading headers")
+	row(&ps.body, "reading the body")
+}
+
+// phasesSummary is the machine-readable form of an endpoint's request
+// phases. The connection phases are only set if connections were opened.
+type phasesSummary struct {
+	DNS         *timingSummary `json:"dns,omitempty"`
+	Lookups     int            `json:"lookups,omitempty"`
+	Connect     *timingSummary `json:"connect,omitempty"`
+	Connections int            `json:"connections,omitempty"`
+	TLS         *timingSummary `json:"tls,omitempty"`
+	Handshakes  int            `json:"handshakes,omitempty"`
+
+	Write     timingSummary `json:"write"`
+	FirstByte timingSummary `json:"first_byte"`
+	Header    timingSummary `json:"header"`
+	Body      timingSummary `json:"body"`
+}
+
+// Summary returns the distributions of the phases, or nil if none were
+// recorded.
+func (ps *phaseStats) Summary() *phasesSummary {
+	if ps.firstByte.Len() == 0 {
+		return nil
+	}
+	s := &phasesSummary{
+		Lookups:     ps.dns.Len(),
+		Connections: ps.connect.Len(),
+		Handshakes:  ps.tls.Len(),
+		Write:       summarizeTiming(&ps.write),
+		FirstByte:   summarizeTiming(&ps.firstByte),
+		Header:      summarizeTiming(&ps.header),
+		Body:        summarizeTiming(&ps.body),
+	}
+	optional := func(h *histogram) *timingSummary {
+		if h.Len() == 0 {
+			return nil
+		}
+		t := summarizeTiming(h)
+		return &t
+	}
+	s.DNS, s.Connect, s.TLS = optional(&ps.dns), optional(&ps.connect), optional(&ps.tls)
+	return s
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPPhases(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Think, then send the headers and stream the body slowly
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`{"result":`))
+		w.(http.Flusher).Flush()
+		time.Sleep(30 * time.Millisecond)
+		w.Write([]byte(`"0x1"}`))
+	}))
+	defer srv.Close()
+
+	tr, err := NewTransport(srv.URL, transportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp Response
+	if err := tr.Send(context.Background(), &Request{Line: []byte(`{}`)}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	p := resp.Phases
+	if p == nil {
+		t.Fatal("got no phases")
+	}
+	if p.FirstByte < 50*time.Millisecond || p.FirstByte > 500*time.Millisecond {
+		t.Errorf("got: %s to first byte; want about 50ms", p.FirstByte)
+	}
+	if p.Body < 30*time.Millisecond || p.Body > 500*time.Millisecond {
+		t.Errorf("got: %s reading the body; want about 30ms", p.Body)
+	}
+
+	var stats clientStats
+	stats.Count(nil, resp.Elapsed)
+	stats.CountPhases(*p)
+	var buf bytes.Buffer
+	if err := stats.Render(&buf, palette{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "to first byte") {
+		t.Errorf("got:\n%s\nwant the phases", buf.String())
+	}
+	summary := stats.Summary()
+	if summary.Phases == nil || summary.Phases.FirstByte.Avg != p.FirstByte.Seconds() {
+		t.Errorf("got: %+v; want the phases", summary.Phases)
+	}
+
+	// Transports other than HTTP have no phases
+	var other Response
+	noop, _ := NewTransport("noop://", transportOptions{})
+	noop.Send(context.Background(), &Request{}, &other)
+	if other.Phases != nil {
+		t.Errorf("got phases for a transport without them")
+	}
+}
+
+func TestConnectionPhases(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	tr, err := NewTransport(strings.Replace(srv.URL, "127.0.0.1", "localhost", 1), transportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var phases []*httpPhases
+	for i := 0; i < 2; i++ {
+		var resp Response
+		if err := tr.Send(context.Background(), &Request{Line: []byte(`{}`)}, &resp); err != nil {
+			t.Fatal(err)
+		}
+		phases = append(phases, resp.Phases)
+	}
+	if p := phases[0]; p.DNS == 0 || p.Connect == 0 || p.Write == 0 {
+		t.Errorf("got: %+v; want the phases of a new connection", p)
+	}
+	if p := phases[1]; p.DNS != 0 || p.Connect != 0 || p.Write == 0 {
+		t.Errorf("got: %+v; want the phases of a reused connection", p)
+	}
+
+	var stats phaseStats
+	for _, p := range phases {
+		stats.Add(*p, 0)
+	}
+	if s := stats.Summary(); s.Connections != 1 || s.Connect == nil || s.TLS != nil || s.Write.Avg == 0 {
+		t.Errorf("got: %+v; want one connection without TLS", s)
+	}
+
+	tlsSrv := httptest.NewTLSServer(srv.Config.Handler)
+	defer tlsSrv.Close()
+	var trace phaseTrace
+	req, err := http.NewRequest(http.MethodGet, tlsSrv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp, err := tlsSrv.Client().Do(req.WithContext(trace.Start(context.Background())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace.GotHeader()
+	ioutil.ReadAll(httpResp.Body)
+	httpResp.Body.Close()
+	if p := trace.Phases(); p == nil || p.TLS == 0 {
+		t.Errorf("got: %+v; want a TLS handshake", p)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBody is the largest buffer that is returned to the pool, so that
+// a few huge responses don't stay allocated for the rest of the run.
+const maxPooledBody = 1 << 20
+
+// bodyPool reuses the buffers that response bodies are read into. A body is
+// released once its response set has been compared.
+var bodyPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// requestPool reuses the copies of requests that responses point to. A copy
+// is released along with its response, once its response set has been
+// compared.
+var requestPool = sync.Pool{
+	New: func() interface{} {
+		return new(Request)
+	},
+}
+
+// pooledRequest returns a copy of the request from the pool, to be owned by
+// the response to it.
+func pooledRequest(req Request) *Request {
+	p := requestPool.Get().(*Request)
+	*p = req
+	return p
+}
+
+// readPooledBody reads the body into a buffer from the pool. The buffer is
+// tracked by the response, and returned by release.
+func readPooledBody(r io.Reader, resp *Response) error {
+	buf := bodyPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	_, err := buf.ReadFrom(r)
+	resp.Body = buf.Bytes()
+	resp.Size = buf.Len()
+	resp.buf = buf
+	return err
+}
+
+// release returns the response's body buffer and its request, if it owns
+// them, to the pools. Neither must be used afterwards.
+func (r *Response) release() {
+	r.releaseBody()
+	if r.ownsRequest {
+		*r.Request = Request{}
+		requestPool.Put(r.Request)
+		r.Request, r.ownsRequest = nil, false
+	}
+}
+
+// releaseBody returns the response's body buffer to the pool, keeping its
+// request. The body must not be used afterwards.
+func (r *Response) releaseBody() {
+	if r.buf == nil {
+		return
+	}
+	if r.buf.Cap() <= maxPooledBody {
+		bodyPool.Put(r.buf)
+	}
+	r.buf = nil
+	r.Body = nil
+}
+
+// detach copies the body and the request out of the pools, for responses
+// that are kept beyond their comparison, such as cached ones.
+func (r Response) detach() Response {
+	if r.buf != nil {
+		r.Body = append([]byte(nil), r.Body...)
+		r.buf = nil
+	}
+	if r.ownsRequest {
+		req := *r.Request
+		r.Request, r.ownsRequest = &req, false
+	}
+	return r
+}
+
+// newSet returns an empty array for the responses of a request, reusing the
+// arrays of compared sets.
+func (r *report) newSet() []Response {
+	if n := len(r.freeSets); n > 0 {
+		set := r.freeSets[n-1]
+		r.freeSets = r.freeSets[:n-1]
+		return set
+	}
+	return nil
+}
+
+// freeSet keeps the array of a compared set for reuse. The responses must
+// have been released, and the set must not be used afterwards.
+func (r *report) freeSet(set []Response) {
+	if cap(set) == 0 {
+		return
+	}
+	for i := range set {
+		set[i] = Response{}
+	}
+	r.freeSets = append(r.freeSets, set[:0])
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestPooledBody(t *testing.T) {
+	body := []byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)
+	var resp Response
+	if err := readPooledBody(bytes.NewReader(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(resp.Body), string(body); got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+	if got, want := resp.Size, len(body); got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+
+	detached := resp.detach()
+	resp.release()
+	if resp.Body != nil || resp.buf != nil {
+		t.Errorf("released response still has a body")
+	}
+	if detached.buf != nil {
+		t.Errorf("detached response still has a pooled buffer")
+	}
+	if got, want := string(detached.Body), string(body); got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+	// Releasing again, or without a pooled buffer, is a no-op
+	resp.release()
+	detached.release()
+	if got, want := string(detached.Body), string(body); got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+}
+
+func TestPooledRequest(t *testing.T) {
+	clients, err := NewClients([]string{"noop://foo"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := Request{client: clients[0], ID: 7, Line: []byte(`{"id":1}`), Tags: []string{"canary"}}
+	resp := clients[0].do(context.Background(), benchmarkTransport{}, req)
+	if !resp.ownsRequest || resp.Request.ID != 7 || string(resp.Request.Line) != `{"id":1}` {
+		t.Fatalf("got request %+v, owned %t; want a pooled copy", resp.Request, resp.ownsRequest)
+	}
+
+	detached := resp.detach()
+	sent := resp.Request
+	resp.release()
+	if resp.Request != nil || sent.ID != 0 || sent.Tags != nil {
+		t.Errorf("released response still has its request: %+v", sent)
+	}
+	if detached.ownsRequest || detached.Request.ID != 7 {
+		t.Errorf("got detached request %+v, owned %t; want a copy", detached.Request, detached.ownsRequest)
+	}
+	// Only the pooled copy is released
+	detached.release()
+	if detached.Request.ID != 7 {
+		t.Errorf("got detached request %+v after releasing; want it kept", detached.Request)
+	}
+}
+
+var benchmarkBody = bytes.Repeat([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x0123456789abcdef"}`), 64)
+
+func BenchmarkReadBody(b *testing.B) {
+	b.Run("ReadAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			body, err := ioutil.ReadAll(bytes.NewReader(benchmarkBody))
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = Response{Body: body, Size: len(body)}
+		}
+	})
+	b.Run("Pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var resp Response
+			if err := readPooledBody(bytes.NewReader(benchmarkBody), &resp); err != nil {
+				b.Fatal(err)
+			}
+			resp.release()
+		}
+	})
+}
+
+func BenchmarkCompareResponses(b *testing.B) {
+	clients, err := NewClients([]string{
+		"noop://foo",
+		"noop://bar",
+	}, 1, 5*time.Second)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := report{Clients: clients}
+	r.init()
+
+	defer func(l zerolog.Logger) { logger = l }(logger)
+	logger = logger.Level(zerolog.InfoLevel)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, c := range clients {
+			resp := Response{client: c, ID: requestID(i)}
+			if err := readPooledBody(bytes.NewReader(benchmarkBody), &resp); err != nil {
+				b.Fatal(err)
+			}
+			r.handle(resp)
+		}
+	}
+}
+
+// benchmarkTransport answers every request with benchmarkBody.
+type benchmarkTransport struct{}
+
+func (benchmarkTransport) Send(ctx context.Context, req *Request, resp *Response) error {
+	return readPooledBody(bytes.NewReader(benchmarkBody), resp)
+}
+
+func BenchmarkDoCompare(b *testing.B) {
+	clients, err := NewClients([]string{
+		"noop://foo",
+		"noop://bar",
+	}, 1, 5*time.Second)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := report{Clients: clients}
+	r.init()
+
+	defer func(l zerolog.Logger) { logger = l }(logger)
+	logger = logger.Level(zerolog.InfoLevel)
+
+	line := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req := Request{ID: requestID(i), Line: line, Peers: len(clients)}
+		for _, c := range clients {
+			req.client = c
+			r.handle(c.do(context.Background(), benchmarkTransport{}, req))
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// priorityTag tags the requests of the priority input.
+const priorityTag = "lane=priority"
+
+// openPriorityInput opens the priority input. A named pipe is opened for
+// reading and writing, so that opening it doesn't wait for a writer, and it
+// stays open as writers come and go, such as each `echo ... > fifo`.
+func openPriorityInput(path string) (*os.File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeNamedPipe != 0 {
+		return os.OpenFile(path, os.O_RDWR, 0)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("priority input is not a file or named pipe: %s", path)
+	}
+	return os.Open(path)
+}
+
+// priorityLane is the priority input of the feed, whose requests are taken
+// ahead of the main input's as they come.
+type priorityLane struct {
+	lines <-chan []byte // Nil once the input ended
+	errc  <-chan error
+}
+
+// Lines returns the records of the priority input, or nil if there is none
+// or it ended, which never receives.
+func (l *priorityLane) Lines() <-chan []byte {
+	if l == nil {
+		return nil
+	}
+	return l.lines
+}
+
+// End logs the end of the priority input. The main input carries on.
+func (l *priorityLane) End() {
+	if err := <-l.errc; err != nil {
+		logger.Warn().Err(err).Msg("failed to read priority input")
+	} else {
+		logger.Info().Msg("reached end of priority input")
+	}
+	l.lines = nil
+}
+
+// waitFeed waits until the feed lets the next request be sent, like
+// feedControl.Wait, while taking the records of the priority lane meanwhile,
+// so they don't wait for the pace or a pause of the feed.
+func waitFeed(ctx context.Context, feed *feedControl, lane *priorityLane, take func(line []byte, ok bool) error) (bool, error) {
+	if lane.Lines() == nil {
+		return feed.Wait(ctx)
+	}
+	type result struct {
+		ok  bool
+		err error
+	}
+	ready := make(chan result, 1)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ok, err := feed.Wait(ctx)
+		ready <- result{ok, err}
+	}()
+	for {
+		select {
+		case res := <-ready:
+			return res.ok, res.err
+		case line, ok := <-lane.Lines():
+			if err := take(line, ok); err != nil {
+				return false, err
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sinkFunc is a requestSink that calls itself with each request.
+type sinkFunc func(req Request)
+
+func (f sinkFunc) Send(ctx context.Context, req Request) error {
+	f(req)
+	return nil
+}
+
+func (f sinkFunc) Finalize() {}
+
+func TestPumpPriority(t *testing.T) {
+	input, inputW := io.Pipe()
+	priority, priorityW := io.Pipe()
+	sent := make(chan Request, 10)
+	sink := sinkFunc(func(req Request) { sent <- req })
+
+	// The feed is paused, which priority requests go around
+	feed := newFeedControl(0)
+	feed.Pause()
+	done := make(chan error, 1)
+	go func() {
+		done <- pump(context.Background(), input, priority, &inputParser{}, nil, nil, sink, &idGenerator{}, 0, false, feed)
+	}()
+
+	inputW.Write([]byte("{\"id\":1}\n"))
+	priorityW.Write([]byte("{\"id\":2}\n\n"))
+	select {
+	case req := <-sent:
+		if string(req.Line) != `{"id":2}` || !reflect.DeepEqual(req.Tags, []string{priorityTag}) {
+			t.Errorf("got: %s %q; want the priority request", req.Line, req.Tags)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("priority request wasn't sent while the feed was paused")
+	}
+
+	// The end of the priority input doesn't end the feed
+	priorityW.Close()
+	feed.Resume()
+	select {
+	case req := <-sent:
+		if string(req.Line) != `{"id":1}` || len(req.Tags) != 0 {
+			t.Errorf("got: %s %q; want the main request", req.Line, req.Tags)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("main request wasn't sent once the feed resumed")
+	}
+	inputW.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 0 {
+		t.Errorf("got %d more requests; want none", len(sent))
+	}
+}
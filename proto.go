@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protoCodec decodes binary protobuf response bodies into canonical JSON, so
+// that they can be compared field by field.
+type protoCodec struct {
+	message protoreflect.MessageType
+}
+
+// loadProtoCodec loads a FileDescriptorSet, as produced by
+// `protoc --include_imports --descriptor_set_out=FILE`, and looks up the
+// message type that responses are decoded as.
+func loadProtoCodec(descriptorsPath string, messageName string) (*protoCodec, error) {
+	raw, err := ioutil.ReadFile(descriptorsPath)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load descriptor set: %w", err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find message %q: %w", messageName, err)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("not a message: %s", messageName)
+	}
+	return &protoCodec{message: dynamicpb.NewMessageType(md)}, nil
+}
+
+// Decode returns the canonical JSON form of a binary protobuf message.
+func (c *protoCodec) Decode(body []byte) ([]byte, error) {
+	msg := c.message.New().Interface()
+	if err := proto.Unmarshal(body, msg); err != nil {
+		return nil, fmt.Errorf("failed to decode protobuf: %w", err)
+	}
+	out, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	// protojson output is deliberately unstable, compact it into a
+	// canonical form.
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, out); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
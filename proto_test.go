@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestProtoCodec(t *testing.T) {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("User"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("user_id"),
+				JsonName: proto.String("userId"),
+				Number:   proto.Int32(1),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}},
+		}},
+	}
+	raw, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "versus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.pb")
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	codec, err := loadProtoCodec(path, "test.User")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Field 1, length-delimited, "abc"
+	body := []byte{0x0a, 0x03, 'a', 'b', 'c'}
+
+	decoded, err := codec.Decode(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(decoded), `{"user_id":"abc"}`; got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+
+	if _, err := codec.Decode([]byte{0xff}); err == nil {
+		t.Errorf("expected error decoding garbage")
+	}
+	if _, err := loadProtoCodec(path, "test.Nope"); err == nil {
+		t.Errorf("expected error for unknown message")
+	}
+}
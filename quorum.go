@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// quorumVerdict groups the responses of three or more endpoints by equality,
+// and returns the indexes of the responses that disagree with the majority,
+// the group of more than half of them. It returns false if no group is a
+// majority.
+func quorumVerdict(resps []Response) ([]int, bool) {
+	group := make([]int, len(resps)) // Index of the first response of each response's group
+	sizes := make(map[int]int)
+	for i := range resps {
+		group[i] = i
+		for j := 0; j < i; j++ {
+			if group[j] == j && resps[j].Equal(resps[i]) {
+				group[i] = j
+				break
+			}
+		}
+		sizes[group[i]] += 1
+	}
+	majority := -1
+	for first, size := range sizes {
+		if size*2 > len(resps) {
+			majority = first
+		}
+	}
+	if majority < 0 {
+		return nil, false
+	}
+	var outliers []int
+	for i := range resps {
+		if group[i] != majority {
+			outliers = append(outliers, i)
+		}
+	}
+	return outliers, true
+}
+
+// countQuorum records which endpoints disagreed with the majority of a
+// mismatched response set of three or more endpoints, and marks their
+// responses as outliers, so that a mismatch points at the endpoint that's
+// wrong rather than at every endpoint.
+func (r *report) countQuorum(others []Response, resp *Response) {
+	set := append(others[:len(others):len(others)], *resp)
+	if len(set) < 3 {
+		return
+	}
+	outliers, ok := quorumVerdict(set)
+	if !ok {
+		r.noQuorum += 1
+		return
+	}
+	r.quorum += 1
+	if r.outliers == nil {
+		r.outliers = make(map[*Client]int)
+	}
+	for _, i := range outliers {
+		r.outliers[set[i].client] += 1
+		if i < len(others) {
+			others[i].Outlier = true
+		} else {
+			resp.Outlier = true
+		}
+	}
+}
+
+// renderQuorum writes how often each endpoint disagreed with the majority of
+// the endpoints, with three or more of them.
+func renderQuorum(w io.Writer, colors palette, endpoints []endpointSummary, quorum, noQuorum int) {
+	if len(endpoints) < 3 || quorum+noQuorum == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s\n", colors.Heading(fmt.Sprintf("** Quorum of %d endpoints, over %d mismatched results:", len(endpoints), quorum+noQuorum)))
+	for i, e := range endpoints {
+		if e.Outlier == 0 {
+			fmt.Fprintf(w, "   %d. %q: agreed with the majority\n", i, e.label())
+			continue
+		}
+		fmt.Fprintf(w, "   %d. %q: outlier in %d results (%0.2f%%)\n", i, e.label(), e.Outlier, float64(e.Outlier*100)/float64(quorum+noQuorum))
+	}
+	if noQuorum > 0 {
+		fmt.Fprintf(w, "   No quorum:  %d results without a majority\n", noQuorum)
+	}
+}
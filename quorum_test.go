@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuorumVerdict(t *testing.T) {
+	body := func(s string) Response {
+		return Response{Body: []byte(s)}
+	}
+	tests := []struct {
+		name     string
+		resps    []Response
+		outliers []int
+		ok       bool
+	}{
+		{name: "agreed", resps: []Response{body(`1`), body(`1`), body(`1`)}, ok: true},
+		{name: "one off", resps: []Response{body(`1`), body(`1`), body(`2`), body(`1`)}, outliers: []int{2}, ok: true},
+		{name: "first off", resps: []Response{body(`2`), body(`1`), body(`1`)}, outliers: []int{0}, ok: true},
+		{name: "error", resps: []Response{body(`1`), {Err: errors.New("bad status code: 502")}, body(`1`)}, outliers: []int{1}, ok: true},
+		{name: "tie", resps: []Response{body(`1`), body(`1`), body(`2`), body(`2`)}, ok: false},
+		{name: "all different", resps: []Response{body(`1`), body(`2`), body(`3`)}, ok: false},
+	}
+	for _, tc := range tests {
+		outliers, ok := quorumVerdict(tc.resps)
+		if ok != tc.ok || !reflect.DeepEqual(outliers, tc.outliers) {
+			t.Errorf("%s: got %v, %t; want %v, %t", tc.name, outliers, ok, tc.outliers, tc.ok)
+		}
+	}
+}
+
+func TestReportQuorum(t *testing.T) {
+	clients, err := NewClients([]string{"noop://a", "noop://b", "noop://c", "noop://d"}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mismatches [][]Response
+	r := report{Clients: clients, MismatchedResponse: func(resps []Response) {
+		mismatches = append(mismatches, resps)
+	}}
+	r.init()
+	sets := [][]string{
+		{`1`, `1`, `2`, `1`},
+		{`1`, `1`, `3`, `1`},
+		{`1`, `2`, `1`, `2`}, // No majority
+		{`1`, `1`, `1`, `1`},
+	}
+	for i, bodies := range sets {
+		id := requestID(i + 1)
+		req := &Request{ID: id, Line: []byte(`{}`)}
+		for j, b := range bodies {
+			r.handle(Response{client: clients[j], ID: id, Request: req, Body: []byte(b)})
+		}
+	}
+
+	s := r.Summary()
+	if s.Quorum != 2 || s.NoQuorum != 1 {
+		t.Errorf("got quorum %d, no quorum %d; want 2, 1", s.Quorum, s.NoQuorum)
+	}
+	for i, want := range []int{0, 0, 2, 0} {
+		if got := s.Endpoints[i].Outlier; got != want {
+			t.Errorf("endpoint %d: got %d outliers; want %d", i, got, want)
+		}
+	}
+	for _, resp := range mismatches[0] {
+		if want := resp.client == clients[2]; resp.Outlier != want {
+			t.Errorf("%s: got outlier %t; want %t", resp.client.Endpoint, resp.Outlier, want)
+		}
+	}
+	for _, reply := range newMismatchRecord("", mismatches[0]).Responses {
+		if want := reply.Endpoint == "noop://c"; reply.Outlier != want {
+			t.Errorf("got mismatch log reply %+v; want outlier %t", reply, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := r.Render(&buf, palette{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"** Quorum of 4 endpoints, over 3 mismatched results:",
+		`0. "noop://a": agreed with the majority`,
+		`2. "noop://c": outlier in 2 results (66.67%)`,
+		"No quorum:  1 results without a majority",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got:\n%s\nwant it to contain %q", buf.String(), want)
+		}
+	}
+}
+
+func TestReportMismatchOncePerSet(t *testing.T) {
+	clients, err := NewClients([]string{"noop://a", "noop://b", "noop://c", "noop://d"}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mismatches [][]Response
+	r := report{Clients: clients, MismatchedResponse: func(resps []Response) {
+		mismatches = append(mismatches, resps)
+	}}
+	r.init()
+	req := &Request{ID: 1, Line: []byte(`{}`)}
+	// The outlier arrives last, and differs from every other response
+	for _, j := range []int{2, 0, 1, 3} {
+		body := `1`
+		if j == 3 {
+			body = `2`
+		}
+		r.handle(Response{client: clients[j], ID: 1, Request: req, Body: []byte(body)})
+	}
+
+	if len(mismatches) != 1 {
+		t.Fatalf("got %d mismatched sets; want 1", len(mismatches))
+	}
+	var endpoints []string
+	for _, resp := range mismatches[0] {
+		endpoints = append(endpoints, resp.client.Endpoint)
+	}
+	if want := []string{"noop://a", "noop://b", "noop://c", "noop://d"}; !reflect.DeepEqual(endpoints, want) {
+		t.Errorf("got responses of %q; want %q", endpoints, want)
+	}
+	s := r.Summary()
+	if s.Mismatched != 1 || s.MismatchRate != 100 || s.Quorum != 1 || s.Endpoints[3].Outlier != 1 {
+		t.Errorf("got %d mismatched (%0.2f%%), quorum %d, outliers of d %d; want 1 (100%%), 1, 1", s.Mismatched, s.MismatchRate, s.Quorum, s.Endpoints[3].Outlier)
+	}
+}
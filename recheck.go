@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// rechecker sends the requests of mismatched response sets again after a
+// delay, to tell transient divergence, such as a node lagging a block behind,
+// from lasting divergence. Rechecks have their own connections and aren't
+// counted in the endpoints' stats.
+type rechecker struct {
+	Delay time.Duration
+
+	mu         sync.Mutex // Rechecks are sent one set at a time
+	transports map[*Client]Transport
+}
+
+// Recheck sends each response's request again to the endpoint that answered
+// it, with the same bytes as the first time, and returns the new responses in
+// the same order.
+func (rc *rechecker) Recheck(ctx context.Context, resps []Response) []Response {
+	sleep(ctx, rc.Delay)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rechecked := make([]Response, len(resps))
+	var wg sync.WaitGroup
+	for i, resp := range resps {
+		req := *resp.Request
+		req.Delay, req.Abandon = 0, 0
+		if req.original != nil {
+			// Rewritten again to the same ids
+			req.Line, req.original = req.original, nil
+		}
+		t, err := rc.transport(req.client)
+		if err != nil {
+			rechecked[i] = Response{client: req.client, Request: &req, ID: req.ID, Err: err}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, req Request, t Transport) {
+			defer wg.Done()
+			rechecked[i] = req.client.exchange(ctx, t, req, false)
+		}(i, req, t)
+	}
+	wg.Wait()
+	return rechecked
+}
+
+// transport returns the recheck connection to the client's endpoint, dialed
+// again if it broke.
+func (rc *rechecker) transport(c *Client) (Transport, error) {
+	if t, ok := rc.transports[c]; ok {
+		t = c.redial(t)
+		rc.transports[c] = t
+		return t, nil
+	}
+	t, err := c.transport()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transport for %s: %w", c.Endpoint, err)
+	}
+	if rc.transports == nil {
+		rc.transports = map[*Client]Transport{}
+	}
+	rc.transports[c] = t
+	return t, nil
+}
+
+// Close closes the connections of the rechecks.
+func (rc *rechecker) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, t := range rc.transports {
+		if closer, ok := t.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	rc.transports = nil
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReportRecheck(t *testing.T) {
+	// Endpoint b lags behind until asked again, except on request 3
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/b" && (!seen[string(body)] || string(body) == `{"id":3}`) {
+			seen[string(body)] = true
+			w.Write([]byte(`{"result":"0x1"}`))
+			return
+		}
+		w.Write([]byte(`{"result":"0x2"}`))
+	}))
+	defer srv.Close()
+
+	clients, err := NewClients([]string{srv.URL + "/a", srv.URL + "/b"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transports, closeTransports, err := clients.Transports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeTransports()
+	rc := &rechecker{Delay: time.Millisecond}
+	defer rc.Close()
+	rechecked := 0
+	r := &report{Clients: clients}
+	r.Recheck = func(ctx context.Context, resps []Response) []Response {
+		mu.Lock()
+		rechecked += 1
+		mu.Unlock()
+		return rc.Recheck(ctx, resps)
+	}
+
+	respCh := make(chan Response, 10)
+	for id := 1; id <= 4; id++ {
+		req := Request{ID: requestID(id), Line: []byte(fmt.Sprintf(`{"id":%d}`, id))}
+		for _, resp := range clients.Do(context.Background(), transports, req) {
+			respCh <- resp
+		}
+	}
+	close(respCh)
+	if err := r.Serve(context.Background(), respCh); err != nil {
+		t.Fatal(err)
+	}
+
+	if rechecked != 4 {
+		t.Errorf("got %d rechecks; want 4", rechecked)
+	}
+	if r.completed != 4 || r.mismatched != 1 || r.transient != 3 {
+		t.Errorf("got: %d completed, %d mismatched, %d transient; want: 4, 1, 3", r.completed, r.mismatched, r.transient)
+	}
+	if got := r.Summary(); got.Transient != 3 || got.Pending != 0 {
+		t.Errorf("got summary: %+v; want 3 transient and none pending", got)
+	}
+}
+
+func TestRecheckSameRequest(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		sent = append(sent, string(body))
+		mu.Unlock()
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	clients, err := NewClients([]string{srv.URL}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients[0].RewriteID = true
+	transports, closeTransports, err := clients.Transports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeTransports()
+	rc := &rechecker{}
+	defer rc.Close()
+
+	req := Request{ID: 42, Line: []byte(`{"jsonrpc":"2.0","id":"a","method":"eth_blockNumber"}`)}
+	resps := clients.Do(context.Background(), transports, req)
+	received := clients[0].Stats.Summary().BytesReceived
+	rechecked := rc.Recheck(context.Background(), resps)
+	if got := clients[0].Stats.Summary().BytesReceived; got != received {
+		t.Errorf("got %d bytes received after the recheck; want %d, rechecks aren't counted", got, received)
+	}
+
+	if len(sent) != 2 || sent[0] != sent[1] {
+		t.Errorf("got sent %q; want the same request twice", sent)
+	}
+	if got, want := string(rechecked[0].Body), string(resps[0].Body); got != want {
+		t.Errorf("got rechecked %s; want %s", got, want)
+	}
+}
+
+func TestReportRecheckTimings(t *testing.T) {
+	clients, err := NewClients([]string{"noop://a", "noop://b"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	r := &report{Clients: clients}
+	r.Recheck = func(ctx context.Context, resps []Response) []Response {
+		<-release
+		rechecked := make([]Response, len(resps))
+		for i, resp := range resps {
+			rechecked[i] = Response{client: resp.client, Request: resp.Request, ID: resp.ID, Body: []byte(`1`), Elapsed: time.Hour}
+		}
+		return rechecked
+	}
+	var timings []time.Duration
+	r.ComparedResponses = func(resps []Response, mismatched bool) {
+		for _, resp := range resps {
+			timings = append(timings, resp.Elapsed)
+		}
+	}
+
+	respCh := make(chan Response)
+	served := make(chan error)
+	go func() { served <- r.Serve(context.Background(), respCh) }()
+	for id := 1; id <= maxRechecks+1; id++ {
+		req := &Request{ID: requestID(id)}
+		respCh <- Response{client: clients[0], Request: req, ID: req.ID, Body: []byte(`1`), Elapsed: time.Millisecond}
+		respCh <- Response{client: clients[1], Request: req, ID: req.ID, Body: []byte(`2`), Elapsed: time.Millisecond}
+	}
+	// Sets beyond the limit aren't held for a recheck
+	s, err := r.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Unchecked != 1 || s.Mismatched != 1 {
+		t.Errorf("got %d unchecked, %d mismatched; want 1, 1", s.Unchecked, s.Mismatched)
+	}
+	close(release)
+	close(respCh)
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+
+	if r.transient != maxRechecks {
+		t.Errorf("got %d transient; want %d", r.transient, maxRechecks)
+	}
+	if len(timings) != 2*(maxRechecks+1) {
+		t.Fatalf("got %d compared responses; want %d", len(timings), 2*(maxRechecks+1))
+	}
+	for _, elapsed := range timings {
+		if elapsed != time.Millisecond {
+			t.Fatalf("got a compared response timed %s; want the first response's %s", elapsed, time.Millisecond)
+		}
+	}
+}
+
+func TestRecheckReconnect(t *testing.T) {
+	var pings int32
+	server := serveWebsocket(t, 1, &pings)
+	defer server.Close()
+
+	clients, err := NewClients([]string{"ws" + strings.TrimPrefix(server.URL, "http")}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &rechecker{}
+	defer rc.Close()
+	for i := 1; i <= 3; i++ {
+		req := &Request{client: clients[0], ID: requestID(i), Line: []byte(`{"id":1}`)}
+		rechecked := rc.Recheck(context.Background(), []Response{{client: clients[0], Request: req, ID: req.ID}})
+		if resp := rechecked[0]; resp.Err != nil || string(resp.Body) != `{"id":1}` {
+			t.Errorf("recheck %d: got %s, %v; want the echo", i, resp.Body, resp.Err)
+		}
+		// Until the rechecker notices that the endpoint closed the connection
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := clients[0].Stats.Summary().Reconnects; got != 0 {
+		t.Errorf("got %d reconnects counted; want 0, rechecks aren't counted", got)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// redirectPolicy returns the redirect check of an HTTP endpoint's redirects
+// option, or nil for the default of following up to 10:
+//
+//	redirects=N     follow up to N redirects, and fail the request beyond
+//	redirects=0     fail on any redirect
+//	redirects=keep  don't follow, the 3xx response is the result
+func redirectPolicy(opt string) (func(*http.Request, []*http.Request) error, error) {
+	switch opt {
+	case "":
+		return nil, nil
+	case "keep":
+		return func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}, nil
+	}
+	max, err := strconv.Atoi(opt)
+	if err != nil || max < 0 {
+		return nil, fmt.Errorf("invalid redirects: %s", opt)
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return fmt.Errorf("stopped after %d redirects", max)
+		}
+		return nil
+	}, nil
+}
+
+// redirectChain returns the redirects that led to the response, as their
+// status and target, such as "301 /v2/users". A kept 3xx response is the
+// last redirect of the chain.
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		if location, err := resp.Location(); err == nil {
+			chain = append(chain, fmt.Sprintf("%d %s", resp.StatusCode, redirectTarget(resp.Request.URL, location)))
+		}
+	}
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		chain = append(chain, fmt.Sprintf("%d %s", req.Response.StatusCode, redirectTarget(req.Response.Request.URL, req.URL)))
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// redirectTarget returns where a redirect from a URL goes: its path if it
+// stays on the same host, so that chains of endpoints on different hosts
+// can be compared, or the whole URL otherwise.
+func redirectTarget(from, to *url.URL) string {
+	if to.Scheme == from.Scheme && to.Host == from.Host {
+		return to.RequestURI()
+	}
+	return to.String()
+}
+
+// redirectsEqual compares the redirect chains of two responses.
+func redirectsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
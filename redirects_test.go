@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/older", http.StatusMovedPermanently))
+	mux.Handle("/older", http.RedirectHandler("/new?v=2", http.StatusFound))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		fragment   string
+		wantStatus int
+		wantChain  []string
+		wantErr    bool
+	}{
+		{"", 200, []string{"301 /older", "302 /new?v=2"}, false},
+		{"redirects=2", 200, []string{"301 /older", "302 /new?v=2"}, false},
+		{"redirects=1", 0, nil, true},
+		{"redirects=0", 0, nil, true},
+		{"redirects=keep", 301, []string{"301 /older"}, false},
+	}
+	for _, tc := range tests {
+		tr, err := NewTransport(srv.URL+"/#"+tc.fragment, transportOptions{Redirects: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp Response
+		err = tr.Send(context.Background(), &Request{Method: "GET", Path: "/old"}, &resp)
+		if got := err != nil; got != tc.wantErr {
+			t.Errorf("%s: got error: %v; want error: %v", tc.fragment, err, tc.wantErr)
+			continue
+		}
+		if resp.Status != tc.wantStatus || !reflect.DeepEqual(resp.Redirects, tc.wantChain) {
+			t.Errorf("%s: got: %d %q; want: %d %q", tc.fragment, resp.Status, resp.Redirects, tc.wantStatus, tc.wantChain)
+		}
+	}
+
+	for _, endpoint := range []string{"http://localhost/#redirects=-1", "http://localhost/#redirects=all"} {
+		if _, err := NewTransport(endpoint, transportOptions{}); err == nil {
+			t.Errorf("%s: expected an error", endpoint)
+		}
+	}
+}
+
+func TestRedirectsEqual(t *testing.T) {
+	a := Response{Body: []byte(`{}`), Redirects: []string{"301 /new"}}
+	b := Response{Body: []byte(`{}`)}
+	if a.Equal(b) {
+		t.Errorf("expected responses with different redirects to mismatch")
+	}
+	b.Redirects = []string{"301 /new"}
+	if !a.Equal(b) {
+		t.Errorf("expected responses with the same redirects to match")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// repl sends requests typed interactively to every endpoint right away, and
+// shows their latencies and how they differ, to probe a divergence by hand.
+type repl struct {
+	Clients Clients
+	Parser  *inputParser
+	Diff    string // Format of body diffs
+	Colors  palette
+	Prompt  bool // Show a prompt, when the input is a terminal
+
+	transports []Transport
+}
+
+// Run reads requests from in until it ends or the context is done, writing
+// the results of each to out.
+func (r *repl) Run(ctx context.Context, in io.Reader, out io.Writer) error {
+	transports, closeTransports, err := r.Clients.Transports()
+	if err != nil {
+		return err
+	}
+	defer closeTransports()
+	r.transports = transports
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; ; n++ {
+		if r.Prompt {
+			fmt.Fprint(out, "> ")
+		}
+		if !scanner.Scan() {
+			if r.Prompt {
+				fmt.Fprintln(out)
+			}
+			return scanner.Err()
+		}
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			n--
+			continue
+		}
+		req, err := r.Parser.Parse(append([]byte(nil), line...))
+		if err != nil {
+			fmt.Fprintf(out, "%s\n", r.Colors.Warning(fmt.Sprintf("invalid request: %s", err)))
+			continue
+		}
+		req.ID = requestID(n)
+		resps := r.Clients.Do(ctx, r.transports, req)
+		if ctx.Err() != nil {
+			return nil
+		}
+		r.render(out, resps)
+		releaseResponses(resps...)
+	}
+}
+
+// render writes a line per endpoint with its status and latency, then how
+// each response differs from the first endpoint's.
+func (r *repl) render(out io.Writer, resps []Response) {
+	ref := &resps[0]
+	rows := make([][]tableCell, 0, len(resps))
+	var mismatched []int
+	for i := range resps {
+		resp := &resps[i]
+		result := tableCell{Text: "ok", Color: ansiGreen}
+		switch {
+		case resp.Err != nil:
+			result = tableCell{Text: "error", Color: ansiRed}
+		case resp.Body == nil && resp.Hash == "":
+			result = tableCell{Text: "empty"}
+		}
+		if i > 0 && !ref.Equal(*resp) {
+			mismatched = append(mismatched, i)
+			if resp.Err == nil {
+				result = tableCell{Text: "mismatch", Color: ansiRed}
+			}
+		}
+		status := ""
+		if resp.Status != 0 {
+			status = fmt.Sprintf("%d", resp.Status)
+		}
+		rows = append(rows, []tableCell{
+			{Text: fmt.Sprintf("%d.", i)},
+			{Text: r.Clients[i].Label()},
+			{Text: status},
+			{Text: fmt.Sprintf("%0.4fs", resp.Elapsed.Seconds())},
+			{Text: formatBytes(len(resp.Body))},
+			result,
+		})
+	}
+	renderTable(out, r.Colors, "", rows)
+	for i, resp := range resps {
+		if resp.Err != nil {
+			fmt.Fprintf(out, "%d. %s: %s\n", i, r.Clients[i].Label(), r.Colors.Warning(resp.Err.Error()))
+		}
+	}
+
+	for _, i := range mismatched {
+		resp := &resps[i]
+		if resp.Err != nil {
+			continue // Shown above
+		}
+		fmt.Fprintf(out, "\n%s\n", r.Colors.Heading(fmt.Sprintf("%d. %s: %s", i, r.Clients[i].Label(), strings.Join(responseDifferences(ref, resp), "; "))))
+		if ref.Err == nil && ref.Hash == "" && resp.Hash == "" && !bytes.Equal(ref.Body, resp.Body) {
+			diff := bodyDiff(r.Diff, r.Clients[0].Label(), r.Clients[i].Label(), ref.Body, resp.Body)
+			fmt.Fprint(out, strings.TrimRight(diff, "\n")+"\n")
+		}
+	}
+	fmt.Fprintln(out)
+}
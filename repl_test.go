@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestREPL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/b" && bytes.Contains(body, []byte("balance")) {
+			w.Write([]byte(`{"result":"0x2"}`))
+			return
+		}
+		w.Write([]byte(`{"result":"0x1"}`))
+	}))
+	defer srv.Close()
+
+	clients, err := NewClients([]string{srv.URL + "/a#name=a", srv.URL + "/b#name=b"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &repl{Clients: clients, Parser: &inputParser{}, Diff: "unified"}
+	in := strings.NewReader("{\"method\":\"version\"}\n\n{\"method\":\"balance\"}\n")
+	var out bytes.Buffer
+	if err := r.Run(context.Background(), in, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	// One result per request, the blank line is skipped
+	results := strings.Split(strings.TrimSpace(out.String()), "\n\n")
+	if len(results) != 3 {
+		t.Fatalf("got %d sections; want two results and a diff:\n%s", len(results), out.String())
+	}
+	if !strings.Contains(results[0], "ok") || strings.Contains(results[0], "mismatch") {
+		t.Errorf("got: %s; want matching responses", results[0])
+	}
+	if !strings.Contains(results[1], "mismatch") {
+		t.Errorf("got: %s; want a mismatch", results[1])
+	}
+	for _, want := range []string{"1. b: $.result", `-  "result": "0x1"`, `+  "result": "0x2"`} {
+		if !strings.Contains(results[2], want) {
+			t.Errorf("got: %s; want: %s", results[2], want)
+		}
+	}
+}
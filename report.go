@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"sync"
 	"time"
 )
@@ -12,42 +13,188 @@ import (
 type report struct {
 	Clients Clients
 
+	// Group is the name of the endpoint group, if the run has several
+	Group string
+
+	// StartAt is when requests start being sent, if it was scheduled
+	StartAt time.Time
+
 	// MismatchedResponse is called when a response set does not match across clients
 	MismatchedResponse func([]Response)
 
+	// Recheck sends the requests of a mismatched response set again and
+	// returns the new responses, if set. The set only counts as mismatched if
+	// they mismatch too.
+	Recheck func(ctx context.Context, resps []Response) []Response
+
+	// ComparedResponses is called with every compared response set, in the
+	// order of the clients
+	ComparedResponses func(resps []Response, mismatched bool)
+
+	// Alerts are checked every AlertInterval while serving, if set
+	Alerts        *alerter
+	AlertInterval time.Duration
+
+	// Metrics are pushed every PushInterval while serving, if set
+	Pusher       *metricsPusher
+	PushInterval time.Duration
+
+	// Self records versus's own resource usage, if set
+	Self *selfMonitor
+
+	// SLOs are the latency objectives that endpoints are checked against
+	SLOs []latencySLO
+
+	// Costs prices the requests sent to each endpoint, if set
+	Costs *costTable
+
+	// Heads tracks the chain heads of the endpoints, if set
+	Heads *headTracker
+
+	// Headers are the response headers whose values are counted per endpoint
+	Headers []string
+
+	// Canary tests the candidate endpoints against the reference, if set
+	Canary *canary
+
+	// Scrub redacts the requests and responses of mismatches before they're
+	// handed to MismatchedResponse or kept for the report, if set
+	Scrub *scrubber
+
 	skipCompare      bool
 	once             sync.Once
 	pendingResponses map[requestID][]Response
+	snapshots        chan chan reportSummary
+	joins            chan *Client
+	tags             map[string]*tagStats
+	droppedTags      int // Number of times a tag was ignored, over maxTags
+	patterns         []*mismatchPattern
+	patternIndex     map[string]*mismatchPattern // By fingerprint
+	patternSets      int                         // Number of mismatched response sets
+	otherPatterns    int                         // Number of mismatched response sets over maxPatterns
+	sloStats         []map[*Client]*sloStats     // By objective, created on first use
+	costs            map[*Client]*costStats      // Created on first use
+	headerCounts     []map[*Client]*headerCounts // By header, created on first use
+	outliers         map[*Client]int             // Mismatched sets that disagreed with the majority, created on first use
+	freeSets         [][]Response                // Arrays of compared sets, for reuse
+	toRecheck        [][]Response                // Mismatched sets to recheck, sent by Serve
+	rechecked        chan recheckedSet
+	rechecking       int           // Number of rechecks in flight
+	done             chan struct{} // Closed when Serve returns
 
 	requests   int // Number of requests
 	errors     int // Number of errors
 	mismatched int // Number of mismatched responses
+	transient  int // Number of response sets that only mismatched until rechecked
+	unchecked  int // Number of mismatched response sets not rechecked, with too many rechecks in flight
+	lagging    int // Number of mismatched response sets while endpoints were at different heights
+	quorum     int // Number of mismatched response sets of three or more endpoints with a majority
+	noQuorum   int // Number of mismatched response sets of three or more endpoints without a majority
 	completed  int // Number of completed responses across clients
 	overloaded int // Number of times reporting channel was overloaded
+	cached     int // Number of responses served from a cache
+	skipped    int // Number of response sets not compared because of their size
+	dropped    int // Number of response sets abandoned by fault injection
+	shed       int // Number of response sets not compared because a request was shed
 
 	started time.Time     // Time when the report serving started
 	elapsed time.Duration // Total duration of requests
 }
 
-func (r *report) Render(w io.Writer) error {
-	fmt.Fprintf(w, "Endpoints:\n")
+// Render writes the report as text, colored by the palette.
+func (r *report) Render(w io.Writer, colors palette) error {
+	fmt.Fprintf(w, "%s\n", colors.Heading("Endpoints:"))
 	for i, c := range r.Clients {
-		fmt.Fprintf(w, "\n%d. %q\n", i, c.Endpoint)
-		if err := c.Stats.Render(w); err != nil {
+		fmt.Fprintf(w, "\n%d. %q\n", i, c.Label())
+		if c.Name != "" {
+			fmt.Fprintf(w, "   Endpoint:   %s\n", redactURL(c.Endpoint))
+		}
+		if !c.Joined.IsZero() {
+			fmt.Fprintf(w, "   Joined:     %s into the run\n", c.Joined.Sub(r.started).Round(time.Second))
+		}
+		if blocked := c.Blocked(); blocked >= time.Millisecond {
+			fmt.Fprintf(w, "   Blocked:    %s holding back the input with a full queue\n", blocked.Round(time.Millisecond))
+		}
+		if shed := c.Shed(); shed > 0 {
+			fmt.Fprintf(w, "   Shed:       %d requests dropped with a full queue\n", shed)
+		}
+		if err := c.Stats.Render(w, colors); err != nil {
 			return err
 		}
 	}
 
-	fmt.Fprintf(w, "\n** Summary for %d endpoints:\n", len(r.Clients))
+	if len(r.Clients) > 1 {
+		endpoints := make([]endpointSummary, 0, len(r.Clients))
+		for _, c := range r.Clients {
+			e := c.Stats.Summary()
+			e.Endpoint, e.Name = redactURL(c.Endpoint), c.Name
+			endpoints = append(endpoints, e)
+		}
+		renderComparison(w, colors, endpoints)
+	}
+
+	fmt.Fprintf(w, "\n%s\n", colors.Heading(fmt.Sprintf("** Summary for %d endpoints:", len(r.Clients))))
 	fmt.Fprintf(w, "   Completed:  %d results with %d total requests\n", r.completed, r.requests)
 	if r.requests > 0 {
+		errorRate := float64(r.errors*100) / float64(r.requests)
 		fmt.Fprintf(w, "   Timing:     %s request avg, %s total run time\n", r.elapsed/time.Duration(r.requests), time.Now().Sub(r.started))
-		fmt.Fprintf(w, "   Errors:     %d (%0.2f%%)\n", r.errors, float64(r.errors*100)/float64(r.requests))
+		fmt.Fprintf(w, "   Errors:     %s\n", colors.Errors(fmt.Sprintf("%d (%0.2f%%)", r.errors, errorRate), errorRate))
+	}
+	var mismatchRate float64
+	if r.completed > 0 {
+		mismatchRate = float64(r.mismatched*100) / float64(r.completed)
+	}
+	fmt.Fprintf(w, "   Mismatched: %s\n", colors.Mismatches(fmt.Sprintf("%d", r.mismatched), mismatchRate))
+	if r.transient > 0 {
+		fmt.Fprintf(w, "   Transient:  %d mismatched results that matched when rechecked\n", r.transient)
+	}
+	if r.unchecked > 0 {
+		fmt.Fprintf(w, "   Unchecked:  %d mismatched results not rechecked, too many rechecks were in flight\n", r.unchecked)
+	}
+	if r.cached > 0 {
+		fmt.Fprintf(w, "   Cached:     %d responses served from cache\n", r.cached)
+	}
+	if r.skipped > 0 {
+		fmt.Fprintf(w, "   Skipped:    %d results over the body size limit\n", r.skipped)
+	}
+	if r.dropped > 0 {
+		fmt.Fprintf(w, "   Dropped:    %d requests abandoned by fault injection\n", r.dropped)
+	}
+	if r.shed > 0 {
+		fmt.Fprintf(w, "   Shed:       %d results not compared, an endpoint's queue was full\n", r.shed)
 	}
-	fmt.Fprintf(w, "   Mismatched: %d\n", r.mismatched)
+	var saturated bool
+	if r.Self != nil {
+		self := r.Self.Summary()
+		self.Render(w)
+		saturated = self.Saturated
+	}
+	renderTags(w, r.tagSummaries(), r.droppedTags)
+	renderSLOs(w, colors, r.sloSummaries())
+	renderHeaders(w, r.headerSummaries())
+	if r.Costs != nil || r.Heads != nil {
+		endpoints := make([]endpointSummary, 0, len(r.Clients))
+		for _, c := range r.Clients {
+			endpoints = append(endpoints, endpointSummary{Endpoint: redactURL(c.Endpoint), Name: c.Name, Cost: r.costSummary(c), Head: r.Heads.Summary(c)})
+		}
+		renderCosts(w, colors, endpoints)
+		renderHeads(w, colors, endpoints, r.lagging, r.Heads != nil && r.Heads.Suppress)
+	}
+	if len(r.Clients) >= 3 {
+		endpoints := make([]endpointSummary, 0, len(r.Clients))
+		for _, c := range r.Clients {
+			endpoints = append(endpoints, endpointSummary{Endpoint: redactURL(c.Endpoint), Name: c.Name, Outlier: r.outliers[c]})
+		}
+		renderQuorum(w, colors, endpoints, r.quorum, r.noQuorum)
+	}
+	renderCanary(w, colors, r.Canary.Summary())
+	renderPatterns(w, r.patternSummaries(), r.patternSets, r.otherPatterns)
 
+	if saturated {
+		fmt.Fprintf(w, "%s\n", colors.Warning("** versus used most of its CPU at times, latencies may be inflated by the load generator rather than the endpoints."))
+	}
 	if r.overloaded > 0 {
-		fmt.Fprintf(w, "** Reporting consumer was overloaded %d times. Please open an issue.\n", r.overloaded)
+		fmt.Fprintf(w, "%s\n", colors.Warning(fmt.Sprintf("** Reporting consumer was overloaded %d times. Please open an issue.", r.overloaded)))
 	}
 
 	if len(r.pendingResponses) != 0 {
@@ -70,43 +217,226 @@ func (r *report) count(err error, elapsed time.Duration) {
 }
 
 func (r *report) compareResponses(resp Response) {
-	// Are we waiting for more responses?
-	if len(r.pendingResponses[resp.ID]) < len(r.Clients)-1 {
-		r.pendingResponses[resp.ID] = append(r.pendingResponses[resp.ID], resp)
+	// Are we waiting for more responses? Requests are sent to the clients
+	// that were active at the time, which can be fewer than all of them.
+	peers := len(r.Clients)
+	if resp.Request != nil && resp.Request.Peers > 0 {
+		peers = resp.Request.Peers
+	}
+	if pending, ok := r.pendingResponses[resp.ID]; len(pending) < peers-1 {
+		if !ok {
+			pending = r.newSet()
+		}
+		r.pendingResponses[resp.ID] = append(pending, resp)
 		return
 	}
 
 	// All set, let's compare
 	otherResponses := r.pendingResponses[resp.ID]
-	delete(r.pendingResponses, resp.ID) // TODO: Reuse these arrays
+	delete(r.pendingResponses, resp.ID)
+	defer r.freeSet(otherResponses)
+	if resp.Abandoned {
+		// Every endpoint abandoned the request, there's nothing to compare
+		r.dropped += 1
+		removeSpilled(resp)
+		removeSpilled(otherResponses...)
+		releaseResponses(resp)
+		releaseResponses(otherResponses...)
+		return
+	}
 	r.completed += 1
 
-	durations := make([]time.Duration, 0, len(r.Clients))
-	durations = append(durations, resp.Elapsed)
+	if resp.Shed || anyShed(otherResponses) {
+		// A request that wasn't sent has nothing to compare
+		r.shed += 1
+		r.completeTags(resp.Request, false, true)
+		removeSpilled(resp)
+		removeSpilled(otherResponses...)
+		releaseResponses(resp)
+		releaseResponses(otherResponses...)
+		return
+	}
+	if resp.Skipped || anySkipped(otherResponses) {
+		r.skipped += 1
+		r.completeTags(resp.Request, false, true)
+		removeSpilled(resp)
+		removeSpilled(otherResponses...)
+		releaseResponses(resp)
+		releaseResponses(otherResponses...)
+		return
+	}
 
+	if r.Recheck != nil && anyMismatched(append(otherResponses[:len(otherResponses):len(otherResponses)], resp)) {
+		if r.rechecking+len(r.toRecheck) >= maxRechecks {
+			// Compared as it is rather than holding more sets
+			r.unchecked += 1
+			r.compareSet(otherResponses, resp)
+			return
+		}
+		// Bodies of the first responses aren't needed, the recheck decides.
+		// Their requests and timings are, until they're compared.
+		set := r.inClientOrder(append(otherResponses, resp))
+		removeSpilled(set...)
+		for i := range set {
+			set[i].releaseBody()
+		}
+		r.toRecheck = append(r.toRecheck, set)
+		return
+	}
+	r.compareSet(otherResponses, resp)
+}
+
+// maxRechecks is the number of mismatched sets that can wait for a recheck
+// at once. Sets beyond it are compared without one.
+const maxRechecks = 64
+
+// recheckedSet is a mismatched set of responses and the responses when its
+// requests were sent again, in the same order.
+type recheckedSet struct {
+	first     []Response
+	rechecked []Response
+}
+
+// compareRechecked compares the responses of a rechecked set, only counting
+// a mismatch if it persists. The first responses are the ones that are timed.
+func (r *report) compareRechecked(s recheckedSet) {
+	if !anyMismatched(s.rechecked) {
+		r.transient += 1
+	}
+	last := len(s.rechecked) - 1
+	r.compareTimed(s.rechecked[:last:last], s.rechecked[last], s.first)
+	releaseResponses(s.first...)
+}
+
+// compareSet compares a complete set of responses, resp and otherResponses,
+// and reports mismatches.
+func (r *report) compareSet(otherResponses []Response, resp Response) {
+	r.compareTimed(otherResponses, resp, nil)
+}
+
+// compareTimed is compareSet with the responses to report the timings of
+// instead, in client order, if they aren't the compared ones.
+func (r *report) compareTimed(otherResponses []Response, resp Response, timed []Response) {
+	suppressed := false
+	if r.Heads != nil && anyMismatched(append(otherResponses, resp)) && r.Heads.Lagging(append(otherResponses, resp)) {
+		r.lagging += 1
+		suppressed = r.Heads.Suppress
+	}
+	mismatched := false
 	for _, other := range otherResponses {
-		durations = append(durations, other.Elapsed)
-
-		if !other.Equal(resp) {
-			// Mismatch found, report the whole response set
-			r.mismatched += 1
-			if r.MismatchedResponse != nil {
-				otherResponses = append(otherResponses, resp)
-				r.MismatchedResponse(otherResponses)
+		if !suppressed && !other.Equal(resp) {
+			mismatched = true
+			break
+		}
+	}
+	all := otherResponses[:len(otherResponses):len(otherResponses)]
+	if mismatched {
+		// Mismatch found, report the whole response set once
+		r.mismatched += 1
+		r.countQuorum(otherResponses, &resp)
+		if r.MismatchedResponse != nil {
+			r.MismatchedResponse(r.Scrub.Responses(r.inClientOrder(append(all, resp))))
+		}
+	}
+
+	r.completeTags(resp.Request, mismatched, false)
+	if mismatched {
+		r.countPattern(append(all, resp))
+	}
+	if r.ComparedResponses != nil || r.Canary != nil {
+		ordered := r.inClientOrder(append(all, resp))
+		if timed != nil {
+			for i := range timed {
+				timed[i].Outlier = ordered[i].Outlier
 			}
+			ordered = timed
+		}
+		if r.ComparedResponses != nil {
+			r.ComparedResponses(ordered, mismatched)
+		}
+		if r.Canary != nil {
+			r.Canary.Observe(ordered)
 		}
 	}
 
+	if !mismatched || r.Scrub != nil {
+		// Spilled bodies are only kept for inspecting mismatches, unless
+		// they're scrubbed
+		removeSpilled(resp)
+		removeSpilled(otherResponses...)
+	}
+
 	// TODO: Check for JSONRPC error objects?
 
-	l := logger.Debug().Int("id", int(resp.ID)).Int("mismatched", r.mismatched).Durs("ms", durations).Err(resp.Err)
-	// For super-debugging:
-	// l = l.Bytes("req", resp.Request.Line).Bytes("resp", resp.Body)
-	l.Msg("result")
+	if l := logger.Debug(); l.Enabled() {
+		durations := make([]time.Duration, 0, len(otherResponses)+1)
+		durations = append(durations, resp.Elapsed)
+		for _, other := range otherResponses {
+			durations = append(durations, other.Elapsed)
+		}
+		l = l.Int64("id", int64(resp.ID)).Int("mismatched", r.mismatched).Durs("ms", durations).Err(r.Scrub.Error(resp.Err))
+		// For super-debugging:
+		// l = l.Bytes("req", resp.Request.Line).Bytes("resp", resp.Body)
+		l.Msg("result")
+	}
+
+	// Bodies aren't needed after comparing
+	releaseResponses(resp)
+	releaseResponses(otherResponses...)
+}
+
+// releaseResponses returns the body buffers of the responses to the pool.
+// Each response must only be released once.
+func releaseResponses(resps ...Response) {
+	for _, resp := range resps {
+		resp.release()
+	}
+}
+
+// removeSpilled removes the files of spilled response bodies.
+func removeSpilled(resps ...Response) {
+	for _, resp := range resps {
+		if resp.Spilled != "" {
+			os.Remove(resp.Spilled)
+		}
+	}
+}
+
+func anyShed(resps []Response) bool {
+	for _, resp := range resps {
+		if resp.Shed {
+			return true
+		}
+	}
+	return false
+}
+
+func anySkipped(resps []Response) bool {
+	for _, resp := range resps {
+		if resp.Skipped {
+			return true
+		}
+	}
+	return false
 }
 
 func (r *report) handle(resp Response) error {
-	r.count(resp.Err, resp.Elapsed)
+	switch {
+	case resp.Abandoned:
+		// Dropped by fault injection, counted once the set is complete
+	case resp.Shed:
+		// Not sent, counted once the set is complete
+	case resp.Cached:
+		r.cached += 1
+	default:
+		r.count(resp.Err, resp.Elapsed)
+		r.countSLOs(resp)
+		r.countCost(resp)
+		r.countHeaders(resp)
+	}
+	if !resp.Abandoned && !resp.Shed {
+		r.countTags(resp)
+	}
 	if r.skipCompare {
 		return nil
 	}
@@ -118,24 +448,114 @@ func (r *report) handle(resp Response) error {
 func (r *report) init() {
 	r.once.Do(func() {
 		r.pendingResponses = make(map[requestID][]Response)
+		r.snapshots = make(chan chan reportSummary)
+		r.joins = make(chan *Client)
+		r.rechecked = make(chan recheckedSet)
+		r.done = make(chan struct{})
 	})
 }
 
+// Join adds a client that joined mid-run to the report.
+func (r *report) Join(ctx context.Context, c *Client) {
+	r.init()
+	select {
+	case r.joins <- c:
+	case <-r.done:
+	case <-ctx.Done():
+	}
+}
+
+// Snapshot returns the current summary from any goroutine.
+func (r *report) Snapshot(ctx context.Context) (reportSummary, error) {
+	r.init()
+	reply := make(chan reportSummary, 1)
+	select {
+	case r.snapshots <- reply:
+		return <-reply, nil
+	case <-r.done:
+		return r.Summary(), nil
+	case <-ctx.Done():
+		return reportSummary{}, ctx.Err()
+	}
+}
+
 func (r *report) Serve(ctx context.Context, respCh <-chan Response) error {
 	r.init()
+	defer close(r.done)
 
 	r.started = time.Now()
+	if r.StartAt.After(r.started) {
+		r.started = r.StartAt
+	}
+
+	var alertTick <-chan time.Time
+	if r.Alerts != nil && r.AlertInterval > 0 {
+		ticker := time.NewTicker(r.AlertInterval)
+		defer ticker.Stop()
+		alertTick = ticker.C
+	}
+	var pushTick <-chan time.Time
+	if r.Pusher != nil && r.PushInterval > 0 {
+		ticker := time.NewTicker(r.PushInterval)
+		defer ticker.Stop()
+		pushTick = ticker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case <-alertTick:
+			for _, al := range r.Alerts.Check(r.Summary()) {
+				go r.Alerts.Fire(ctx, al)
+			}
+		case c := <-r.joins:
+			r.Clients = append(r.Clients, c)
+		case reply := <-r.snapshots:
+			reply <- r.Summary()
+		case <-pushTick:
+			go func(s reportSummary) {
+				if err := r.Pusher.Push(ctx, s); err != nil {
+					logger.Error().Err(err).Msg("failed to push metrics")
+				}
+			}(r.Summary())
+		case s := <-r.rechecked:
+			r.rechecking -= 1
+			r.compareRechecked(s)
+			if respCh == nil && r.rechecking == 0 {
+				return nil
+			}
 		case resp, ok := <-respCh:
 			if !ok {
-				return nil
+				if r.rechecking == 0 {
+					return nil
+				}
+				// Wait for the rechecks in flight
+				respCh = nil
+				continue
 			}
 			if err := r.handle(resp); err != nil {
 				return err
 			}
+			r.startRechecks(ctx)
 		}
 	}
 }
+
+// startRechecks sends the mismatched sets waiting for a recheck in the
+// background, each coming back to Serve once rechecked.
+func (r *report) startRechecks(ctx context.Context) {
+	for _, resps := range r.toRecheck {
+		r.rechecking += 1
+		go func(resps []Response) {
+			rechecked := r.Recheck(ctx, resps)
+			select {
+			case r.rechecked <- recheckedSet{first: resps, rechecked: rechecked}:
+			case <-r.done:
+				releaseResponses(resps...)
+				releaseResponses(rechecked...)
+			}
+		}(resps)
+	}
+	r.toRecheck = r.toRecheck[:0]
+}
@@ -68,3 +68,58 @@ func TestReport(t *testing.T) {
 		t.Errorf("got: %d; want: %d", got, want)
 	}
 }
+
+func TestReportTags(t *testing.T) {
+	clients, err := NewClients([]string{
+		"noop://foo",
+		"noop://bar",
+	}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := report{Clients: clients}
+	r.init()
+
+	acme := &Request{ID: 1, Tags: []string{"tenant=acme"}}
+	both := &Request{ID: 2, Tags: []string{"canary", "tenant=acme"}}
+	r.handle(Response{client: clients[0], ID: 1, Request: acme, Body: []byte("a")})
+	r.handle(Response{client: clients[1], ID: 1, Request: acme, Body: []byte("b")})
+	r.handle(Response{client: clients[0], ID: 2, Request: both, Elapsed: time.Second})
+	r.handle(Response{client: clients[1], ID: 2, Request: both, Elapsed: 2 * time.Second})
+	r.handle(Response{client: clients[0], ID: 3})
+	r.handle(Response{client: clients[1], ID: 3})
+
+	tags := r.tagSummaries()
+	if got, want := len(tags), 2; got != want {
+		t.Fatalf("got: %d tags; want: %d", got, want)
+	}
+	canary, tenant := tags[0], tags[1]
+	if got, want := canary.Tag, "canary"; got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+	if got, want := canary.Completed, 1; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := canary.Mismatched, 0; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := canary.Endpoints[1].Timing.Avg, 2.0; got != want {
+		t.Errorf("got: %f; want: %f", got, want)
+	}
+	if got, want := tenant.Completed, 2; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := tenant.Mismatched, 1; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := tenant.MismatchRate, 50.0; got != want {
+		t.Errorf("got: %f; want: %f", got, want)
+	}
+	if got, want := tenant.Endpoints[0].Requests, 2; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if got, want := r.completed, 3; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+}
@@ -1,30 +1,100 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type requestID int
+// requestID numbers the requests of a run from 1, and is 64 bits wide on
+// every platform so that long runs don't wrap around.
+type requestID int64
+
+// idGenerator hands out the IDs of the requests of a run. It's safe for
+// concurrent use, and each run, or each library user, has its own.
+type idGenerator struct {
+	UUIDs bool // Whether requests also get a random UUID
+
+	last int64
+}
+
+// Next returns a new request ID, and a UUID if the generator makes them.
+func (g *idGenerator) Next() (requestID, string) {
+	id := requestID(atomic.AddInt64(&g.last, 1))
+	if !g.UUIDs {
+		return id, ""
+	}
+	return id, newUUID()
+}
+
+// newUUID returns a random (version 4) UUID, or nothing if there's no
+// randomness to make it from.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
 
 type Request struct {
 	client *Client
 
 	ID        requestID
+	UUID      string // Random ID shared by the copies of the request, with --request-ids=uuid
 	Line      []byte
 	Timestamp time.Time
+	Peers     int           // Number of clients the request was sent to
+	Tags      []string      // Sorted tags, such as "tenant=acme", from the input
+	Method    string        // HTTP method from the input, optional
+	Path      string        // Path from the input, relative to the endpoint, optional
+	Timeout   time.Duration // Timeout from the input, overrides the client's if set
+
+	Delay   time.Duration // Injected wait before sending
+	Abandon time.Duration // Injected drop: give up on the request after this long
+
+	answered *sync.WaitGroup // Done by each client once it answered, in lockstep
+	original []byte          // Line before its ids were rewritten, set once sent
 }
 
-func (req *Request) Do(t Transport) Response {
-	timeStarted := time.Now()
-	body, err := t.Send(req.Line)
-	return Response{
+// rpcID returns the id that replaces JSON-RPC request ids: the UUID if the
+// request has one, or else its ID.
+func (req *Request) rpcID() json.RawMessage {
+	if req.UUID != "" {
+		return json.RawMessage(strconv.Quote(req.UUID))
+	}
+	return json.RawMessage(strconv.FormatInt(int64(req.ID), 10))
+}
+
+// cacheKey identifies requests with the same method, path and body.
+func (req *Request) cacheKey() string {
+	if req.Method == "" && req.Path == "" {
+		return string(req.Line)
+	}
+	return req.Method + " " + req.Path + "\n" + string(req.Line)
+}
+
+func (req *Request) Do(ctx context.Context, t Transport) Response {
+	resp := Response{
 		client: req.client,
 
 		Request: req,
 		ID:      req.ID,
-		Body:    body,
-		Err:     err,
-
-		Elapsed: time.Now().Sub(timeStarted),
 	}
+	if p, ok := t.(Preparer); ok {
+		if resp.Err = p.Prepare(ctx, req); resp.Err != nil {
+			return resp
+		}
+	}
+	timeStarted := time.Now()
+	resp.Err = t.Send(ctx, req, &resp)
+	resp.Elapsed = time.Now().Sub(timeStarted)
+	return resp
 }
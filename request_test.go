@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+func TestIDGenerator(t *testing.T) {
+	var g idGenerator
+	var mu sync.Mutex
+	seen := make(map[requestID]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				id, uuid := g.Next()
+				mu.Lock()
+				if seen[id] || id <= 0 || uuid != "" {
+					t.Errorf("got id %d, uuid %q; want a new positive id without a uuid", id, uuid)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 8000 {
+		t.Errorf("got %d ids; want 8000", len(seen))
+	}
+
+	// Each generator counts on its own
+	if id, _ := (&idGenerator{}).Next(); id != 1 {
+		t.Errorf("got first id %d; want 1", id)
+	}
+
+	g = idGenerator{UUIDs: true}
+	_, a := g.Next()
+	_, b := g.Next()
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(a) {
+		t.Errorf("got uuid %q; want a version 4 uuid", a)
+	}
+	if a == b {
+		t.Errorf("got the same uuid twice: %s", a)
+	}
+}
+
+func TestSendRequestIDs(t *testing.T) {
+	var sent []Request
+	sink := sinkFunc(func(req Request) { sent = append(sent, req) })
+	ids := &idGenerator{UUIDs: true}
+	if err := sendRequest(context.Background(), sink, ids, Request{Line: []byte(`{}`)}, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendRequest(context.Background(), sink, ids, Request{Line: []byte(`{}`)}, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 3 {
+		t.Fatalf("got %d requests; want 3", len(sent))
+	}
+	// Duplicates are told apart from the original
+	for i, req := range sent {
+		if want := requestID(i + 1); req.ID != want || req.UUID == "" {
+			t.Errorf("got request %d with id %d, uuid %q; want id %d with a uuid", i, req.ID, req.UUID, want)
+		}
+	}
+	if sent[0].UUID == sent[1].UUID {
+		t.Errorf("got the duplicate with the same uuid %s", sent[0].UUID)
+	}
+}
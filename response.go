@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"reflect"
 	"strings"
 	"time"
@@ -17,13 +19,59 @@ type Response struct {
 	Body []byte
 	Err  error
 
+	Status    int         // Status code, if the transport has one
+	Header    http.Header // Response headers, if the transport has them
+	Redirects []string    // Redirect chain, if redirects are compared
+	Size      int         // Size of the body as received, before content decoding
+
+	Hash     string // SHA-256 of the decoded body, when bodies are hashed rather than kept
+	HashSize int    // Size of the hashed body
+
+	Oversize int    // Bytes of the decoded body beyond the size limit, which were not kept
+	Skipped  bool   // Not compared, because the body was over the size limit
+	Spilled  string // Temporary file with the body, when it was too large to keep in memory
+	decoded  bool   // The body was decoded while reading it
+
+	buf         *bytes.Buffer // Pooled buffer holding the body as received, if any
+	ownsRequest bool          // Request is a pooled copy, released with the response
+
 	Elapsed time.Duration
+	Phases  *httpPhases // Phases of HTTP requests
+	Cached  bool        // Served from the cache rather than the endpoint
+
+	Abandoned bool // Dropped by fault injection, not counted or compared
+	Shed      bool // Not sent because the client's queue was full, not counted or compared
+	Outlier   bool // Disagreed with the majority of three or more endpoints
 }
 
 func (r *Response) Equal(other Response) bool {
 	if r.Err == nil && other.Err == nil {
+		if !redirectsEqual(r.Redirects, other.Redirects) {
+			return false
+		}
+		if r.Spilled != "" || other.Spilled != "" {
+			// Spilled bodies are only known by their hash
+			hash, size, ok := wholeHash(r)
+			otherHash, otherSize, otherOK := wholeHash(&other)
+			return ok && otherOK && hash == otherHash && size == otherSize
+		}
+		if r.Hash != "" || other.Hash != "" {
+			// With a body size limit, the kept prefix must match too
+			return r.Hash == other.Hash && r.HashSize == other.HashSize && bytes.Equal(r.Body, other.Body)
+		}
 		// TODO: Use github.com/nsf/jsondiff to detect subsets and for pretty printing diffs?
-		return bytes.Equal(r.Body, other.Body) || jsonEqual(r.Body, other.Body)
+		if bytes.Equal(r.Body, other.Body) {
+			return true
+		}
+		if kind := contentKind(r.Header); kind == contentKind(other.Header) {
+			if equal, ok := bodiesEqual(kind, r.Body, other.Body); ok {
+				return equal
+			}
+		}
+		if keys, ok := batchDiff(r.Body, other.Body); ok {
+			return len(keys) == 0
+		}
+		return jsonEqual(r.Body, other.Body)
 	}
 	if r.Err != nil && other.Err != nil {
 		return r.Err.Error() == other.Err.Error() && bytes.Equal(r.Body, other.Body)
@@ -34,6 +82,12 @@ func (r *Response) Equal(other Response) bool {
 type Responses []Response
 
 func (resps Responses) String() string {
+	return resps.Diff("unified")
+}
+
+// Diff describes how the responses differ, with mismatched bodies shown in
+// the diff format: unified, side-by-side or raw.
+func (resps Responses) Diff(format string) string {
 	var buf strings.Builder
 
 	// TODO: Sort before printing
@@ -42,8 +96,20 @@ func (resps Responses) String() string {
 		fmt.Fprintf(&buf, "\t%s", resp.Elapsed)
 
 		if resp.Err == nil && last.Err == nil {
-			if !bytes.Equal(resp.Body, last.Body) {
-				fmt.Fprintf(&buf, "[%d: body mismatch:\n%s\n\t%s\n%s\n\t%s]", i, resp.client.Endpoint, resp.Body, last.client.Endpoint, last.Body)
+			if !redirectsEqual(resp.Redirects, last.Redirects) {
+				fmt.Fprintf(&buf, "[%d: redirect mismatch: %q != %q]", i, last.Redirects, resp.Redirects)
+			} else if resp.Hash != "" || last.Hash != "" {
+				if resp.Hash != last.Hash || resp.HashSize != last.HashSize {
+					fmt.Fprintf(&buf, "[%d: hash mismatch:\n%s\n\tsha256:%s (%s)\n%s\n\tsha256:%s (%s)]", i, redactURL(resp.client.Endpoint), resp.Hash, formatBytes(resp.HashSize), redactURL(last.client.Endpoint), last.Hash, formatBytes(last.HashSize))
+				}
+			} else if keys, ok := batchDiff(resp.Body, last.Body); ok {
+				if len(keys) > 0 {
+					fmt.Fprintf(&buf, "[%d: batch mismatch for ids %s:", i, strings.Join(keys, ", "))
+					writeBatchElements(&buf, keys, resp, last)
+					fmt.Fprintf(&buf, "]")
+				}
+			} else if !bytes.Equal(resp.Body, last.Body) {
+				fmt.Fprintf(&buf, "[%d: body mismatch:\n%s]", i, bodyDiff(format, redactURL(last.client.Endpoint), redactURL(resp.client.Endpoint), last.Body, resp.Body))
 			}
 		} else if resp.Err != nil && last.Err != nil && resp.Err.Error() != last.Err.Error() {
 			fmt.Fprintf(&buf, "[%d: error mismatch: %s != %s]", i, resp.Err, last.Err)
@@ -56,6 +122,22 @@ func (resps Responses) String() string {
 	return buf.String()
 }
 
+// writeBatchElements writes the batch elements with the given keys from each
+// response, so only the mismatched parts of a batch are shown.
+func writeBatchElements(w io.Writer, keys []string, resps ...Response) {
+	for _, resp := range resps {
+		batch, _ := parseBatch(resp.Body)
+		fmt.Fprintf(w, "\n%s", redactURL(resp.client.Endpoint))
+		for _, key := range keys {
+			if el, ok := batch[key]; ok {
+				fmt.Fprintf(w, "\n\t%s", el)
+			} else {
+				fmt.Fprintf(w, "\n\t<missing id %s>", key)
+			}
+		}
+	}
+}
+
 // jsonEqual returns true if a and b are JSON objects (starting with '{') and equal.
 func jsonEqual(a, b []byte) bool {
 	if len(a) == 0 || a[0] != '{' {
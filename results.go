@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resultBuffer is the number of lines buffered per consumer of the results
+// stream. Further lines are dropped until the consumer catches up.
+const resultBuffer = 1024
+
+// resultCloseTimeout bounds the time spent writing buffered lines at the end
+// of a run, such as to a named pipe that nobody reads.
+const resultCloseTimeout = 5 * time.Second
+
+// resultRecord is a line of the results stream.
+type resultRecord struct {
+	ID         requestID     `json:"id"`
+	UUID       string        `json:"uuid,omitempty"`
+	Group      string        `json:"group,omitempty"`
+	Class      string        `json:"class,omitempty"`
+	Tags       []string      `json:"tags,omitempty"`
+	Mismatched bool          `json:"mismatched"`
+	Responses  []resultReply `json:"responses"`
+}
+
+type resultReply struct {
+	Endpoint string  `json:"endpoint"`
+	Status   int     `json:"status,omitempty"`
+	Elapsed  float64 `json:"elapsed"` // Seconds
+	Error    string  `json:"error,omitempty"`
+	Cached   bool    `json:"cached,omitempty"`
+	Outlier  bool    `json:"outlier,omitempty"` // Disagreed with the majority of three or more endpoints
+}
+
+// resultStream streams a JSON line per compared response set, without the
+// bodies, to live consumers: a named pipe, or the clients connected to a Unix
+// socket that it listens on. A slow consumer has lines dropped rather than
+// holding back the run.
+type resultStream struct {
+	// Scrub redacts the errors of the responses, if set
+	Scrub *scrubber
+
+	mu        sync.Mutex
+	consumers map[*resultConsumer]bool
+	writers   []io.Closer // Of every consumer, closed if they're stuck at the end
+	ln        net.Listener
+	dropped   int // Lines dropped for slow consumers
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// resultConsumer writes the lines it's sent in the background.
+type resultConsumer struct {
+	w     io.WriteCloser
+	lines chan []byte
+}
+
+// openResultStream opens the results stream to a named pipe or file, or
+// listens for consumers on a Unix socket with a "unix:" prefix. A named pipe
+// is opened for reading and writing, so that opening it doesn't wait for a
+// consumer.
+func openResultStream(target string) (*resultStream, error) {
+	s := &resultStream{consumers: map[*resultConsumer]bool{}}
+	if path := strings.TrimPrefix(target, "unix:"); path != target {
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			// Left over by a previous run
+			os.Remove(path)
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for results consumers: %w", err)
+		}
+		s.ln = ln
+		s.wg.Add(1)
+		go s.accept()
+		return s, nil
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if info, err := os.Stat(target); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(target, flag, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results stream: %w", err)
+	}
+	s.add(f)
+	return s, nil
+}
+
+func (s *resultStream) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			// Closed
+			return
+		}
+		logger.Debug().Msg("results consumer connected")
+		s.add(conn)
+	}
+}
+
+func (s *resultStream) add(w io.WriteCloser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		w.Close()
+		return
+	}
+	c := &resultConsumer{w: w, lines: make(chan []byte, resultBuffer)}
+	s.consumers[c] = true
+	s.writers = append(s.writers, w)
+	s.wg.Add(1)
+	go s.write(c)
+}
+
+// write writes the consumer's lines until it's closed or it fails, such as a
+// socket client that disconnected.
+func (s *resultStream) write(c *resultConsumer) {
+	defer s.wg.Done()
+	defer c.w.Close()
+	for line := range c.lines {
+		if _, err := c.w.Write(line); err != nil {
+			logger.Debug().Err(err).Msg("results consumer disconnected")
+			s.mu.Lock()
+			if s.consumers[c] {
+				delete(s.consumers, c)
+				close(c.lines)
+			}
+			s.mu.Unlock()
+			for range c.lines {
+			}
+			return
+		}
+	}
+}
+
+// Write sends a line with the outcome of a response set of the endpoint
+// group to every consumer.
+func (s *resultStream) Write(group string, resps []Response, mismatched bool) {
+	record := resultRecord{
+		ID:         resps[0].ID,
+		Group:      group,
+		Mismatched: mismatched,
+		Responses:  make([]resultReply, 0, len(resps)),
+	}
+	if req := resps[0].Request; req != nil {
+		record.UUID = req.UUID
+		record.Class = requestClass(req)
+		record.Tags = req.Tags
+	}
+	for _, resp := range resps {
+		reply := resultReply{
+			Endpoint: resp.client.Label(),
+			Status:   resp.Status,
+			Elapsed:  resp.Elapsed.Seconds(),
+			Cached:   resp.Cached,
+			Outlier:  resp.Outlier,
+		}
+		if resp.Err != nil {
+			reply.Error = s.Scrub.Error(resp.Err).Error()
+		}
+		record.Responses = append(record.Responses, reply)
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.consumers {
+		select {
+		case c.lines <- line:
+		default:
+			if s.dropped == 0 {
+				logger.Warn().Msg("results consumer is too slow, dropping lines")
+			}
+			s.dropped += 1
+		}
+	}
+}
+
+// Close stops listening, and closes every consumer once its buffered lines
+// are written. It returns the number of lines dropped for slow consumers.
+func (s *resultStream) Close() int {
+	s.mu.Lock()
+	s.closed = true
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for c := range s.consumers {
+		delete(s.consumers, c)
+		close(c.lines)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(resultCloseTimeout):
+		s.mu.Lock()
+		for _, w := range s.writers {
+			w.Close()
+		}
+		s.mu.Unlock()
+		<-done
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResultStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "versus-results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "results.sock")
+	s, err := openResultStream("unix:" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Wait for the consumer to be accepted
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		n := len(s.consumers)
+		s.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("consumer wasn't accepted")
+		}
+	}
+
+	a, b := &Client{Endpoint: "a"}, &Client{Endpoint: "b", Name: "b"}
+	req := &Request{ID: 3, Line: []byte(`{"method":"eth_call"}`), Tags: []string{"tenant=acme"}}
+	s.Write("g", []Response{
+		{client: a, Request: req, ID: 3, Status: 200, Elapsed: time.Second},
+		{client: b, Request: req, ID: 3, Err: errTimeout},
+	}, true)
+	if s.Scrub, err = newScrubber(nil, []string{"secret-[0-9]+"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Write("g", []Response{
+		{client: a, Request: req, ID: 4},
+		{client: b, Request: req, ID: 4, Err: errors.New("bad token secret-123")},
+	}, true)
+	if dropped := s.Close(); dropped != 0 {
+		t.Errorf("got %d dropped; want none", dropped)
+	}
+
+	lines := bufio.NewReader(conn)
+	line, err := lines.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got resultRecord
+	if err := json.Unmarshal(line, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 3 || got.Group != "g" || got.Class != "eth_call" || !got.Mismatched || len(got.Responses) != 2 {
+		t.Fatalf("got: %s", line)
+	}
+	if r := got.Responses[0]; r.Endpoint != "a" || r.Status != 200 || r.Elapsed != 1 {
+		t.Errorf("got: %+v", r)
+	}
+	if r := got.Responses[1]; r.Endpoint != "b" || r.Error != errTimeout.Error() {
+		t.Errorf("got: %+v", r)
+	}
+	if line, err = lines.ReadBytes('\n'); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(line), "secret-123") {
+		t.Errorf("got an unscrubbed error: %s", line)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("got socket: %v; want it removed", err)
+	}
+}
+
+// stuckWriter blocks writes until it's closed, like a named pipe that nobody
+// reads.
+type stuckWriter struct{ closed chan struct{} }
+
+func (w *stuckWriter) Write(p []byte) (int, error) {
+	<-w.closed
+	return 0, errors.New("closed")
+}
+
+func (w *stuckWriter) Close() error {
+	select {
+	case <-w.closed:
+	default:
+		close(w.closed)
+	}
+	return nil
+}
+
+func TestResultStreamSlowConsumer(t *testing.T) {
+	s := &resultStream{consumers: map[*resultConsumer]bool{}}
+	w := &stuckWriter{closed: make(chan struct{})}
+	defer w.Close()
+	s.add(w)
+	resps := []Response{{client: &Client{Endpoint: "a"}, ID: 1}}
+	for i := 0; i < resultBuffer+10; i++ {
+		s.Write("", resps, false)
+	}
+	// The first line is being written, and the buffer is full
+	if dropped := s.dropped; dropped < 9 || dropped > 10 {
+		t.Errorf("got %d dropped; want 9 or 10", dropped)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotation is when a log is rolled over to a new file: on every multiple of
+// an interval of the clock, such as on the hour, past a size or a number of
+// records, whichever comes first. The zero rotation never rolls.
+type rotation struct {
+	Every   time.Duration
+	Size    int64
+	Records int
+	Keep    int // Rolled files that are kept, the oldest are deleted, 0 keeps all
+}
+
+func (r rotation) enabled() bool {
+	return r.Every > 0 || r.Size > 0 || r.Records > 0
+}
+
+// rolledFile is a file that was rolled over, with the files that are deleted
+// along with it.
+type rolledFile struct {
+	Path     string
+	Attached []string
+}
+
+// rotatingFile is a log file that is rolled over by its rotation, so that
+// long runs don't fill the disk: the file is renamed with the time it was
+// started at, such as mismatches-20240601T140000.jsonl, and compressed with
+// gzip in the background, and the oldest rolled files are deleted beyond the
+// rotation's Keep. Records must be written whole between rolls, and it's not
+// safe for concurrent use.
+type rotatingFile struct {
+	Rotation rotation
+
+	path     string
+	f        *os.File
+	opened   time.Time
+	size     int64
+	records  int
+	attached []string
+
+	rolls chan rolledFile
+	wg    sync.WaitGroup
+	mu    sync.Mutex // Guards kept and err, set in the background
+	kept  []rolledFile
+	err   error
+}
+
+func createRotatingFile(path string, rot rotation) (*rotatingFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	rf := &rotatingFile{Rotation: rot, path: path, f: f, opened: time.Now()}
+	if rot.enabled() {
+		rf.rolls = make(chan rolledFile, 16)
+		rf.wg.Add(1)
+		go rf.compress()
+	}
+	return rf, nil
+}
+
+// Name returns the path of the current file.
+func (rf *rotatingFile) Name() string {
+	return rf.path
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Record counts a record written whole, and attaches files to the current
+// file, such as the spilled bodies it refers to, which are deleted along
+// with it.
+func (rf *rotatingFile) Record(attached ...string) {
+	rf.records += 1
+	rf.attached = append(rf.attached, attached...)
+}
+
+// Due returns whether the file is to be rolled over at now, with the bytes
+// buffered by the writer in front of it.
+func (rf *rotatingFile) Due(now time.Time, buffered int) bool {
+	r := rf.Rotation
+	switch {
+	case r.Every > 0 && !now.Truncate(r.Every).Equal(rf.opened.Truncate(r.Every)):
+		return true
+	case r.Size > 0 && rf.size+int64(buffered) >= r.Size:
+		return true
+	case r.Records > 0 && rf.records >= r.Records:
+		return true
+	}
+	return false
+}
+
+// Roll renames the current file to be compressed in the background, and
+// starts a new one. The writer in front of it must be flushed first.
+func (rf *rotatingFile) Roll(now time.Time) error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	rolled := rolledFile{Path: rolledName(rf.path, rf.opened), Attached: rf.attached}
+	if err := os.Rename(rf.path, rolled.Path); err != nil {
+		return err
+	}
+	f, err := os.Create(rf.path)
+	if err != nil {
+		return err
+	}
+	rf.f, rf.opened, rf.size, rf.records, rf.attached = f, now, 0, 0, nil
+	rf.rolls <- rolled
+	return nil
+}
+
+// rolledName returns the name of a file rolled over that was started at t,
+// which is free with or without compression.
+func rolledName(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext) + "-" + t.Format("20060102T150405")
+	name := base + ext
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	return name
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// compress compresses the rolled files, and deletes the oldest of them beyond
+// Keep, until the file is closed.
+func (rf *rotatingFile) compress() {
+	defer rf.wg.Done()
+	for rolled := range rf.rolls {
+		if err := gzipFile(rolled.Path); err != nil {
+			rf.fail(err)
+		} else {
+			rolled.Path += ".gz"
+		}
+		rf.mu.Lock()
+		rf.kept = append(rf.kept, rolled)
+		for rf.Rotation.Keep > 0 && len(rf.kept) > rf.Rotation.Keep {
+			os.Remove(rf.kept[0].Path)
+			for _, path := range rf.kept[0].Attached {
+				os.Remove(path)
+			}
+			rf.kept = rf.kept[1:]
+		}
+		rf.mu.Unlock()
+	}
+}
+
+// Segments returns the paths of the rolled files that are kept, oldest first,
+// and of the current file. Rolled files are only final once it's closed.
+func (rf *rotatingFile) Segments() []string {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	segments := make([]string, 0, len(rf.kept)+1)
+	for _, rolled := range rf.kept {
+		segments = append(segments, rolled.Path)
+	}
+	return append(segments, rf.path)
+}
+
+// segmentNames names the segments of a rotated log after name for artifacts,
+// such as mismatches.jsonl for the current file, the last segment, and
+// mismatches-20240601T140000.jsonl.gz for a rolled one. It returns the paths
+// by name.
+func segmentNames(name string, segments []string) map[string]string {
+	names := make(map[string]string, len(segments))
+	if len(segments) == 0 {
+		return names
+	}
+	current := segments[len(segments)-1]
+	stem := strings.TrimSuffix(filepath.Base(current), filepath.Ext(current))
+	for _, path := range segments[:len(segments)-1] {
+		suffix := strings.TrimPrefix(filepath.Base(path), stem)
+		names[strings.TrimSuffix(name, filepath.Ext(name))+suffix] = path
+	}
+	names[name] = current
+	return names
+}
+
+func (rf *rotatingFile) fail(err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.err == nil {
+		rf.err = err
+	}
+}
+
+// gzipFile replaces the file with its gzip-compressed copy, path.gz.
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close closes the current file, and waits for the rolled files to be
+// compressed. It returns the first error.
+func (rf *rotatingFile) Close() error {
+	err := rf.f.Close()
+	if rf.rolls != nil {
+		close(rf.rolls)
+		rf.wg.Wait()
+	}
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if err == nil {
+		err = rf.err
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readRolled returns the lines of the rolled files of the log at path, which
+// are compressed once it's closed.
+func readRolled(t *testing.T, path string) [][]string {
+	t.Helper()
+	ext := filepath.Ext(path)
+	rolled, err := filepath.Glob(strings.TrimSuffix(path, ext) + "-*" + ext + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files [][]string
+	for _, name := range rolled {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		scanner := bufio.NewScanner(zr)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		f.Close()
+		files = append(files, lines)
+	}
+	return files
+}
+
+func TestRotationDue(t *testing.T) {
+	opened := time.Date(2024, 6, 1, 14, 59, 0, 0, time.UTC)
+	tests := []struct {
+		rot      rotation
+		now      time.Time
+		size     int64
+		buffered int
+		records  int
+		want     bool
+	}{
+		{rot: rotation{}, now: opened.Add(24 * time.Hour), size: 1 << 30, records: 1 << 20, want: false},
+		{rot: rotation{Every: time.Hour}, now: opened.Add(59 * time.Second), want: false},
+		{rot: rotation{Every: time.Hour}, now: opened.Add(time.Minute), want: true},
+		{rot: rotation{Size: 100}, now: opened, size: 60, buffered: 30, want: false},
+		{rot: rotation{Size: 100}, now: opened, size: 60, buffered: 40, want: true},
+		{rot: rotation{Records: 3}, now: opened, records: 2, want: false},
+		{rot: rotation{Records: 3}, now: opened, records: 3, want: true},
+	}
+	for _, tc := range tests {
+		rf := &rotatingFile{Rotation: tc.rot, opened: opened, size: tc.size, records: tc.records}
+		if got := rf.Due(tc.now, tc.buffered); got != tc.want {
+			t.Errorf("%+v at %s: got %t; want %t", tc.rot, tc.now.Format(time.Kitchen), got, tc.want)
+		}
+	}
+}
+
+func TestMismatchLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "versus-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mismatches.jsonl")
+	l, err := createMismatchLog(path, rotation{Records: 2, Keep: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spilled []string
+	for i := 1; i <= 5; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("body-%d", i))
+		if err := ioutil.WriteFile(name, []byte(`{}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		spilled = append(spilled, name)
+		id := requestID(i)
+		l.Write("", []Response{{ID: id, Body: []byte(`1`)}, {ID: id, Spilled: name}})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rolled after the 2nd and 4th records, and the first is deleted
+	rolled := readRolled(t, path)
+	if len(rolled) != 1 || len(rolled[0]) != 2 || !strings.HasPrefix(rolled[0][0], `{"id":3,`) {
+		t.Errorf("got rolled files %q; want the 3rd and 4th records", rolled)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), `{"id":5,`) || strings.Count(string(data), "\n") != 1 {
+		t.Errorf("got current file %q; want the 5th record", data)
+	}
+	for i, name := range spilled {
+		if want := i >= 2; exists(name) != want {
+			t.Errorf("spilled body of record %d: got exists %t; want %t", i+1, exists(name), want)
+		}
+	}
+	segments := l.Segments()
+	if len(segments) != 2 || !strings.HasSuffix(segments[0], ".jsonl.gz") || segments[1] != path {
+		t.Errorf("got segments %q; want the rolled file and %s", segments, path)
+	}
+}
+
+func TestSegmentNames(t *testing.T) {
+	got := segmentNames("mismatches.jsonl", []string{
+		"/var/log/soak-20240601T140000.jsonl.gz",
+		"/var/log/soak-20240601T150000-1.jsonl.gz",
+		"/var/log/soak.jsonl",
+	})
+	want := map[string]string{
+		"mismatches-20240601T140000.jsonl.gz":   "/var/log/soak-20240601T140000.jsonl.gz",
+		"mismatches-20240601T150000-1.jsonl.gz": "/var/log/soak-20240601T150000-1.jsonl.gz",
+		"mismatches.jsonl":                      "/var/log/soak.jsonl",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestLatencyLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "versus-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "latency.csv")
+	l, err := createLatencyLog(path, rotation{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients, err := NewClients([]string{"noop://a", "noop://b"}, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		req := &Request{ID: requestID(i), Line: []byte(`{"method":"eth_call"}`)}
+		l.Write("", []Response{
+			{client: clients[0], ID: req.ID, Request: req, Elapsed: 10 * time.Millisecond},
+			{client: clients[1], ID: req.ID, Request: req, Elapsed: 20 * time.Millisecond},
+		}, false)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	header := strings.Join(latencyHeader, ",")
+	rolled := readRolled(t, path)
+	if len(rolled) != 2 {
+		t.Fatalf("got %d rolled files; want 2", len(rolled))
+	}
+	for _, lines := range rolled {
+		if len(lines) != 2 || lines[0] != header {
+			t.Errorf("got rolled file %q; want the header and a row", lines)
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), header+"\n"; got != want {
+		t.Errorf("got current file %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// scheduleCheck bounds the time between checks of the replay windows, so that
+// changes of the wall clock, such as daylight saving time, are picked up.
+const scheduleCheck = time.Minute
+
+// replayWindow is a daily time window, in seconds since midnight. Windows
+// that end before they start span midnight.
+type replayWindow struct {
+	Spec       string
+	Start, End int
+}
+
+// parseWindow parses a window written as "HH:MM-HH:MM", such as "02:00-05:00"
+// or "22:00-02:00".
+func parseWindow(spec string) (replayWindow, error) {
+	parts := strings.Split(spec, "-")
+	if len(parts) != 2 {
+		return replayWindow{}, fmt.Errorf("invalid window, want HH:MM-HH:MM: %s", spec)
+	}
+	w := replayWindow{Spec: spec}
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return replayWindow{}, fmt.Errorf("invalid window time, want HH:MM: %s", part)
+		}
+		seconds := t.Hour()*3600 + t.Minute()*60
+		if i == 0 {
+			w.Start = seconds
+		} else {
+			w.End = seconds
+		}
+	}
+	if w.Start == w.End {
+		return replayWindow{}, fmt.Errorf("invalid window, it starts when it ends: %s", spec)
+	}
+	return w, nil
+}
+
+// Contains returns whether the window contains the time of day.
+func (w replayWindow) Contains(seconds int) bool {
+	if w.Start < w.End {
+		return seconds >= w.Start && seconds < w.End
+	}
+	return seconds >= w.Start || seconds < w.End
+}
+
+// windowSchedule holds the feed outside its replay windows, so that requests
+// are only sent during them, such as off-peak hours, while the run and its
+// report carry on across them.
+type windowSchedule struct {
+	Windows  []replayWindow
+	Location *time.Location
+
+	known bool // Whether open is known, used by Serve only
+	open  bool
+}
+
+func newWindowSchedule(specs []string, location *time.Location) (*windowSchedule, error) {
+	s := &windowSchedule{Location: location}
+	for _, spec := range specs {
+		w, err := parseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		s.Windows = append(s.Windows, w)
+	}
+	return s, nil
+}
+
+// Open returns whether t is within any window.
+func (s *windowSchedule) Open(t time.Time) bool {
+	t = t.In(s.Location)
+	seconds := t.Hour()*3600 + t.Minute()*60 + t.Second()
+	for _, w := range s.Windows {
+		if w.Contains(seconds) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the first start or end of a window after t.
+func (s *windowSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.Location)
+	var next time.Time
+	for _, w := range s.Windows {
+		for _, seconds := range []int{w.Start, w.End} {
+			b := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, seconds, 0, s.Location)
+			if !b.After(t) {
+				b = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, seconds, 0, s.Location)
+			}
+			if next.IsZero() || b.Before(next) {
+				next = b
+			}
+		}
+	}
+	return next
+}
+
+// Apply holds or releases the feed for the schedule at now.
+func (s *windowSchedule) Apply(fc *feedControl, now time.Time) {
+	open := s.Open(now)
+	if s.known && open == s.open {
+		return
+	}
+	s.known, s.open = true, open
+	fc.SetOutside(!open)
+	if open {
+		logger.Info().Time("until", s.Next(now)).Msg("replay window opened, sending requests")
+	} else {
+		logger.Info().Time("until", s.Next(now)).Msg("outside replay windows, holding requests")
+	}
+}
+
+// Serve applies the schedule to the feed until the context is done.
+func (s *windowSchedule) Serve(ctx context.Context, fc *feedControl) {
+	for {
+		now := time.Now()
+		s.Apply(fc, now)
+		wait := s.Next(now).Sub(now)
+		if wait > scheduleCheck {
+			wait = scheduleCheck
+		}
+		if !sleep(ctx, wait) {
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    replayWindow
+		wantErr bool
+	}{
+		{spec: "02:00-05:00", want: replayWindow{Spec: "02:00-05:00", Start: 2 * 3600, End: 5 * 3600}},
+		{spec: "22:30 - 02:00", want: replayWindow{Spec: "22:30 - 02:00", Start: 22*3600 + 30*60, End: 2 * 3600}},
+		{spec: "02:00", wantErr: true},
+		{spec: "2am-5am", wantErr: true},
+		{spec: "25:00-05:00", wantErr: true},
+		{spec: "03:00-03:00", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := parseWindow(tc.spec)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: got %+v; want error", tc.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tc.spec, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %+v; want %+v", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestWindowSchedule(t *testing.T) {
+	s, err := newWindowSchedule([]string{"02:00-05:00", "22:00-23:30"}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 6, 1, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		now  time.Time
+		open bool
+		next time.Time
+	}{
+		{now: at(1, 0), open: false, next: at(2, 0)},
+		{now: at(2, 0), open: true, next: at(5, 0)},
+		{now: at(4, 59), open: true, next: at(5, 0)},
+		{now: at(5, 0), open: false, next: at(22, 0)},
+		{now: at(23, 0), open: true, next: at(23, 30)},
+		{now: at(23, 45), open: false, next: at(26, 0)}, // 02:00 the next day
+	}
+	for _, tc := range tests {
+		if got := s.Open(tc.now); got != tc.open {
+			t.Errorf("%s: got open %t; want %t", tc.now.Format("15:04"), got, tc.open)
+		}
+		if got := s.Next(tc.now); !got.Equal(tc.next) {
+			t.Errorf("%s: got next %s; want %s", tc.now.Format("15:04"), got, tc.next)
+		}
+	}
+
+	// Windows spanning midnight, in another time zone
+	tokyo := time.FixedZone("JST", 9*3600)
+	s, err = newWindowSchedule([]string{"23:00-01:00"}, tokyo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Open(at(15, 30)) { // 00:30 in Tokyo
+		t.Errorf("got closed at 00:30 JST; want open")
+	}
+	if s.Open(at(16, 0)) {
+		t.Errorf("got open at 01:00 JST; want closed")
+	}
+}
+
+func TestWindowScheduleHoldsFeed(t *testing.T) {
+	s, err := newWindowSchedule([]string{"02:00-05:00"}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := newFeedControl(0)
+	s.Apply(fc, time.Date(2024, 6, 1, 1, 0, 0, 0, time.UTC))
+	if !fc.Outside() || fc.Paused() {
+		t.Fatalf("got outside %t, paused %t; want outside and not paused", fc.Outside(), fc.Paused())
+	}
+
+	// Resuming doesn't release a feed that's outside the windows
+	fc.Pause()
+	fc.Resume()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if ok, err := fc.Wait(ctx); ok || err == nil {
+		t.Errorf("got: %t, %v; want: false, deadline exceeded outside the windows", ok, err)
+	}
+
+	// Opening the window doesn't release a paused feed
+	fc.Pause()
+	s.Apply(fc, time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC))
+	if fc.Outside() {
+		t.Errorf("got outside in the window")
+	}
+	released := make(chan bool, 1)
+	go func() {
+		ok, _ := fc.Wait(context.Background())
+		released <- ok
+	}()
+	select {
+	case <-released:
+		t.Fatalf("got released while paused")
+	case <-time.After(10 * time.Millisecond):
+	}
+	fc.Resume()
+	if ok := <-released; !ok {
+		t.Errorf("got not released after resuming in the window")
+	}
+}
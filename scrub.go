@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// redacted replaces scrubbed values.
+const redacted = "[REDACTED]"
+
+// scrubber redacts values, such as PII and secrets, from requests and
+// responses before they're written to the mismatch log, hooks, verbose logs
+// and reports: the values at JSON paths, and the matches of regular
+// expressions. Comparisons use the responses as they are.
+type scrubber struct {
+	Paths    []jsonPath
+	Patterns []*regexp.Regexp
+}
+
+func newScrubber(paths, patterns []string) (*scrubber, error) {
+	s := &scrubber{}
+	for _, spec := range paths {
+		p, err := parseJSONPath(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrub path: %w", err)
+		}
+		s.Paths = append(s.Paths, p)
+	}
+	for _, spec := range patterns {
+		re, err := regexp.Compile(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrub pattern: %w", err)
+		}
+		s.Patterns = append(s.Patterns, re)
+	}
+	return s, nil
+}
+
+// Scrub returns the data with the values at the paths, if it's JSON, and the
+// matches of the patterns redacted. Paths that start with a field apply to
+// each element of a JSON-RPC batch.
+func (s *scrubber) Scrub(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	if len(s.Paths) > 0 {
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err == nil {
+			if s.scrubPaths(&v) {
+				if scrubbed, err := marshalJSON(v); err == nil {
+					data = scrubbed
+				}
+			}
+		}
+	}
+	for _, re := range s.Patterns {
+		data = re.ReplaceAll(data, []byte(redacted))
+	}
+	return data
+}
+
+// scrubPaths redacts the values at the paths of a decoded JSON value, and
+// returns whether any were there.
+func (s *scrubber) scrubPaths(v *interface{}) bool {
+	changed := false
+	for _, p := range s.Paths {
+		if len(p) == 0 {
+			*v = redacted
+			return true
+		}
+		if batch, ok := (*v).([]interface{}); ok && p[0].IsKey {
+			for _, el := range batch {
+				if p.Replace(el, redacted) {
+					changed = true
+				}
+			}
+			continue
+		}
+		if p.Replace(*v, redacted) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// scrubString returns s with the matches of the patterns redacted.
+func (s *scrubber) scrubString(str string) string {
+	for _, re := range s.Patterns {
+		str = re.ReplaceAllString(str, redacted)
+	}
+	return str
+}
+
+// Error returns the error with the matches of the patterns redacted from its
+// message. It returns the error as it is without a scrubber.
+func (s *scrubber) Error(err error) error {
+	if s == nil || err == nil || len(s.Patterns) == 0 {
+		return err
+	}
+	return errors.New(s.scrubString(err.Error()))
+}
+
+// Responses returns a scrubbed copy of a response set, to be written out. The
+// copies don't own the pooled bodies of the responses, and spilled bodies are
+// left out, since their files are removed rather than kept. It returns the
+// set as it is without a scrubber.
+func (s *scrubber) Responses(resps []Response) []Response {
+	if s == nil || len(resps) == 0 {
+		return resps
+	}
+	// Responses have their own requests when they're rewritten per endpoint
+	reqs := make(map[*Request]*Request, 1)
+	out := make([]Response, len(resps))
+	for i, resp := range resps {
+		if resp.Request != nil {
+			req, ok := reqs[resp.Request]
+			if !ok {
+				scrubbed := *resp.Request
+				scrubbed.Line = s.Scrub(scrubbed.Line)
+				scrubbed.Path = s.scrubString(scrubbed.Path)
+				req = &scrubbed
+				reqs[resp.Request] = req
+			}
+			resp.Request = req
+		}
+		resp.Body = s.Scrub(resp.Body)
+		resp.Err = s.Error(resp.Err)
+		resp.Spilled = ""
+		resp.buf, resp.ownsRequest = nil, false
+		out[i] = resp
+	}
+	return out
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScrubber(t *testing.T) {
+	s, err := newScrubber(
+		[]string{"$.params[0].email", "$.result.token", "$.params[-1]"},
+		[]string{`Bearer [A-Za-z0-9._-]+`},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{
+			in:   `{"method":"login","params":[{"email":"a@example.com","n":12345678901234567890},"secret"]}`,
+			want: `{"method":"login","params":[{"email":"[REDACTED]","n":12345678901234567890},"[REDACTED]"]}`,
+		},
+		{
+			in:   `[{"result":{"token":"abc"}},{"result":{"id":1}}]`,
+			want: `[{"result":{"token":"[REDACTED]"}},{"result":{"id":1}}]`,
+		},
+		{
+			// Left as it is without a match
+			in:   `{"result": {"id": 1}}`,
+			want: `{"result": {"id": 1}}`,
+		},
+		{
+			in:   `{"result":"Authorization: Bearer eyJhbGciOi.x-y_z"}`,
+			want: `{"result":"Authorization: [REDACTED]"}`,
+		},
+		{
+			in:   `not json, Bearer abc`,
+			want: `not json, [REDACTED]`,
+		},
+	}
+	for _, tc := range tests {
+		if got := string(s.Scrub([]byte(tc.in))); got != tc.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tc.in, got, tc.want)
+		}
+	}
+
+	if _, err := newScrubber([]string{"params"}, nil); err == nil {
+		t.Errorf("got no error for a path without $")
+	}
+	if _, err := newScrubber(nil, []string{"("}); err == nil {
+		t.Errorf("got no error for an invalid pattern")
+	}
+}
+
+func TestScrubberResponses(t *testing.T) {
+	s, err := newScrubber([]string{"$.params[0]"}, []string{`user-[0-9]+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &Request{Line: []byte(`{"id":1,"method":"get","params":["secret"]}`), Path: "/users/user-42"}
+	resps := []Response{
+		{Request: req, Body: []byte(`{"name":"user-42"}`)},
+		{Request: req, Err: errors.New("rpc error: not_found: no such user user-42"), Spilled: "/tmp/body"},
+	}
+	got := s.Responses(resps)
+
+	if got, want := string(got[0].Request.Line), `{"id":1,"method":"get","params":["[REDACTED]"]}`; got != want {
+		t.Errorf("got request %s; want %s", got, want)
+	}
+	if got, want := got[1].Request.Path, "/users/[REDACTED]"; got != want {
+		t.Errorf("got path %s; want %s", got, want)
+	}
+	if got, want := string(got[0].Body), `{"name":"[REDACTED]"}`; got != want {
+		t.Errorf("got body %s; want %s", got, want)
+	}
+	if got, want := got[1].Err.Error(), "rpc error: not_found: no such user [REDACTED]"; got != want {
+		t.Errorf("got error %s; want %s", got, want)
+	}
+	if got[1].Spilled != "" {
+		t.Errorf("got spilled %s; want none", got[1].Spilled)
+	}
+	// The responses are compared as they are
+	if string(req.Line) != `{"id":1,"method":"get","params":["secret"]}` || string(resps[0].Body) != `{"name":"user-42"}` {
+		t.Errorf("scrubbing changed the responses: %s, %s", req.Line, resps[0].Body)
+	}
+
+	// Requests rewritten per endpoint are kept apart
+	rewritten := &Request{Line: []byte(`{"id":1,"method":"get_v2","params":["secret"]}`)}
+	resps[1].Request = rewritten
+	got = s.Responses(resps)
+	if got, want := string(got[1].Request.Line), `{"id":1,"method":"get_v2","params":["[REDACTED]"]}`; got != want {
+		t.Errorf("got rewritten request %s; want %s", got, want)
+	}
+	if got, want := string(got[0].Request.Line), `{"id":1,"method":"get","params":["[REDACTED]"]}`; got != want {
+		t.Errorf("got request %s; want %s", got, want)
+	}
+
+	var none *scrubber
+	if got := none.Responses(resps); &got[0] != &resps[0] {
+		t.Errorf("got a copy without a scrubber")
+	}
+}
+
+func TestReportScrub(t *testing.T) {
+	clients, err := NewClients([]string{"noop://foo", "noop://bar"}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrub, err := newScrubber([]string{"$.email"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spilled, err := ioutil.TempFile("", "versus-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spilled.Close()
+	defer os.Remove(spilled.Name())
+
+	var mismatches [][]Response
+	r := report{Clients: clients, Scrub: scrub, MismatchedResponse: func(resps []Response) {
+		mismatches = append(mismatches, resps)
+	}}
+	r.init()
+	req := &Request{ID: 1, Line: []byte(`{"email":"a@example.com"}`)}
+	r.handle(Response{client: clients[0], ID: 1, Request: req, Body: []byte(`{"email":"a@example.com"}`)})
+	r.handle(Response{client: clients[1], ID: 1, Request: req, Body: []byte(`{"email":"b@example.com"}`), Spilled: spilled.Name()})
+
+	if len(mismatches) != 1 {
+		t.Fatalf("got %d mismatches; want 1", len(mismatches))
+	}
+	for _, resp := range mismatches[0] {
+		if strings.Contains(string(resp.Body), "example.com") || strings.Contains(string(resp.Request.Line), "example.com") {
+			t.Errorf("got unscrubbed response: %s to %s", resp.Body, resp.Request.Line)
+		}
+	}
+	patterns := r.patternSummaries()
+	if len(patterns) != 1 || strings.Contains(patterns[0].Example.Request+patterns[0].Example.Responses[1].Body, "example.com") {
+		t.Errorf("got unscrubbed pattern example: %+v", patterns)
+	}
+	if _, err := os.Stat(spilled.Name()); !os.IsNotExist(err) {
+		t.Errorf("got spilled body kept; want it removed when scrubbing")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import "time"
+
+// processCPUTime isn't supported on this platform.
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
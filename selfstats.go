@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// selfSampleInterval is how often versus samples its own resource usage.
+const selfSampleInterval = time.Second
+
+// saturatedCPU is the CPU utilization, as a fraction of the available cores,
+// above which versus itself is considered the bottleneck.
+const saturatedCPU = 0.9
+
+// selfMonitor records versus's own CPU, memory and goroutine usage during a
+// run, so that latency caused by a saturated load generator can be told apart
+// from the endpoints' own.
+type selfMonitor struct {
+	mu            sync.Mutex
+	started       time.Time
+	startCPU      time.Duration
+	sampled       time.Time // Time of the latest CPU sample
+	cpu           time.Duration
+	windowStart   time.Time // Start of the interval the peak is measured over
+	windowCPU     time.Duration
+	peakCPU       float64 // Highest utilization over a sample interval
+	goroutines    int
+	maxGoroutines int
+	heap          uint64
+	maxHeap       uint64
+	startGC       uint32
+	numGC         uint32
+	startPause    uint64
+	pause         uint64 // Nanoseconds
+	guard         *memoryGuard
+}
+
+func newSelfMonitor() *selfMonitor {
+	m := &selfMonitor{}
+	m.started = time.Now()
+	m.startCPU, _ = processCPUTime()
+	m.sampled, m.cpu = m.started, m.startCPU
+	m.windowStart, m.windowCPU = m.started, m.startCPU
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	m.startGC, m.startPause = mem.NumGC, mem.PauseTotalNs
+	m.record(&mem)
+	return m
+}
+
+// Serve samples resource usage until the context is done.
+func (m *selfMonitor) Serve(ctx context.Context) {
+	ticker := time.NewTicker(selfSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sample()
+		}
+	}
+}
+
+func (m *selfMonitor) sample() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	now := time.Now()
+	cpu, ok := processCPUTime()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record(&mem)
+	m.guard.Check(mem.HeapAlloc, now)
+	if !ok {
+		return
+	}
+	m.sampled, m.cpu = now, cpu
+	// Shorter intervals are too coarse for the CPU time to be meaningful
+	if wall := now.Sub(m.windowStart); wall >= selfSampleInterval/2 {
+		utilization := float64(cpu-m.windowCPU) / float64(wall) / float64(runtime.GOMAXPROCS(0))
+		if utilization > m.peakCPU {
+			m.peakCPU = utilization
+		}
+		m.windowStart, m.windowCPU = now, cpu
+	}
+}
+
+// SetGuard keeps the heap under the guard's limit from the next sample on.
+func (m *selfMonitor) SetGuard(g *memoryGuard) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.guard = g
+}
+
+func (m *selfMonitor) record(mem *runtime.MemStats) {
+	m.goroutines = runtime.NumGoroutine()
+	if m.goroutines > m.maxGoroutines {
+		m.maxGoroutines = m.goroutines
+	}
+	m.heap = mem.HeapAlloc
+	if m.heap > m.maxHeap {
+		m.maxHeap = m.heap
+	}
+	m.numGC = mem.NumGC - m.startGC
+	m.pause = mem.PauseTotalNs - m.startPause
+}
+
+// selfSummary is the machine-readable form of versus's own resource usage.
+type selfSummary struct {
+	Cores          int            `json:"cores"`                // GOMAXPROCS
+	CPU            float64        `json:"cpu"`                  // Seconds of user and system time
+	CPUUtilization float64        `json:"cpu_utilization"`      // Percent of the cores, over the run
+	PeakCPU        float64        `json:"peak_cpu_utilization"` // Percent of the cores, over a sample interval
+	Goroutines     int            `json:"goroutines"`
+	MaxGoroutines  int            `json:"max_goroutines"`
+	HeapBytes      uint64         `json:"heap_bytes"`
+	MaxHeapBytes   uint64         `json:"max_heap_bytes"`
+	NumGC          uint32         `json:"num_gc"`
+	GCPause        float64        `json:"gc_pause"` // Seconds, in total
+	Saturated      bool           `json:"saturated"`
+	Memory         *memorySummary `json:"memory,omitempty"`
+}
+
+// Summary samples the current usage and returns it along with the peaks.
+func (m *selfMonitor) Summary() selfSummary {
+	m.sample()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := selfSummary{
+		Cores:         runtime.GOMAXPROCS(0),
+		PeakCPU:       m.peakCPU * 100,
+		Goroutines:    m.goroutines,
+		MaxGoroutines: m.maxGoroutines,
+		HeapBytes:     m.heap,
+		MaxHeapBytes:  m.maxHeap,
+		NumGC:         m.numGC,
+		GCPause:       time.Duration(m.pause).Seconds(),
+		Saturated:     m.peakCPU >= saturatedCPU,
+		Memory:        m.guard.Summary(time.Now()),
+	}
+	s.CPU = (m.cpu - m.startCPU).Seconds()
+	if wall := m.sampled.Sub(m.started); wall > 0 {
+		s.CPUUtilization = float64(m.cpu-m.startCPU) / float64(wall) / float64(s.Cores) * 100
+	}
+	return s
+}
+
+// Render writes the usage as part of the text report.
+func (s selfSummary) Render(w io.Writer) {
+	fmt.Fprintf(w, "   Self:       %0.2fs CPU (%0.0f%% of %d cores, %0.0f%% peak)\n", s.CPU, s.CPUUtilization, s.Cores, s.PeakCPU)
+	fmt.Fprintf(w, "               %d goroutines (%d max), %s heap (%s max)\n", s.Goroutines, s.MaxGoroutines, formatBytes(int(s.HeapBytes)), formatBytes(int(s.MaxHeapBytes)))
+	fmt.Fprintf(w, "               %d GCs, %s total pause\n", s.NumGC, time.Duration(s.GCPause*float64(time.Second)).Round(time.Microsecond))
+	s.Memory.Render(w)
+}
+
+// servePprof serves the pprof endpoints under /debug/pprof/ on addr until
+// the returned shutdown function is called.
+func servePprof(addr string) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for pprof: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Error().Err(err).Msg("pprof server failed")
+		}
+	}()
+	logger.Info().Str("addr", ln.Addr().String()).Msg("serving pprof")
+	return func() { srv.Close() }, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSelfMonitor(t *testing.T) {
+	m := newSelfMonitor()
+	s := m.Summary()
+	if s.Cores < 1 {
+		t.Errorf("got: %d cores; want at least 1", s.Cores)
+	}
+	if s.Goroutines < 1 || s.MaxGoroutines < s.Goroutines {
+		t.Errorf("got: %d goroutines, %d max", s.Goroutines, s.MaxGoroutines)
+	}
+	if s.HeapBytes == 0 || s.MaxHeapBytes < s.HeapBytes {
+		t.Errorf("got: %d heap bytes, %d max", s.HeapBytes, s.MaxHeapBytes)
+	}
+	if s.Saturated {
+		t.Errorf("got: saturated; want: not saturated")
+	}
+
+	m.peakCPU = 0.95
+	if s := m.Summary(); !s.Saturated {
+		t.Errorf("got: not saturated at %0.0f%% peak; want: saturated", s.PeakCPU)
+	}
+}
+
+func TestSelfSummaryRender(t *testing.T) {
+	var buf bytes.Buffer
+	selfSummary{
+		Cores:          4,
+		CPU:            2.5,
+		CPUUtilization: 31.25,
+		PeakCPU:        60,
+		Goroutines:     12,
+		MaxGoroutines:  40,
+		HeapBytes:      3 << 20,
+		MaxHeapBytes:   8 << 20,
+		NumGC:          7,
+		GCPause:        0.0015,
+	}.Render(&buf)
+
+	want := []string{
+		"   Self:       2.50s CPU (31% of 4 cores, 60% peak)",
+		"               12 goroutines (40 max), " + formatBytes(3<<20) + " heap (" + formatBytes(8<<20) + " max)",
+		"               7 GCs, 1.5ms total pause",
+	}
+	if got, want := buf.String(), strings.Join(want, "\n")+"\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
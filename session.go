@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/cookiejar"
+)
+
+// Sessioned is a type of Transport that can keep session state, such as
+// cookies, between requests. Not all transports support sessions.
+type Sessioned interface {
+	SetCookieJar(http.CookieJar)
+}
+
+// enableCookies gives the transport its own cookie jar.
+func enableCookies(t Transport) error {
+	s, ok := t.(Sessioned)
+	if !ok {
+		return fmt.Errorf("transport does not support cookies: %T", t)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+	s.SetCookieJar(jar)
+	return nil
+}
+
+// sessionKey returns the value of a top-level field of a JSON object request
+// body, or an empty string if the field is not found.
+func sessionKey(line []byte, field string) string {
+	if len(line) == 0 || line[0] != '{' {
+		return ""
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(line, &obj); err != nil {
+		return ""
+	}
+	v, ok := obj[field]
+	if !ok {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		return s
+	}
+	// Not a string, use the raw JSON value
+	return string(v)
+}
+
+// sessionWorker picks the worker index for a session key, so that all
+// requests of a session are handled by the same worker (and its cookie jar).
+func sessionWorker(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,72 @@
+package main
+
+import "math/rand"
+
+// defaultShuffleWindow is the number of requests shuffled together by
+// default.
+const defaultShuffleWindow = 10000
+
+// shuffler randomizes the order of input records within a window: the
+// window is filled, then each record read replaces a random one of the
+// window, which is sent. Records move about a window away on average, which
+// breaks up clusters of similar requests without keeping the whole input in
+// memory.
+// The order only depends on the seed and the input.
+type shuffler struct {
+	Window int
+
+	rand *rand.Rand
+}
+
+func newShuffler(window int, seed int64) *shuffler {
+	if window < 1 {
+		window = defaultShuffleWindow
+	}
+	return &shuffler{Window: window, rand: rand.New(rand.NewSource(seed))}
+}
+
+// Shuffle returns the records of in in shuffled order, until in is closed or
+// an empty record ends the input, which is sent last.
+func (s *shuffler) Shuffle(in <-chan []byte, stop <-chan struct{}) <-chan []byte {
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		send := func(record []byte) bool {
+			select {
+			case out <- record:
+				return true
+			case <-stop:
+				return false
+			}
+		}
+		window := make([][]byte, 0, s.Window)
+		var end []byte
+		for record := range in {
+			if len(record) == 0 {
+				end = record
+				break
+			}
+			if len(window) < s.Window {
+				window = append(window, record)
+				continue
+			}
+			i := s.rand.Intn(len(window))
+			if !send(window[i]) {
+				return
+			}
+			window[i] = record
+		}
+		s.rand.Shuffle(len(window), func(i, j int) {
+			window[i], window[j] = window[j], window[i]
+		})
+		for _, record := range window {
+			if !send(record) {
+				return
+			}
+		}
+		if end != nil {
+			send(end)
+		}
+	}()
+	return out
+}
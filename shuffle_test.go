@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func shuffled(window int, seed int64, records []string) []string {
+	in := make(chan []byte)
+	go func() {
+		defer close(in)
+		for _, r := range records {
+			in <- []byte(r)
+		}
+	}()
+	stop := make(chan struct{})
+	defer close(stop)
+	var got []string
+	for record := range newShuffler(window, seed).Shuffle(in, stop) {
+		got = append(got, string(record))
+	}
+	return got
+}
+
+func TestShuffler(t *testing.T) {
+	var records []string
+	for i := 0; i < 100; i++ {
+		records = append(records, strconv.Itoa(i))
+	}
+
+	for _, window := range []int{10, 1000} {
+		got := shuffled(window, 42, records)
+		if again := shuffled(window, 42, records); !reflect.DeepEqual(got, again) {
+			t.Errorf("window %d: got different orders with the same seed", window)
+		}
+		if other := shuffled(window, 7, records); reflect.DeepEqual(got, other) {
+			t.Errorf("window %d: got the same order with another seed", window)
+		}
+		if reflect.DeepEqual(got, records) {
+			t.Errorf("window %d: got the input order", window)
+		}
+		sorted := append([]string(nil), got...)
+		sort.Slice(sorted, func(i, j int) bool {
+			a, _ := strconv.Atoi(sorted[i])
+			b, _ := strconv.Atoi(sorted[j])
+			return a < b
+		})
+		if !reflect.DeepEqual(sorted, records) {
+			t.Errorf("window %d: got: %v; want every record once", window, got)
+		}
+	}
+
+	// An empty record ends the input, after the shuffled records before it
+	got := shuffled(10, 42, []string{"a", "b", "c", "", "d"})
+	if len(got) != 4 || got[3] != "" {
+		t.Errorf("got: %q; want a, b and c shuffled, then the end", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestHMACSigner(t *testing.T) {
+	os.Setenv("VERSUS_TEST_HMAC_KEY", "key")
+	defer os.Unsetenv("VERSUS_TEST_HMAC_KEY")
+
+	opts, err := url.ParseQuery("sign=hmac&hmac-key-env=VERSUS_TEST_HMAC_KEY&hmac-header=X-Hub-Signature-256&hmac-prefix=sha256%3D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newRequestSigner(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/", nil)
+	if err := s.Sign(req, []byte("The quick brown fox jumps over the lazy dog")); err != nil {
+		t.Fatal(err)
+	}
+	want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if got := req.Header.Get("X-Hub-Signature-256"); got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+}
+
+func TestEndpointOptions(t *testing.T) {
+	u, _ := url.Parse("https://api.example.com/rpc#sign=sigv4&region=eu-west-1")
+	opts, err := endpointOptions(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := opts.Get("region"), "eu-west-1"; got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+	if got, want := u.String(), "https://api.example.com/rpc"; got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+
+	u, _ = url.Parse("https://api.example.com/#sing=sigv4")
+	if _, err := endpointOptions(u); err == nil {
+		t.Errorf("expected an error for an unknown option")
+	}
+}
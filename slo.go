@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// latencySLO is a latency objective for a class of requests, that a
+// percentile of their latency stays under a threshold on each endpoint.
+type latencySLO struct {
+	Spec       string
+	Pattern    string        // Glob of the JSON-RPC method, or the HTTP method and path, or the path
+	Threshold  time.Duration // Latency that the percentile must not exceed
+	Percentile int
+}
+
+// parseSLO parses an objective written as "PATTERN < DURATION [pNN]", such
+// as "eth_call < 200ms p99", "eth_get* < 1s" or "GET /v1/* < 50ms p95". The
+// percentile defaults to p99.
+func parseSLO(spec string) (latencySLO, error) {
+	i := strings.LastIndexByte(spec, '<')
+	if i < 0 {
+		return latencySLO{}, fmt.Errorf("invalid slo, want PATTERN < DURATION [pNN]: %s", spec)
+	}
+	slo := latencySLO{
+		Spec:       spec,
+		Pattern:    strings.TrimSpace(spec[:i]),
+		Percentile: 99,
+	}
+	if slo.Pattern == "" {
+		return latencySLO{}, fmt.Errorf("invalid slo, missing pattern: %s", spec)
+	}
+	if _, err := path.Match(slo.Pattern, ""); err != nil {
+		return latencySLO{}, fmt.Errorf("invalid slo pattern %q: %w", slo.Pattern, err)
+	}
+	fields := strings.Fields(spec[i+1:])
+	if len(fields) == 0 || len(fields) > 2 {
+		return latencySLO{}, fmt.Errorf("invalid slo, want PATTERN < DURATION [pNN]: %s", spec)
+	}
+	var err error
+	if slo.Threshold, err = time.ParseDuration(fields[0]); err != nil || slo.Threshold <= 0 {
+		return latencySLO{}, fmt.Errorf("invalid slo threshold: %s", fields[0])
+	}
+	if len(fields) == 2 {
+		p, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(fields[1]), "p"))
+		if err != nil || !strings.HasPrefix(strings.ToLower(fields[1]), "p") || p < 1 || p > 100 {
+			return latencySLO{}, fmt.Errorf("invalid slo percentile, want p1 to p100: %s", fields[1])
+		}
+		slo.Percentile = p
+	}
+	return slo, nil
+}
+
+// Matches returns whether requests of the class are subject to the
+// objective. HTTP requests from the input can be matched by their path alone.
+func (slo latencySLO) Matches(class string) bool {
+	if class == "" {
+		return false
+	}
+	if ok, _ := path.Match(slo.Pattern, class); ok {
+		return true
+	}
+	if i := strings.IndexByte(class, ' '); i >= 0 {
+		ok, _ := path.Match(slo.Pattern, class[i+1:])
+		return ok
+	}
+	return false
+}
+
+// sloStats are the latencies of an endpoint's requests subject to an
+// objective.
+type sloStats struct {
+	timing histogram
+	within int // Number of requests under the threshold
+}
+
+// countSLOs records the latency of a successful response in the objectives
+// its request is subject to. Errors aren't counted, they're an availability
+// matter rather than a latency one.
+func (r *report) countSLOs(resp Response) {
+	if len(r.SLOs) == 0 || resp.Request == nil || resp.Err != nil {
+		return
+	}
+	class := requestClass(resp.Request)
+	for i, slo := range r.SLOs {
+		if !slo.Matches(class) {
+			continue
+		}
+		if r.sloStats == nil {
+			r.sloStats = make([]map[*Client]*sloStats, len(r.SLOs))
+		}
+		if r.sloStats[i] == nil {
+			r.sloStats[i] = map[*Client]*sloStats{}
+		}
+		stats, ok := r.sloStats[i][resp.client]
+		if !ok {
+			stats = &sloStats{}
+			if resp.client != nil {
+				stats.timing.Limit = resp.client.Stats.timing.Limit
+			}
+			r.sloStats[i][resp.client] = stats
+		}
+		stats.timing.Add(resp.Elapsed.Seconds())
+		if resp.Elapsed <= slo.Threshold {
+			stats.within += 1
+		}
+	}
+}
+
+// sloSummary is the machine-readable form of an objective's compliance.
+type sloSummary struct {
+	SLO        string               `json:"slo"`
+	Threshold  float64              `json:"threshold"` // Seconds
+	Percentile int                  `json:"percentile"`
+	Endpoints  []sloEndpointSummary `json:"endpoints"`
+}
+
+// sloEndpointSummary is the compliance of an endpoint with an objective.
+type sloEndpointSummary struct {
+	Endpoint string  `json:"endpoint"`
+	Name     string  `json:"name,omitempty"`
+	Requests int     `json:"requests"`
+	Latency  float64 `json:"latency"` // Seconds, at the objective's percentile
+	Within   float64 `json:"within"`  // Percent of requests under the threshold
+	Met      bool    `json:"met"`
+}
+
+// label returns the name of the endpoint if it has one, or its URI.
+func (e sloEndpointSummary) label() string {
+	return endpointLabel(e.Name, e.Endpoint)
+}
+
+// sloSummaries returns the compliance of every endpoint with every
+// objective, in the order they were given.
+func (r *report) sloSummaries() []sloSummary {
+	if len(r.SLOs) == 0 {
+		return nil
+	}
+	summaries := make([]sloSummary, 0, len(r.SLOs))
+	for i, slo := range r.SLOs {
+		s := sloSummary{
+			SLO:        slo.Spec,
+			Threshold:  slo.Threshold.Seconds(),
+			Percentile: slo.Percentile,
+			Endpoints:  make([]sloEndpointSummary, 0, len(r.Clients)),
+		}
+		for _, c := range r.Clients {
+			e := sloEndpointSummary{Endpoint: redactURL(c.Endpoint), Name: c.Name}
+			if i < len(r.sloStats) {
+				if stats, ok := r.sloStats[i][c]; ok {
+					e.Requests = stats.timing.Len()
+					e.Latency = stats.timing.Percentiles(slo.Percentile)[0]
+					e.Within = float64(stats.within*100) / float64(e.Requests)
+					e.Met = e.Latency <= slo.Threshold.Seconds()
+				}
+			}
+			s.Endpoints = append(s.Endpoints, e)
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
+
+// renderSLOs writes the compliance with every objective as part of the text
+// report.
+func renderSLOs(w io.Writer, colors palette, slos []sloSummary) {
+	if len(slos) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s\n", colors.Heading("** Latency SLOs:"))
+	for _, s := range slos {
+		fmt.Fprintf(w, "\n   %s\n", s.SLO)
+		for i, e := range s.Endpoints {
+			if e.Requests == 0 {
+				fmt.Fprintf(w, "     %d. %q: no requests\n", i, e.label())
+				continue
+			}
+			result := colors.paint(ansiGreen, "met")
+			if !e.Met {
+				result = colors.paint(ansiRed, "missed")
+			}
+			fmt.Fprintf(w, "     %d. %q: %s, %0.4fs p%d over %d requests, %0.2f%% under %s\n",
+				i, e.label(), result, e.Latency, s.Percentile, e.Requests, e.Within, time.Duration(s.Threshold*float64(time.Second)))
+		}
+	}
+}
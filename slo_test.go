@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSLO(t *testing.T) {
+	tests := []struct {
+		spec       string
+		pattern    string
+		threshold  time.Duration
+		percentile int
+		wantErr    bool
+	}{
+		{"eth_call < 200ms p99", "eth_call", 200 * time.Millisecond, 99, false},
+		{"eth_get*<1s", "eth_get*", time.Second, 99, false},
+		{"GET /v1/* < 50ms P95", "GET /v1/*", 50 * time.Millisecond, 95, false},
+		{"eth_call 200ms", "", 0, 0, true},
+		{" < 200ms", "", 0, 0, true},
+		{"eth_call < fast", "", 0, 0, true},
+		{"eth_call < 200ms p0", "", 0, 0, true},
+		{"eth_call < 200ms 99", "", 0, 0, true},
+		{"eth_[ < 200ms", "", 0, 0, true},
+	}
+	for _, tc := range tests {
+		slo, err := parseSLO(tc.spec)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%q: got error: %v; want error: %t", tc.spec, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if slo.Pattern != tc.pattern || slo.Threshold != tc.threshold || slo.Percentile != tc.percentile {
+			t.Errorf("%q: got: %+v", tc.spec, slo)
+		}
+	}
+}
+
+func TestSLOMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		class   string
+		want    bool
+	}{
+		{"eth_call", "eth_call", true},
+		{"eth_get*", "eth_getBalance", true},
+		{"eth_get*", "eth_call", false},
+		{"GET /v1/*", "GET /v1/users", true},
+		{"/v1/*", "POST /v1/users", true},
+		{"/v1/*", "/v1/users/1", false},
+		{"*", "", false},
+	}
+	for _, tc := range tests {
+		if got := (latencySLO{Pattern: tc.pattern}).Matches(tc.class); got != tc.want {
+			t.Errorf("%q matches %q: got: %t; want: %t", tc.pattern, tc.class, got, tc.want)
+		}
+	}
+}
+
+func TestReportSLOs(t *testing.T) {
+	a, b := &Client{Endpoint: "a"}, &Client{Endpoint: "b"}
+	slo, err := parseSLO("eth_call < 100ms p50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &report{Clients: Clients{a, b}, SLOs: []latencySLO{slo}}
+	call := &Request{Line: []byte(`{"method":"eth_call"}`)}
+	other := &Request{Line: []byte(`{"method":"eth_chainId"}`)}
+	for _, resp := range []Response{
+		{client: a, Request: call, Elapsed: 50 * time.Millisecond},
+		{client: a, Request: call, Elapsed: 80 * time.Millisecond},
+		{client: a, Request: other, Elapsed: time.Second},
+		{client: b, Request: call, Elapsed: 150 * time.Millisecond},
+		{client: b, Request: call, Elapsed: 90 * time.Millisecond},
+		{client: b, Request: call, Elapsed: 200 * time.Millisecond},
+		{client: b, Request: call, Elapsed: time.Millisecond, Err: errTimeout},
+	} {
+		r.countSLOs(resp)
+	}
+
+	summaries := r.sloSummaries()
+	if len(summaries) != 1 || len(summaries[0].Endpoints) != 2 {
+		t.Fatalf("got: %+v; want an objective with two endpoints", summaries)
+	}
+	ea, eb := summaries[0].Endpoints[0], summaries[0].Endpoints[1]
+	if ea.Requests != 2 || !ea.Met || ea.Within != 100 {
+		t.Errorf("got: %+v; want a met objective over 2 requests", ea)
+	}
+	if eb.Requests != 3 || eb.Met || eb.Latency != 0.2 {
+		t.Errorf("got: %+v; want a missed objective over 3 requests", eb)
+	}
+
+	var buf bytes.Buffer
+	renderSLOs(&buf, palette{}, summaries)
+	for _, want := range []string{"eth_call < 100ms p50", `0. "a": met`, `1. "b": missed, 0.2000s p50 over 3 requests, 33.33% under 100ms`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got:\n%s\nwant: %s", buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// isEventStream returns whether the response is a stream of server-sent
+// events.
+func isEventStream(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// sseEvent is the comparable form of a server-sent event. Event ids and
+// retry intervals are dropped, since they differ between endpoints.
+type sseEvent struct {
+	Event string          `json:"event,omitempty"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// readEventStream collects the events of a server-sent event stream for the
+// subscription window, or until the stream ends if there is no window, and
+// returns them as a normalized body.
+func readEventStream(body io.ReadCloser, encoding string, opts subscriptionOptions, resp *Response) error {
+	var expired int32
+	if opts.Window > 0 {
+		timer := time.AfterFunc(opts.Window, func() {
+			atomic.StoreInt32(&expired, 1)
+			body.Close()
+		})
+		defer timer.Stop()
+	}
+	received := &countingReader{Reader: body}
+	r, err := decodeReader(received, encoding)
+	if err != nil {
+		return err
+	}
+
+	var stream notificationStream
+	var event string
+	var data []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// A blank line dispatches the event, if it has data
+			if data != nil {
+				stream.Add(sseEventBody(event, strings.Join(data, "\n"), opts.IgnoreFields))
+			}
+			event, data = "", nil
+			continue
+		}
+		if line[0] == ':' {
+			continue // Comment
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	resp.Size = received.n
+	if err := scanner.Err(); err != nil && atomic.LoadInt32(&expired) == 0 {
+		return err
+	}
+	resp.decoded = true
+	resp.Body, err = stream.Body(opts.Unordered)
+	return err
+}
+
+// sseEventBody returns the event as JSON. Data that is JSON is kept as JSON
+// without the ignored fields, so that volatile fields such as timestamps
+// don't make streams differ.
+func sseEventBody(event, data string, ignore []string) json.RawMessage {
+	e := sseEvent{Event: event}
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err == nil && !dec.More() {
+		if len(ignore) > 0 {
+			v = dropFields(v, ignore)
+		}
+		e.Data, _ = marshalJSON(v)
+	}
+	if e.Data == nil {
+		e.Data, _ = marshalJSON(data)
+	}
+	body, _ := marshalJSON(e)
+	return body
+}
+
+// dropFields removes the object fields with any of the names from a JSON
+// value, at any depth.
+func dropFields(v interface{}, names []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range names {
+			delete(v, name)
+		}
+		for k, child := range v {
+			v[k] = dropFields(child, names)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = dropFields(child, names)
+		}
+	}
+	return v
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEventStream(t *testing.T) {
+	// Streams of two endpoints, with different ids and timestamps
+	handler := func(id int, hold bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, ": connected\n\nretry: 1000\n\n")
+			fmt.Fprintf(w, "id: %d\nevent: update\ndata: {\"n\":1,\"meta\":{\"ts\":%d}}\n\n", id, id*1000)
+			fmt.Fprintf(w, "id: %d\ndata: first line\ndata: second line\n\n", id+1)
+			w.(http.Flusher).Flush()
+			if hold {
+				<-r.Context().Done()
+			}
+		}
+	}
+	a := httptest.NewServer(handler(1, false))
+	defer a.Close()
+	b := httptest.NewServer(handler(7, true))
+	defer b.Close()
+
+	send := func(endpoint string, opts subscriptionOptions) Response {
+		t.Helper()
+		tr, err := NewTransport(endpoint, transportOptions{Timeout: 5 * time.Second, Subscriptions: opts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp Response
+		if err := tr.Send(context.Background(), &Request{Path: "/events"}, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Without a window, the stream is read until it ends
+	got := send(a.URL, subscriptionOptions{IgnoreFields: []string{"ts"}})
+	want := `{"notifications":[{"event":"update","data":{"meta":{},"n":1}},{"data":"first line\nsecond line"}]}`
+	if string(got.Body) != want {
+		t.Errorf("got: %s; want: %s", got.Body, want)
+	}
+
+	// A stream that doesn't end is read for the window
+	start := time.Now()
+	other := send(b.URL, subscriptionOptions{Window: 100 * time.Millisecond, IgnoreFields: []string{"ts"}})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("read the stream for %s; want about the window", elapsed)
+	}
+	if !got.Equal(other) {
+		t.Errorf("got: %s; want equal to: %s", other.Body, got.Body)
+	}
+
+	// Timestamps differ unless they're ignored
+	withTimestamps := send(b.URL, subscriptionOptions{Window: 100 * time.Millisecond})
+	if got.Equal(withTimestamps) {
+		t.Errorf("got equal streams with different timestamps: %s", withTimestamps.Body)
+	}
+}
+
+func TestEventStreamTimeout(t *testing.T) {
+	stream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; ; i++ {
+			fmt.Fprintf(w, "data: {\"n\":%d}\n\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}))
+	defer stream.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"partial":`)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+	send := func(endpoint string, opts transportOptions) (Response, error) {
+		t.Helper()
+		tr, err := NewTransport(endpoint, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp Response
+		err = tr.Send(context.Background(), &Request{Path: "/events"}, &resp)
+		return resp, err
+	}
+
+	// The window is longer than the request timeout
+	window := subscriptionOptions{Window: 300 * time.Millisecond}
+	resp, err := send(stream.URL, transportOptions{Timeout: 50 * time.Millisecond, Subscriptions: window})
+	if err != nil {
+		t.Fatalf("got %v; want the events of the window", err)
+	}
+	if len(resp.Body) < 100 {
+		t.Errorf("got %s; want the events of the whole window", resp.Body)
+	}
+
+	// The collected events are capped like any other body
+	resp, err = send(stream.URL, transportOptions{Timeout: time.Second, Subscriptions: window, MaxBodySize: 20, Oversize: oversizeTruncate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Body) != 20 || resp.Oversize == 0 || resp.Size < 100 {
+		t.Errorf("got body %q, oversize %d, size %d; want 20 bytes kept of the whole stream", resp.Body, resp.Oversize, resp.Size)
+	}
+
+	// Other bodies are still bounded by the timeout
+	started := time.Now()
+	if _, err := send(slow.URL, transportOptions{Timeout: 50 * time.Millisecond}); !errors.Is(err, errTimeout) {
+		t.Errorf("got %v; want a timeout", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("took %s to time out; want about the timeout", elapsed)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// subscriptionOptions configure how subscription requests (e.g.
+// eth_subscribe) are handled by streaming transports.
+type subscriptionOptions struct {
+	Window    time.Duration // Duration to collect notifications for, or 0 to disable
+	Unordered bool          // Compare notifications as a set rather than a sequence
+
+	IgnoreFields []string // Fields dropped from the JSON data of server-sent events
+}
+
+// rpcMessage is the subset of a JSON-RPC message that is relevant for
+// telling responses and subscription notifications apart.
+type rpcMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+	Params json.RawMessage `json:"params"`
+}
+
+// notification is the params of a subscription notification.
+type notification struct {
+	Subscription json.RawMessage `json:"subscription"`
+	Result       json.RawMessage `json:"result"`
+}
+
+// isNotification returns true for JSON-RPC messages that are not responses,
+// such as subscription notifications.
+func isNotification(msg []byte) bool {
+	var m rpcMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return false
+	}
+	return m.Method != "" && len(m.ID) == 0
+}
+
+// subscriptionRequest returns the method and id of a JSON-RPC subscription
+// request, which is any method ending in "_subscribe".
+func subscriptionRequest(line []byte) (method string, id json.RawMessage, ok bool) {
+	if len(line) == 0 || line[0] != '{' {
+		return "", nil, false
+	}
+	var m rpcMessage
+	if err := json.Unmarshal(line, &m); err != nil {
+		return "", nil, false
+	}
+	if !strings.HasSuffix(m.Method, "_subscribe") {
+		return "", nil, false
+	}
+	return m.Method, m.ID, true
+}
+
+// unsubscribeRequest builds the request that cancels a subscription created
+// with the given method, e.g. eth_unsubscribe for eth_subscribe.
+func unsubscribeRequest(method string, id json.RawMessage, subscription json.RawMessage) ([]byte, error) {
+	if len(id) == 0 {
+		id = json.RawMessage("1")
+	}
+	return marshalJSON(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  strings.TrimSuffix(method, "_subscribe") + "_unsubscribe",
+		"params":  []json.RawMessage{subscription},
+	})
+}
+
+// notificationStream is the normalized body that a subscription is compared
+// by. Subscription ids are dropped, since they differ between endpoints.
+type notificationStream struct {
+	Notifications []json.RawMessage `json:"notifications"`
+}
+
+func (s *notificationStream) Add(result json.RawMessage) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, result); err == nil {
+		result = buf.Bytes()
+	}
+	s.Notifications = append(s.Notifications, result)
+}
+
+func (s *notificationStream) Body(unordered bool) ([]byte, error) {
+	if s.Notifications == nil {
+		s.Notifications = []json.RawMessage{}
+	}
+	if unordered {
+		sort.Slice(s.Notifications, func(i, j int) bool {
+			return bytes.Compare(s.Notifications[i], s.Notifications[j]) < 0
+		})
+	}
+	return marshalJSON(s)
+}
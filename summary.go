@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// reportBuckets are the percentiles included in reports.
+var reportBuckets = []int{25, 50, 75, 90, 95, 99}
+
+// timingSummary is a latency distribution, in seconds.
+type timingSummary struct {
+	Avg         float64            `json:"avg"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Stddev      float64            `json:"stddev"`
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+// endpointSummary is the machine-readable form of an endpoint's stats.
+type endpointSummary struct {
+	Endpoint      string         `json:"endpoint"`
+	Name          string         `json:"name,omitempty"`
+	Joined        string         `json:"joined,omitempty"` // RFC 3339, if it joined mid-run
+	Requests      int            `json:"requests"`
+	Errors        int            `json:"errors"`
+	ErrorRate     float64        `json:"error_rate"` // Percent
+	RPS           float64        `json:"rps"`
+	Cached        int            `json:"cached,omitempty"`
+	Reconnects    int            `json:"reconnects,omitempty"`
+	BytesReceived int            `json:"bytes_received"`
+	BytesDecoded  int            `json:"bytes_decoded"`
+	Timing        timingSummary  `json:"timing"`
+	Phases        *phasesSummary `json:"phases,omitempty"`  // Of successful HTTP requests
+	Cost          *costSummary   `json:"cost,omitempty"`    // With a cost table
+	Head          *headSummary   `json:"head,omitempty"`    // With chain head checks
+	Behind        int            `json:"behind,omitempty"`  // Requests sent and not answered yet
+	Queued        int            `json:"queued,omitempty"`  // Requests waiting in the endpoint's queue
+	Blocked       float64        `json:"blocked,omitempty"` // Seconds the input waited for room in the queue
+	Shed          int            `json:"shed,omitempty"`    // Requests dropped with a full queue
+	Outlier       int            `json:"outlier,omitempty"` // Mismatched results where it disagreed with the majority
+	ErrorMessages map[string]int `json:"error_messages,omitempty"`
+}
+
+// reportSummary is the machine-readable form of a report.
+type reportSummary struct {
+	Version       string            `json:"version"`
+	Group         string            `json:"group,omitempty"`
+	Endpoints     []endpointSummary `json:"endpoints"`
+	Completed     int               `json:"completed"`
+	Requests      int               `json:"requests"`
+	Errors        int               `json:"errors"`
+	ErrorRate     float64           `json:"error_rate"` // Percent
+	Mismatched    int               `json:"mismatched"`
+	MismatchRate  float64           `json:"mismatch_rate"`       // Percent of completed
+	Transient     int               `json:"transient,omitempty"` // Mismatched until rechecked
+	Unchecked     int               `json:"unchecked,omitempty"` // Mismatched and not rechecked, with too many in flight
+	Lagging       int               `json:"lagging,omitempty"`   // Mismatched while endpoints were at different heights
+	Quorum        int               `json:"quorum,omitempty"`    // Mismatched with a majority of three or more endpoints
+	NoQuorum      int               `json:"no_quorum,omitempty"` // Mismatched without a majority of three or more endpoints
+	Cached        int               `json:"cached,omitempty"`
+	Skipped       int               `json:"skipped,omitempty"`
+	Dropped       int               `json:"dropped,omitempty"`
+	Shed          int               `json:"shed,omitempty"`
+	Pending       int               `json:"pending,omitempty"`
+	Overloaded    int               `json:"overloaded,omitempty"`
+	AvgRequest    float64           `json:"avg_request"` // Seconds
+	RunTime       float64           `json:"run_time"`    // Seconds
+	Self          *selfSummary      `json:"self,omitempty"`
+	Tags          []tagSummary      `json:"tags,omitempty"`
+	DroppedTags   int               `json:"dropped_tags,omitempty"`
+	SLOs          []sloSummary      `json:"slos,omitempty"`
+	Headers       []headerSummary   `json:"headers,omitempty"`
+	Canary        *canarySummary    `json:"canary,omitempty"`
+	Patterns      []patternSummary  `json:"mismatch_patterns,omitempty"`
+	OtherPatterns int               `json:"other_patterns,omitempty"` // Mismatched sets beyond maxPatterns
+}
+
+// endpointLabel returns the name of an endpoint in reports: its name if it
+// has one, or its URI without secrets.
+func endpointLabel(name, endpoint string) string {
+	if name != "" {
+		return name
+	}
+	return redactURL(endpoint)
+}
+
+// label returns the name of the endpoint if it has one, or its URI.
+func (e endpointSummary) label() string {
+	return endpointLabel(e.Name, e.Endpoint)
+}
+
+// Summary returns a snapshot of the stats.
+func (stats *clientStats) Summary() endpointSummary {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	s := endpointSummary{
+		Requests:      stats.numTotal,
+		Errors:        stats.numErrors,
+		Cached:        stats.numCached,
+		Reconnects:    stats.numReconnects,
+		BytesReceived: stats.bytesReceived,
+		BytesDecoded:  stats.bytesDecoded,
+		Timing: timingSummary{
+			Percentiles: make(map[string]float64, len(reportBuckets)),
+		},
+		Phases: stats.phases.Summary(),
+	}
+	if len(stats.errors) > 0 {
+		s.ErrorMessages = make(map[string]int, len(stats.errors))
+		for msg, n := range stats.errors {
+			s.ErrorMessages[msg] = n
+		}
+	}
+	if stats.numTotal == 0 {
+		return s
+	}
+
+	concurrency := 1
+	if stats.Concurrency > 0 {
+		concurrency = stats.Concurrency
+	}
+	s.ErrorRate = float64(stats.numErrors*100) / float64(stats.numTotal)
+	if total := stats.timing.Total(); total > 0 {
+		s.RPS = float64(stats.numTotal*concurrency) / total
+	}
+	s.Timing = summarizeTiming(&stats.timing)
+	return s
+}
+
+// summarizeTiming returns the distribution of a histogram of latencies.
+func summarizeTiming(h *histogram) timingSummary {
+	t := timingSummary{
+		Avg:         h.Average(),
+		Min:         h.Min(),
+		Max:         h.Max(),
+		Stddev:      math.Sqrt(h.Variance()),
+		Percentiles: make(map[string]float64, len(reportBuckets)),
+	}
+	for i, p := range h.Percentiles(reportBuckets...) {
+		t.Percentiles[strconv.Itoa(reportBuckets[i])] = p
+	}
+	return t
+}
+
+// Summary returns a snapshot of the report. It must be called from the same
+// goroutine as Serve, or after Serve has returned.
+func (r *report) Summary() reportSummary {
+	s := reportSummary{
+		Version:       Version,
+		Group:         r.Group,
+		Endpoints:     make([]endpointSummary, 0, len(r.Clients)),
+		Completed:     r.completed,
+		Requests:      r.requests,
+		Errors:        r.errors,
+		Mismatched:    r.mismatched,
+		Transient:     r.transient,
+		Unchecked:     r.unchecked,
+		Lagging:       r.lagging,
+		Quorum:        r.quorum,
+		NoQuorum:      r.noQuorum,
+		Cached:        r.cached,
+		Skipped:       r.skipped,
+		Dropped:       r.dropped,
+		Shed:          r.shed,
+		Pending:       len(r.pendingResponses) + r.rechecking,
+		Overloaded:    r.overloaded,
+		Tags:          r.tagSummaries(),
+		DroppedTags:   r.droppedTags,
+		SLOs:          r.sloSummaries(),
+		Headers:       r.headerSummaries(),
+		Canary:        r.Canary.Summary(),
+		Patterns:      r.patternSummaries(),
+		OtherPatterns: r.otherPatterns,
+	}
+	for _, c := range r.Clients {
+		endpoint := c.Stats.Summary()
+		endpoint.Endpoint = redactURL(c.Endpoint)
+		endpoint.Name = c.Name
+		endpoint.Cost = r.costSummary(c)
+		endpoint.Head = r.Heads.Summary(c)
+		endpoint.Behind, endpoint.Queued = c.Behind(), c.Queued()
+		endpoint.Blocked = c.Blocked().Seconds()
+		endpoint.Shed = c.Shed()
+		endpoint.Outlier = r.outliers[c]
+		if !c.Joined.IsZero() {
+			endpoint.Joined = c.Joined.Format(time.RFC3339)
+		}
+		s.Endpoints = append(s.Endpoints, endpoint)
+	}
+	if r.requests > 0 {
+		s.ErrorRate = float64(r.errors*100) / float64(r.requests)
+		s.AvgRequest = (r.elapsed / time.Duration(r.requests)).Seconds()
+	}
+	if r.completed > 0 {
+		s.MismatchRate = float64(r.mismatched*100) / float64(r.completed)
+	}
+	if !r.started.IsZero() {
+		s.RunTime = time.Now().Sub(r.started).Seconds()
+	}
+	if r.Self != nil {
+		self := r.Self.Summary()
+		s.Self = &self
+	}
+	return s
+}
+
+// RenderJSON writes the report summary as JSON.
+func (r *report) RenderJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.Summary())
+}
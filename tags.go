@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// maxTags is the number of distinct tags that stats are kept for. Requests
+// with further tags are only counted in the overall stats, so that a tag with
+// unbounded values can't exhaust memory.
+const maxTags = 1000
+
+// tagStats are the stats of the requests with a tag.
+type tagStats struct {
+	completed  int // Number of completed response sets
+	mismatched int // Number of mismatched response sets
+	skipped    int // Number of response sets not compared because of their size
+	endpoints  map[*Client]*clientStats
+}
+
+// tag returns the stats of the tag, or nil if too many tags are tracked
+// already.
+func (r *report) tag(tag string) *tagStats {
+	if ts, ok := r.tags[tag]; ok {
+		return ts
+	}
+	if r.tags == nil {
+		r.tags = map[string]*tagStats{}
+	}
+	if len(r.tags) >= maxTags {
+		if r.droppedTags == 0 {
+			logger.Warn().Int("max", maxTags).Str("tag", tag).Msg("too many distinct tags, ignoring new ones")
+		}
+		r.droppedTags += 1
+		return nil
+	}
+	ts := &tagStats{endpoints: map[*Client]*clientStats{}}
+	r.tags[tag] = ts
+	return ts
+}
+
+// countTags records the response in the stats of its request's tags.
+func (r *report) countTags(resp Response) {
+	if resp.Request == nil {
+		return
+	}
+	for _, tag := range resp.Request.Tags {
+		ts := r.tag(tag)
+		if ts == nil {
+			continue
+		}
+		stats, ok := ts.endpoints[resp.client]
+		if !ok {
+			stats = &clientStats{}
+			if resp.client != nil {
+				stats.Concurrency = resp.client.Concurrency
+				stats.timing.Limit = resp.client.Stats.timing.Limit
+			}
+			ts.endpoints[resp.client] = stats
+		}
+		if resp.Cached {
+			stats.CountCached()
+		} else {
+			stats.Count(resp.Err, resp.Elapsed)
+		}
+	}
+}
+
+// completeTags records the outcome of a response set in the stats of its
+// request's tags.
+func (r *report) completeTags(req *Request, mismatched, skipped bool) {
+	if req == nil {
+		return
+	}
+	for _, tag := range req.Tags {
+		ts := r.tags[tag]
+		if ts == nil {
+			continue
+		}
+		ts.completed += 1
+		if mismatched {
+			ts.mismatched += 1
+		}
+		if skipped {
+			ts.skipped += 1
+		}
+	}
+}
+
+// tagSummary is the machine-readable form of a tag's stats.
+type tagSummary struct {
+	Tag          string            `json:"tag"`
+	Completed    int               `json:"completed"`
+	Mismatched   int               `json:"mismatched"`
+	MismatchRate float64           `json:"mismatch_rate"` // Percent of completed
+	Skipped      int               `json:"skipped,omitempty"`
+	Endpoints    []endpointSummary `json:"endpoints"`
+}
+
+// tagSummaries returns the stats of every tag, sorted by tag.
+func (r *report) tagSummaries() []tagSummary {
+	if len(r.tags) == 0 {
+		return nil
+	}
+	tags := make([]string, 0, len(r.tags))
+	for tag := range r.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	summaries := make([]tagSummary, 0, len(tags))
+	for _, tag := range tags {
+		ts := r.tags[tag]
+		s := tagSummary{
+			Tag:        tag,
+			Completed:  ts.completed,
+			Mismatched: ts.mismatched,
+			Skipped:    ts.skipped,
+			Endpoints:  make([]endpointSummary, 0, len(r.Clients)),
+		}
+		if ts.completed > 0 {
+			s.MismatchRate = float64(ts.mismatched*100) / float64(ts.completed)
+		}
+		for _, c := range r.Clients {
+			stats, ok := ts.endpoints[c]
+			if !ok {
+				stats = &clientStats{}
+			}
+			endpoint := stats.Summary()
+			endpoint.Endpoint = redactURL(c.Endpoint)
+			endpoint.Name = c.Name
+			s.Endpoints = append(s.Endpoints, endpoint)
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
+
+// renderTags writes the stats of every tag as part of the text report.
+func renderTags(w io.Writer, tags []tagSummary, dropped int) {
+	if len(tags) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n** By tag:\n")
+	for _, s := range tags {
+		fmt.Fprintf(w, "\n   %s: %d results, %d mismatched (%0.2f%%)", s.Tag, s.Completed, s.Mismatched, s.MismatchRate)
+		if s.Skipped > 0 {
+			fmt.Fprintf(w, ", %d skipped", s.Skipped)
+		}
+		fmt.Fprintf(w, "\n")
+		for i, e := range s.Endpoints {
+			fmt.Fprintf(w, "     %d. %q: %d requests, %d errors (%0.2f%%), %0.4fs avg, %0.4fs p50, %0.4fs p99\n",
+				i, e.label(), e.Requests, e.Errors, e.ErrorRate, e.Timing.Avg, e.Timing.Percentiles["50"], e.Timing.Percentiles["99"])
+		}
+	}
+	if dropped > 0 {
+		fmt.Fprintf(w, "\n   Tags beyond the first %d were ignored for %d responses.\n", maxTags, dropped)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleDialer wraps the dial function of an endpoint so that its
+// connections simulate a poor network, as set by its options, or returns it
+// as-is if it has none:
+//
+//	http://backend:8545/#name=3g&download=200KB&upload=50KB&latency=150ms
+//
+// download and upload cap the bandwidth of the endpoint's connections in bytes
+// per second (a trailing /s is allowed), shared by the connections of each
+// concurrent client. latency is added to each response, once the request is
+// written, and to connecting.
+func throttleDialer(dial dialFunc, opts url.Values) (dialFunc, error) {
+	download, err := parseBandwidth(opts.Get("download"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid download: %w", err)
+	}
+	upload, err := parseBandwidth(opts.Get("upload"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload: %w", err)
+	}
+	var latency time.Duration
+	if s := opts.Get("latency"); s != "" {
+		if latency, err = time.ParseDuration(s); err != nil || latency < 0 {
+			return nil, fmt.Errorf("invalid latency: %s", s)
+		}
+	}
+	if download == 0 && upload == 0 && latency == 0 {
+		return dial, nil
+	}
+
+	if dial == nil {
+		// Same as http.DefaultTransport's
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		dial = dialer.DialContext
+	}
+	var reads, writes *bandwidthLimiter
+	if download > 0 {
+		reads = &bandwidthLimiter{rate: download}
+	}
+	if upload > 0 {
+		writes = &bandwidthLimiter{rate: upload}
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if !sleep(ctx, latency) {
+			return nil, ctx.Err()
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &throttledConn{
+			Conn:    conn,
+			reads:   reads,
+			writes:  writes,
+			latency: latency,
+			closed:  make(chan struct{}),
+		}, nil
+	}, nil
+}
+
+// parseBandwidth parses a size per second, such as 1MB or 1MB/s, or returns 0
+// if it's empty.
+func parseBandwidth(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := parseSize(strings.TrimSuffix(s, "/s"))
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("bandwidth must be positive: %s", s)
+	}
+	return n, nil
+}
+
+// bandwidthLimiter spaces out bytes to a rate, like a link they all go
+// through.
+type bandwidthLimiter struct {
+	rate int // Bytes per second
+
+	mu   sync.Mutex
+	next time.Time // When the link is free
+}
+
+// Reserve returns how long until n more bytes have gone through the link.
+func (l *bandwidthLimiter) Reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+	return l.next.Sub(now)
+}
+
+// Chunk is how many bytes go through at once, about a tenth of a second's
+// worth, so that transfers flow instead of bursting.
+func (l *bandwidthLimiter) Chunk() int {
+	chunk := l.rate / 10
+	if chunk < 512 {
+		return 512
+	}
+	if chunk > 64*1024 {
+		return 64 * 1024
+	}
+	return chunk
+}
+
+var errConnClosed = errors.New("connection closed")
+
+// throttledConn is a connection with limited bandwidth and added latency.
+type throttledConn struct {
+	net.Conn
+	reads, writes *bandwidthLimiter // Unlimited if nil
+	latency       time.Duration
+
+	mu      sync.Mutex
+	written bool // Whether a write is waiting for its response
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	if c.reads != nil {
+		if chunk := c.reads.Chunk(); len(p) > chunk {
+			p = p[:chunk]
+		}
+	}
+	// Clients like net/http read ahead, so the response is delayed once it
+	// arrives rather than when the read starts
+	n, err := c.Conn.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	var delay time.Duration
+	c.mu.Lock()
+	if c.written {
+		delay = c.latency
+		c.written = false
+	}
+	c.mu.Unlock()
+	if c.reads != nil {
+		delay += c.reads.Reserve(n)
+	}
+	if !c.wait(delay) {
+		return n, errConnClosed
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.written = c.latency > 0
+	c.mu.Unlock()
+	if c.writes == nil {
+		return c.Conn.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if size := c.writes.Chunk(); len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		if !c.wait(c.writes.Reserve(len(chunk))) {
+			return written, errConnClosed
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *throttledConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// wait sleeps for the duration, and returns false if the connection is closed
+// meanwhile.
+func (c *throttledConn) wait(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-c.closed:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestThrottleDialer(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 100*1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			ioutil.ReadAll(r.Body)
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		fragment string
+		line     []byte
+		want     time.Duration
+	}{
+		// Two exchanges of one connection: connecting, then each response
+		{"/#latency=50ms", []byte(`{}`), 150 * time.Millisecond},
+		{"/#download=500KB/s", []byte(`{}`), 400 * time.Millisecond},
+		{"/upload#upload=500KB", body, 400 * time.Millisecond},
+	}
+	for _, tc := range tests {
+		tr, err := NewTransport(srv.URL+tc.fragment, transportOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		started := time.Now()
+		for i := 0; i < 2; i++ {
+			var resp Response
+			if err := tr.Send(context.Background(), &Request{Line: tc.line}, &resp); err != nil {
+				t.Fatalf("%s: %s", tc.fragment, err)
+			}
+		}
+		if got := time.Since(started); got < tc.want || got > tc.want*3 {
+			t.Errorf("%s: took %s; want about: %s", tc.fragment, got, tc.want)
+		}
+	}
+
+	for _, fragment := range []string{"download=fast", "upload=0", "latency=-1s", "latency=1"} {
+		if _, err := throttleDialer(nil, mustParseQuery(t, fragment)); err == nil {
+			t.Errorf("%s: expected an error", fragment)
+		}
+	}
+	if dial, err := throttleDialer(nil, url.Values{}); dial != nil || err != nil {
+		t.Errorf("got: %v, %v; want no dialer", dial != nil, err)
+	}
+}
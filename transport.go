@@ -2,50 +2,190 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// transportOptions are the settings that a client creates its transports with.
+type transportOptions struct {
+	Timeout        time.Duration // Timeout of each request
+	AcceptEncoding string        // Accept-Encoding header of HTTP requests
+	HashBodies     bool          // Hash HTTP response bodies as a stream instead of keeping them
+	NoKeepAlive    bool          // Open a new HTTP connection for every request, unless the endpoint sets keepalive
+	Redirects      bool          // Record the redirect chains of HTTP responses to compare them
+	MaxBodySize    int           // Limit of response body bytes kept, 0 is unlimited
+	Oversize       string        // Policy for bodies over the limit
+	SpillSize      int           // Bodies larger than this are spilled to disk, 0 never spills
+	SpillDir       string        // Directory of spilled bodies
+	PingInterval   time.Duration // Keepalive of idle websocket and MQTT connections, 0 never pings
+	Subscriptions  subscriptionOptions
+}
+
+// endpointOptionNames are the per-endpoint options that can be set in the
+// fragment of an endpoint URI.
+var endpointOptionNames = map[string]bool{
+	"sign": true, "region": true, "service": true,
+	"hmac-key-env": true, "hmac-header": true, "hmac-prefix": true, "hmac-encoding": true,
+	"oauth2-token-url": true, "oauth2-client-id": true, "oauth2-client-secret-env": true, "oauth2-scope": true,
+	"name": true, "concurrency": true, "keepalive": true,
+	"bind": true, "interface": true, "ip": true,
+	"host": true, "sni": true, "redirects": true,
+	"download": true, "upload": true, "latency": true,
+	"response-topic": true, "codec": true,
+}
+
+// endpointOptions parses the per-endpoint options in the fragment of the
+// endpoint URI, such as "https://api.example.com/#sign=sigv4", and removes
+// the fragment.
+func endpointOptions(u *url.URL) (url.Values, error) {
+	opts, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint options: %w", err)
+	}
+	for name := range opts {
+		if !endpointOptionNames[name] {
+			return nil, fmt.Errorf("unknown endpoint option: %s", name)
+		}
+	}
+	u.Fragment = ""
+	return opts, nil
+}
+
 // NewTransport creates a transport that supports the given endpoint. The
 // endpoint is a URI with a scheme and an optional mode, for example
-// "https+get://infura.io/".
-func NewTransport(endpoint string, timeout time.Duration) (Transport, error) {
+// "https+get://infura.io/", and optional per-endpoint options in its
+// fragment.
+func NewTransport(endpoint string, opts transportOptions) (Transport, error) {
 	url, err := url.Parse(endpoint)
 	if err != nil {
 		return nil, err
 	}
+	endpointOpts, err := endpointOptions(url)
+	if err != nil {
+		return nil, err
+	}
 	scheme, mode := url.Scheme, ""
 	if parts := strings.Split(scheme, "+"); len(parts) > 1 {
 		scheme, mode = parts[0], parts[1]
 	}
+	dial, err := endpointDialer(endpointOpts)
+	if err != nil {
+		return nil, err
+	}
+	if dial, err = throttleDialer(dial, endpointOpts); err != nil {
+		return nil, err
+	}
+	host, tlsConfig, err := endpointHost(endpointOpts, scheme)
+	if err != nil {
+		return nil, err
+	}
 	var t Transport
 	switch scheme {
 	case "http", "https":
 		url.Scheme = scheme
+		signer, err := newRequestSigner(endpointOpts)
+		if err != nil {
+			return nil, err
+		}
+		tokens, err := newTokenSource(endpointOpts, opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// Bodies are decoded by us so that the size on the wire is known
+		transport.DisableCompression = true
+		switch endpointOpts.Get("keepalive") {
+		case "":
+			transport.DisableKeepAlives = opts.NoKeepAlive
+		case "on":
+		case "off":
+			transport.DisableKeepAlives = true
+		default:
+			return nil, fmt.Errorf("invalid keepalive: %s", endpointOpts.Get("keepalive"))
+		}
+		if dial != nil {
+			transport.DialContext = dial
+		}
+		if tlsConfig != nil {
+			transport.TLSClientConfig = tlsConfig
+		}
+		checkRedirect, err := redirectPolicy(endpointOpts.Get("redirects"))
+		if err != nil {
+			return nil, err
+		}
 		t = &httpTransport{
-			Client:      http.Client{Timeout: timeout},
-			endpoint:    url.String(),
-			contentType: "application/json",
-			bodyReader: func(body io.ReadCloser) ([]byte, error) {
+			Client: http.Client{
+				Timeout:       opts.Timeout,
+				Transport:     transport,
+				CheckRedirect: checkRedirect,
+			},
+			endpoint:       url.String(),
+			base:           *url,
+			host:           host,
+			redirects:      opts.Redirects,
+			contentType:    "application/json",
+			acceptEncoding: opts.AcceptEncoding,
+			hashBodies:     opts.HashBodies,
+			maxBodySize:    opts.MaxBodySize,
+			oversize:       opts.Oversize,
+			spillSize:      opts.SpillSize,
+			spillDir:       opts.SpillDir,
+			subscriptions:  opts.Subscriptions,
+			signer:         signer,
+			tokens:         tokens,
+			codec:          endpointOpts.Get("codec"),
+			bodyReader: func(body io.ReadCloser, resp *Response) error {
 				defer body.Close()
-				return ioutil.ReadAll(body)
+				return readPooledBody(body, resp)
 			},
 		}
 	case "ws", "wss":
-		conn, _, err := websocket.DefaultDialer.Dial(url.String(), nil)
+		if endpointOpts.Get("sign") != "" || endpointOpts.Get("oauth2-token-url") != "" {
+			return nil, fmt.Errorf("request signing and oauth2 are only supported over http")
+		}
+		if endpointOpts.Get("keepalive") != "" || endpointOpts.Get("redirects") != "" {
+			return nil, fmt.Errorf("keepalive and redirects are only supported over http")
+		}
+		dialer := *websocket.DefaultDialer
+		dialer.NetDialContext = dial
+		dialer.TLSClientConfig = tlsConfig
+		var header http.Header
+		if host != "" {
+			header = http.Header{"Host": {host}}
+		}
+		conn, _, err := dialer.Dial(url.String(), header)
 		if err != nil {
 			return nil, fmt.Errorf("Got: %s when connecting to ws", err)
 		}
-		t = &websocketTransport{
-			ws: conn,
+		t = newWebsocketTransport(conn, opts)
+	case "dns":
+		for name := range endpointOpts {
+			if name != "name" && name != "concurrency" {
+				return nil, fmt.Errorf("%s is not supported over dns", name)
+			}
+		}
+		t = newDNSTransport(url.Host, opts)
+	case "mqtt", "mqtts":
+		for _, name := range []string{"sign", "oauth2-token-url", "keepalive", "redirects", "host"} {
+			if endpointOpts.Get(name) != "" {
+				return nil, fmt.Errorf("%s is not supported over mqtt", name)
+			}
+		}
+		t, err = newMQTTTransport(url, tlsConfig, dial, endpointOpts, opts)
+		if err != nil {
+			return nil, err
 		}
 	case "noop":
 		t = &noopTransport{}
@@ -53,6 +193,9 @@ func NewTransport(endpoint string, timeout time.Duration) (Transport, error) {
 		return nil, fmt.Errorf("unsupported transport: %s", scheme)
 	}
 
+	if endpointOpts.Get("codec") != "" && mode != "grpc" && mode != "grpc-web" && mode != "connect" {
+		return nil, fmt.Errorf("codec is only supported with the grpc, grpc-web and connect modes of http")
+	}
 	if mode == "" {
 		return t, nil
 	}
@@ -62,27 +205,64 @@ func NewTransport(endpoint string, timeout time.Duration) (Transport, error) {
 	return nil, fmt.Errorf("transport is not modal: %s", scheme)
 }
 
+var errTimeout = errors.New("timed out waiting for response")
+
+// pingTimeout bounds the time spent sending a keepalive.
+const pingTimeout = 10 * time.Second
+
 // Modal is a type of Transport that has multiple modes for interpreting the
 // payloads sent to it. Not all transports support modes.
 type Modal interface {
 	Mode(string) error
 }
+
+// Preparer is a type of Transport that has work to do before a request is
+// sent, such as fetching credentials, which isn't part of its timing.
+type Preparer interface {
+	Prepare(ctx context.Context, req *Request) error
+}
+
 type Transport interface {
-	// TODO: Add context?
-	// TODO: Should this be: Do(Request) (Response, error)?
-	Send(body []byte) ([]byte, error)
+	// Send sends the request and fills in the response body and any metadata
+	// that the transport has.
+	Send(ctx context.Context, req *Request, resp *Response) error
 }
 
 type httpTransport struct {
 	http.Client
 
-	contentType string
-	endpoint    string
+	contentType    string
+	endpoint       string
+	base           url.URL // Endpoint that paths of requests are relative to
+	host           string  // Host header, if it's not the endpoint's host
+	redirects      bool    // Record redirect chains
+	acceptEncoding string
+	hashBodies     bool
+	maxBodySize    int
+	oversize       string
+	spillSize      int
+	spillDir       string
+	subscriptions  subscriptionOptions // Window of server-sent event streams
+	signer         requestSigner
+	tokens         *oauth2TokenSource
 
 	getHost string
 	getPath string
 
-	bodyReader func(io.ReadCloser) ([]byte, error)
+	rpc   *rpcProtocol // Frames requests and responses of an RPC protocol, optional
+	codec string       // Of the RPC protocol's messages
+
+	bodyReader func(io.ReadCloser, *Response) error
+}
+
+// Prepare fetches the access token of the endpoint, if it uses OAuth2, so
+// that Send finds it cached.
+func (t *httpTransport) Prepare(ctx context.Context, req *Request) error {
+	if t.tokens == nil {
+		return nil
+	}
+	_, err := t.tokens.Token(ctx)
+	return err
 }
 
 func (t *httpTransport) Mode(m string) error {
@@ -100,55 +280,410 @@ func (t *httpTransport) Mode(m string) error {
 		}
 		url.Path = ""
 		t.getHost = url.String()
+	case "grpc", "grpc-web", "connect":
+		if m == "grpc" && t.base.Scheme != "https" {
+			// HTTP/2 without TLS (h2c) isn't supported by net/http
+			return fmt.Errorf("grpc needs HTTP/2, which is only supported over https+grpc")
+		}
+		rpc, err := newRPCProtocol(m, t.codec)
+		if err != nil {
+			return err
+		}
+		t.rpc = rpc
+		t.contentType = rpc.ContentType()
 	default:
 		return fmt.Errorf("invalid mode for http transport: %s", m)
 	}
 	return nil
 }
 
-func (t *httpTransport) Send(body []byte) ([]byte, error) {
-	var resp *http.Response
-	var err error
-	if t.getHost != "" {
-		url := t.getHost + path.Join(t.getPath, string(body))
-		resp, err = t.Client.Get(url)
-	} else {
-		resp, err = t.Client.Post(t.endpoint, t.contentType, bytes.NewReader(body))
+// requestURL returns the URL of a request path, which is appended to the
+// endpoint's path. A query string in the path is added to the endpoint's.
+func (t *httpTransport) requestURL(p string) string {
+	if p == "" {
+		return t.endpoint
+	}
+	u := t.base
+	if i := strings.IndexByte(p, '?'); i >= 0 {
+		if u.RawQuery != "" {
+			u.RawQuery += "&" + p[i+1:]
+		} else {
+			u.RawQuery = p[i+1:]
+		}
+		p = p[:i]
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawPath = ""
+	return u.String()
+}
+
+func (t *httpTransport) SetCookieJar(jar http.CookieJar) {
+	t.Client.Jar = jar
+}
+
+func (t *httpTransport) Send(ctx context.Context, req *Request, resp *Response) (err error) {
+	var httpReq *http.Request
+	var body []byte // Sent and signed
+	line := req.Line
+	if t.rpc != nil {
+		line = t.rpc.Encode(req.Line)
+	}
+	switch {
+	case req.Method != "" || req.Path != "":
+		// The input record has its own method and path
+		method := req.Method
+		if method == "" {
+			method = http.MethodGet
+			if len(req.Line) > 0 {
+				method = http.MethodPost
+			}
+		}
+		var reqBody io.Reader
+		if len(req.Line) > 0 || t.rpc != nil {
+			body = line
+			reqBody = bytes.NewReader(body)
+		}
+		httpReq, err = http.NewRequestWithContext(ctx, method, t.requestURL(req.Path), reqBody)
+		if err == nil && body != nil {
+			httpReq.Header.Set("Content-Type", t.contentType)
+		}
+	case t.getHost != "":
+		url := t.getHost + path.Join(t.getPath, string(req.Line))
+		httpReq, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	default:
+		body = line
+		httpReq, err = http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+		if err == nil {
+			httpReq.Header.Set("Content-Type", t.contentType)
+		}
 	}
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if t.host != "" {
+		httpReq.Host = t.host
+	}
+	if t.rpc != nil {
+		t.rpc.SetHeaders(httpReq.Header)
+	}
+	if t.acceptEncoding != "" && (t.rpc == nil || t.rpc.Name == "connect") {
+		// gRPC messages are framed before any decoding
+		httpReq.Header.Set("Accept-Encoding", t.acceptEncoding)
+	}
+	var token string
+	if t.tokens != nil {
+		if token, err = t.tokens.Token(ctx); err != nil {
+			return err
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+token)
 	}
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("bad status code: %d", resp.StatusCode)
+	if t.signer != nil {
+		if err := t.signer.Sign(httpReq, body); err != nil {
+			return err
+		}
+	}
+
+	// The timeout is enforced here rather than by the client, which would
+	// also cut off event streams that are read for the subscription window
+	timeout := t.Client.Timeout
+	if req.Timeout > 0 {
+		// The request's own budget replaces the endpoint's
+		timeout = req.Timeout
+	}
+	client := t.Client
+	client.Timeout = 0
+	reqCtx, cancel := context.WithCancel(httpReq.Context())
+	defer cancel()
+	var timedOut int32
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+	defer func() {
+		if err != nil && atomic.LoadInt32(&timedOut) == 1 {
+			err = fmt.Errorf("%w after %s", errTimeout, timeout)
+		}
+	}()
+	var trace phaseTrace
+	httpResp, err := client.Do(httpReq.WithContext(trace.Start(reqCtx)))
+	if err != nil {
+		return err
+	}
+	trace.GotHeader()
+	defer func() { resp.Phases = trace.Phases() }()
+	resp.Status = httpResp.StatusCode
+	resp.Header = httpResp.Header
+	if t.redirects {
+		resp.Redirects = redirectChain(httpResp)
+	}
+	if httpResp.StatusCode == http.StatusUnauthorized && token != "" {
+		// Revoked or expired early, fetch a new one for the next request
+		t.tokens.Invalidate(token)
+	}
+	if t.rpc != nil {
+		return t.rpc.Read(httpResp, resp)
+	}
+	if httpResp.StatusCode >= 400 {
+		httpResp.Body.Close()
+		return fmt.Errorf("bad status code: %d", httpResp.StatusCode)
+	}
+	if isEventStream(httpResp.Header) {
+		defer httpResp.Body.Close()
+		if timer != nil {
+			// The stream is read for the subscription window, or until it
+			// ends, however long the request timeout is
+			timer.Stop()
+		}
+		if err = readEventStream(httpResp.Body, httpResp.Header.Get("Content-Encoding"), t.subscriptions, resp); err != nil {
+			return err
+		}
+		return t.limitEvents(resp)
+	}
+	if t.hashBodies {
+		defer httpResp.Body.Close()
+		return hashBody(httpResp.Body, httpResp.Header.Get("Content-Encoding"), resp)
+	}
+	if t.maxBodySize > 0 {
+		defer httpResp.Body.Close()
+		return limitBody(httpResp.Body, httpResp.Header.Get("Content-Encoding"), t.maxBodySize, t.oversize, resp)
+	}
+	if t.spillSize > 0 {
+		defer httpResp.Body.Close()
+		return spillBody(httpResp.Body, httpResp.Header.Get("Content-Encoding"), t.spillSize, t.spillDir, resp)
 	}
 	if t.bodyReader == nil {
-		resp.Body.Close()
-		return nil, nil
+		httpResp.Body.Close()
+		return nil
 	}
 	// TODO: Avoid reading the whole body into memory
-	return t.bodyReader(resp.Body)
+	return t.bodyReader(httpResp.Body, resp)
+}
+
+// limitEvents applies the body size limit and spilling to the collected
+// events of a stream, like to any other body. The size on the wire is kept.
+func (t *httpTransport) limitEvents(resp *Response) error {
+	size := resp.Size
+	defer func() { resp.Size = size }()
+	events := resp.Body
+	switch {
+	case t.maxBodySize > 0 && len(events) > t.maxBodySize:
+		return limitBody(bytes.NewReader(events), "", t.maxBodySize, t.oversize, resp)
+	case t.spillSize > 0 && len(events) > t.spillSize:
+		return spillBody(bytes.NewReader(events), "", t.spillSize, t.spillDir, resp)
+	}
+	return nil
 }
 
 type websocketTransport struct {
-	ws *websocket.Conn
+	ws            *websocket.Conn
+	timeout       time.Duration
+	subscriptions subscriptionOptions
+	maxBodySize   int
+	oversize      string
+	spillSize     int
+	spillDir      string
+
+	keepalive keepalive
+
+	messages  chan []byte   // Closed when reading fails
+	readErr   error         // Set before messages is closed
+	done      chan struct{} // Closed when reading fails
+	closing   chan struct{} // Closed by Close, so that reading stops
+	closeOnce sync.Once
+	stale     int32 // Set once a reply wasn't waited for, accessed atomically
+}
+
+func newWebsocketTransport(conn *websocket.Conn, opts transportOptions) *websocketTransport {
+	t := &websocketTransport{
+		ws:            conn,
+		timeout:       opts.Timeout,
+		subscriptions: opts.Subscriptions,
+		maxBodySize:   opts.MaxBodySize,
+		oversize:      opts.Oversize,
+		spillSize:     opts.SpillSize,
+		spillDir:      opts.SpillDir,
+		keepalive:     keepalive{Interval: opts.PingInterval},
+		messages:      make(chan []byte, 16),
+		done:          make(chan struct{}),
+		closing:       make(chan struct{}),
+	}
+	go t.readLoop()
+	go t.keepalive.Run(t.done, func() error {
+		return t.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingTimeout))
+	})
+	return t
+}
+
+// readLoop reads messages in the background, so that waiting for a message
+// can time out without corrupting the connection.
+func (t *websocketTransport) readLoop() {
+	fail := func(err error) {
+		t.readErr = err
+		close(t.messages)
+		close(t.done)
+	}
+	for {
+		_, message, err := t.ws.ReadMessage()
+		if err != nil {
+			fail(err)
+			return
+		}
+		select {
+		case t.messages <- message:
+		case <-t.closing:
+			// Nothing reads the messages that are left anymore
+			fail(errConnClosed)
+			return
+		}
+	}
+}
+
+// Close closes the connection.
+func (t *websocketTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.closing) })
+	return t.ws.Close()
 }
 
-func (t *websocketTransport) Send(body []byte) ([]byte, error) {
-	err := t.ws.WriteMessage(websocket.TextMessage, body)
+// Broken returns whether reading from the connection failed, or a reply was
+// given up on: it would be read as the reply to the next request otherwise.
+func (t *websocketTransport) Broken() bool {
+	return closed(t.done) || atomic.LoadInt32(&t.stale) != 0
+}
+
+// next returns the next message, or an error when the deadline passes or the
+// context is done first. A zero deadline waits forever.
+func (t *websocketTransport) next(ctx context.Context, deadline <-chan time.Time) ([]byte, error) {
+	select {
+	case message, ok := <-t.messages:
+		if !ok {
+			return nil, t.readErr
+		}
+		return message, nil
+	case <-deadline:
+		return nil, errTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// response returns the next message that is not a notification, waiting at
+// most the timeout for it. A zero timeout waits forever.
+func (t *websocketTransport) response(ctx context.Context, timeout time.Duration) ([]byte, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		message, err := t.next(ctx, deadline)
+		if err != nil {
+			return nil, err
+		}
+		if isNotification(message) {
+			// Late notification of a previous subscription
+			continue
+		}
+		return message, nil
+	}
+}
+
+func (t *websocketTransport) Send(ctx context.Context, req *Request, resp *Response) error {
+	if req.Method != "" || req.Path != "" {
+		return fmt.Errorf("request methods and paths are only supported over http")
+	}
+	t.keepalive.Touch()
+	err := t.ws.WriteMessage(websocket.TextMessage, req.Line)
+	if err != nil {
+		return err
+	}
+	timeout := t.timeout
+	if req.Timeout > 0 {
+		timeout = req.Timeout
+	}
+	var body []byte
+	if method, id, ok := subscriptionRequest(req.Line); ok && t.subscriptions.Window > 0 {
+		body, err = t.subscribe(ctx, method, id, timeout)
+	} else {
+		body, err = t.response(ctx, timeout)
+	}
+	if err == errTimeout || (err != nil && ctx.Err() != nil) {
+		// The reply may still come, reconnect rather than pair it with the
+		// next request
+		atomic.StoreInt32(&t.stale, 1)
+	}
+	if err == nil && t.maxBodySize > 0 && len(body) > t.maxBodySize {
+		// Messages are read whole, but only the limit is kept for comparison
+		return limitBody(bytes.NewReader(body), "", t.maxBodySize, t.oversize, resp)
+	}
+	if err == nil && t.spillSize > 0 && len(body) > t.spillSize {
+		// Spilled so that the message isn't held until the other endpoints respond
+		return spillBody(bytes.NewReader(body), "", t.spillSize, t.spillDir, resp)
+	}
+	resp.Body = body
+	resp.Size = len(body)
+	return err
+}
+
+// subscribe collects the notifications of a subscription for the configured
+// window, then unsubscribes. The notifications are returned as a normalized
+// body, so that streams can be compared between endpoints.
+func (t *websocketTransport) subscribe(ctx context.Context, method string, id json.RawMessage, timeout time.Duration) ([]byte, error) {
+	message, err := t.response(ctx, timeout)
 	if err != nil {
 		return nil, err
 	}
-	_, message, err := t.ws.ReadMessage()
-	_ = message
+	var resp rpcMessage
+	if err := json.Unmarshal(message, &resp); err != nil || len(resp.Error) > 0 || len(resp.Result) == 0 {
+		// Failed to subscribe, compare the response as-is
+		return message, nil
+	}
+	subscription := resp.Result
+
+	var stream notificationStream
+	window := time.NewTimer(t.subscriptions.Window)
+	defer window.Stop()
+	for {
+		message, err := t.next(ctx, window.C)
+		if err == errTimeout {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var m rpcMessage
+		if err := json.Unmarshal(message, &m); err != nil || len(m.ID) > 0 {
+			continue
+		}
+		var n notification
+		if err := json.Unmarshal(m.Params, &n); err != nil || !bytes.Equal(n.Subscription, subscription) {
+			continue
+		}
+		stream.Add(n.Result)
+	}
+
+	unsubscribe, err := unsubscribeRequest(method, id, subscription)
 	if err != nil {
 		return nil, err
 	}
+	if err := t.ws.WriteMessage(websocket.TextMessage, unsubscribe); err != nil {
+		return nil, err
+	}
+	if _, err := t.response(ctx, timeout); err != nil {
+		return nil, err
+	}
 
-	return nil, nil
+	return stream.Body(t.subscriptions.Unordered)
 }
 
 type noopTransport struct{}
 
-func (t *noopTransport) Send(body []byte) ([]byte, error) {
-	return nil, nil
+func (t *noopTransport) Send(ctx context.Context, req *Request, resp *Response) error {
+	return nil
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPTransportPath(t *testing.T) {
+	var gotMethod, gotURI, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		gotMethod, gotURI, gotBody = r.Method, r.URL.RequestURI(), string(body)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	tr, err := NewTransport(srv.URL+"/api/?key=k", transportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		req        Request
+		wantMethod string
+		wantURI    string
+		wantBody   string
+	}{
+		{Request{Line: []byte(`{"a":1}`)}, "POST", "/api/?key=k", `{"a":1}`},
+		{Request{Path: "/v2/users/123"}, "GET", "/api/v2/users/123?key=k", ""},
+		{Request{Path: "users?page=2", Method: "DELETE"}, "DELETE", "/api/users?key=k&page=2", ""},
+		{Request{Path: "/users", Method: "PUT", Line: []byte(`{"name":"x"}`)}, "PUT", "/api/users?key=k", `{"name":"x"}`},
+		{Request{Method: "PATCH", Line: []byte(`{}`)}, "PATCH", "/api/?key=k", `{}`},
+	}
+	for _, tc := range tests {
+		var resp Response
+		if err := tr.Send(context.Background(), &tc.req, &resp); err != nil {
+			t.Errorf("%s %s: %s", tc.req.Method, tc.req.Path, err)
+			continue
+		}
+		if gotMethod != tc.wantMethod || gotURI != tc.wantURI || gotBody != tc.wantBody {
+			t.Errorf("got: %s %s %q; want: %s %s %q", gotMethod, gotURI, gotBody, tc.wantMethod, tc.wantURI, tc.wantBody)
+		}
+	}
+}
+
+func TestHTTPTransportRequestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	tr, err := NewTransport(srv.URL, transportOptions{Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		timeout time.Duration
+		wantErr bool
+	}{
+		{0, true},
+		{time.Second, false},
+	}
+	for _, tc := range tests {
+		var resp Response
+		err := tr.Send(context.Background(), &Request{Line: []byte(`{}`), Timeout: tc.timeout}, &resp)
+		if got := err != nil; got != tc.wantErr {
+			t.Errorf("timeout %s: got error: %v; want error: %v", tc.timeout, err, tc.wantErr)
+		}
+	}
+}